@@ -6,14 +6,28 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
-	loomisvc "github.com/blueplan/loomi-go/internal/loomi"
 	"github.com/blueplan/loomi-go/internal/loomi/agents"
 	"github.com/blueplan/loomi-go/internal/loomi/api"
+	"github.com/blueplan/loomi-go/internal/loomi/base"
 	"github.com/blueplan/loomi-go/internal/loomi/config"
+	"github.com/blueplan/loomi-go/internal/loomi/database"
 	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	"github.com/blueplan/loomi-go/internal/loomi/llm/cache"
+	"github.com/blueplan/loomi-go/internal/loomi/llm/cassette"
+	"github.com/blueplan/loomi-go/internal/loomi/llm/redisslots"
 	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/monitoring"
+	"github.com/blueplan/loomi-go/internal/loomi/monitoring/redisexperiments"
+	"github.com/blueplan/loomi-go/internal/loomi/plans"
+	"github.com/blueplan/loomi-go/internal/loomi/pool"
+	"github.com/blueplan/loomi-go/internal/loomi/prompts"
+	"github.com/blueplan/loomi-go/internal/loomi/tokens/tokenizer"
+	"github.com/blueplan/loomi-go/internal/loomi/tools/search"
+	"github.com/blueplan/loomi-go/internal/loomi/utils"
 )
 
 func main() {
@@ -30,28 +44,193 @@ func main() {
 		logx.KV("version", cfg.App.Version),
 		logx.KV("environment", getEnv("ENVIRONMENT", "development")))
 
+	// Register native (non OpenAI-compatible) provider adapters
+	llm.RegisterProvider("gemini", func(pc config.LLMProviderConfig) (llm.Client, error) {
+		return utils.NewGeminiLLMClient(utils.NewGeminiClientGen(*logger, cfg), pc), nil
+	})
+	llm.RegisterProvider("zhipu", func(pc config.LLMProviderConfig) (llm.Client, error) {
+		return search.NewZhipuLLMClient(logger, pc), nil
+	})
+
+	// Load a BPE vocab (e.g. cl100k_base.tiktoken) for token counting; the CJK estimator is used otherwise
+	if vocabPath := getEnv("TOKENIZER_VOCAB_PATH", ""); vocabPath != "" {
+		bpe, err := tokenizer.LoadBPEFile(vocabPath)
+		if err != nil {
+			logger.Error(context.Background(), "Failed to load tokenizer vocab, using estimator", logx.KV("error", err))
+		} else {
+			tokenizer.SetDefault(bpe)
+		}
+	}
+
+	// Per-agent generation overrides (llm.agents.<agent_name>)
+	base.SetAgentLLMOverrides(cfg.LLM.Agents)
+
+	// Register YAML-defined agents (config/agents/*.yaml) on top of the built-in ones
+	agentDir := getEnv("AGENT_DEFINITIONS_DIR", "config/agents")
+	if defs, err := agents.LoadAgentDefinitions(agentDir); err != nil {
+		logger.Warn(context.Background(), "Failed to load agent definitions", logx.KV("dir", agentDir), logx.KV("error", err))
+	} else if len(defs) > 0 {
+		agents.RegisterAgentDefinitions(defs)
+		logger.Info(context.Background(), "Agent definitions registered", logx.KV("dir", agentDir), logx.KV("count", len(defs)))
+	}
+
+	// Supabase client shared by the prompt source, checkpoints and file storage
+	dbClient, dbErr := database.NewSupabaseClient(cfg.Database)
+
+	// Versioned system prompts (PROMPT_SOURCE=dir|database), reloaded every PROMPT_RELOAD_SECONDS
+	var promptSource prompts.Source = prompts.DirSource{Dir: getEnv("PROMPTS_DIR", "config/prompts")}
+	if getEnv("PROMPT_SOURCE", "dir") == "database" {
+		if dbErr != nil {
+			logger.Warn(context.Background(), "Prompt database unavailable, using prompt directory", logx.KV("error", dbErr))
+		} else {
+			promptSource = database.NewSupabasePromptSource(dbClient)
+		}
+	}
+	promptStore := prompts.NewStore(promptSource)
+	if err := promptStore.Reload(context.Background()); err != nil {
+		logger.Warn(context.Background(), "Failed to load prompt templates, using built-in prompts", logx.KV("error", err))
+	}
+	base.SetPromptStore(promptStore)
+	reloadSeconds := envInt(logger, "PROMPT_RELOAD_SECONDS", 30, 1)
+	go promptStore.Watch(context.Background(), time.Duration(reloadSeconds)*time.Second, func(err error) {
+		logger.Warn(context.Background(), "Prompt template reload failed, keeping previous versions", logx.KV("error", err))
+	})
+
+	// Items are emitted as soon as their closing tag arrives; ITEM_PROGRESS_EVENTS=true also streams their text
+	base.SetItemProgress(getEnv("ITEM_PROGRESS_EVENTS", "false") == "true")
+
+	// Orchestrators loop at most ORCHESTRATOR_MAX_ITERATIONS rounds within ORCHESTRATOR_TOKEN_BUDGET tokens (0 = unlimited)
+	base.SetReActLimits(envInt(logger, "ORCHESTRATOR_MAX_ITERATIONS", 4, 1), envInt(logger, "ORCHESTRATOR_TOKEN_BUDGET", 100000, 0))
+
+	// Same-type creative actions of one iteration are merged once there are ORCHESTRATOR_MERGE_THRESHOLD of them
+	base.SetMergeLimits(envInt(logger, "ORCHESTRATOR_MERGE_THRESHOLD", 2, 1), envInt(logger, "ORCHESTRATOR_MAX_BATCH_SIZE", 5, 1))
+
+	// Request budgets (budgets.default, budgets.plans.<plan>); a request over budget is stopped with a billing_summary
+	base.SetBudgets(cfg.Budgets)
+
+	// Redis pools shared by the plan store, the distributed LLM limits, the response cache and the
+	// prompt experiment counters
+	pools, poolsErr := pool.InitializePoolManager(&cfg.Memory, logger)
+	if poolsErr == nil {
+		monitoring.DefaultExperimentMetrics.SetStore(redisexperiments.New(pools, "normal"))
+	}
+
+	// With auto mode off, orchestrator plans are parked for approval (Redis, kept PLAN_TTL_SECONDS)
+	planTTL := envInt(logger, "PLAN_TTL_SECONDS", 86400, 1)
+	if poolsErr != nil {
+		logger.Warn(context.Background(), "Plan store in memory, parked plans will not survive a restart", logx.KV("error", poolsErr))
+		base.SetPlanStore(plans.NewMemoryStore(), time.Duration(planTTL)*time.Second)
+	} else {
+		base.SetPlanStore(plans.NewRedisStore(pools, "high_priority"), time.Duration(planTTL)*time.Second)
+	}
+
+	// Resolve uploaded files (AgentRequest.FileIDs) into multimodal message parts, and checkpoint
+	// orchestrator runs so interrupted ones can be resumed (/api/loomi/resume)
+	if dbErr != nil {
+		logger.Warn(context.Background(), "File storage and checkpoints disabled, file_ids will be ignored", logx.KV("error", dbErr))
+	} else {
+		base.SetCheckpointStorage(database.NewSupabaseCheckpointStorage(dbClient, logger))
+		var fileURL base.FileURLFunc
+		if getEnv("OSS_ENDPOINT", "") != "" {
+			oss := utils.NewOSSClient(*logger, cfg)
+			fileURL = func(ctx context.Context, key string) (string, error) {
+				return oss.GeneratePresignedURL(ctx, key, "GET", time.Hour)
+			}
+		}
+		base.SetFileStorage(database.NewSupabaseFileStorage(dbClient, logger), fileURL)
+	}
+
+	// Bound concurrent streams per provider across all requests (llm.concurrency)
+	governorCfg := llm.GovernorConfigFrom(cfg.LLM.Concurrency)
+	if cfg.LLM.Concurrency.Distributed {
+		if poolsErr != nil {
+			logger.Warn(context.Background(), "Distributed LLM limits disabled, Redis unavailable", logx.KV("error", poolsErr))
+		} else {
+			governorCfg.Slots = redisslots.New(pools, "high_priority")
+		}
+	}
+	llm.SetGovernor(llm.NewGovernor(governorCfg))
+
+	// Providers without credentials fall back to the mock client only in development
+	llm.SetMockFallback(cfg.App.Environment == "development")
+	if pc := cfg.LLM.Providers[cfg.LLM.DefaultProvider]; cfg.App.Environment == "development" && pc.APIKey == "" && pc.BaseURL == "" {
+		logger.Warn(context.Background(), "LLM provider not configured, serving mock answers", logx.KV("provider", cfg.LLM.DefaultProvider))
+	}
+
 	// Initialize LLM client
 	llmClient, err := llm.NewClient(cfg.LLM.DefaultProvider, cfg.LLM.Providers[cfg.LLM.DefaultProvider])
 	if err != nil {
 		log.Fatalf("Failed to initialize LLM client: %v", err)
 	}
 
-	// Initialize Loomi service
-	loomiService := loomisvc.NewLoomiService(cfg, logger, llmClient)
-	// Inject default agents after service construct to avoid import cycle in loomi_service
-	concierge := agents.NewLoomiConcierge(logger, llmClient)
-	orchestrator := agents.NewLoomiOrchestrator(logger, llmClient)
-	// wire dependencies via service getters
-	_ = concierge // will be wired inside service when ProcessRequest is called if needed
-	_ = orchestrator
+	// Wrap providers in a fallback router when routing chains are configured
+	var llmRouter *llm.Router
+	if len(cfg.LLM.Routing.Default) > 0 || len(cfg.LLM.Routing.Agents) > 0 {
+		llmRouter, err = llm.NewRouter(cfg.LLM)
+		if err != nil {
+			log.Fatalf("Failed to initialize LLM router: %v", err)
+		}
+		llmClient = llmRouter
+	}
+
+	// Cache complete responses of agents with llm.agents.<agent_name>.cache enabled
+	if cacheCfg := cache.ConfigFrom(cfg.PerformanceOptimization, cfg.LLM.Agents); cacheCfg.Enabled() {
+		err := poolsErr
+		if err == nil {
+			_, err = pools.GetRedisClient(context.Background(), "normal")
+		}
+		if err != nil {
+			logger.Warn(context.Background(), "LLM response cache disabled, Redis unavailable", logx.KV("error", err))
+		} else {
+			llmClient = cache.Wrap(llmClient, cache.NewRedisStore(pools, "normal"), cacheCfg)
+		}
+	}
 
-	// Initialize API server
-	apiServer := api.NewServer(logger, llmClient, loomiService)
+	// LLM_CASSETTE_MODE=record|replay captures or replays provider streams (see llm/cassette)
+	if mode := getEnv("LLM_CASSETTE_MODE", ""); mode != "" {
+		cassetteClient, err := cassette.New(cassette.Mode(mode), getEnv("LLM_CASSETTE_DIR", "testdata/cassettes"), llmClient)
+		if err != nil {
+			log.Fatalf("Failed to initialize LLM cassette: %v", err)
+		}
+		llmClient = cassetteClient
+	}
+
+	// HTTP API: orchestrators come from the shared agent pool, so parked plans can be approved
+	// (/api/loomi/plans/:id/approve) and interrupted runs resumed (/api/loomi/resume)
+	monitorLog := monitorLogger{logger}
+	checkInterval := time.Duration(cfg.Monitoring.HealthCheckInterval) * time.Second
+	if checkInterval <= 0 {
+		checkInterval = time.Minute
+	}
+	monitor := monitoring.NewMonitor(&monitoring.MonitorConfig{
+		Port:            cfg.Monitoring.MetricsPort,
+		CheckInterval:   checkInterval,
+		HealthCheckPath: "/health",
+		MetricsPath:     "/metrics",
+	}, monitorLog)
+	portMonitor := monitoring.NewPortMonitor(&monitoring.PortMonitorConfig{CheckInterval: checkInterval}, monitorLog)
+	systemMonitor := monitoring.NewSystemMonitor(&monitoring.SystemMonitorConfig{CheckInterval: checkInterval}, monitorLog)
+	if cfg.Monitoring.EnableMetrics {
+		go func() {
+			if err := monitor.Start(context.Background()); err != nil {
+				logger.Error(context.Background(), "Monitor stopped", logx.KV("error", err))
+			}
+		}()
+	} else {
+		monitor.StartChecks(context.Background())
+	}
+
+	router := api.NewRouter(cfg, logger, database.NewPersistenceManager(cfg, logger), monitor, portMonitor, systemMonitor, nil, nil)
+	router.RegisterAgents(base.NewAgentPool(logger, llmClient))
+	if llmRouter != nil {
+		// Provider breaker states show up under services.llm of /monitor/health
+		router.RegisterLLMHealth(llmRouter)
+	}
 
 	// Start server in a goroutine
 	serverAddr := fmt.Sprintf("%s:%d", cfg.API.Host, cfg.API.Port)
 	go func() {
-		if err := apiServer.Run(serverAddr); err != nil {
+		if err := router.Run(); err != nil {
 			logger.Error(context.Background(), "Failed to start server", logx.KV("error", err))
 			os.Exit(1)
 		}
@@ -67,18 +246,43 @@ func main() {
 
 	logger.Info(context.Background(), "Shutting down Loomi service...")
 
-	// Shutdown services
-	ctx, cancel := context.WithTimeout(context.Background(), 30)
-	defer cancel()
+	monitor.Stop()
 
-	if err := loomiService.Shutdown(ctx); err != nil {
-		logger.Error(ctx, "Error shutting down Loomi service", logx.KV("error", err))
-	}
+	logger.Info(context.Background(), "Loomi service stopped")
+}
 
-	// Graceful shutdown would be implemented here
-	logger.Info(ctx, "API server shutdown completed")
+// monitorLogger adapts the service logger to monitoring.Logger, whose fields are key/value pairs
+type monitorLogger struct {
+	logger *logx.Logger
+}
 
-	logger.Info(ctx, "Loomi service stopped")
+func (l monitorLogger) Info(ctx context.Context, message string, fields ...interface{}) {
+	l.logger.Info(ctx, message, monitorFields(fields)...)
+}
+
+func (l monitorLogger) Error(ctx context.Context, message string, fields ...interface{}) {
+	l.logger.Error(ctx, message, monitorFields(fields)...)
+}
+
+func (l monitorLogger) Warn(ctx context.Context, message string, fields ...interface{}) {
+	l.logger.Warn(ctx, message, monitorFields(fields)...)
+}
+
+func (l monitorLogger) Debug(ctx context.Context, message string, fields ...interface{}) {
+	l.logger.Debug(ctx, message, monitorFields(fields)...)
+}
+
+func monitorFields(fields []interface{}) []logx.KV {
+	kvs := make([]logx.KV, 0, (len(fields)+1)/2)
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		var value interface{}
+		if i+1 < len(fields) {
+			value = fields[i+1]
+		}
+		kvs = append(kvs, logx.KV(key, value))
+	}
+	return kvs
 }
 
 func getEnv(key, defaultValue string) string {
@@ -87,3 +291,21 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// envInt reads an integer environment variable of at least min; an invalid value is logged and
+// replaced by defaultValue
+func envInt(logger *logx.Logger, key string, defaultValue, min int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min {
+		logger.Warn(context.Background(), "Invalid integer environment variable, using default",
+			logx.KV("name", key),
+			logx.KV("value", raw),
+			logx.KV("default", defaultValue))
+		return defaultValue
+	}
+	return value
+}
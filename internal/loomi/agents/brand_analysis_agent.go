@@ -21,7 +21,7 @@ type BrandAnalysisAgent struct {
 
 // NewBrandAnalysisAgent creates a new BrandAnalysis agent
 func NewBrandAnalysisAgent(logger *logx.Logger, client llm.Client) *BrandAnalysisAgent {
-	baseAgent := base.NewBaseLoomiAgent("loomi_brand_analysis_agent", logger, client)
+	baseAgent := base.NewBaseLoomiAgent("loomi_brand_analysis_agent", logger, client).WithOutputSchema(base.ItemSchema(xmlx.UnifiedConfigs["brand_analysis"]))
 	return &BrandAnalysisAgent{
 		BaseLoomiAgent: baseAgent,
 		xmlParser:      xmlx.NewLoomiXMLParser(),
@@ -34,15 +34,14 @@ func (a *BrandAnalysisAgent) ProcessRequest(
 	req types.AgentRequest,
 	emit func(ev events.StreamEvent) error,
 ) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
 	a.Logger.Info(ctx, "Processing brand analysis request",
 		logx.KV("user_id", req.UserID),
 		logx.KV("session_id", req.SessionID),
 		logx.KV("instruction_length", len(req.Instruction)))
 
-	// Set current session
-	a.SetCurrentSession(req.UserID, req.SessionID)
-	defer a.ClearCurrentSession()
-
 	// Clear stop state before starting
 	if err := a.ClearStopState(ctx, req.UserID, req.SessionID); err != nil {
 		a.Logger.Error(ctx, "Failed to clear stop state", logx.KV("error", err))
@@ -61,14 +60,16 @@ func (a *BrandAnalysisAgent) ProcessRequest(
 	}
 
 	// Prepare messages
+	// Uploaded images/documents (e.g. a competitor's cover) are sent alongside the prompt
 	messages := []llm.Message{
-		{Role: "system", Content: a.getSystemPrompt()},
-		{Role: "user", Content: userPrompt},
+		{Role: "system", Content: a.SystemPrompt(ctx, req, a.getSystemPrompt())},
+		{Role: "user", Content: userPrompt, Parts: a.ResolveFileParts(ctx, req)},
 	}
 
-	// Collect LLM response
+	// Collect LLM response; each brand analysis is emitted and saved as soon as its closing tag arrives
 	a.Logger.Info(ctx, "Starting LLM response collection")
 	llmResponse := ""
+	stream := a.NewItemStream(req, xmlx.UnifiedConfigs["brand_analysis"], events.ContentLoomiBrandAnalysis, emit, a.buildBrandAnalysis, a.createBrandAnalysisNote)
 
 	err = a.SafeStreamCall(ctx, req.UserID, req.SessionID, messages, func(ctx context.Context, chunk string) error {
 		llmResponse += chunk
@@ -85,7 +86,7 @@ func (a *BrandAnalysisAgent) ProcessRequest(
 			}
 		}
 
-		return nil
+		return stream.Write(ctx, chunk)
 	})
 	if err != nil {
 		return err
@@ -102,17 +103,9 @@ func (a *BrandAnalysisAgent) ProcessRequest(
 	// Extract other content outside of brand_analysis tags
 	otherContent := a.ExtractOtherContent(llmResponse, []string{`<brand_analysis\\d+>`})
 
-	// Parse brand analyses with unique IDs
-	analyses, err := a.parseBrandAnalysesWithUniqueIDs(ctx, llmResponse, req)
-	if err != nil {
-		a.Logger.Error(ctx, "Failed to parse brand analysis results", logx.KV("error", err))
-		// Send raw response if parsing fails
-		return emit(events.StreamEvent{
-			Type:    events.LLMChunk,
-			Content: events.ContentLoomiBrandAnalysis,
-			Data:    llmResponse,
-		})
-	}
+	// Parse whatever was not streamed
+	analyses := stream.Finish(ctx, llmResponse)
+	analyses = a.appendFallbackAnalysis(ctx, req, llmResponse, analyses)
 
 	a.Logger.Info(ctx, "Brand analysis parsing completed",
 		logx.KV("analyses_count", len(analyses)))
@@ -128,20 +121,10 @@ func (a *BrandAnalysisAgent) ProcessRequest(
 			metadata["agent_other_message"] = otherContent
 		}
 
-		analysisEvent := events.StreamEvent{
-			Type:    events.LLMChunk,
-			Content: events.ContentLoomiBrandAnalysis,
-			Data:    analyses,
-			Meta:    metadata,
-		}
-		if err := emit(analysisEvent); err != nil {
+		if err := emit(stream.FinalEvent(analyses, metadata)); err != nil {
 			return err
 		}
 
-		// Create notes concurrently
-		if err := a.createBrandAnalysisNotes(ctx, req, analyses); err != nil {
-			a.Logger.Error(ctx, "Failed to create brand analysis notes", logx.KV("error", err))
-		}
 	} else {
 		// Send raw response if no analyses parsed
 		return emit(events.StreamEvent{
@@ -159,90 +142,75 @@ func (a *BrandAnalysisAgent) Process(ctx context.Context, req types.AgentRequest
 	return a.ProcessRequest(ctx, req, emit)
 }
 
-// parseBrandAnalysesWithUniqueIDs parses LLM response and assigns unique IDs
-func (a *BrandAnalysisAgent) parseBrandAnalysesWithUniqueIDs(
-	ctx context.Context,
-	response string,
-	req types.AgentRequest,
-) ([]map[string]any, error) {
-	// Parse using enhanced XML parser
-	config := xmlx.UnifiedConfigs["brand_analysis"]
-	parseResults := a.xmlParser.ParseEnhanced(response, config, 1)
-
-	analyses := make([]map[string]any, 0, len(parseResults))
-	for _, result := range parseResults {
-		// Get unique ID per analysis
-		uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "brand_analysis")
-		if err != nil {
-			a.Logger.Error(ctx, "Failed to get next action ID", logx.KV("error", err))
-			continue
-		}
-
-		// Ensure markdown compatibility
-		title := a.EnsureMarkdownCompatibility(result.Title)
-		if title == "" {
-			title = fmt.Sprintf("品牌分析 %d", len(analyses)+1)
-		}
-		content := a.EnsureMarkdownCompatibility(result.Content)
-
-		analyses = append(analyses, map[string]any{
-			"id":      fmt.Sprintf("brand_analysis%d", uniqueID),
-			"title":   title,
-			"type":    result.Type,
-			"content": content,
-		})
+// buildBrandAnalysis assigns a unique ID to a parsed brand analysis
+func (a *BrandAnalysisAgent) buildBrandAnalysis(ctx context.Context, req types.AgentRequest, result xmlx.ParseResult, position int) (map[string]any, bool) {
+	// Get unique ID per analysis
+	uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "brand_analysis")
+	if err != nil {
+		a.Logger.Error(ctx, "Failed to get next action ID", logx.KV("error", err))
+		return nil, false
 	}
 
-	a.Logger.Info(ctx, "Assigned unique IDs to brand analyses",
-		logx.KV("count", len(analyses)))
-
-	// Fallback: if fewer than 3 results and response has content, wrap whole response as one analysis
-	if len(analyses) < 3 && len(response) > 0 {
-		clean := a.xmlParser.CleanXMLTags(response, "brand_analysis")
-		if clean != "" {
-			uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "brand_analysis")
-			if err == nil {
-				analyses = append(analyses, map[string]any{
-					"id":      fmt.Sprintf("brand_analysis%d", uniqueID),
-					"title":   fmt.Sprintf("品牌分析 %d", len(analyses)+1),
-					"type":    "brand_analysis",
-					"content": a.EnsureMarkdownCompatibility(clean),
-				})
-			}
-		}
+	// Ensure markdown compatibility
+	title := a.EnsureMarkdownCompatibility(result.Title)
+	if title == "" {
+		title = fmt.Sprintf("品牌分析 %d", position)
 	}
-
-	return analyses, nil
+	content := a.EnsureMarkdownCompatibility(result.Content)
+
+	return map[string]any{
+		"id":      fmt.Sprintf("brand_analysis%d", uniqueID),
+		"title":   title,
+		"type":    result.Type,
+		"content": content,
+	}, true
 }
 
-// createBrandAnalysisNotes creates notes for brand analysis results
-func (a *BrandAnalysisAgent) createBrandAnalysisNotes(
+// appendFallbackAnalysis wraps the whole response as one more analysis when fewer than 3 were parsed
+func (a *BrandAnalysisAgent) appendFallbackAnalysis(
 	ctx context.Context,
 	req types.AgentRequest,
+	response string,
 	analyses []map[string]any,
-) error {
-	if a.NotesService == nil {
-		return fmt.Errorf("notes service not available")
+) []map[string]any {
+	if len(analyses) >= 3 || len(response) == 0 {
+		return analyses
 	}
+	clean := a.xmlParser.CleanXMLTags(response, "brand_analysis")
+	if clean == "" {
+		return analyses
+	}
+	uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "brand_analysis")
+	if err != nil {
+		return analyses
+	}
+	analysis := map[string]any{
+		"id":      fmt.Sprintf("brand_analysis%d", uniqueID),
+		"title":   fmt.Sprintf("品牌分析 %d", len(analyses)+1),
+		"type":    "brand_analysis",
+		"content": a.EnsureMarkdownCompatibility(clean),
+	}
+	a.createBrandAnalysisNote(ctx, req, analysis)
+	return append(analyses, analysis)
+}
 
-	a.Logger.Info(ctx, "Creating brand analysis notes",
-		logx.KV("count", len(analyses)))
+// createBrandAnalysisNote creates the note of a brand analysis
+func (a *BrandAnalysisAgent) createBrandAnalysisNote(ctx context.Context, req types.AgentRequest, analysis map[string]any) {
+	if a.NotesService == nil {
+		return
+	}
 
-	for _, analysis := range analyses {
-		id := analysis["id"].(string)
-		title := analysis["title"].(string)
-		content := analysis["content"].(string)
+	id := analysis["id"].(string)
+	title := analysis["title"].(string)
+	content := analysis["content"].(string)
 
-		if err := a.CreateNote(ctx, req.UserID, req.SessionID, "brand_analysis", id, content, title, "", nil); err != nil {
-			a.Logger.Error(ctx, "Failed to create brand analysis note",
-				logx.KV("id", id),
-				logx.KV("error", err))
-		} else {
-			a.Logger.Info(ctx, "Created brand analysis note", logx.KV("id", id))
-		}
+	if err := a.CreateNote(ctx, req.UserID, req.SessionID, "brand_analysis", id, content, title, "", nil); err != nil {
+		a.Logger.Error(ctx, "Failed to create brand analysis note",
+			logx.KV("id", id),
+			logx.KV("error", err))
+	} else {
+		a.Logger.Info(ctx, "Created brand analysis note", logx.KV("id", id))
 	}
-
-	return nil
 }
 
 // getSystemPrompt returns the system prompt for brand analysis
@@ -2,6 +2,7 @@ package agents
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"regexp"
 	"strings"
@@ -18,14 +19,8 @@ import (
 type LoomiConcierge struct {
 	*base.BaseLoomiAgent
 
-	// Configuration
-	autoMode       bool
-	userSelections []string
-
-	// Execution state
-	executedActions map[string]bool
-	totalLLMCalls   int
-	totalActions    int
+	// orchestrator is shared across requests; per-request state lives on base.Run
+	orchestrator *LoomiOrchestrator
 
 	// Optional multimodal processor (Noop by default)
 	mmProcessor mmp.Processor
@@ -37,10 +32,8 @@ func NewLoomiConcierge(logger *logx.Logger, client llm.Client) *LoomiConcierge {
 
 	concierge := &LoomiConcierge{
 		BaseLoomiAgent: baseAgent,
-
-		// Initialize execution state
-		executedActions: make(map[string]bool),
-		mmProcessor:     &mmp.Noop{},
+		orchestrator:   NewLoomiOrchestrator(logger, client),
+		mmProcessor:    &mmp.Noop{},
 	}
 
 	concierge.Logger.Info(context.Background(), "LoomiConcierge initialized",
@@ -56,6 +49,11 @@ func (a *LoomiConcierge) ProcessRequest(
 	req types.AgentRequest,
 	emit func(ev events.StreamEvent) error,
 ) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
+	ctx = base.WithSystemNotices(ctx, emit)
+
 	// Emit pre-plan event (loomi_plan_concierge) similar to Python
 	if err := emit(events.StreamEvent{
 		Type:    events.LLMChunk,
@@ -77,10 +75,6 @@ func (a *LoomiConcierge) ProcessRequest(
 		logx.KV("session_id", req.SessionID),
 		logx.KV("instruction_length", len(req.Instruction)))
 
-	// Set current session
-	a.SetCurrentSession(req.UserID, req.SessionID)
-	defer a.ClearCurrentSession()
-
 	// Clear stop state before starting
 	if err := a.ClearStopState(ctx, req.UserID, req.SessionID); err != nil {
 		a.Logger.Error(ctx, "Failed to clear stop state", logx.KV("error", err))
@@ -98,12 +92,6 @@ func (a *LoomiConcierge) ProcessRequest(
 		userPrompt = req.Instruction
 	}
 
-	// Set execution mode and user selections
-	a.autoMode = req.AutoMode
-	a.userSelections = req.Selections
-	a.totalLLMCalls = 0
-	a.totalActions = 0
-
 	// Before LLM call: optional multimodal processing
 	// 1) file_ids 模式
 	if len(req.FileIDs) > 0 && a.mmProcessor != nil && a.mmProcessor.Enabled() {
@@ -141,7 +129,7 @@ func (a *LoomiConcierge) ProcessRequest(
 
 	// Prepare messages
 	messages := []llm.Message{
-		{Role: "system", Content: a.getSystemPrompt()},
+		{Role: "system", Content: a.SystemPrompt(ctx, req, a.getSystemPrompt())},
 		{Role: "user", Content: userPrompt},
 	}
 
@@ -149,7 +137,11 @@ func (a *LoomiConcierge) ProcessRequest(
 	a.Logger.Info(ctx, "Starting LLM response collection")
 	llmResponse := ""
 
-	err = a.SafeStreamCall(ctx, req.UserID, req.SessionID, messages, func(ctx context.Context, chunk string) error {
+	// Native tool calls are queued here; XML tags are still parsed below as the fallback
+	var toolOrchestratorCalls, toolWebSearchCalls []string
+	tools := a.buildToolSet(req.UserID, req.SessionID, &toolOrchestratorCalls, &toolWebSearchCalls)
+
+	err = a.SafeStreamCallWithTools(ctx, req.UserID, req.SessionID, messages, tools, func(ctx context.Context, chunk string) error {
 		llmResponse += chunk
 
 		// Emit thought process if configured
@@ -181,6 +173,8 @@ func (a *LoomiConcierge) ProcessRequest(
 
 	// Process XML tags in concierge response
 	processedText, orchestratorCalls, webSearchCalls := a.processXMLTags(ctx, llmResponse, req.UserID, req.SessionID)
+	orchestratorCalls = append(toolOrchestratorCalls, orchestratorCalls...)
+	webSearchCalls = append(toolWebSearchCalls, webSearchCalls...)
 	a.Logger.Info(ctx, "XML tag processing completed",
 		logx.KV("orchestrator_calls", len(orchestratorCalls)),
 		logx.KV("web_search_calls", len(webSearchCalls)))
@@ -270,7 +264,7 @@ func (a *LoomiConcierge) ProcessRequest(
 		}
 
 		messages2 := []llm.Message{
-			{Role: "system", Content: a.getSystemPrompt()},
+			{Role: "system", Content: a.SystemPrompt(ctx, req, a.getSystemPrompt())},
 			{Role: "user", Content: reAnalysisPrompt},
 		}
 
@@ -308,6 +302,93 @@ func (a *LoomiConcierge) ProcessRequest(
 	return nil
 }
 
+// buildToolSet declares the concierge's native tools. Notes are saved immediately; orchestrator
+// and web search calls are queued and run after the response is emitted, like their XML tags.
+func (a *LoomiConcierge) buildToolSet(userID, sessionID string, orchestratorCalls, webSearchCalls *[]string) *llm.ToolSet {
+	tools := llm.NewToolSet()
+
+	tools.Register(llm.Tool{
+		Name:        "create_note",
+		Description: "保存一条笔记（如 persona、hitpoint 等分析结果）",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"type":{"type":"string","description":"笔记类型"},` +
+			`"id":{"type":"string","description":"笔记编号"},` +
+			`"content":{"type":"string","description":"笔记内容"}},` +
+			`"required":["type","id","content"]}`),
+	}, func(ctx context.Context, raw json.RawMessage) (string, error) {
+		var args struct {
+			Type    string `json:"type"`
+			ID      string `json:"id"`
+			Content string `json:"content"`
+		}
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return "", err
+		}
+		if err := a.CreateNote(ctx, userID, sessionID, args.Type, args.ID, args.Content, "", "", nil); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("已保存%s: %s", args.Type, args.ID), nil
+	})
+
+	tools.Register(llm.Tool{
+		Name:        "save_material",
+		Description: "保存研究进展素材",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"id":{"type":"string","description":"素材编号"},` +
+			`"content":{"type":"string","description":"素材内容"}},` +
+			`"required":["id","content"]}`),
+	}, func(ctx context.Context, raw json.RawMessage) (string, error) {
+		var args struct {
+			ID      string `json:"id"`
+			Content string `json:"content"`
+		}
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return "", err
+		}
+		noteName := fmt.Sprintf("material%s", args.ID)
+		if err := a.CreateNote(ctx, userID, sessionID, "material", noteName, args.Content, "", "", nil); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("已保存研究进展: %s", noteName), nil
+	})
+
+	tools.Register(llm.Tool{
+		Name:        "call_orchestrator",
+		Description: "将创作任务交给编排器执行",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"instruction":{"type":"string","description":"交给编排器的任务说明"}},` +
+			`"required":["instruction"]}`),
+	}, func(ctx context.Context, raw json.RawMessage) (string, error) {
+		var args struct {
+			Instruction string `json:"instruction"`
+		}
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return "", err
+		}
+		*orchestratorCalls = append(*orchestratorCalls, strings.TrimSpace(args.Instruction))
+		return "已提交编排器，将在回复后执行", nil
+	})
+
+	tools.Register(llm.Tool{
+		Name:        "web_search",
+		Description: "联网搜索关键词，结果会在回复后补充到上下文",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"keyword":{"type":"string","description":"搜索关键词"}},` +
+			`"required":["keyword"]}`),
+	}, func(ctx context.Context, raw json.RawMessage) (string, error) {
+		var args struct {
+			Keyword string `json:"keyword"`
+		}
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return "", err
+		}
+		*webSearchCalls = append(*webSearchCalls, strings.TrimSpace(args.Keyword))
+		return fmt.Sprintf("即将搜索: %s", args.Keyword), nil
+	})
+
+	return tools
+}
+
 // processXMLTags processes XML tags in concierge response
 func (a *LoomiConcierge) processXMLTags(
 	ctx context.Context,
@@ -441,14 +522,15 @@ func (a *LoomiConcierge) processWebSearchTags(
 	ctx context.Context,
 	text string,
 ) (string, []string) {
-	pattern := regexp.MustCompile(`<web_search(\d+)>\s*(.*?)\s*</web_search\1>`)
+	// Go regexp has no backreferences; the closing tag's number is compared below
+	pattern := regexp.MustCompile(`<web_search(\d+)>\s*(.*?)\s*</web_search(\d+)>`)
 	matches := pattern.FindAllStringSubmatch(text, -1)
 
 	processedText := text
 	webSearchCalls := []string{}
 
 	for _, match := range matches {
-		if len(match) >= 3 {
+		if len(match) >= 4 && match[1] == match[3] {
 			keyword := strings.TrimSpace(match[2])
 			webSearchCalls = append(webSearchCalls, keyword)
 
@@ -479,7 +561,7 @@ func (a *LoomiConcierge) parseConciergeResultsWithUniqueIDs(
 	}
 
 	// Parse confirm tags and regular text
-	confirmPattern := regexp.MustCompile(`<confirm(\d+)>(.*?)</confirm\1>`)
+	confirmPattern := regexp.MustCompile(`<confirm(\d+)>(.*?)</confirm(\d+)>`)
 	confirmMatches := confirmPattern.FindAllStringSubmatch(response, -1)
 
 	currentPos := 0
@@ -487,7 +569,7 @@ func (a *LoomiConcierge) parseConciergeResultsWithUniqueIDs(
 
 	// Process confirm tags
 	for _, match := range confirmMatches {
-		if len(match) >= 3 {
+		if len(match) >= 4 && match[1] == match[3] {
 			// Add text before confirm tag
 			startPos := strings.Index(response, match[0])
 			if startPos > currentPos {
@@ -594,19 +676,19 @@ func (a *LoomiConcierge) triggerOrchestrator(
 		logx.KV("instruction", instruction))
 
 	// Create orchestrator request
+	run := base.RunFrom(ctx)
 	orchestratorReq := types.AgentRequest{
 		UserID:      userID,
 		SessionID:   sessionID,
 		Instruction: instruction,
-		AutoMode:    a.autoMode,
-		Selections:  a.userSelections,
+		AutoMode:    run.Options.AutoMode,
+		Selections:  run.Options.Selections,
+		UserPlan:    run.Options.UserPlan,
 	}
 
-	// Create orchestrator instance
-	orchestrator := NewLoomiOrchestrator(a.Logger, a.LLMClient)
-
 	// Process orchestrator request
-	return orchestrator.ProcessRequest(ctx, orchestratorReq, emit)
+	run.CountAction()
+	return a.orchestrator.ProcessRequest(ctx, orchestratorReq, emit)
 }
 
 // triggerWebSearch triggers web search execution
@@ -624,6 +706,8 @@ func (a *LoomiConcierge) triggerWebSearch(
 
 	// Forward to WebSearchAgent to perform actual search and emit concierge-specific events upstream
 	web := NewWebSearchAgent(a.Logger, a.LLMClient)
+	run := base.RunFrom(ctx)
+	run.CountAction()
 	// Wrap emit to remap nova3 websearch event types into concierge-specific ones for frontend
 	remapEmit := func(ev events.StreamEvent) error {
 		switch ev.Content {
@@ -633,7 +717,7 @@ func (a *LoomiConcierge) triggerWebSearch(
 		return emit(ev)
 	}
 	return web.ProcessRequest(ctx, types.AgentRequest{
-		UserID: userID, SessionID: sessionID, Instruction: keyword, AutoMode: a.autoMode, Selections: a.userSelections,
+		UserID: userID, SessionID: sessionID, Instruction: keyword, AutoMode: run.Options.AutoMode, Selections: run.Options.Selections,
 	}, remapEmit)
 }
 
@@ -644,24 +728,7 @@ func (a *LoomiConcierge) getSystemPrompt() string {
 
 // Helper methods for concierge functionality
 
-// GetExecutionStats returns current execution statistics
-func (a *LoomiConcierge) GetExecutionStats() map[string]int {
-	return map[string]int{
-		"total_llm_calls": a.totalLLMCalls,
-		"total_actions":   a.totalActions,
-	}
-}
-
-// SetAutoMode sets the auto mode configuration
-func (a *LoomiConcierge) SetAutoMode(autoMode bool) {
-	a.autoMode = autoMode
-	a.Logger.Info(context.Background(), "Updated auto mode configuration",
-		logx.KV("auto_mode", autoMode))
-}
-
-// SetUserSelections sets the user selections configuration
-func (a *LoomiConcierge) SetUserSelections(selections []string) {
-	a.userSelections = selections
-	a.Logger.Info(context.Background(), "Updated user selections configuration",
-		logx.KV("selections", selections))
+// GetExecutionStats returns the execution statistics of a run
+func (a *LoomiConcierge) GetExecutionStats(run *base.Run) map[string]int {
+	return run.Stats()
 }
@@ -21,7 +21,7 @@ type ContentAnalysisAgent struct {
 
 // NewContentAnalysisAgent creates a new ContentAnalysis agent
 func NewContentAnalysisAgent(logger *logx.Logger, client llm.Client) *ContentAnalysisAgent {
-	baseAgent := base.NewBaseLoomiAgent("loomi_content_analysis_agent", logger, client)
+	baseAgent := base.NewBaseLoomiAgent("loomi_content_analysis_agent", logger, client).WithOutputSchema(base.ItemSchema(xmlx.UnifiedConfigs["content_analysis"]))
 	return &ContentAnalysisAgent{
 		BaseLoomiAgent: baseAgent,
 		xmlParser:      xmlx.NewLoomiXMLParser(),
@@ -34,15 +34,14 @@ func (a *ContentAnalysisAgent) ProcessRequest(
 	req types.AgentRequest,
 	emit func(ev events.StreamEvent) error,
 ) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
 	a.Logger.Info(ctx, "Processing content analysis request",
 		logx.KV("user_id", req.UserID),
 		logx.KV("session_id", req.SessionID),
 		logx.KV("instruction_length", len(req.Instruction)))
 
-	// Set current session
-	a.SetCurrentSession(req.UserID, req.SessionID)
-	defer a.ClearCurrentSession()
-
 	// Clear stop state before starting
 	if err := a.ClearStopState(ctx, req.UserID, req.SessionID); err != nil {
 		a.Logger.Error(ctx, "Failed to clear stop state", logx.KV("error", err))
@@ -61,14 +60,16 @@ func (a *ContentAnalysisAgent) ProcessRequest(
 	}
 
 	// Prepare messages
+	// Uploaded images/documents (e.g. a competitor's cover) are sent alongside the prompt
 	messages := []llm.Message{
-		{Role: "system", Content: a.getSystemPrompt()},
-		{Role: "user", Content: userPrompt},
+		{Role: "system", Content: a.SystemPrompt(ctx, req, a.getSystemPrompt())},
+		{Role: "user", Content: userPrompt, Parts: a.ResolveFileParts(ctx, req)},
 	}
 
-	// Collect LLM response
+	// Collect LLM response; each content analysis is emitted and saved as soon as its closing tag arrives
 	a.Logger.Info(ctx, "Starting LLM response collection")
 	llmResponse := ""
+	stream := a.NewItemStream(req, xmlx.UnifiedConfigs["content_analysis"], events.ContentLoomiContentAnalysis, emit, a.buildContentAnalysis, a.createContentAnalysisNote)
 
 	err = a.SafeStreamCall(ctx, req.UserID, req.SessionID, messages, func(ctx context.Context, chunk string) error {
 		llmResponse += chunk
@@ -85,7 +86,7 @@ func (a *ContentAnalysisAgent) ProcessRequest(
 			}
 		}
 
-		return nil
+		return stream.Write(ctx, chunk)
 	})
 	if err != nil {
 		return err
@@ -102,17 +103,8 @@ func (a *ContentAnalysisAgent) ProcessRequest(
 	// Extract other content outside of content_analysis tags
 	otherContent := a.ExtractOtherContent(llmResponse, []string{`<content_analysis\\d+>`})
 
-	// Parse content analyses with unique IDs
-	analyses, err := a.parseContentAnalysesWithUniqueIDs(ctx, llmResponse, req)
-	if err != nil {
-		a.Logger.Error(ctx, "Failed to parse content analysis results", logx.KV("error", err))
-		// Send raw response if parsing fails
-		return emit(events.StreamEvent{
-			Type:    events.LLMChunk,
-			Content: events.ContentLoomiContentAnalysis,
-			Data:    llmResponse,
-		})
-	}
+	// Parse whatever was not streamed
+	analyses := stream.Finish(ctx, llmResponse)
 
 	a.Logger.Info(ctx, "Content analysis parsing completed",
 		logx.KV("analyses_count", len(analyses)))
@@ -128,20 +120,10 @@ func (a *ContentAnalysisAgent) ProcessRequest(
 			metadata["agent_other_message"] = otherContent
 		}
 
-		analysisEvent := events.StreamEvent{
-			Type:    events.LLMChunk,
-			Content: events.ContentLoomiContentAnalysis,
-			Data:    analyses,
-			Meta:    metadata,
-		}
-		if err := emit(analysisEvent); err != nil {
+		if err := emit(stream.FinalEvent(analyses, metadata)); err != nil {
 			return err
 		}
 
-		// Create notes concurrently
-		if err := a.createContentAnalysisNotes(ctx, req, analyses); err != nil {
-			a.Logger.Error(ctx, "Failed to create content analysis notes", logx.KV("error", err))
-		}
 	} else {
 		// Send raw response if no analyses parsed
 		return emit(events.StreamEvent{
@@ -159,74 +141,47 @@ func (a *ContentAnalysisAgent) Process(ctx context.Context, req types.AgentReque
 	return a.ProcessRequest(ctx, req, emit)
 }
 
-// parseContentAnalysesWithUniqueIDs parses LLM response and assigns unique IDs
-func (a *ContentAnalysisAgent) parseContentAnalysesWithUniqueIDs(
-	ctx context.Context,
-	response string,
-	req types.AgentRequest,
-) ([]map[string]any, error) {
-	// Parse using enhanced XML parser
-	config := xmlx.UnifiedConfigs["content_analysis"]
-	parseResults := a.xmlParser.ParseEnhanced(response, config, 1)
-
-	analyses := make([]map[string]any, 0, len(parseResults))
-	for _, result := range parseResults {
-		// Get unique ID per analysis
-		uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "content_analysis")
-		if err != nil {
-			a.Logger.Error(ctx, "Failed to get next action ID", logx.KV("error", err))
-			continue
-		}
-
-		// Ensure markdown compatibility
-		title := a.EnsureMarkdownCompatibility(result.Title)
-		if title == "" {
-			title = fmt.Sprintf("内容分析 %d", len(analyses)+1)
-		}
-		content := a.EnsureMarkdownCompatibility(result.Content)
-
-		analyses = append(analyses, map[string]any{
-			"id":      fmt.Sprintf("content_analysis%d", uniqueID),
-			"title":   title,
-			"content": content,
-			"type":    result.Type,
-		})
+// buildContentAnalysis assigns a unique ID to a parsed content analysis
+func (a *ContentAnalysisAgent) buildContentAnalysis(ctx context.Context, req types.AgentRequest, result xmlx.ParseResult, position int) (map[string]any, bool) {
+	// Get unique ID per analysis
+	uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "content_analysis")
+	if err != nil {
+		a.Logger.Error(ctx, "Failed to get next action ID", logx.KV("error", err))
+		return nil, false
 	}
 
-	a.Logger.Info(ctx, "Assigned unique IDs to content analyses",
-		logx.KV("count", len(analyses)))
-
-	return analyses, nil
+	// Ensure markdown compatibility
+	title := a.EnsureMarkdownCompatibility(result.Title)
+	if title == "" {
+		title = fmt.Sprintf("内容分析 %d", position)
+	}
+	content := a.EnsureMarkdownCompatibility(result.Content)
+
+	return map[string]any{
+		"id":      fmt.Sprintf("content_analysis%d", uniqueID),
+		"title":   title,
+		"content": content,
+		"type":    result.Type,
+	}, true
 }
 
-// createContentAnalysisNotes creates notes for content analysis results
-func (a *ContentAnalysisAgent) createContentAnalysisNotes(
-	ctx context.Context,
-	req types.AgentRequest,
-	analyses []map[string]any,
-) error {
+// createContentAnalysisNote creates the note of a content analysis
+func (a *ContentAnalysisAgent) createContentAnalysisNote(ctx context.Context, req types.AgentRequest, analysis map[string]any) {
 	if a.NotesService == nil {
-		return fmt.Errorf("notes service not available")
+		return
 	}
 
-	a.Logger.Info(ctx, "Creating content analysis notes",
-		logx.KV("count", len(analyses)))
+	id := analysis["id"].(string)
+	title := analysis["title"].(string)
+	content := analysis["content"].(string)
 
-	for _, analysis := range analyses {
-		id := analysis["id"].(string)
-		title := analysis["title"].(string)
-		content := analysis["content"].(string)
-
-		if err := a.CreateNote(ctx, req.UserID, req.SessionID, "content_analysis", id, content, title, "", nil); err != nil {
-			a.Logger.Error(ctx, "Failed to create content analysis note",
-				logx.KV("id", id),
-				logx.KV("error", err))
-		} else {
-			a.Logger.Info(ctx, "Created content analysis note", logx.KV("id", id))
-		}
+	if err := a.CreateNote(ctx, req.UserID, req.SessionID, "content_analysis", id, content, title, "", nil); err != nil {
+		a.Logger.Error(ctx, "Failed to create content analysis note",
+			logx.KV("id", id),
+			logx.KV("error", err))
+	} else {
+		a.Logger.Info(ctx, "Created content analysis note", logx.KV("id", id))
 	}
-
-	return nil
 }
 
 // getSystemPrompt returns the system prompt for content analysis
@@ -0,0 +1,315 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/blueplan/loomi-go/internal/loomi/base"
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+	xmlx "github.com/blueplan/loomi-go/internal/loomi/utils/xml"
+	"gopkg.in/yaml.v3"
+)
+
+// AgentDefinition declares an XML-tag agent in YAML (see config/agents). Only action, the parse tag
+// and a system prompt are required; everything else has a default derived from the action.
+type AgentDefinition struct {
+	Action      string `yaml:"action"`
+	AgentName   string `yaml:"agent_name"`
+	Description string `yaml:"description"`
+	// SystemPrompt is inline; SystemPromptFile is read relative to the definition file
+	SystemPrompt     string `yaml:"system_prompt"`
+	SystemPromptFile string `yaml:"system_prompt_file"`
+	Parse            struct {
+		Tag          string `yaml:"tag"`
+		TitleTag     string `yaml:"title_tag"`
+		ContentTag   string `yaml:"content_tag"`
+		CoverTextTag string `yaml:"cover_text_tag"`
+		HookTag      string `yaml:"hook_tag"`
+		Type         string `yaml:"type"`
+	} `yaml:"parse"`
+	ContentType string `yaml:"content_type"`
+	NoteAction  string `yaml:"note_action"`
+	// IDPrefix prefixes item IDs (id_prefix + sequence); defaults to the parse tag
+	IDPrefix string `yaml:"id_prefix"`
+	// DefaultTitle names untitled items; %d is replaced with the item's position
+	DefaultTitle string `yaml:"default_title"`
+	Instruction  struct {
+		// Separator: only the text after its last occurrence is shown as the event's instruction
+		Separator string `yaml:"separator"`
+		// Template wraps the instruction before context is added; {instruction} is replaced
+		Template string `yaml:"template"`
+	} `yaml:"instruction"`
+	UseFiles   bool `yaml:"use_files"`
+	AutoSelect bool `yaml:"auto_select"`
+	// Temperature is the agent's default sampling temperature; omitted means the registry default
+	Temperature *float64 `yaml:"temperature"`
+	PoolType    string   `yaml:"pool_type"`
+}
+
+// ParseConfig returns the XML parse config of the definition
+func (d AgentDefinition) ParseConfig() xmlx.ParseConfig {
+	return xmlx.ParseConfig{
+		TagName:      d.Parse.Tag,
+		TitleTag:     d.Parse.TitleTag,
+		ContentTag:   d.Parse.ContentTag,
+		CoverTextTag: d.Parse.CoverTextTag,
+		HookTag:      d.Parse.HookTag,
+		Type:         d.Parse.Type,
+	}
+}
+
+// normalize fills defaults and validates the definition
+func (d *AgentDefinition) normalize() error {
+	d.Action = strings.TrimSpace(d.Action)
+	if d.Action == "" {
+		return fmt.Errorf("action is required")
+	}
+	if d.Parse.Tag == "" {
+		return fmt.Errorf("agent %s: parse.tag is required", d.Action)
+	}
+	if strings.TrimSpace(d.SystemPrompt) == "" {
+		return fmt.Errorf("agent %s: system_prompt or system_prompt_file is required", d.Action)
+	}
+	if d.AgentName == "" {
+		d.AgentName = "loomi_" + d.Action + "_agent"
+	}
+	if d.Parse.TitleTag == "" {
+		d.Parse.TitleTag = "title"
+	}
+	if d.Parse.ContentTag == "" {
+		d.Parse.ContentTag = "content"
+	}
+	if d.Parse.Type == "" {
+		d.Parse.Type = d.Action
+	}
+	if d.ContentType == "" {
+		d.ContentType = "loomi_" + d.Action
+	}
+	if d.NoteAction == "" {
+		d.NoteAction = d.Action
+	}
+	if d.IDPrefix == "" {
+		d.IDPrefix = d.Parse.Tag
+	}
+	if d.DefaultTitle == "" {
+		d.DefaultTitle = d.Action + " %d"
+	}
+	return nil
+}
+
+// Spec converts the definition into a registry entry backed by GenericXMLAgent
+func (d AgentDefinition) Spec() base.AgentSpec {
+	return base.AgentSpec{
+		Action:      d.Action,
+		AgentName:   d.AgentName,
+		Description: d.Description,
+		ContentType: events.ContentType(d.ContentType),
+		NoteAction:  d.NoteAction,
+		AutoSelect:  d.AutoSelect,
+		Temperature: d.Temperature,
+		PoolType:    d.PoolType,
+		New: func(logger *logx.Logger, client llm.Client) types.Agent {
+			return NewGenericXMLAgent(d, logger, client)
+		},
+	}
+}
+
+// LoadAgentDefinitions reads every *.yaml / *.yml file in dir, one definition per file.
+// A missing dir yields no definitions; any invalid file fails the whole load.
+func LoadAgentDefinitions(dir string) ([]AgentDefinition, error) {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	defs := make([]AgentDefinition, 0, len(files))
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read agent definition %s: %w", file, err)
+		}
+		var def AgentDefinition
+		if err := yaml.Unmarshal(raw, &def); err != nil {
+			return nil, fmt.Errorf("parse agent definition %s: %w", file, err)
+		}
+		if def.SystemPromptFile != "" {
+			prompt, err := os.ReadFile(filepath.Join(filepath.Dir(file), def.SystemPromptFile))
+			if err != nil {
+				return nil, fmt.Errorf("read system prompt for %s: %w", file, err)
+			}
+			def.SystemPrompt = string(prompt)
+		}
+		if err := def.normalize(); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+// RegisterAgentDefinitions registers definitions in the agent registry; a definition whose
+// agent_name matches a built-in agent replaces it
+func RegisterAgentDefinitions(defs []AgentDefinition) {
+	for _, def := range defs {
+		base.RegisterAgent(def.Spec())
+	}
+}
+
+// GenericXMLAgent runs the common XML agent flow from an AgentDefinition: build the prompt,
+// stream, parse the tagged items, assign IDs, emit them and save them as notes
+type GenericXMLAgent struct {
+	*base.BaseLoomiAgent
+	def AgentDefinition
+}
+
+// NewGenericXMLAgent creates an agent from a definition
+func NewGenericXMLAgent(def AgentDefinition, logger *logx.Logger, client llm.Client) *GenericXMLAgent {
+	baseAgent := base.NewBaseLoomiAgent(def.AgentName, logger, client).WithOutputSchema(base.ItemSchema(def.ParseConfig()))
+	return &GenericXMLAgent{BaseLoomiAgent: baseAgent, def: def}
+}
+
+// ProcessRequest handles a request for the defined action
+func (a *GenericXMLAgent) ProcessRequest(
+	ctx context.Context,
+	req types.AgentRequest,
+	emit func(ev events.StreamEvent) error,
+) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
+	a.Logger.Info(ctx, "Processing generic agent request",
+		logx.KV("action", a.def.Action),
+		logx.KV("user_id", req.UserID),
+		logx.KV("session_id", req.SessionID),
+		logx.KV("instruction_length", len(req.Instruction)))
+
+	if err := a.ClearStopState(ctx, req.UserID, req.SessionID); err != nil {
+		a.Logger.Error(ctx, "Failed to clear stop state", logx.KV("error", err))
+	}
+	if err := a.CheckAndRaiseIfStopped(ctx, req.UserID, req.SessionID); err != nil {
+		return err
+	}
+
+	instruction := req.Instruction
+	if a.def.Instruction.Template != "" {
+		instruction = strings.ReplaceAll(a.def.Instruction.Template, "{instruction}", req.Instruction)
+	}
+	userPrompt, err := a.BuildCleanAgentPrompt(ctx, req.UserID, req.SessionID, instruction, a.def.Action, req.AutoMode, req.Selections)
+	if err != nil {
+		a.Logger.Error(ctx, "Failed to build prompt", logx.KV("error", err))
+		userPrompt = instruction
+	}
+
+	userMessage := llm.Message{Role: "user", Content: userPrompt}
+	if a.def.UseFiles {
+		userMessage.Parts = a.ResolveFileParts(ctx, req)
+	}
+	messages := []llm.Message{
+		{Role: "system", Content: a.SystemPrompt(ctx, req, a.def.SystemPrompt)},
+		userMessage,
+	}
+
+	contentType := events.ContentType(a.def.ContentType)
+	llmResponse := ""
+	stream := a.NewItemStream(req, a.def.ParseConfig(), contentType, emit, a.buildItem, a.createItemNote)
+	err = a.SafeStreamCall(ctx, req.UserID, req.SessionID, messages, func(ctx context.Context, chunk string) error {
+		llmResponse += chunk
+		if a.ShouldEmitThought(chunk) {
+			if err := emit(events.StreamEvent{Type: events.LLMChunk, Content: events.ContentThought, Data: chunk}); err != nil {
+				return err
+			}
+		}
+		return stream.Write(ctx, chunk)
+	})
+	if err != nil {
+		return err
+	}
+
+	a.Logger.Info(ctx, "LLM response collection completed",
+		logx.KV("action", a.def.Action),
+		logx.KV("response_length", len(llmResponse)))
+	if err := a.CheckAndRaiseIfStopped(ctx, req.UserID, req.SessionID); err != nil {
+		return err
+	}
+
+	otherContent := a.ExtractOtherContent(llmResponse, []string{`<` + regexp.QuoteMeta(a.def.Parse.Tag) + `\d+>`})
+	items := stream.Finish(ctx, llmResponse)
+	if len(items) == 0 {
+		a.Logger.Info(ctx, "Sending raw response (no items parsed)", logx.KV("action", a.def.Action))
+		return emit(events.StreamEvent{Type: events.LLMChunk, Content: contentType, Data: llmResponse})
+	}
+
+	meta := map[string]any{"instruction": a.displayInstruction(req.Instruction)}
+	if otherContent != "" {
+		meta["agent_other_message"] = otherContent
+	}
+	if err := emit(stream.FinalEvent(items, meta)); err != nil {
+		return err
+	}
+
+	a.Logger.Info(ctx, "Generic agent processing completed",
+		logx.KV("action", a.def.Action),
+		logx.KV("notes_count", len(items)))
+	return nil
+}
+
+// buildItem assigns a session-unique ID to a parsed item
+func (a *GenericXMLAgent) buildItem(ctx context.Context, req types.AgentRequest, result xmlx.ParseResult, position int) (map[string]any, bool) {
+	uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, a.def.NoteAction)
+	if err != nil {
+		a.Logger.Error(ctx, "Failed to get next action ID", logx.KV("error", err))
+		return nil, false
+	}
+
+	title := a.EnsureMarkdownCompatibility(result.Title)
+	if title == "" {
+		title = strings.ReplaceAll(a.def.DefaultTitle, "%d", fmt.Sprint(position))
+	}
+	item := map[string]any{
+		"id":         fmt.Sprintf("%s%d", a.def.IDPrefix, uniqueID),
+		"title":      title,
+		"content":    a.EnsureMarkdownCompatibility(result.Content),
+		"cover_text": result.CoverText,
+		"type":       result.Type,
+	}
+	if a.def.Parse.HookTag != "" {
+		item["hook"] = result.Hook
+	}
+	return item, true
+}
+
+// createItemNote saves an item, including its hook when the definition parses one, as a note
+// under the definition's note action
+func (a *GenericXMLAgent) createItemNote(ctx context.Context, req types.AgentRequest, it map[string]any) {
+	id := it["id"].(string)
+	hook, _ := it["hook"].(string)
+	if err := a.CreateNoteWithHook(ctx, req.UserID, req.SessionID, a.def.NoteAction, id, it["content"].(string), it["title"].(string), it["cover_text"].(string), hook, nil); err != nil {
+		a.Logger.Error(ctx, "Failed to create note",
+			logx.KV("action", a.def.Action),
+			logx.KV("id", id),
+			logx.KV("error", err))
+	}
+}
+
+// displayInstruction keeps only the part after the configured separator
+func (a *GenericXMLAgent) displayInstruction(instruction string) string {
+	if sep := a.def.Instruction.Separator; sep != "" {
+		if idx := strings.LastIndex(instruction, sep); idx != -1 {
+			return strings.TrimSpace(instruction[idx+len(sep):])
+		}
+	}
+	return instruction
+}
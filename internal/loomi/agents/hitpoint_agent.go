@@ -21,7 +21,7 @@ type HitpointAgent struct {
 
 // NewHitpointAgent creates a new hitpoint agent
 func NewHitpointAgent(logger *logx.Logger, client llm.Client) *HitpointAgent {
-	baseAgent := base.NewBaseLoomiAgent("loomi_hitpoint_agent", logger, client)
+	baseAgent := base.NewBaseLoomiAgent("loomi_hitpoint_agent", logger, client).WithOutputSchema(base.ItemSchema(xmlx.UnifiedConfigs["hitpoint"]))
 	return &HitpointAgent{
 		BaseLoomiAgent: baseAgent,
 		xmlParser:      xmlx.NewLoomiXMLParser(),
@@ -34,15 +34,14 @@ func (a *HitpointAgent) ProcessRequest(
 	req types.AgentRequest,
 	emit func(ev events.StreamEvent) error,
 ) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
 	a.Logger.Info(ctx, "Processing hitpoint analysis request",
 		logx.KV("user_id", req.UserID),
 		logx.KV("session_id", req.SessionID),
 		logx.KV("instruction_length", len(req.Instruction)))
 
-	// Set current session
-	a.SetCurrentSession(req.UserID, req.SessionID)
-	defer a.ClearCurrentSession()
-
 	// Clear stop state before starting
 	if err := a.ClearStopState(ctx, req.UserID, req.SessionID); err != nil {
 		a.Logger.Error(ctx, "Failed to clear stop state", logx.KV("error", err))
@@ -62,13 +61,14 @@ func (a *HitpointAgent) ProcessRequest(
 
 	// Prepare messages
 	messages := []llm.Message{
-		{Role: "system", Content: a.getSystemPrompt()},
+		{Role: "system", Content: a.SystemPrompt(ctx, req, a.getSystemPrompt())},
 		{Role: "user", Content: userPrompt},
 	}
 
-	// Collect LLM response
+	// Collect LLM response; each hitpoint is emitted and saved as soon as its closing tag arrives
 	a.Logger.Info(ctx, "Starting LLM response collection")
 	llmResponse := ""
+	stream := a.NewItemStream(req, xmlx.UnifiedConfigs["hitpoint"], events.ContentLoomiHitpoint, emit, a.buildHitpoint, a.createHitpointNote)
 
 	err = a.SafeStreamCall(ctx, req.UserID, req.SessionID, messages, func(ctx context.Context, chunk string) error {
 		llmResponse += chunk
@@ -85,7 +85,7 @@ func (a *HitpointAgent) ProcessRequest(
 			}
 		}
 
-		return nil
+		return stream.Write(ctx, chunk)
 	})
 
 	if err != nil {
@@ -100,17 +100,8 @@ func (a *HitpointAgent) ProcessRequest(
 		return err
 	}
 
-	// Parse hitpoint results with unique IDs
-	hitpoints, err := a.parseHitpointsWithUniqueIDs(ctx, llmResponse, req)
-	if err != nil {
-		a.Logger.Error(ctx, "Failed to parse hitpoint results", logx.KV("error", err))
-		// Send raw response if parsing fails
-		return emit(events.StreamEvent{
-			Type:    events.LLMChunk,
-			Content: events.ContentLoomiHitpoint,
-			Data:    llmResponse,
-		})
-	}
+	// Parse whatever was not streamed
+	hitpoints := stream.Finish(ctx, llmResponse)
 
 	a.Logger.Info(ctx, "Hitpoint parsing completed",
 		logx.KV("hitpoints_count", len(hitpoints)))
@@ -120,22 +111,10 @@ func (a *HitpointAgent) ProcessRequest(
 		// Process instruction for display
 		displayInstruction := a.processInstruction(req.Instruction)
 
-		hitpointEvent := events.StreamEvent{
-			Type:    events.LLMChunk,
-			Content: events.ContentLoomiHitpoint,
-			Data:    hitpoints,
-			Meta:    map[string]any{"instruction": displayInstruction},
-		}
-
-		if err := emit(hitpointEvent); err != nil {
+		if err := emit(stream.FinalEvent(hitpoints, map[string]any{"instruction": displayInstruction})); err != nil {
 			return err
 		}
 
-		// Create notes concurrently
-		if err := a.createHitpointNotes(ctx, req, hitpoints); err != nil {
-			a.Logger.Error(ctx, "Failed to create hitpoint notes", logx.KV("error", err))
-		}
-
 		a.Logger.Info(ctx, "Hitpoint processing completed",
 			logx.KV("notes_count", len(hitpoints)))
 	} else {
@@ -151,77 +130,47 @@ func (a *HitpointAgent) ProcessRequest(
 	return nil
 }
 
-// parseHitpointsWithUniqueIDs parses LLM response and assigns unique IDs
-func (a *HitpointAgent) parseHitpointsWithUniqueIDs(
-	ctx context.Context,
-	response string,
-	req types.AgentRequest,
-) ([]map[string]any, error) {
-	// Parse using enhanced XML parser
-	config := xmlx.UnifiedConfigs["hitpoint"]
-	parseResults := a.xmlParser.ParseEnhanced(response, config, 1)
-
-	// Assign unique IDs to each hitpoint
-	hitpoints := make([]map[string]any, 0, len(parseResults))
-
-	for _, result := range parseResults {
-		// Get unique ID for this hitpoint
-		uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "hitpoint")
-		if err != nil {
-			a.Logger.Error(ctx, "Failed to get next action ID", logx.KV("error", err))
-			continue
-		}
-
-		// Ensure markdown compatibility
-		title := a.EnsureMarkdownCompatibility(result.Title)
-		if title == "" {
-			title = fmt.Sprintf("打点分析 %d", len(hitpoints)+1)
-		}
-		content := a.EnsureMarkdownCompatibility(result.Content)
-
-		hitpoints = append(hitpoints, map[string]any{
-			"id":      fmt.Sprintf("hitpoint%d", uniqueID),
-			"title":   title,
-			"content": content,
-			"type":    result.Type,
-		})
+// buildHitpoint assigns a unique ID to a parsed hitpoint
+func (a *HitpointAgent) buildHitpoint(ctx context.Context, req types.AgentRequest, result xmlx.ParseResult, position int) (map[string]any, bool) {
+	uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "hitpoint")
+	if err != nil {
+		a.Logger.Error(ctx, "Failed to get next action ID", logx.KV("error", err))
+		return nil, false
 	}
 
-	a.Logger.Info(ctx, "Assigned unique IDs to hitpoints",
-		logx.KV("count", len(hitpoints)))
-
-	return hitpoints, nil
+	// Ensure markdown compatibility
+	title := a.EnsureMarkdownCompatibility(result.Title)
+	if title == "" {
+		title = fmt.Sprintf("打点分析 %d", position)
+	}
+	content := a.EnsureMarkdownCompatibility(result.Content)
+
+	return map[string]any{
+		"id":      fmt.Sprintf("hitpoint%d", uniqueID),
+		"title":   title,
+		"content": content,
+		"type":    result.Type,
+	}, true
 }
 
-// createHitpointNotes creates notes for hitpoint analysis results
-func (a *HitpointAgent) createHitpointNotes(
-	ctx context.Context,
-	req types.AgentRequest,
-	hitpoints []map[string]any,
-) error {
+// createHitpointNote creates the note of a hitpoint
+func (a *HitpointAgent) createHitpointNote(ctx context.Context, req types.AgentRequest, hitpoint map[string]any) {
 	if a.NotesService == nil {
-		return fmt.Errorf("notes service not available")
+		return
 	}
 
-	a.Logger.Info(ctx, "Creating hitpoint notes",
-		logx.KV("count", len(hitpoints)))
-
-	for _, hitpoint := range hitpoints {
-		id := hitpoint["id"].(string)
-		title := hitpoint["title"].(string)
-		content := hitpoint["content"].(string)
-
-		err := a.CreateNote(ctx, req.UserID, req.SessionID, "hitpoint", id, content, title, "", nil)
-		if err != nil {
-			a.Logger.Error(ctx, "Failed to create hitpoint note",
-				logx.KV("id", id),
-				logx.KV("error", err))
-		} else {
-			a.Logger.Info(ctx, "Created hitpoint note", logx.KV("id", id))
-		}
-	}
+	id := hitpoint["id"].(string)
+	title := hitpoint["title"].(string)
+	content := hitpoint["content"].(string)
 
-	return nil
+	err := a.CreateNote(ctx, req.UserID, req.SessionID, "hitpoint", id, content, title, "", nil)
+	if err != nil {
+		a.Logger.Error(ctx, "Failed to create hitpoint note",
+			logx.KV("id", id),
+			logx.KV("error", err))
+	} else {
+		a.Logger.Info(ctx, "Created hitpoint note", logx.KV("id", id))
+	}
 }
 
 // getSystemPrompt returns the system prompt for hitpoint analysis
@@ -21,7 +21,7 @@ type KnowledgeAgent struct {
 
 // NewKnowledgeAgent creates a new knowledge agent
 func NewKnowledgeAgent(logger *logx.Logger, client llm.Client) *KnowledgeAgent {
-	base := base.NewBaseLoomiAgent("loomi_knowledge_agent", logger, client)
+	base := base.NewBaseLoomiAgent("loomi_knowledge_agent", logger, client).WithOutputSchema(base.ItemSchema(xmlx.UnifiedConfigs["knowledge"]))
 	return &KnowledgeAgent{BaseLoomiAgent: base, xmlParser: xmlx.NewLoomiXMLParser()}
 }
 
@@ -31,14 +31,14 @@ func (a *KnowledgeAgent) ProcessRequest(
 	req types.AgentRequest,
 	emit func(ev events.StreamEvent) error,
 ) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
 	a.Logger.Info(ctx, "Processing knowledge request",
 		logx.KV("user_id", req.UserID),
 		logx.KV("session_id", req.SessionID),
 		logx.KV("instruction_length", len(req.Instruction)))
 
-	a.SetCurrentSession(req.UserID, req.SessionID)
-	defer a.ClearCurrentSession()
-
 	if err := a.ClearStopState(ctx, req.UserID, req.SessionID); err != nil {
 		a.Logger.Error(ctx, "Failed to clear stop state", logx.KV("error", err))
 	}
@@ -53,12 +53,13 @@ func (a *KnowledgeAgent) ProcessRequest(
 	}
 
 	messages := []llm.Message{
-		{Role: "system", Content: a.getSystemPrompt()},
+		{Role: "system", Content: a.SystemPrompt(ctx, req, a.getSystemPrompt())},
 		{Role: "user", Content: userPrompt},
 	}
 
 	a.Logger.Info(ctx, "Starting LLM response collection")
 	llmResponse := ""
+	stream := a.NewItemStream(req, xmlx.UnifiedConfigs["knowledge"], events.ContentLoomiKnowledge, emit, a.buildKnowledge, a.createKnowledgeNote)
 	err = a.SafeStreamCall(ctx, req.UserID, req.SessionID, messages, func(ctx context.Context, chunk string) error {
 		llmResponse += chunk
 		if a.ShouldEmitThought(chunk) {
@@ -66,7 +67,7 @@ func (a *KnowledgeAgent) ProcessRequest(
 				return err
 			}
 		}
-		return nil
+		return stream.Write(ctx, chunk)
 	})
 	if err != nil {
 		return err
@@ -79,12 +80,8 @@ func (a *KnowledgeAgent) ProcessRequest(
 
 	otherContent := a.ExtractOtherContent(llmResponse, []string{`<knowledge\\d+>`})
 
-	// Parse knowledge with unique IDs
-	items, err := a.parseKnowledgeWithUniqueIDs(ctx, llmResponse, req)
-	if err != nil {
-		a.Logger.Error(ctx, "Failed to parse knowledge", logx.KV("error", err))
-		return emit(events.StreamEvent{Type: events.LLMChunk, Content: events.ContentLoomiKnowledge, Data: llmResponse})
-	}
+	// Parse whatever was not streamed
+	items := stream.Finish(ctx, llmResponse)
 
 	if len(items) > 0 {
 		displayInstruction := a.processInstruction(req.Instruction)
@@ -92,47 +89,33 @@ func (a *KnowledgeAgent) ProcessRequest(
 		if otherContent != "" {
 			meta["agent_other_message"] = otherContent
 		}
-		if err := emit(events.StreamEvent{Type: events.LLMChunk, Content: events.ContentLoomiKnowledge, Data: items, Meta: meta}); err != nil {
-			return err
-		}
-
-		// Create notes
-		for _, it := range items {
-			_ = a.CreateNote(ctx, req.UserID, req.SessionID, "knowledge", it["id"].(string), it["content"].(string), it["title"].(string), "", nil)
-		}
-		return nil
+		return emit(stream.FinalEvent(items, meta))
 	}
 
 	return emit(events.StreamEvent{Type: events.LLMChunk, Content: events.ContentLoomiKnowledge, Data: llmResponse})
 }
 
-func (a *KnowledgeAgent) parseKnowledgeWithUniqueIDs(
-	ctx context.Context,
-	response string,
-	req types.AgentRequest,
-) ([]map[string]any, error) {
-	config := xmlx.UnifiedConfigs["knowledge"]
-	parsed := a.xmlParser.ParseEnhanced(response, config, 1)
-	items := make([]map[string]any, 0, len(parsed))
-	for _, r := range parsed {
-		uid, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "knowledge")
-		if err != nil {
-			a.Logger.Error(ctx, "NextActionID failed", logx.KV("error", err))
-			continue
-		}
-		title := a.EnsureMarkdownCompatibility(r.Title)
-		if title == "" {
-			title = fmt.Sprintf("知识点 %d", len(items)+1)
-		}
-		content := a.EnsureMarkdownCompatibility(r.Content)
-		items = append(items, map[string]any{
-			"id":      fmt.Sprintf("knowledge%d", uid),
-			"title":   title,
-			"content": content,
-			"type":    r.Type,
-		})
+func (a *KnowledgeAgent) buildKnowledge(ctx context.Context, req types.AgentRequest, r xmlx.ParseResult, position int) (map[string]any, bool) {
+	uid, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "knowledge")
+	if err != nil {
+		a.Logger.Error(ctx, "NextActionID failed", logx.KV("error", err))
+		return nil, false
 	}
-	return items, nil
+	title := a.EnsureMarkdownCompatibility(r.Title)
+	if title == "" {
+		title = fmt.Sprintf("知识点 %d", position)
+	}
+	content := a.EnsureMarkdownCompatibility(r.Content)
+	return map[string]any{
+		"id":      fmt.Sprintf("knowledge%d", uid),
+		"title":   title,
+		"content": content,
+		"type":    r.Type,
+	}, true
+}
+
+func (a *KnowledgeAgent) createKnowledgeNote(ctx context.Context, req types.AgentRequest, it map[string]any) {
+	_ = a.CreateNote(ctx, req.UserID, req.SessionID, "knowledge", it["id"].(string), it["content"].(string), it["title"].(string), "", nil)
 }
 
 func (a *KnowledgeAgent) processInstruction(instruction string) string {
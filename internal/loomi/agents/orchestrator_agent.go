@@ -2,14 +2,18 @@ package agents
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/blueplan/loomi-go/internal/loomi/base"
 	"github.com/blueplan/loomi-go/internal/loomi/events"
 	"github.com/blueplan/loomi-go/internal/loomi/llm"
 	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/plans"
 	"github.com/blueplan/loomi-go/internal/loomi/types"
 	xmlx "github.com/blueplan/loomi-go/internal/loomi/utils/xml"
 )
@@ -21,27 +25,27 @@ type LoomiOrchestrator struct {
 
 	// Configuration
 	maxIterations        int
+	tokenBudget          int
 	mergeableActions     map[string]bool
 	maxConcurrentAgents  int
 	outputInterval       float64
 	noIntervalAgentTypes map[string]bool
 
-	// Execution state
-	autoMode        bool
-	userSelections  []string
-	executedActions map[string]bool
-	totalLLMCalls   int
-	totalActions    int
+	// Sub-agents are shared across requests; per-request state lives on base.Run
+	agents *base.AgentPool
 }
 
 // NewLoomiOrchestrator creates a new orchestrator agent
 func NewLoomiOrchestrator(logger *logx.Logger, client llm.Client) *LoomiOrchestrator {
 	baseAgent := base.NewBaseLoomiAgent("loomi_orchestrator", logger, client)
+	maxIterations, tokenBudget := base.ReActLimits()
+	mergeThreshold, maxBatchSize := base.MergeLimits()
 
 	orchestrator := &LoomiOrchestrator{
 		BaseLoomiAgent:      baseAgent,
 		xmlParser:           xmlx.NewLoomiXMLParser(),
-		maxIterations:       4,
+		maxIterations:       maxIterations,
+		tokenBudget:         tokenBudget,
 		maxConcurrentAgents: 8,
 		outputInterval:      10.0,
 
@@ -62,34 +66,41 @@ func NewLoomiOrchestrator(logger *logx.Logger, client llm.Client) *LoomiOrchestr
 			"revision":       true,
 		},
 
-		// Initialize execution state
-		executedActions: make(map[string]bool),
+		agents: base.NewAgentPool(logger, client),
 	}
 
 	orchestrator.Logger.Info(context.Background(), "LoomiOrchestrator initialized",
 		logx.KV("max_iterations", orchestrator.maxIterations),
+		logx.KV("token_budget", orchestrator.tokenBudget),
 		logx.KV("max_concurrent_agents", orchestrator.maxConcurrentAgents),
 		logx.KV("mergeable_actions", orchestrator.getMergeableActionsList()),
+		logx.KV("merge_threshold", mergeThreshold),
+		logx.KV("max_batch_size", maxBatchSize),
 	)
 
 	return orchestrator
 }
 
-// ProcessRequest processes orchestrator workflow requests
+// ProcessRequest runs the orchestrator's observe→think→act loop: each iteration plans with the
+// summaries of the previous actions' outputs, executes the requested actions and feeds their
+// results back, until a finish tag, an iteration without actions, the iteration limit, the
+// token budget or the request's budget (see base.CheckBudget) ends the run. With auto mode off
+// each plan is parked for approval first (see ResumePlan).
 func (a *LoomiOrchestrator) ProcessRequest(
 	ctx context.Context,
 	req types.AgentRequest,
 	emit func(ev events.StreamEvent) error,
 ) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
+	ctx = base.WithSystemNotices(ctx, emit)
+
 	a.Logger.Info(ctx, "Processing orchestrator workflow request",
 		logx.KV("user_id", req.UserID),
 		logx.KV("session_id", req.SessionID),
 		logx.KV("instruction_length", len(req.Instruction)))
 
-	// Set current session
-	a.SetCurrentSession(req.UserID, req.SessionID)
-	defer a.ClearCurrentSession()
-
 	// Clear stop state before starting
 	if err := a.ClearStopState(ctx, req.UserID, req.SessionID); err != nil {
 		a.Logger.Error(ctx, "Failed to clear stop state", logx.KV("error", err))
@@ -107,23 +118,362 @@ func (a *LoomiOrchestrator) ProcessRequest(
 		userPrompt = req.Instruction
 	}
 
-	// Set execution mode and user selections
-	a.autoMode = req.AutoMode
-	a.userSelections = req.Selections
-	a.totalLLMCalls = 0
-	a.totalActions = 0
-
 	// Prepare messages
 	messages := []llm.Message{
-		{Role: "system", Content: a.getSystemPrompt()},
+		{Role: "system", Content: a.SystemPrompt(ctx, req, a.getSystemPrompt())},
 		{Role: "user", Content: userPrompt},
 	}
 
+	return a.runLoop(ctx, req, run, messages, nil, base.NewCheckpointer(run, req, nil, a.Logger), emit)
+}
+
+// resumePoint is where a resumed run re-enters the loop: an iteration whose plan is already
+// decided, and the outcomes of those of its actions that completed before the run stopped
+type resumePoint struct {
+	iteration int
+	response  string
+	calls     []map[string]string
+	completed map[string]*base.ActionOutcome
+	// checkpointed is set when the decision is already saved as a checkpoint
+	checkpointed bool
+}
+
+// ResumePlan continues a run parked for approval: the approved actions of the plan are executed
+// and the loop goes on from the plan's iteration. Tokens spent before parking count against the
+// budget of the resumed run.
+func (a *LoomiOrchestrator) ResumePlan(
+	ctx context.Context,
+	plan *plans.Plan,
+	emit func(ev events.StreamEvent) error,
+) error {
+	req := types.AgentRequest{
+		UserID:      plan.UserID,
+		SessionID:   plan.SessionID,
+		UserPlan:    plan.UserPlan,
+		Instruction: plan.Instruction,
+		UseFiles:    plan.UseFiles,
+		FileIDs:     plan.FileIDs,
+		Selections:  plan.Selections,
+	}
+	calls := make([]map[string]string, 0, len(plan.Actions))
+	for _, action := range plan.Actions {
+		calls = append(calls, map[string]string{"id": action.ID, "action": action.Action, "instruction": action.Instruction})
+	}
+	a.Logger.Info(ctx, "Resuming approved orchestrator plan",
+		logx.KV("plan_id", plan.ID),
+		logx.KV("parked_request_id", plan.RequestID),
+		logx.KV("iteration", plan.Iteration),
+		logx.KV("actions", len(plan.Actions)))
+	return a.resume(ctx, req, plan.TokensUsed, plan.Messages, &resumePoint{
+		iteration: plan.Iteration,
+		response:  plan.Response,
+		calls:     calls,
+	}, nil, emit)
+}
+
+// ResumeCheckpoint continues an interrupted run from its last checkpoint: actions the checkpoint
+// marks completed are not run again and their tokens are not billed again; the remaining actions
+// of the iteration run and the loop goes on.
+func (a *LoomiOrchestrator) ResumeCheckpoint(
+	ctx context.Context,
+	cp *base.Checkpoint,
+	emit func(ev events.StreamEvent) error,
+) error {
+	if cp.Status != base.CheckpointRunning {
+		return fmt.Errorf("checkpoint %s is %s, nothing to resume", cp.ID, cp.Status)
+	}
+	calls := make([]map[string]string, 0, len(cp.Actions))
+	for _, action := range cp.Actions {
+		calls = append(calls, map[string]string{"id": action.ID, "action": action.Action, "instruction": action.Instruction})
+	}
+	a.Logger.Info(ctx, "Resuming orchestrator run from checkpoint",
+		logx.KV("checkpoint_id", cp.ID),
+		logx.KV("interrupted_request_id", cp.RequestID),
+		logx.KV("iteration", cp.Iteration),
+		logx.KV("completed", len(cp.Completed)),
+		logx.KV("actions", len(cp.Actions)))
+	return a.resume(ctx, cp.Request(), cp.TokensUsed, cp.Messages, &resumePoint{
+		iteration:    cp.Iteration,
+		response:     cp.Response,
+		calls:        calls,
+		completed:    cp.Outcomes(),
+		checkpointed: true,
+	}, cp, emit)
+}
+
+// resume starts a run for req that re-enters the loop at point; tokensUsed, spent before the run
+// stopped, counts against its budget
+func (a *LoomiOrchestrator) resume(
+	ctx context.Context,
+	req types.AgentRequest,
+	tokensUsed int,
+	messages []llm.Message,
+	point *resumePoint,
+	from *base.Checkpoint,
+	emit func(ev events.StreamEvent) error,
+) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
+	ctx = base.WithSystemNotices(ctx, emit)
+	run.CountTokens(tokensUsed)
+
+	if err := a.ClearStopState(ctx, req.UserID, req.SessionID); err != nil {
+		a.Logger.Error(ctx, "Failed to clear stop state", logx.KV("error", err))
+	}
+	messages = a.RestoreFileParts(ctx, req.UserID, messages)
+	return a.runLoop(ctx, req, run, messages, point, base.NewCheckpointer(run, req, from, a.Logger), emit)
+}
+
+// runLoop iterates think→act→observe on messages, checkpointing each decision and each completed
+// action. A resume point stands in for the first iteration's planning call.
+func (a *LoomiOrchestrator) runLoop(
+	ctx context.Context,
+	req types.AgentRequest,
+	run *base.Run,
+	messages []llm.Message,
+	resume *resumePoint,
+	ckpt *base.Checkpointer,
+	emit func(ev events.StreamEvent) error,
+) error {
+	iteration, stopReason := 0, base.StopMaxIterations
+	for resume != nil || iteration < a.maxIterations {
+		var llmResponse string
+		var calls []map[string]string
+		var completed map[string]*base.ActionOutcome
+		checkpointed := false
+		if resume != nil {
+			iteration, llmResponse, calls, completed = resume.iteration, resume.response, resume.calls, resume.completed
+			checkpointed = resume.checkpointed
+			resume = nil
+		} else {
+			iteration++
+			if err := emit(base.IterationEvent(iteration, a.maxIterations, "thinking", nil)); err != nil {
+				return err
+			}
+			response, executeCalls, err := a.think(ctx, req, messages, emit)
+			if errors.Is(err, base.ErrBudgetExceeded) {
+				// The billing_summary event has told the user why; the run ends like any other stop
+				stopReason = base.StopBudgetExceeded
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := dependencyAttrs(executeCalls); err != nil {
+				// Actions here run in plan order; a plan relying on depends_on goes back for replanning
+				a.Logger.Warn(ctx, "Rejected orchestrator plan", logx.KV("iteration", iteration), logx.KV("error", err))
+				if err := emit(base.IterationEvent(iteration, a.maxIterations, "rejected", map[string]any{"error": err.Error()})); err != nil {
+					return err
+				}
+				messages = append(messages,
+					llm.Message{Role: "assistant", Content: response},
+					llm.Message{Role: "user", Content: fmt.Sprintf("[第 %d 轮计划无效] %s\n动作会按书写顺序依次执行，请去掉这些属性，把前置动作写在前面后重新规划。", iteration, err)},
+				)
+				continue
+			}
+			llmResponse, calls = response, actionCalls(executeCalls)
+			if !run.Options.AutoMode && len(calls) > 0 {
+				if parked, err := a.parkPlan(ctx, req, run, iteration, llmResponse, messages, calls, ckpt, emit); parked || err != nil {
+					return err
+				}
+			}
+		}
+		if !checkpointed {
+			ckpt.Decision(ctx, iteration, llmResponse, messages, checkpointActions(calls), run.Tokens())
+		}
+
+		outcomes := a.act(ctx, req, run, calls, completed, ckpt, emit)
+		if err := ctx.Err(); err != nil {
+			// Interrupted (shutdown, client gone): the last checkpoint stays resumable
+			return err
+		}
+		a.saveIteration(ctx, req, iteration, llmResponse, outcomes)
+
+		records := make([]map[string]any, 0, len(outcomes))
+		executed := make([]string, 0, len(outcomes))
+		for _, outcome := range outcomes {
+			records = append(records, outcome.Record())
+			executed = append(executed, outcome.Action)
+		}
+		if err := emit(base.IterationEvent(iteration, a.maxIterations, "observed", map[string]any{
+			"actions":     records,
+			"tokens_used": run.Tokens(),
+		})); err != nil {
+			return err
+		}
+
+		reason := ""
+		switch {
+		case run.BudgetErr() != nil:
+			reason = base.StopBudgetExceeded
+		case a.ShouldFinish(llmResponse):
+			reason = base.StopFinish
+		case len(outcomes) == 0:
+			reason = base.StopNoActions
+		case a.ShouldBreakAfterRound(executed, iteration):
+			reason = base.StopFinalAction
+		case a.tokenBudget > 0 && run.Tokens() >= a.tokenBudget:
+			reason = base.StopTokenBudget
+		}
+		if reason != "" {
+			stopReason = reason
+			break
+		}
+
+		// Observe: the next iteration sees this plan and what its actions produced
+		messages = append(messages,
+			llm.Message{Role: "assistant", Content: llmResponse},
+			llm.Message{Role: "user", Content: base.FormatObservations(iteration, outcomes)},
+		)
+	}
+	ckpt.Finish(ctx, base.CheckpointCompleted, "", run.Tokens())
+
+	a.Logger.Info(ctx, "Orchestrator loop completed",
+		logx.KV("iterations", iteration),
+		logx.KV("stop_reason", stopReason),
+		logx.KV("tokens_used", run.Tokens()))
+
+	return emit(base.IterationEvent(iteration, a.maxIterations, "done", map[string]any{
+		"stop_reason": stopReason,
+		"tokens_used": run.Tokens(),
+		"stats":       run.Stats(),
+	}))
+}
+
+// dependencyAttrs rejects execute calls carrying the Orchestrator's DAG attributes (id, depends_on,
+// on_failure): LoomiOrchestrator runs its actions in plan order and cannot honour them
+func dependencyAttrs(executeCalls []map[string]string) error {
+	for _, call := range executeCalls {
+		for _, attr := range []string{"id", "depends_on", "on_failure"} {
+			if call[attr] != "" {
+				return fmt.Errorf("动作 %s 使用了不支持的属性 %s", call["action"], attr)
+			}
+		}
+	}
+	return nil
+}
+
+// actionCalls keeps the requested actions that name both an action and an instruction and gives
+// them the IDs (a1, a2…) plans and checkpoints refer to them by
+func actionCalls(executeCalls []map[string]string) []map[string]string {
+	calls := make([]map[string]string, 0, len(executeCalls))
+	for _, call := range executeCalls {
+		action := strings.TrimSpace(call["action"])
+		instruction := strings.TrimSpace(call["instruction"])
+		if action == "" || instruction == "" {
+			continue
+		}
+		calls = append(calls, map[string]string{
+			"id":          fmt.Sprintf("a%d", len(calls)+1),
+			"action":      action,
+			"instruction": instruction,
+		})
+	}
+	return calls
+}
+
+// checkpointActions is the checkpointed form of an iteration's calls
+func checkpointActions(calls []map[string]string) []base.CheckpointAction {
+	actions := make([]base.CheckpointAction, 0, len(calls))
+	for _, call := range calls {
+		actions = append(actions, base.CheckpointAction{ID: call["id"], Action: call["action"], Instruction: call["instruction"]})
+	}
+	return actions
+}
+
+// parkPlan saves the iteration's plan for approval instead of executing it and ends the run with
+// an orchestrator_plan event. It reports false, and the plan runs unapproved, when no plan store
+// is configured or the plan cannot be saved.
+func (a *LoomiOrchestrator) parkPlan(
+	ctx context.Context,
+	req types.AgentRequest,
+	run *base.Run,
+	iteration int,
+	response string,
+	messages []llm.Message,
+	calls []map[string]string,
+	ckpt *base.Checkpointer,
+	emit func(ev events.StreamEvent) error,
+) (bool, error) {
+	store, ttl := base.CurrentPlanStore()
+	if store == nil {
+		return false, nil
+	}
+	plan := &plans.Plan{
+		ID:          plans.NewID(),
+		AgentName:   a.AgentName,
+		UserID:      req.UserID,
+		SessionID:   req.SessionID,
+		RequestID:   run.RequestID,
+		UserPlan:    run.Options.UserPlan,
+		Instruction: req.Instruction,
+		Selections:  run.Options.Selections,
+		UseFiles:    run.Options.UseFiles,
+		FileIDs:     run.Options.FileIDs,
+		Iteration:   iteration,
+		Response:    response,
+		Messages:    base.FileRefs(messages),
+		TokensUsed:  run.Tokens(),
+		CreatedAt:   time.Now(),
+	}
+	for _, call := range calls {
+		estimatedTokens, estimatedCost := base.EstimateAction(call["action"], call["instruction"])
+		plan.Actions = append(plan.Actions, plans.Action{
+			ID:              call["id"],
+			Action:          call["action"],
+			Instruction:     call["instruction"],
+			EstimatedTokens: estimatedTokens,
+			EstimatedCost:   estimatedCost,
+		})
+		plan.EstimatedTokens += estimatedTokens
+		plan.EstimatedCost += estimatedCost
+	}
+	if err := store.Save(ctx, plan, ttl); err != nil {
+		a.Logger.Error(ctx, "Failed to park plan, executing without approval", logx.KV("error", err))
+		return false, nil
+	}
+	ckpt.Finish(ctx, base.CheckpointAwaitingApproval, plan.ID, run.Tokens())
+
+	a.Logger.Info(ctx, "Orchestrator plan parked for approval",
+		logx.KV("plan_id", plan.ID),
+		logx.KV("iteration", iteration),
+		logx.KV("actions", len(plan.Actions)),
+		logx.KV("estimated_tokens", plan.EstimatedTokens))
+
+	if err := emit(events.StreamEvent{
+		Type:    events.LLMChunk,
+		Content: events.ContentOrchestratorPlan,
+		Data:    plan.Summary(),
+		Meta:    map[string]any{"instruction": req.Instruction},
+	}); err != nil {
+		return true, err
+	}
+	return true, emit(base.IterationEvent(iteration, a.maxIterations, "done", map[string]any{
+		"stop_reason": base.StopAwaitingApproval,
+		"plan_id":     plan.ID,
+		"tokens_used": run.Tokens(),
+		"stats":       run.Stats(),
+	}))
+}
+
+// think runs one planning call: the analysis is emitted and saved as notes, and the requested
+// actions are returned. Unparseable responses are sent raw and request no actions.
+func (a *LoomiOrchestrator) think(
+	ctx context.Context,
+	req types.AgentRequest,
+	messages []llm.Message,
+	emit func(ev events.StreamEvent) error,
+) (string, []map[string]string, error) {
 	// Collect LLM response
 	a.Logger.Info(ctx, "Starting LLM response collection")
 	llmResponse := ""
 
-	err = a.SafeStreamCall(ctx, req.UserID, req.SessionID, messages, func(ctx context.Context, chunk string) error {
+	// Native execute tool calls are queued here; <execute> tags are still parsed below as the fallback
+	var toolExecuteCalls []map[string]string
+	tools := a.buildToolSet(&toolExecuteCalls)
+
+	err := a.SafeStreamCallWithTools(ctx, req.UserID, req.SessionID, messages, tools, func(ctx context.Context, chunk string) error {
 		llmResponse += chunk
 
 		// Emit thought process if configured
@@ -142,7 +492,7 @@ func (a *LoomiOrchestrator) ProcessRequest(
 	})
 
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
 	a.Logger.Info(ctx, "LLM response collection completed",
@@ -150,7 +500,7 @@ func (a *LoomiOrchestrator) ProcessRequest(
 
 	// Check stop status again
 	if err := a.CheckAndRaiseIfStopped(ctx, req.UserID, req.SessionID); err != nil {
-		return err
+		return "", nil, err
 	}
 
 	// Parse orchestrator results with unique IDs
@@ -158,7 +508,7 @@ func (a *LoomiOrchestrator) ProcessRequest(
 	if err != nil {
 		a.Logger.Error(ctx, "Failed to parse orchestrator results", logx.KV("error", err))
 		// Send raw response if parsing fails
-		return emit(events.StreamEvent{
+		return llmResponse, nil, emit(events.StreamEvent{
 			Type:    events.LLMChunk,
 			Content: events.ContentLoomiOrchestrator,
 			Data:    llmResponse,
@@ -181,7 +531,7 @@ func (a *LoomiOrchestrator) ProcessRequest(
 		}
 
 		if err := emit(orchestratorEvent); err != nil {
-			return err
+			return "", nil, err
 		}
 
 		// Create notes concurrently
@@ -194,41 +544,170 @@ func (a *LoomiOrchestrator) ProcessRequest(
 	} else {
 		// Send raw response if no results found
 		a.Logger.Info(ctx, "Sending raw response (no orchestrator results parsed)")
-		return emit(events.StreamEvent{
+		return llmResponse, nil, emit(events.StreamEvent{
 			Type:    events.LLMChunk,
 			Content: events.ContentLoomiOrchestrator,
 			Data:    llmResponse,
 		})
 	}
 
-	// Execute actions requested by orchestrator via <execute action="..." instruction="..." /> tags
-	executeCalls := a.ProcessExecuteTags(llmResponse)
-	if len(executeCalls) > 0 {
-		a.Logger.Info(ctx, "Detected execute actions from orchestrator",
-			logx.KV("count", len(executeCalls)))
-		for _, call := range executeCalls {
-			action := strings.TrimSpace(call["action"])
-			instruction := strings.TrimSpace(call["instruction"])
-			if action == "" || instruction == "" {
-				continue
-			}
-			// Build downstream request
-			subReq := types.AgentRequest{
-				UserID:      req.UserID,
-				SessionID:   req.SessionID,
-				Instruction: instruction,
-				AutoMode:    a.autoMode,
-				Selections:  a.userSelections,
+	// Actions requested via native tool calls or <execute action="..." instruction="..." /> tags
+	return llmResponse, append(toolExecuteCalls, a.ProcessExecuteTags(llmResponse)...), nil
+}
+
+// act executes the requested actions in order and returns what each of them produced. Actions
+// already completed before a resume are not run again. Same-type mergeable actions are sent to
+// their agent as one multi-task request (see planBatches).
+func (a *LoomiOrchestrator) act(
+	ctx context.Context,
+	req types.AgentRequest,
+	run *base.Run,
+	calls []map[string]string,
+	completed map[string]*base.ActionOutcome,
+	ckpt *base.Checkpointer,
+	emit func(ev events.StreamEvent) error,
+) []*base.ActionOutcome {
+	if len(calls) == 0 {
+		return nil
+	}
+	outcomes := make([]*base.ActionOutcome, len(calls))
+	pending := make([]int, 0, len(calls))
+	pendingCalls := make([]map[string]string, 0, len(calls))
+	for i, call := range calls {
+		if outcome, ok := completed[call["id"]]; ok {
+			outcomes[i] = outcome
+			continue
+		}
+		pending = append(pending, i)
+		pendingCalls = append(pendingCalls, call)
+	}
+	a.Logger.Info(ctx, "Detected execute actions from orchestrator",
+		logx.KV("count", len(calls)),
+		logx.KV("completed", len(calls)-len(pending)))
+
+	for _, batch := range a.planBatches(pendingCalls) {
+		action := pendingCalls[batch[0]]["action"]
+		batchOutcomes := make([]*base.ActionOutcome, len(batch))
+		tasks := make([]string, len(batch))
+		for i, b := range batch {
+			call := pendingCalls[b]
+			tasks[i] = call["instruction"]
+			batchOutcomes[i] = &base.ActionOutcome{ID: call["id"], Action: action, Instruction: tasks[i]}
+			outcomes[pending[b]] = batchOutcomes[i]
+		}
+
+		// Once the budget is used up the remaining actions are skipped, not started
+		if err := base.CheckBudget(ctx, a.TokenAccumulator, req.UserID, req.SessionID); err != nil {
+			for _, outcome := range batchOutcomes {
+				outcome.Skipped = true
+				outcome.Err = err
+				ckpt.ActionDone(ctx, outcome, run.Tokens())
 			}
-			if err := a.executeAction(ctx, action, subReq, emit); err != nil {
-				a.Logger.Error(ctx, "Execute action failed",
-					logx.KV("action", action),
-					logx.KV("error", err))
+			continue
+		}
+
+		// Build downstream request
+		subReq := types.AgentRequest{
+			UserID:      req.UserID,
+			SessionID:   req.SessionID,
+			Instruction: tasks[0],
+			UseFiles:    req.UseFiles,
+			FileIDs:     req.FileIDs,
+			AutoMode:    run.Options.AutoMode,
+			Selections:  run.Options.Selections,
+		}
+		observed := base.SplitBatch(emit, batchOutcomes)
+		if len(batch) > 1 {
+			subReq.Instruction = base.BatchInstruction(itemTag(action), tasks)
+			subReq.Tasks = tasks
+			a.Logger.Info(ctx, "Merged same-type actions into one request",
+				logx.KV("action", action),
+				logx.KV("count", len(batch)))
+		}
+
+		err := a.executeAction(ctx, action, subReq, observed)
+		if err != nil {
+			a.Logger.Error(ctx, "Execute action failed",
+				logx.KV("action", action),
+				logx.KV("error", err))
+		}
+		for _, outcome := range batchOutcomes {
+			outcome.Err = err
+			ckpt.ActionDone(ctx, outcome, run.Tokens())
+		}
+	}
+	return outcomes
+}
+
+// planBatches groups the calls into executions. Mergeable actions requested at least the merge
+// threshold times are merged into batches of up to the max batch size (see base.SetMergeLimits);
+// every other call runs on its own. A batch runs at the position of its last call, so calls requested before any of
+// its members have finished first.
+func (a *LoomiOrchestrator) planBatches(calls []map[string]string) [][]int {
+	mergeThreshold, maxBatchSize := base.MergeLimits()
+	groups := map[string][]int{}
+	for i, call := range calls {
+		groups[call["action"]] = append(groups[call["action"]], i)
+	}
+
+	batchAt := make(map[int][]int, len(calls))
+	for action, indexes := range groups {
+		if !a.mergeableActions[action] || len(indexes) < mergeThreshold {
+			for _, i := range indexes {
+				batchAt[i] = []int{i}
 			}
+			continue
+		}
+		for len(indexes) > 0 {
+			n := min(maxBatchSize, len(indexes))
+			batchAt[indexes[n-1]] = indexes[:n]
+			indexes = indexes[n:]
 		}
 	}
 
-	return nil
+	batches := make([][]int, 0, len(batchAt))
+	for i := range calls {
+		if batch, ok := batchAt[i]; ok {
+			batches = append(batches, batch)
+		}
+	}
+	return batches
+}
+
+// itemTag returns the tag an action's agent writes its items in
+func itemTag(action string) string {
+	if cfg, ok := xmlx.ContentConfigs[action]; ok {
+		return cfg.TagName
+	}
+	if cfg, ok := xmlx.UnifiedConfigs[action]; ok {
+		return cfg.TagName
+	}
+	return action
+}
+
+// saveIteration persists an iteration's plan and the summaries of its actions' outputs
+func (a *LoomiOrchestrator) saveIteration(
+	ctx context.Context,
+	req types.AgentRequest,
+	iteration int,
+	response string,
+	outcomes []*base.ActionOutcome,
+) {
+	if a.ContextManager == nil {
+		return
+	}
+	observations := make([]map[string]any, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		observations = append(observations, outcome.Record())
+	}
+	if err := a.ContextManager.UpdateOrchestratorCallResponse(req.UserID, req.SessionID, iteration-1, response, map[string]any{
+		"iteration":    iteration,
+		"observations": observations,
+	}); err != nil {
+		a.Logger.Error(ctx, "Failed to save orchestrator iteration",
+			logx.KV("iteration", iteration),
+			logx.KV("error", err))
+	}
 }
 
 // executeAction maps action type to a concrete agent and runs it (logic mirrors Python _create_agent_by_type)
@@ -238,43 +717,12 @@ func (a *LoomiOrchestrator) executeAction(
 	req types.AgentRequest,
 	emit func(ev events.StreamEvent) error,
 ) error {
-	switch action {
-	case "xhs_post":
-		ag := NewXHSPostAgent(a.Logger, a.LLMClient)
-		return ag.ProcessRequest(ctx, req, emit)
-	case "wechat_article":
-		ag := NewWeChatArticleAgent(a.Logger, a.LLMClient)
-		return ag.ProcessRequest(ctx, req, emit)
-	case "hitpoint":
-		ag := NewHitpointAgent(a.Logger, a.LLMClient)
-		return ag.ProcessRequest(ctx, req, emit)
-	case "persona":
-		ag := NewPersonaAgent(a.Logger, a.LLMClient)
-		return ag.ProcessRequest(ctx, req, emit)
-	case "websearch":
-		ag := NewWebSearchAgent(a.Logger, a.LLMClient)
-		return ag.ProcessRequest(ctx, req, emit)
-	case "tiktok_script":
-		ag := NewTikTokScriptAgent(a.Logger, a.LLMClient)
-		return ag.Process(ctx, req, emit)
-	case "brand_analysis":
-		ag := NewBrandAnalysisAgent(a.Logger, a.LLMClient)
-		return ag.ProcessRequest(ctx, req, emit)
-	case "content_analysis":
-		ag := NewContentAnalysisAgent(a.Logger, a.LLMClient)
-		return ag.ProcessRequest(ctx, req, emit)
-	case "knowledge":
-		ag := NewKnowledgeAgent(a.Logger, a.LLMClient)
-		return ag.ProcessRequest(ctx, req, emit)
-	case "resonant":
-		ag := NewResonantAgent(a.Logger, a.LLMClient)
-		return ag.ProcessRequest(ctx, req, emit)
-	case "revision":
-		ag := NewRevisionAgent(a.Logger, a.LLMClient)
-		return ag.ProcessRequest(ctx, req, emit)
-	default:
+	ag := a.agents.Get(action)
+	if ag == nil {
 		return fmt.Errorf("unknown action: %s", action)
 	}
+	base.RunFrom(ctx).CountAction()
+	return ag.ProcessRequest(ctx, req, emit)
 }
 
 // parseOrchestratorResultsWithUniqueIDs parses LLM response and assigns unique IDs
@@ -285,7 +733,7 @@ func (a *LoomiOrchestrator) parseOrchestratorResultsWithUniqueIDs(
 ) ([]map[string]any, error) {
 	// Parse using enhanced XML parser
 	config := xmlx.UnifiedConfigs["orchestrator"]
-	parseResults := a.xmlParser.ParseEnhanced(response, config, 1)
+	parseResults := a.ParseStructured(ctx, req.UserID, req.SessionID, response, config)
 
 	// Assign unique IDs to each orchestrator result
 	results := make([]map[string]any, 0, len(parseResults))
@@ -389,23 +837,65 @@ func (a *LoomiOrchestrator) RemoveMergeableAction(actionType string) {
 		logx.KV("action_type", actionType))
 }
 
-// ProcessExecuteTags processes execute tags in orchestrator response
+// buildToolSet declares the native execute tool; calls are queued and run after the plan is emitted
+func (a *LoomiOrchestrator) buildToolSet(executeCalls *[]map[string]string) *llm.ToolSet {
+	tools := llm.NewToolSet()
+	tools.Register(llm.Tool{
+		Name:        "execute",
+		Description: "调度一个子智能体执行任务",
+		Parameters:  executeToolSchema(),
+	}, func(ctx context.Context, raw json.RawMessage) (string, error) {
+		var args map[string]any
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return "", err
+		}
+		// Every argument is kept, so dependency attributes the schema does not offer are rejected
+		call := make(map[string]string, len(args))
+		for k, v := range args {
+			call[k] = strings.TrimSpace(fmt.Sprint(v))
+		}
+		*executeCalls = append(*executeCalls, call)
+		return fmt.Sprintf("已排队执行 %s", call["action"]), nil
+	})
+	return tools
+}
+
+// executeToolSchema declares the execute tool's arguments, offering every registered action
+func executeToolSchema() json.RawMessage {
+	var actions []string
+	for _, spec := range base.RegisteredAgents() {
+		if spec.Action != "" {
+			actions = append(actions, spec.Action)
+		}
+	}
+	schema, _ := json.Marshal(map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"action":      map[string]any{"type": "string", "enum": actions},
+			"instruction": map[string]any{"type": "string", "description": "交给该智能体的任务说明"},
+		},
+		"required": []string{"action", "instruction"},
+	})
+	return schema
+}
+
+var (
+	executePattern = regexp.MustCompile(`<execute\s+((?:[a-zA-Z_]+="[^"]*"\s*)+)/>`)
+	executeAttrs   = regexp.MustCompile(`([a-zA-Z_]+)="([^"]*)"`)
+)
+
+// ProcessExecuteTags processes execute tags in orchestrator response. Every attribute is kept, so
+// tags carrying attributes other than action and instruction are rejected rather than dropped.
 func (a *LoomiOrchestrator) ProcessExecuteTags(response string) []map[string]string {
-	// Extract execute tags from response
-	executePattern := regexp.MustCompile(`<execute\s+action="([^"]+)"\s+instruction="([^"]+)"\s*/>`)
 	matches := executePattern.FindAllStringSubmatch(response, -1)
 
 	executeActions := make([]map[string]string, 0, len(matches))
 	for _, match := range matches {
-		if len(match) >= 3 {
-			action := strings.TrimSpace(match[1])
-			instruction := strings.TrimSpace(match[2])
-
-			executeActions = append(executeActions, map[string]string{
-				"action":      action,
-				"instruction": instruction,
-			})
+		call := map[string]string{}
+		for _, attr := range executeAttrs.FindAllStringSubmatch(match[1], -1) {
+			call[attr[1]] = strings.TrimSpace(attr[2])
 		}
+		executeActions = append(executeActions, call)
 	}
 
 	return executeActions
@@ -437,28 +927,15 @@ func (a *LoomiOrchestrator) ShouldBreakAfterRound(executedActions []string, roun
 // ShouldFinish checks if orchestrator should finish execution
 func (a *LoomiOrchestrator) ShouldFinish(response string) bool {
 	// Check for ORCHESTRATOR_DECLARATION or other finish patterns
-	finishPatterns := []string{
-		`<ORCHESTRATOR_DECLARATION`,
-		`<finish>`,
-		`<complete>`,
-		`<done>`,
-	}
-
-	for _, pattern := range finishPatterns {
-		if strings.Contains(strings.ToUpper(response), strings.ToUpper(pattern)) {
-			a.Logger.Info(context.Background(), "Detected finish pattern",
-				logx.KV("pattern", pattern))
-			return true
-		}
+	pattern, ok := base.FinishTag(response)
+	if ok {
+		a.Logger.Info(context.Background(), "Detected finish pattern",
+			logx.KV("pattern", pattern))
 	}
-
-	return false
+	return ok
 }
 
-// GetExecutionStats returns current execution statistics
-func (a *LoomiOrchestrator) GetExecutionStats() map[string]int {
-	return map[string]int{
-		"total_llm_calls": a.totalLLMCalls,
-		"total_actions":   a.totalActions,
-	}
+// GetExecutionStats returns the execution statistics of a run
+func (a *LoomiOrchestrator) GetExecutionStats(run *base.Run) map[string]int {
+	return run.Stats()
 }
@@ -51,10 +51,6 @@ func (a *PersonaAgent) ProcessRequest(ctx context.Context, request *types.AgentR
 		"session_id", sessionID,
 		"use_files", useFiles)
 
-	// 设置当前会话信息
-	a.SetCurrentSession(userID, sessionID)
-	defer a.ClearCurrentSession()
-
 	// 构建用户提示词
 	userPrompt, err := a.BuildCleanAgentPrompt(ctx, userID, sessionID, instruction, "persona", autoMode, userSelections)
 	if err != nil {
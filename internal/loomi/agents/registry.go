@@ -0,0 +1,164 @@
+package agents
+
+import (
+	"github.com/blueplan/loomi-go/internal/loomi/base"
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+)
+
+// builtinAgents are the agents shipped with Loomi; orchestrators resolve actions through base.LookupAction
+var builtinAgents = []base.AgentSpec{
+	{
+		AgentName:   "loomi_concierge",
+		Description: "接待员：理解需求、记录素材并转交编排器",
+		ContentType: events.ContentLoomiConcierge,
+		NoteAction:  "concierge",
+		Temperature: llm.Float64(0.4),
+		PoolType:    "high_priority",
+		New: func(logger *logx.Logger, client llm.Client) types.Agent {
+			return NewLoomiConcierge(logger, client)
+		},
+	},
+	{
+		AgentName:   "loomi_orchestrator",
+		Description: "编排器：规划并调度子智能体",
+		ContentType: events.ContentLoomiOrchestrator,
+		NoteAction:  "orchestrator",
+		Temperature: llm.Float64(0.5),
+		PoolType:    "high_priority",
+		New: func(logger *logx.Logger, client llm.Client) types.Agent {
+			return NewLoomiOrchestrator(logger, client)
+		},
+	},
+	{
+		Action:      "xhs_post",
+		AgentName:   "loomi_xhs_post_agent",
+		Description: "小红书笔记创作",
+		ContentType: events.ContentLoomiXHSPost,
+		NoteAction:  "xhs_post",
+		Temperature: llm.Float64(0.6),
+		New: func(logger *logx.Logger, client llm.Client) types.Agent {
+			return NewXHSPostAgent(logger, client)
+		},
+	},
+	{
+		Action:      "wechat_article",
+		AgentName:   "loomi_wechat_article_agent",
+		Description: "公众号文章创作",
+		ContentType: events.ContentLoomiWeChatArticle,
+		NoteAction:  "wechat_article",
+		Temperature: llm.Float64(0.6),
+		New: func(logger *logx.Logger, client llm.Client) types.Agent {
+			return NewWeChatArticleAgent(logger, client)
+		},
+	},
+	{
+		Action:      "hitpoint",
+		AgentName:   "loomi_hitpoint_agent",
+		Description: "内容打点（选题切入点）",
+		ContentType: events.ContentLoomiHitpoint,
+		NoteAction:  "hitpoint",
+		Temperature: llm.Float64(0.6),
+		New: func(logger *logx.Logger, client llm.Client) types.Agent {
+			return NewHitpointAgent(logger, client)
+		},
+	},
+	{
+		Action:      "persona",
+		AgentName:   "loomi_persona_agent",
+		Description: "受众画像分析",
+		ContentType: events.ContentLoomiPersona,
+		NoteAction:  "persona",
+		AutoSelect:  true,
+		New: func(logger *logx.Logger, client llm.Client) types.Agent {
+			return NewPersonaAgent(logger, client)
+		},
+	},
+	{
+		Action:      "websearch",
+		AgentName:   "loomi_websearch_agent",
+		Description: "联网搜索并总结",
+		ContentType: events.ContentNova3Websearch,
+		NoteAction:  "websearch",
+		AutoSelect:  true,
+		Temperature: llm.Float64(0.1),
+		New: func(logger *logx.Logger, client llm.Client) types.Agent {
+			return NewWebSearchAgent(logger, client)
+		},
+	},
+	{
+		Action:      "tiktok_script",
+		AgentName:   "loomi_tiktok_script_agent",
+		Description: "抖音短视频脚本创作",
+		ContentType: events.ContentLoomiTikTokScript,
+		NoteAction:  "tiktok_script",
+		Temperature: llm.Float64(0.6),
+		New: func(logger *logx.Logger, client llm.Client) types.Agent {
+			return NewTikTokScriptAgent(logger, client)
+		},
+	},
+	{
+		Action:      "brand_analysis",
+		AgentName:   "loomi_brand_analysis_agent",
+		Description: "品牌分析",
+		ContentType: events.ContentLoomiBrandAnalysis,
+		NoteAction:  "brand_analysis",
+		AutoSelect:  true,
+		New: func(logger *logx.Logger, client llm.Client) types.Agent {
+			return NewBrandAnalysisAgent(logger, client)
+		},
+	},
+	{
+		Action:      "content_analysis",
+		AgentName:   "loomi_content_analysis_agent",
+		Description: "内容分析",
+		ContentType: events.ContentLoomiContentAnalysis,
+		NoteAction:  "content_analysis",
+		AutoSelect:  true,
+		New: func(logger *logx.Logger, client llm.Client) types.Agent {
+			return NewContentAnalysisAgent(logger, client)
+		},
+	},
+	{
+		Action:      "knowledge",
+		AgentName:   "loomi_knowledge_agent",
+		Description: "行业知识整理",
+		ContentType: events.ContentLoomiKnowledge,
+		NoteAction:  "knowledge",
+		AutoSelect:  true,
+		Temperature: llm.Float64(0.3),
+		New: func(logger *logx.Logger, client llm.Client) types.Agent {
+			return NewKnowledgeAgent(logger, client)
+		},
+	},
+	{
+		Action:      "resonant",
+		AgentName:   "loomi_resonant_agent",
+		Description: "情绪共鸣点挖掘",
+		ContentType: events.ContentLoomiResonant,
+		NoteAction:  "resonant",
+		AutoSelect:  true,
+		New: func(logger *logx.Logger, client llm.Client) types.Agent {
+			return NewResonantAgent(logger, client)
+		},
+	},
+	{
+		Action:      "revision",
+		AgentName:   "loomi_revision_agent",
+		Description: "按反馈修改已有内容",
+		ContentType: events.ContentLoomiRevision,
+		NoteAction:  "revision",
+		Temperature: llm.Float64(0.6),
+		New: func(logger *logx.Logger, client llm.Client) types.Agent {
+			return NewRevisionAgent(logger, client)
+		},
+	},
+}
+
+func init() {
+	for _, spec := range builtinAgents {
+		base.RegisterAgent(spec)
+	}
+}
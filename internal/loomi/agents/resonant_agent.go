@@ -21,7 +21,7 @@ type ResonantAgent struct {
 
 // NewResonantAgent creates a new resonant agent
 func NewResonantAgent(logger *logx.Logger, client llm.Client) *ResonantAgent {
-	baseAgent := base.NewBaseLoomiAgent("loomi_resonant_agent", logger, client)
+	baseAgent := base.NewBaseLoomiAgent("loomi_resonant_agent", logger, client).WithOutputSchema(base.ItemSchema(xmlx.UnifiedConfigs["resonant"]))
 	return &ResonantAgent{
 		BaseLoomiAgent: baseAgent,
 		xmlParser:      xmlx.NewLoomiXMLParser(),
@@ -34,15 +34,14 @@ func (a *ResonantAgent) ProcessRequest(
 	req types.AgentRequest,
 	emit func(ev events.StreamEvent) error,
 ) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
 	a.Logger.Info(ctx, "Processing resonant analysis request",
 		logx.KV("user_id", req.UserID),
 		logx.KV("session_id", req.SessionID),
 		logx.KV("instruction_length", len(req.Instruction)))
 
-	// Set current session
-	a.SetCurrentSession(req.UserID, req.SessionID)
-	defer a.ClearCurrentSession()
-
 	// Clear stop state before starting
 	if err := a.ClearStopState(ctx, req.UserID, req.SessionID); err != nil {
 		a.Logger.Error(ctx, "Failed to clear stop state", logx.KV("error", err))
@@ -62,13 +61,14 @@ func (a *ResonantAgent) ProcessRequest(
 
 	// Prepare messages
 	messages := []llm.Message{
-		{Role: "system", Content: a.getSystemPrompt()},
+		{Role: "system", Content: a.SystemPrompt(ctx, req, a.getSystemPrompt())},
 		{Role: "user", Content: userPrompt},
 	}
 
-	// Collect LLM response
+	// Collect LLM response; each resonant is emitted and saved as soon as its closing tag arrives
 	a.Logger.Info(ctx, "Starting LLM response collection")
 	llmResponse := ""
+	stream := a.NewItemStream(req, xmlx.UnifiedConfigs["resonant"], events.ContentLoomiResonant, emit, a.buildResonant, a.createResonantNote)
 
 	err = a.SafeStreamCall(ctx, req.UserID, req.SessionID, messages, func(ctx context.Context, chunk string) error {
 		llmResponse += chunk
@@ -85,7 +85,7 @@ func (a *ResonantAgent) ProcessRequest(
 			}
 		}
 
-		return nil
+		return stream.Write(ctx, chunk)
 	})
 
 	if err != nil {
@@ -103,17 +103,8 @@ func (a *ResonantAgent) ProcessRequest(
 	// Extract other content outside of resonant tags
 	otherContent := a.ExtractOtherContent(llmResponse, []string{`<resonant\\d+>`})
 
-	// Parse resonant results with unique IDs
-	resonants, err := a.parseResonantsWithUniqueIDs(ctx, llmResponse, req)
-	if err != nil {
-		a.Logger.Error(ctx, "Failed to parse resonant results", logx.KV("error", err))
-		// Send raw response if parsing fails
-		return emit(events.StreamEvent{
-			Type:    events.LLMChunk,
-			Content: events.ContentLoomiResonant,
-			Data:    llmResponse,
-		})
-	}
+	// Parse whatever was not streamed
+	resonants := stream.Finish(ctx, llmResponse)
 
 	a.Logger.Info(ctx, "Resonant parsing completed",
 		logx.KV("resonants_count", len(resonants)))
@@ -129,22 +120,10 @@ func (a *ResonantAgent) ProcessRequest(
 			metadata["agent_other_message"] = otherContent
 		}
 
-		resonantEvent := events.StreamEvent{
-			Type:    events.LLMChunk,
-			Content: events.ContentLoomiResonant,
-			Data:    resonants,
-			Meta:    metadata,
-		}
-
-		if err := emit(resonantEvent); err != nil {
+		if err := emit(stream.FinalEvent(resonants, metadata)); err != nil {
 			return err
 		}
 
-		// Create notes concurrently
-		if err := a.createResonantNotes(ctx, req, resonants); err != nil {
-			a.Logger.Error(ctx, "Failed to create resonant notes", logx.KV("error", err))
-		}
-
 		a.Logger.Info(ctx, "Resonant processing completed",
 			logx.KV("notes_count", len(resonants)))
 	} else {
@@ -165,77 +144,47 @@ func (a *ResonantAgent) Process(ctx context.Context, req types.AgentRequest, emi
 	return a.ProcessRequest(ctx, req, emit)
 }
 
-// parseResonantsWithUniqueIDs parses LLM response and assigns unique IDs
-func (a *ResonantAgent) parseResonantsWithUniqueIDs(
-	ctx context.Context,
-	response string,
-	req types.AgentRequest,
-) ([]map[string]any, error) {
-	// Parse using enhanced XML parser
-	config := xmlx.UnifiedConfigs["resonant"]
-	parseResults := a.xmlParser.ParseEnhanced(response, config, 1)
-
-	// Assign unique IDs to each resonant
-	resonants := make([]map[string]any, 0, len(parseResults))
-
-	for _, result := range parseResults {
-		// Get unique ID for this resonant
-		uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "resonant")
-		if err != nil {
-			a.Logger.Error(ctx, "Failed to get next action ID", logx.KV("error", err))
-			continue
-		}
-
-		// Ensure markdown compatibility
-		title := a.EnsureMarkdownCompatibility(result.Title)
-		if title == "" {
-			title = fmt.Sprintf("共鸣分析 %d", len(resonants)+1)
-		}
-		content := a.EnsureMarkdownCompatibility(result.Content)
-
-		resonants = append(resonants, map[string]any{
-			"id":      fmt.Sprintf("resonant%d", uniqueID),
-			"title":   title,
-			"content": content,
-			"type":    result.Type,
-		})
+// buildResonant assigns a unique ID to a parsed resonant
+func (a *ResonantAgent) buildResonant(ctx context.Context, req types.AgentRequest, result xmlx.ParseResult, position int) (map[string]any, bool) {
+	uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "resonant")
+	if err != nil {
+		a.Logger.Error(ctx, "Failed to get next action ID", logx.KV("error", err))
+		return nil, false
 	}
 
-	a.Logger.Info(ctx, "Assigned unique IDs to resonants",
-		logx.KV("count", len(resonants)))
-
-	return resonants, nil
+	// Ensure markdown compatibility
+	title := a.EnsureMarkdownCompatibility(result.Title)
+	if title == "" {
+		title = fmt.Sprintf("共鸣分析 %d", position)
+	}
+	content := a.EnsureMarkdownCompatibility(result.Content)
+
+	return map[string]any{
+		"id":      fmt.Sprintf("resonant%d", uniqueID),
+		"title":   title,
+		"content": content,
+		"type":    result.Type,
+	}, true
 }
 
-// createResonantNotes creates notes for resonant analysis results
-func (a *ResonantAgent) createResonantNotes(
-	ctx context.Context,
-	req types.AgentRequest,
-	resonants []map[string]any,
-) error {
+// createResonantNote creates the note of a resonant
+func (a *ResonantAgent) createResonantNote(ctx context.Context, req types.AgentRequest, resonant map[string]any) {
 	if a.NotesService == nil {
-		return fmt.Errorf("notes service not available")
+		return
 	}
 
-	a.Logger.Info(ctx, "Creating resonant notes",
-		logx.KV("count", len(resonants)))
-
-	for _, resonant := range resonants {
-		id := resonant["id"].(string)
-		title := resonant["title"].(string)
-		content := resonant["content"].(string)
-
-		err := a.CreateNote(ctx, req.UserID, req.SessionID, "resonant", id, content, title, "", nil)
-		if err != nil {
-			a.Logger.Error(ctx, "Failed to create resonant note",
-				logx.KV("id", id),
-				logx.KV("error", err))
-		} else {
-			a.Logger.Info(ctx, "Created resonant note", logx.KV("id", id))
-		}
-	}
+	id := resonant["id"].(string)
+	title := resonant["title"].(string)
+	content := resonant["content"].(string)
 
-	return nil
+	err := a.CreateNote(ctx, req.UserID, req.SessionID, "resonant", id, content, title, "", nil)
+	if err != nil {
+		a.Logger.Error(ctx, "Failed to create resonant note",
+			logx.KV("id", id),
+			logx.KV("error", err))
+	} else {
+		a.Logger.Info(ctx, "Created resonant note", logx.KV("id", id))
+	}
 }
 
 // getSystemPrompt returns the system prompt for resonant analysis
@@ -8,6 +8,7 @@ import (
 	"github.com/blueplan/loomi-go/internal/loomi/events"
 	"github.com/blueplan/loomi-go/internal/loomi/llm"
 	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/monitoring"
 	"github.com/blueplan/loomi-go/internal/loomi/types"
 	xmlx "github.com/blueplan/loomi-go/internal/loomi/utils/xml"
 )
@@ -18,7 +19,7 @@ type RevisionAgent struct {
 }
 
 func NewRevisionAgent(logger *logx.Logger, client llm.Client) *RevisionAgent {
-	base := base.NewBaseLoomiAgent("loomi_revision_agent", logger, client)
+	base := base.NewBaseLoomiAgent("loomi_revision_agent", logger, client).WithOutputSchema(base.ItemSchema(xmlx.UnifiedConfigs["revision"]))
 	return &RevisionAgent{BaseLoomiAgent: base, xmlParser: xmlx.NewLoomiXMLParser()}
 }
 
@@ -31,14 +32,14 @@ func (a *RevisionAgent) ProcessRequest(
 	req types.AgentRequest,
 	emit func(ev events.StreamEvent) error,
 ) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
 	a.Logger.Info(ctx, "Processing revision request",
 		logx.KV("user_id", req.UserID),
 		logx.KV("session_id", req.SessionID),
 		logx.KV("instruction_length", len(req.Instruction)))
 
-	a.SetCurrentSession(req.UserID, req.SessionID)
-	defer a.ClearCurrentSession()
-
 	if err := a.ClearStopState(ctx, req.UserID, req.SessionID); err != nil {
 		a.Logger.Error(ctx, "Failed to clear stop state", logx.KV("error", err))
 	}
@@ -46,16 +47,20 @@ func (a *RevisionAgent) ProcessRequest(
 		return err
 	}
 
+	// A revision request counts against the prompt variants the session was served
+	monitoring.DefaultExperimentMetrics.RecordRevision(req.UserID, req.SessionID)
+
 	userPrompt, err := a.BuildCleanAgentPrompt(ctx, req.UserID, req.SessionID, req.Instruction, "revision", req.AutoMode, req.Selections)
 	if err != nil {
 		a.Logger.Error(ctx, "Failed to build prompt", logx.KV("error", err))
 		userPrompt = req.Instruction
 	}
 
-	messages := []llm.Message{{Role: "system", Content: a.getSystemPrompt()}, {Role: "user", Content: userPrompt}}
+	messages := []llm.Message{{Role: "system", Content: a.SystemPrompt(ctx, req, a.getSystemPrompt())}, {Role: "user", Content: userPrompt}}
 
 	a.Logger.Info(ctx, "Starting LLM response collection")
 	llmResponse := ""
+	stream := a.NewItemStream(req, xmlx.UnifiedConfigs["revision"], events.ContentLoomiRevision, emit, a.buildRevision, a.createRevisionNote)
 	err = a.SafeStreamCall(ctx, req.UserID, req.SessionID, messages, func(ctx context.Context, chunk string) error {
 		llmResponse += chunk
 		if a.ShouldEmitThought(chunk) {
@@ -63,7 +68,7 @@ func (a *RevisionAgent) ProcessRequest(
 				return err
 			}
 		}
-		return nil
+		return stream.Write(ctx, chunk)
 	})
 	if err != nil {
 		return err
@@ -74,37 +79,40 @@ func (a *RevisionAgent) ProcessRequest(
 		return err
 	}
 
-	config := xmlx.UnifiedConfigs["revision"]
-	parsed := a.xmlParser.ParseEnhanced(llmResponse, config, 1)
-	items := make([]map[string]any, 0, len(parsed))
-	for _, r := range parsed {
-		uid, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "revision")
-		if err != nil {
-			a.Logger.Error(ctx, "NextActionID failed", logx.KV("error", err))
-			continue
-		}
-		title := a.EnsureMarkdownCompatibility(r.Title)
-		if title == "" {
-			title = fmt.Sprintf("修订建议 %d", len(items)+1)
-		}
-		content := a.EnsureMarkdownCompatibility(r.Content)
-		items = append(items, map[string]any{"id": fmt.Sprintf("revision%d", uid), "title": title, "content": content, "type": r.Type})
-	}
+	items := stream.Finish(ctx, llmResponse)
 
 	if len(items) > 0 {
-		ev := events.StreamEvent{Type: events.LLMChunk, Content: events.ContentLoomiRevision, Data: items, Meta: map[string]any{"instruction": req.Instruction}}
-		if err := emit(ev); err != nil {
-			return err
-		}
-		for _, it := range items {
-			_ = a.CreateNote(ctx, req.UserID, req.SessionID, "revision", it["id"].(string), it["content"].(string), it["title"].(string), "", nil)
-		}
-		return nil
+		return emit(stream.FinalEvent(items, map[string]any{"instruction": req.Instruction}))
 	}
 
 	return emit(events.StreamEvent{Type: events.LLMChunk, Content: events.ContentLoomiRevision, Data: llmResponse})
 }
 
+func (a *RevisionAgent) buildRevision(ctx context.Context, req types.AgentRequest, r xmlx.ParseResult, position int) (map[string]any, bool) {
+	uid, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "revision")
+	if err != nil {
+		a.Logger.Error(ctx, "NextActionID failed", logx.KV("error", err))
+		return nil, false
+	}
+	title := a.EnsureMarkdownCompatibility(r.Title)
+	if title == "" {
+		title = fmt.Sprintf("修订建议 %d", position)
+	}
+	content := a.EnsureMarkdownCompatibility(r.Content)
+	return map[string]any{"id": fmt.Sprintf("revision%d", uid), "title": title, "content": content, "type": r.Type}, true
+}
+
+func (a *RevisionAgent) createRevisionNote(ctx context.Context, req types.AgentRequest, it map[string]any) {
+	_ = a.CreateNote(ctx, req.UserID, req.SessionID, "revision", it["id"].(string), it["content"].(string), it["title"].(string), "", nil)
+}
+
+// getSystemPrompt is the built-in fallback; config/prompts/loomi_revision_agent holds the versioned prompt
 func (a *RevisionAgent) getSystemPrompt() string {
-	return `xxxx`
+	return `你是一名资深内容编辑，负责根据用户反馈修改已有内容。只修改反馈涉及的部分，保留原内容的结构、风格与核心信息，输出修改后的完整内容。
+
+输出格式（每份修改结果一个块，序号从 1 开始）：
+<revision1>
+<title>修改后的标题</title>
+<content>修改后的完整内容</content>
+</revision1>`
 }
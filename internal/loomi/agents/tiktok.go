@@ -18,7 +18,7 @@ type TikTokScriptAgent struct {
 }
 
 func NewTikTokScriptAgent(logger *logx.Logger, client llm.Client) *TikTokScriptAgent {
-	base := base.NewBaseLoomiAgent("loomi_tiktok_script_agent", logger, client)
+	base := base.NewBaseLoomiAgent("loomi_tiktok_script_agent", logger, client).WithOutputSchema(base.ItemSchema(xmlx.UnifiedConfigs["tiktok_script"]))
 	return &TikTokScriptAgent{BaseLoomiAgent: base, xmlParser: xmlx.NewLoomiXMLParser()}
 }
 
@@ -32,14 +32,14 @@ func (a *TikTokScriptAgent) ProcessRequest(
 	req types.AgentRequest,
 	emit func(ev events.StreamEvent) error,
 ) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
 	a.Logger.Info(ctx, "Processing TikTok script request",
 		logx.KV("user_id", req.UserID),
 		logx.KV("session_id", req.SessionID),
 		logx.KV("instruction_length", len(req.Instruction)))
 
-	a.SetCurrentSession(req.UserID, req.SessionID)
-	defer a.ClearCurrentSession()
-
 	if err := a.ClearStopState(ctx, req.UserID, req.SessionID); err != nil {
 		a.Logger.Error(ctx, "Failed to clear stop state", logx.KV("error", err))
 	}
@@ -53,10 +53,11 @@ func (a *TikTokScriptAgent) ProcessRequest(
 		userPrompt = req.Instruction
 	}
 
-	messages := []llm.Message{{Role: "system", Content: a.getSystemPrompt()}, {Role: "user", Content: userPrompt}}
+	messages := []llm.Message{{Role: "system", Content: a.SystemPrompt(ctx, req, a.getSystemPrompt())}, {Role: "user", Content: userPrompt}}
 
 	a.Logger.Info(ctx, "Starting LLM response collection")
 	llmResponse := ""
+	stream := a.NewItemStream(req, xmlx.UnifiedConfigs["tiktok_script"], events.ContentLoomiTikTokScript, emit, a.buildTikTokScript, a.createTikTokScriptNote)
 	err = a.SafeStreamCall(ctx, req.UserID, req.SessionID, messages, func(ctx context.Context, chunk string) error {
 		llmResponse += chunk
 		if a.ShouldEmitThought(chunk) {
@@ -64,7 +65,7 @@ func (a *TikTokScriptAgent) ProcessRequest(
 				return err
 			}
 		}
-		return nil
+		return stream.Write(ctx, chunk)
 	})
 	if err != nil {
 		return err
@@ -75,50 +76,45 @@ func (a *TikTokScriptAgent) ProcessRequest(
 		return err
 	}
 
-	// Parse results with cover_text and hook, and assign unique IDs
-	config := xmlx.UnifiedConfigs["tiktok_script"]
-	parsed := a.xmlParser.ParseEnhanced(llmResponse, config, 1)
-	items := make([]map[string]any, 0, len(parsed))
-	for _, r := range parsed {
-		uid, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "tiktok_script")
-		if err != nil {
-			a.Logger.Error(ctx, "NextActionID failed", logx.KV("error", err))
-			continue
-		}
-		title := a.EnsureMarkdownCompatibility(r.Title)
-		content := a.EnsureMarkdownCompatibility(r.Content)
-		coverText := a.EnsureMarkdownCompatibility(r.CoverText)
-		hook := a.EnsureMarkdownCompatibility(r.Hook)
-		if title == "" {
-			title = fmt.Sprintf("抖音脚本 %d", len(items)+1)
-		}
-		items = append(items, map[string]any{
-			"id":         fmt.Sprintf("tiktok_script%d", uid),
-			"title":      title,
-			"content":    content,
-			"cover_text": coverText,
-			"hook":       hook,
-			"type":       r.Type,
-		})
-	}
+	items := stream.Finish(ctx, llmResponse)
 
 	if len(items) > 0 {
 		meta := map[string]any{"instruction": req.Instruction}
-		ev := events.StreamEvent{Type: events.LLMChunk, Content: events.ContentLoomiTikTokScript, Data: items, Meta: meta}
-		if err := emit(ev); err != nil {
-			return err
-		}
-		// Create notes (store pure content; pass title/cover to DB)
-		for _, it := range items {
-			_ = a.CreateNote(ctx, req.UserID, req.SessionID, "tiktok_script", it["id"].(string), it["content"].(string), it["title"].(string), it["cover_text"].(string), nil)
-		}
-		return nil
+		return emit(stream.FinalEvent(items, meta))
 	}
 
 	// Fallback raw
 	return emit(events.StreamEvent{Type: events.LLMChunk, Content: events.ContentLoomiTikTokScript, Data: llmResponse})
 }
 
+func (a *TikTokScriptAgent) buildTikTokScript(ctx context.Context, req types.AgentRequest, r xmlx.ParseResult, position int) (map[string]any, bool) {
+	uid, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "tiktok_script")
+	if err != nil {
+		a.Logger.Error(ctx, "NextActionID failed", logx.KV("error", err))
+		return nil, false
+	}
+	title := a.EnsureMarkdownCompatibility(r.Title)
+	content := a.EnsureMarkdownCompatibility(r.Content)
+	coverText := a.EnsureMarkdownCompatibility(r.CoverText)
+	hook := a.EnsureMarkdownCompatibility(r.Hook)
+	if title == "" {
+		title = fmt.Sprintf("抖音脚本 %d", position)
+	}
+	return map[string]any{
+		"id":         fmt.Sprintf("tiktok_script%d", uid),
+		"title":      title,
+		"content":    content,
+		"cover_text": coverText,
+		"hook":       hook,
+		"type":       r.Type,
+	}, true
+}
+
+// createTikTokScriptNote stores pure content; title/cover go to the DB columns
+func (a *TikTokScriptAgent) createTikTokScriptNote(ctx context.Context, req types.AgentRequest, it map[string]any) {
+	_ = a.CreateNote(ctx, req.UserID, req.SessionID, "tiktok_script", it["id"].(string), it["content"].(string), it["title"].(string), it["cover_text"].(string), nil)
+}
+
 func (a *TikTokScriptAgent) getSystemPrompt() string {
 	return `>`
 }
@@ -51,10 +51,6 @@ func (a *TikTokScriptAgent) ProcessRequest(ctx context.Context, request *types.A
 		"session_id", sessionID,
 		"use_files", useFiles)
 
-	// 设置当前会话信息
-	a.SetCurrentSession(userID, sessionID)
-	defer a.ClearCurrentSession()
-
 	// 构建用户提示词
 	userPrompt, err := a.BuildCleanAgentPrompt(ctx, userID, sessionID, instruction, "tiktok_script", autoMode, userSelections)
 	if err != nil {
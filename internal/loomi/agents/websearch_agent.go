@@ -25,7 +25,7 @@ type WebSearchAgent struct {
 
 // NewWebSearchAgent creates a new web search agent
 func NewWebSearchAgent(logger *logx.Logger, client llm.Client) *WebSearchAgent {
-	baseAgent := base.NewBaseLoomiAgent("loomi_websearch_agent", logger, client)
+	baseAgent := base.NewBaseLoomiAgent("loomi_websearch_agent", logger, client).WithOutputSchema(base.ItemSchema(xmlx.UnifiedConfigs["websearch"]))
 	return &WebSearchAgent{
 		BaseLoomiAgent: baseAgent,
 		xmlParser:      xmlx.NewLoomiXMLParser(),
@@ -39,15 +39,14 @@ func (a *WebSearchAgent) ProcessRequest(
 	req types.AgentRequest,
 	emit func(ev events.StreamEvent) error,
 ) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
 	a.Logger.Info(ctx, "Processing web search request",
 		logx.KV("user_id", req.UserID),
 		logx.KV("session_id", req.SessionID),
 		logx.KV("instruction_length", len(req.Instruction)))
 
-	// Set current session
-	a.SetCurrentSession(req.UserID, req.SessionID)
-	defer a.ClearCurrentSession()
-
 	// Clear stop state before starting
 	if err := a.ClearStopState(ctx, req.UserID, req.SessionID); err != nil {
 		a.Logger.Error(ctx, "Failed to clear stop state", logx.KV("error", err))
@@ -67,7 +66,7 @@ func (a *WebSearchAgent) ProcessRequest(
 
 	// Prepare messages
 	messages := []llm.Message{
-		{Role: "system", Content: a.getSystemPrompt()},
+		{Role: "system", Content: a.SystemPrompt(ctx, req, a.getSystemPrompt())},
 		{Role: "user", Content: userPrompt},
 	}
 
@@ -134,7 +133,7 @@ func (a *WebSearchAgent) ProcessRequest(
 
 	// Update messages for summarization phase
 	messages = []llm.Message{
-		{Role: "system", Content: a.getSystemPrompt()},
+		{Role: "system", Content: a.SystemPrompt(ctx, req, a.getSystemPrompt())},
 		{Role: "user", Content: summaryPrompt},
 	}
 
@@ -362,7 +361,7 @@ func (a *WebSearchAgent) parseWebSearchSummaries(
 ) ([]map[string]any, error) {
 	// Parse using enhanced XML parser
 	config := xmlx.UnifiedConfigs["websearch"]
-	parseResults := a.xmlParser.ParseEnhanced(response, config, 1)
+	parseResults := a.ParseStructured(ctx, req.UserID, req.SessionID, response, config)
 
 	// Assign unique IDs to each summary
 	summaries := make([]map[string]any, 0, len(parseResults))
@@ -20,7 +20,7 @@ type WeChatArticleAgent struct {
 
 // NewWeChatArticleAgent creates a new WeChat article agent
 func NewWeChatArticleAgent(logger *logx.Logger, client llm.Client) *WeChatArticleAgent {
-	baseAgent := base.NewBaseLoomiAgent("loomi_wechat_article_agent", logger, client)
+	baseAgent := base.NewBaseLoomiAgent("loomi_wechat_article_agent", logger, client).WithOutputSchema(base.ItemSchema(xmlx.ContentConfigs["wechat_article"]))
 	return &WeChatArticleAgent{
 		BaseLoomiAgent: baseAgent,
 		xmlParser:      xmlx.NewLoomiXMLParser(),
@@ -33,15 +33,14 @@ func (a *WeChatArticleAgent) ProcessRequest(
 	req types.AgentRequest,
 	emit func(ev events.StreamEvent) error,
 ) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
 	a.Logger.Info(ctx, "Processing WeChat article creation request",
 		logx.KV("user_id", req.UserID),
 		logx.KV("session_id", req.SessionID),
 		logx.KV("instruction_length", len(req.Instruction)))
 
-	// Set current session
-	a.SetCurrentSession(req.UserID, req.SessionID)
-	defer a.ClearCurrentSession()
-
 	// Clear stop state before starting
 	if err := a.ClearStopState(ctx, req.UserID, req.SessionID); err != nil {
 		a.Logger.Error(ctx, "Failed to clear stop state", logx.KV("error", err))
@@ -61,13 +60,14 @@ func (a *WeChatArticleAgent) ProcessRequest(
 
 	// Prepare messages
 	messages := []llm.Message{
-		{Role: "system", Content: a.getSystemPrompt()},
+		{Role: "system", Content: a.SystemPrompt(ctx, req, a.getSystemPrompt())},
 		{Role: "user", Content: userPrompt},
 	}
 
-	// Collect LLM response
+	// Collect LLM response; each WeChat article is emitted and saved as soon as its closing tag arrives
 	a.Logger.Info(ctx, "Starting LLM response collection")
 	llmResponse := ""
+	stream := a.NewItemStream(req, xmlx.ContentConfigs["wechat_article"], events.ContentLoomiWeChatArticle, emit, a.buildWeChatArticle, a.createWeChatArticleNote)
 
 	err = a.SafeStreamCall(ctx, req.UserID, req.SessionID, messages, func(ctx context.Context, chunk string) error {
 		llmResponse += chunk
@@ -84,7 +84,7 @@ func (a *WeChatArticleAgent) ProcessRequest(
 			}
 		}
 
-		return nil
+		return stream.Write(ctx, chunk)
 	})
 
 	if err != nil {
@@ -102,17 +102,8 @@ func (a *WeChatArticleAgent) ProcessRequest(
 	// Extract other content outside of wechat_article tags
 	otherContent := a.ExtractOtherContent(llmResponse, []string{`<wechat_article\\d+>`})
 
-	// Parse WeChat article results with unique IDs
-	articles, err := a.parseWeChatArticlesWithUniqueIDs(ctx, llmResponse, req)
-	if err != nil {
-		a.Logger.Error(ctx, "Failed to parse WeChat article results", logx.KV("error", err))
-		// Send raw response if parsing fails
-		return emit(events.StreamEvent{
-			Type:    events.LLMChunk,
-			Content: events.ContentLoomiConcierge,
-			Data:    llmResponse,
-		})
-	}
+	// Parse whatever was not streamed
+	articles := stream.Finish(ctx, llmResponse)
 
 	a.Logger.Info(ctx, "WeChat article parsing completed",
 		logx.KV("articles_count", len(articles)))
@@ -125,22 +116,10 @@ func (a *WeChatArticleAgent) ProcessRequest(
 			metadata["agent_other_message"] = otherContent
 		}
 
-		articleEvent := events.StreamEvent{
-			Type:    events.LLMChunk,
-			Content: events.ContentLoomiWeChatArticle,
-			Data:    articles,
-			Meta:    metadata,
-		}
-
-		if err := emit(articleEvent); err != nil {
+		if err := emit(stream.FinalEvent(articles, metadata)); err != nil {
 			return err
 		}
 
-		// Create notes concurrently
-		if err := a.createWeChatArticleNotes(ctx, req, articles); err != nil {
-			a.Logger.Error(ctx, "Failed to create WeChat article notes", logx.KV("error", err))
-		}
-
 		a.Logger.Info(ctx, "WeChat article processing completed",
 			logx.KV("notes_count", len(articles)))
 	} else {
@@ -156,75 +135,45 @@ func (a *WeChatArticleAgent) ProcessRequest(
 	return nil
 }
 
-// parseWeChatArticlesWithUniqueIDs parses LLM response and assigns unique IDs
-func (a *WeChatArticleAgent) parseWeChatArticlesWithUniqueIDs(
-	ctx context.Context,
-	response string,
-	req types.AgentRequest,
-) ([]map[string]any, error) {
-	// Parse using enhanced XML parser
-	config := xmlx.ContentConfigs["wechat_article"]
-	parseResults := a.xmlParser.ParseEnhanced(response, config, 1)
-
-	// Assign unique IDs to each article
-	articles := make([]map[string]any, 0, len(parseResults))
-
-	for _, result := range parseResults {
-		// Get unique ID for this article
-		uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "wechat_article")
-		if err != nil {
-			a.Logger.Error(ctx, "Failed to get next action ID", logx.KV("error", err))
-			continue
-		}
-
-		// Ensure markdown compatibility
-		title := a.EnsureMarkdownCompatibility(result.Title)
-		content := a.EnsureMarkdownCompatibility(result.Content)
-
-		articles = append(articles, map[string]any{
-			"id":           fmt.Sprintf("wechat_article%d", uniqueID),
-			"title":        title,
-			"content":      content,
-			"full_content": result.Content,
-			"type":         result.Type,
-		})
+// buildWeChatArticle assigns a unique ID to a parsed WeChat article
+func (a *WeChatArticleAgent) buildWeChatArticle(ctx context.Context, req types.AgentRequest, result xmlx.ParseResult, position int) (map[string]any, bool) {
+	uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "wechat_article")
+	if err != nil {
+		a.Logger.Error(ctx, "Failed to get next action ID", logx.KV("error", err))
+		return nil, false
 	}
 
-	a.Logger.Info(ctx, "Assigned unique IDs to WeChat articles",
-		logx.KV("count", len(articles)))
-
-	return articles, nil
+	// Ensure markdown compatibility
+	title := a.EnsureMarkdownCompatibility(result.Title)
+	content := a.EnsureMarkdownCompatibility(result.Content)
+
+	return map[string]any{
+		"id":           fmt.Sprintf("wechat_article%d", uniqueID),
+		"title":        title,
+		"content":      content,
+		"full_content": result.Content,
+		"type":         result.Type,
+	}, true
 }
 
-// createWeChatArticleNotes creates notes for WeChat article results
-func (a *WeChatArticleAgent) createWeChatArticleNotes(
-	ctx context.Context,
-	req types.AgentRequest,
-	articles []map[string]any,
-) error {
+// createWeChatArticleNote creates the note of a WeChat article
+func (a *WeChatArticleAgent) createWeChatArticleNote(ctx context.Context, req types.AgentRequest, article map[string]any) {
 	if a.NotesService == nil {
-		return fmt.Errorf("notes service not available")
+		return
 	}
 
-	a.Logger.Info(ctx, "Creating WeChat article notes",
-		logx.KV("count", len(articles)))
-
-	for _, article := range articles {
-		id := article["id"].(string)
-		title := article["title"].(string)
-		content := article["content"].(string)
-
-		err := a.CreateNote(ctx, req.UserID, req.SessionID, "wechat_article", id, content, title, "", nil)
-		if err != nil {
-			a.Logger.Error(ctx, "Failed to create WeChat article note",
-				logx.KV("id", id),
-				logx.KV("error", err))
-		} else {
-			a.Logger.Info(ctx, "Created WeChat article note", logx.KV("id", id))
-		}
-	}
+	id := article["id"].(string)
+	title := article["title"].(string)
+	content := article["content"].(string)
 
-	return nil
+	err := a.CreateNote(ctx, req.UserID, req.SessionID, "wechat_article", id, content, title, "", nil)
+	if err != nil {
+		a.Logger.Error(ctx, "Failed to create WeChat article note",
+			logx.KV("id", id),
+			logx.KV("error", err))
+	} else {
+		a.Logger.Info(ctx, "Created WeChat article note", logx.KV("id", id))
+	}
 }
 
 // getSystemPrompt returns the system prompt for WeChat article creation
@@ -22,7 +22,7 @@ type XHSPostAgent struct {
 
 // NewXHSPostAgent creates a new XHS post agent
 func NewXHSPostAgent(logger *logx.Logger, client llm.Client) *XHSPostAgent {
-	baseAgent := base.NewBaseLoomiAgent("loomi_xhs_post_agent", logger, client)
+	baseAgent := base.NewBaseLoomiAgent("loomi_xhs_post_agent", logger, client).WithOutputSchema(base.ItemSchema(xmlx.ContentConfigs["xhs_post"]))
 	return &XHSPostAgent{
 		BaseLoomiAgent: baseAgent,
 		xmlParser:      xmlx.NewLoomiXMLParser(),
@@ -35,15 +35,14 @@ func (a *XHSPostAgent) ProcessRequest(
 	req types.AgentRequest,
 	emit func(ev events.StreamEvent) error,
 ) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
 	a.Logger.Info(ctx, "Processing XHS post creation request",
 		logx.KV("user_id", req.UserID),
 		logx.KV("session_id", req.SessionID),
 		logx.KV("instruction_length", len(req.Instruction)))
 
-	// Set current session
-	a.SetCurrentSession(req.UserID, req.SessionID)
-	defer a.ClearCurrentSession()
-
 	// Clear stop state before starting
 	if err := a.ClearStopState(ctx, req.UserID, req.SessionID); err != nil {
 		a.Logger.Error(ctx, "Failed to clear stop state", logx.KV("error", err))
@@ -63,13 +62,14 @@ func (a *XHSPostAgent) ProcessRequest(
 
 	// Prepare messages
 	messages := []llm.Message{
-		{Role: "system", Content: a.getSystemPrompt()},
+		{Role: "system", Content: a.SystemPrompt(ctx, req, a.getSystemPrompt())},
 		{Role: "user", Content: userPrompt},
 	}
 
-	// Collect LLM response
+	// Collect LLM response; each post is emitted and saved as soon as its closing tag arrives
 	a.Logger.Info(ctx, "Starting LLM response collection")
 	llmResponse := ""
+	stream := a.NewItemStream(req, xmlx.ContentConfigs["xhs_post"], events.ContentLoomiXHSPost, emit, a.buildXHSPost, a.createXHSPostNote)
 
 	err = a.SafeStreamCall(ctx, req.UserID, req.SessionID, messages, func(ctx context.Context, chunk string) error {
 		llmResponse += chunk
@@ -86,7 +86,7 @@ func (a *XHSPostAgent) ProcessRequest(
 			}
 		}
 
-		return nil
+		return stream.Write(ctx, chunk)
 	})
 
 	if err != nil {
@@ -104,17 +104,8 @@ func (a *XHSPostAgent) ProcessRequest(
 	// Extract other content outside of xhs_post tags
 	otherContent := a.ExtractOtherContent(llmResponse, []string{`<xhs_post\\d+>`})
 
-	// Parse XHS post results with unique IDs
-	posts, err := a.parseXHSPostsWithUniqueIDs(ctx, llmResponse, req)
-	if err != nil {
-		a.Logger.Error(ctx, "Failed to parse XHS post results", logx.KV("error", err))
-		// Send raw response if parsing fails
-		return emit(events.StreamEvent{
-			Type:    events.LLMChunk,
-			Content: events.ContentLoomiXHSPost,
-			Data:    llmResponse,
-		})
-	}
+	// Parse whatever was not streamed
+	posts := stream.Finish(ctx, llmResponse)
 
 	a.Logger.Info(ctx, "XHS post parsing completed",
 		logx.KV("posts_count", len(posts)))
@@ -127,22 +118,10 @@ func (a *XHSPostAgent) ProcessRequest(
 			metadata["agent_other_message"] = otherContent
 		}
 
-		postEvent := events.StreamEvent{
-			Type:    events.LLMChunk,
-			Content: events.ContentLoomiXHSPost,
-			Data:    posts,
-			Meta:    metadata,
-		}
-
-		if err := emit(postEvent); err != nil {
+		if err := emit(stream.FinalEvent(posts, metadata)); err != nil {
 			return err
 		}
 
-		// Create notes concurrently
-		if err := a.createXHSPostNotes(ctx, req, posts); err != nil {
-			a.Logger.Error(ctx, "Failed to create XHS post notes", logx.KV("error", err))
-		}
-
 		a.Logger.Info(ctx, "XHS post processing completed",
 			logx.KV("notes_count", len(posts)))
 	} else {
@@ -158,49 +137,30 @@ func (a *XHSPostAgent) ProcessRequest(
 	return nil
 }
 
-// parseXHSPostsWithUniqueIDs parses LLM response and assigns unique IDs
-func (a *XHSPostAgent) parseXHSPostsWithUniqueIDs(
-	ctx context.Context,
-	response string,
-	req types.AgentRequest,
-) ([]map[string]any, error) {
-	// Parse using enhanced XML parser
-	config := xmlx.ContentConfigs["xhs_post"]
-	parseResults := a.xmlParser.ParseEnhanced(response, config, 1)
-
-	// Assign unique IDs to each post
-	posts := make([]map[string]any, 0, len(parseResults))
-
-	for _, result := range parseResults {
-		// Get unique ID for this post
-		uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "xhs_post")
-		if err != nil {
-			a.Logger.Error(ctx, "Failed to get next action ID", logx.KV("error", err))
-			continue
-		}
-
-		// Extract title and cover text from content
-		title, coverText, content := a.extractTitleAndCoverText(result.Content)
-
-		// Ensure markdown compatibility
-		title = a.EnsureMarkdownCompatibility(title)
-		coverText = a.EnsureMarkdownCompatibility(coverText)
-		content = a.EnsureMarkdownCompatibility(content)
-
-		posts = append(posts, map[string]any{
-			"id":           fmt.Sprintf("xhs_post%d", uniqueID),
-			"title":        title,
-			"cover_text":   coverText,
-			"content":      content,
-			"full_content": result.Content,
-			"type":         result.Type,
-		})
+// buildXHSPost assigns a unique ID to a parsed post and splits out its title and cover text
+func (a *XHSPostAgent) buildXHSPost(ctx context.Context, req types.AgentRequest, result xmlx.ParseResult, position int) (map[string]any, bool) {
+	uniqueID, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "xhs_post")
+	if err != nil {
+		a.Logger.Error(ctx, "Failed to get next action ID", logx.KV("error", err))
+		return nil, false
 	}
 
-	a.Logger.Info(ctx, "Assigned unique IDs to XHS posts",
-		logx.KV("count", len(posts)))
-
-	return posts, nil
+	// Extract title and cover text from content
+	title, coverText, content := a.extractTitleAndCoverText(result.Content)
+
+	// Ensure markdown compatibility
+	title = a.EnsureMarkdownCompatibility(title)
+	coverText = a.EnsureMarkdownCompatibility(coverText)
+	content = a.EnsureMarkdownCompatibility(content)
+
+	return map[string]any{
+		"id":           fmt.Sprintf("xhs_post%d", uniqueID),
+		"title":        title,
+		"cover_text":   coverText,
+		"content":      content,
+		"full_content": result.Content,
+		"type":         result.Type,
+	}, true
 }
 
 // extractTitleAndCoverText extracts title and cover text from content
@@ -265,36 +225,25 @@ func (a *XHSPostAgent) extractTitleAndCoverText(content string) (string, string,
 	return title, coverText, cleanContent
 }
 
-// createXHSPostNotes creates notes for XHS post results
-func (a *XHSPostAgent) createXHSPostNotes(
-	ctx context.Context,
-	req types.AgentRequest,
-	posts []map[string]any,
-) error {
+// createXHSPostNote creates the note of an XHS post
+func (a *XHSPostAgent) createXHSPostNote(ctx context.Context, req types.AgentRequest, post map[string]any) {
 	if a.NotesService == nil {
-		return fmt.Errorf("notes service not available")
+		return
 	}
 
-	a.Logger.Info(ctx, "Creating XHS post notes",
-		logx.KV("count", len(posts)))
+	id := post["id"].(string)
+	title := post["title"].(string)
+	coverText := post["cover_text"].(string)
+	content := post["content"].(string)
 
-	for _, post := range posts {
-		id := post["id"].(string)
-		title := post["title"].(string)
-		coverText := post["cover_text"].(string)
-		content := post["content"].(string)
-
-		err := a.CreateNote(ctx, req.UserID, req.SessionID, "xhs_post", id, content, title, coverText, nil)
-		if err != nil {
-			a.Logger.Error(ctx, "Failed to create XHS post note",
-				logx.KV("id", id),
-				logx.KV("error", err))
-		} else {
-			a.Logger.Info(ctx, "Created XHS post note", logx.KV("id", id))
-		}
+	err := a.CreateNote(ctx, req.UserID, req.SessionID, "xhs_post", id, content, title, coverText, nil)
+	if err != nil {
+		a.Logger.Error(ctx, "Failed to create XHS post note",
+			logx.KV("id", id),
+			logx.KV("error", err))
+	} else {
+		a.Logger.Info(ctx, "Created XHS post note", logx.KV("id", id))
 	}
-
-	return nil
 }
 
 // getSystemPrompt returns the system prompt for XHS post creation
@@ -26,6 +26,8 @@ type JWTClaims struct {
 	UserID    string `json:"user_id"`
 	SessionID string `json:"session_id"`
 	Role      string `json:"role"`
+	// Plan 用户所属套餐，决定请求的用量预算（budgets.plans.<plan>）
+	Plan      string `json:"plan,omitempty"`
 	ExpiresAt int64  `json:"exp"`
 	IssuedAt  int64  `json:"iat"`
 	jwt.RegisteredClaims
@@ -101,6 +103,7 @@ func (am *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 		c.Set("user_id", claims.UserID)
 		c.Set("session_id", claims.SessionID)
 		c.Set("role", claims.Role)
+		c.Set("user_plan", claims.Plan)
 
 		am.logger.Info(c.Request.Context(), "用户认证成功",
 			logx.KV("user_id", claims.UserID),
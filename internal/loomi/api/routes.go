@@ -1,15 +1,24 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
+	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/base"
 	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/config"
 	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/database"
+	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/events"
+	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/llm"
 	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/log"
 	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/monitoring"
+	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/plans"
+	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/prompts"
 	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/tools"
+	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/types"
 	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/utils"
 
 	"github.com/gin-gonic/gin"
@@ -19,19 +28,20 @@ import (
 type Router struct {
 	engine              *gin.Engine
 	config              *config.Config
-	logger              log.Logger
+	logger              *log.Logger
 	persistenceManager  *database.PersistenceManager
 	monitor             *monitoring.Monitor
 	portMonitor         *monitoring.PortMonitor
 	systemMonitor       *monitoring.SystemMonitor
 	searchTool          tools.SearchTool
 	multimodalProcessor tools.MultimodalProcessor
+	agents              *base.AgentPool
 }
 
 // NewRouter 创建新的路由器
 func NewRouter(
 	config *config.Config,
-	logger log.Logger,
+	logger *log.Logger,
 	persistenceManager *database.PersistenceManager,
 	monitor *monitoring.Monitor,
 	portMonitor *monitoring.PortMonitor,
@@ -69,6 +79,38 @@ func NewRouter(
 	return router
 }
 
+// RegisterLLMHealth 将 LLM 路由器各 provider 的熔断状态接入 /monitor/health
+func (r *Router) RegisterLLMHealth(llmRouter *llm.Router) {
+	r.monitor.RegisterServiceCheck("llm", func(ctx context.Context) monitoring.ServiceInfo {
+		providers := llmRouter.Health()
+		available := 0
+		for _, p := range providers {
+			if p.State != llm.BreakerOpen {
+				available++
+			}
+		}
+
+		status := "healthy"
+		switch {
+		case available == 0:
+			status = "unhealthy"
+		case available < len(providers):
+			status = "degraded"
+		}
+		return monitoring.ServiceInfo{
+			Name:        "llm",
+			Status:      status,
+			LastChecked: time.Now(),
+			Details:     providers,
+		}
+	})
+}
+
+// RegisterAgents 接入共享的智能体池，审批通过的计划由其中的编排器继续执行
+func (r *Router) RegisterAgents(pool *base.AgentPool) {
+	r.agents = pool
+}
+
 // setupRoutes 设置路由
 func (r *Router) setupRoutes() {
 	// 健康检查
@@ -84,6 +126,20 @@ func (r *Router) setupRoutes() {
 		monitorGroup.POST("/alerts/:id/resolve", r.handleResolveAlert)
 		monitorGroup.GET("/ports", r.handlePortStatus)
 		monitorGroup.GET("/system", r.handleSystemMetrics)
+		monitorGroup.GET("/parse", r.handleParseMetrics)
+		monitorGroup.GET("/cache", r.handleCacheMetrics)
+		monitorGroup.GET("/prompts", r.handlePromptVersions)
+		monitorGroup.POST("/prompts/reload", r.handleReloadPrompts)
+		monitorGroup.GET("/experiments", r.handleExperimentResults)
+	}
+
+	// 对话、编排计划审批（auto_mode 关闭时编排器先推送计划，审批后继续执行）与中断运行的恢复
+	loomiGroup := r.engine.Group("/api/loomi")
+	{
+		loomiGroup.POST("/chat", r.handleChat)
+		loomiGroup.GET("/plans/:id", r.handleGetPlan)
+		loomiGroup.POST("/plans/:id/approve", r.handleApprovePlan)
+		loomiGroup.POST("/resume", r.handleResume)
 	}
 
 	// API版本组
@@ -196,7 +252,7 @@ func (r *Router) handlePing(c *gin.Context) {
 func (r *Router) handleMonitorHealth(c *gin.Context) {
 	health := r.monitor.GetHealth()
 
-	if health.Status == "healthy" {
+	if health.Status == "healthy" || health.Status == "degraded" {
 		c.JSON(http.StatusOK, health)
 	} else {
 		c.JSON(http.StatusServiceUnavailable, health)
@@ -209,6 +265,290 @@ func (r *Router) handleMonitorMetrics(c *gin.Context) {
 	c.JSON(http.StatusOK, health)
 }
 
+// handleParseMetrics 返回各智能体输出解析成功率
+func (r *Router) handleParseMetrics(c *gin.Context) {
+	stats := monitoring.DefaultParseMetrics.Snapshot()
+	c.JSON(http.StatusOK, gin.H{
+		"agents": stats,
+		"count":  len(stats),
+	})
+}
+
+// handleCacheMetrics 返回各智能体 LLM 响应缓存命中情况
+func (r *Router) handleCacheMetrics(c *gin.Context) {
+	stats := monitoring.DefaultCacheMetrics.Snapshot()
+	c.JSON(http.StatusOK, gin.H{
+		"agents": stats,
+		"count":  len(stats),
+	})
+}
+
+// handlePromptVersions 返回已加载的提示词模板及当前生效版本
+func (r *Router) handlePromptVersions(c *gin.Context) {
+	store := base.CurrentPromptStore()
+	if store == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false, "prompts": []any{}, "count": 0})
+		return
+	}
+	list, loadedAt := store.List()
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":   true,
+		"prompts":   list,
+		"count":     len(list),
+		"loaded_at": loadedAt,
+	})
+}
+
+// handleReloadPrompts 立即重新加载提示词模板，无需等待定时刷新
+func (r *Router) handleReloadPrompts(c *gin.Context) {
+	store := base.CurrentPromptStore()
+	if store == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "prompt store not configured"})
+		return
+	}
+	if err := store.Reload(c.Request.Context()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	r.handlePromptVersions(c)
+}
+
+// handleExperimentResults 返回运行中的提示词实验及各分组的笔记选中率（含 95% 置信区间）与修改率
+func (r *Router) handleExperimentResults(c *gin.Context) {
+	experiments := []prompts.Experiment{}
+	if store := base.CurrentPromptStore(); store != nil {
+		experiments = store.Experiments()
+	}
+	results := monitoring.DefaultExperimentMetrics.Snapshot()
+	c.JSON(http.StatusOK, gin.H{
+		"experiments": experiments,
+		"results":     results,
+		"count":       len(results),
+	})
+}
+
+// chatBudget 请求体中的用量预算，只能收紧用户套餐的预算；0 表示不额外限制
+type chatBudget struct {
+	MaxTokens          int `json:"max_tokens"`
+	MaxSessionTokens   int `json:"max_session_tokens"`
+	MaxLLMCalls        int `json:"max_llm_calls"`
+	MaxWallTimeSeconds int `json:"max_wall_time_seconds"`
+}
+
+// handleChat 由接待员处理一轮对话（需要时转交编排器），并以 SSE 推送处理过程。
+// 预算取认证用户所属套餐（认证中间件写入的 user_plan），请求体中的 budget 在此基础上收紧
+func (r *Router) handleChat(c *gin.Context) {
+	var req struct {
+		UserID      string      `json:"user_id"`
+		SessionID   string      `json:"session_id" binding:"required"`
+		Instruction string      `json:"instruction" binding:"required"`
+		AutoMode    bool        `json:"auto_mode"`
+		Selections  []string    `json:"selections"`
+		UseFiles    bool        `json:"use_files"`
+		FileIDs     []string    `json:"file_ids"`
+		Budget      *chatBudget `json:"budget"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	var agent types.Agent
+	if r.agents != nil {
+		agent = r.agents.Named("loomi_concierge")
+	}
+	if agent == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "agent unavailable: loomi_concierge"})
+		return
+	}
+
+	agentReq := types.AgentRequest{
+		UserID:      planOwner(c, req.UserID),
+		SessionID:   req.SessionID,
+		Instruction: req.Instruction,
+		AutoMode:    req.AutoMode,
+		Selections:  req.Selections,
+		UseFiles:    req.UseFiles,
+		FileIDs:     req.FileIDs,
+		UserPlan:    c.GetString("user_plan"),
+	}
+	if b := req.Budget; b != nil {
+		agentReq.Budget = &types.Budget{
+			MaxTokens:        b.MaxTokens,
+			MaxSessionTokens: b.MaxSessionTokens,
+			MaxLLMCalls:      b.MaxLLMCalls,
+			MaxWallTime:      time.Duration(b.MaxWallTimeSeconds) * time.Second,
+		}
+	}
+
+	streamEvents(c, func(emit func(ev events.StreamEvent) error) error {
+		return agent.ProcessRequest(c.Request.Context(), agentReq, emit)
+	})
+}
+
+// handleGetPlan 返回待审批计划的动作与预估消耗
+func (r *Router) handleGetPlan(c *gin.Context) {
+	_, plan, ok := r.loadPlan(c, c.Query("user_id"))
+	if !ok {
+		return
+	}
+	summary := plan.Summary()
+	summary["session_id"] = plan.SessionID
+	summary["created_at"] = plan.CreatedAt
+	c.JSON(http.StatusOK, summary)
+}
+
+// handleApprovePlan 按用户的决定批准、修改或删除计划中的动作，并以 SSE 推送后续执行过程。
+// 计划被取出后即失效，同一计划只能恢复一次
+func (r *Router) handleApprovePlan(c *gin.Context) {
+	var req struct {
+		UserID    string           `json:"user_id"`
+		Decisions []plans.Decision `json:"decisions"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	store, plan, ok := r.loadPlan(c, req.UserID)
+	if !ok {
+		return
+	}
+	if err := plan.Apply(req.Decisions, base.EstimateAction); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "审批决定无效",
+			"details": err.Error(),
+		})
+		return
+	}
+	var resumer base.PlanResumer
+	if r.agents != nil {
+		resumer, _ = r.agents.Named(plan.AgentName).(base.PlanResumer)
+	}
+	if resumer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "agent unavailable: " + plan.AgentName})
+		return
+	}
+
+	// 取出计划；并发审批时只有一方能取到
+	taken, err := store.Take(c.Request.Context(), plan.ID)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "计划已被处理或已过期"})
+		return
+	}
+	if err := taken.Apply(req.Decisions, base.EstimateAction); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	streamEvents(c, func(emit func(ev events.StreamEvent) error) error {
+		return resumer.ResumePlan(c.Request.Context(), taken, emit)
+	})
+}
+
+// handleResume 从会话最近的检查点恢复被中断（发布、OOM 等）的编排运行：已完成的动作不会重新执行，
+// 其 token 也不会重复计费；可通过 checkpoint_id 指定检查点
+func (r *Router) handleResume(c *gin.Context) {
+	var req struct {
+		UserID       string `json:"user_id"`
+		SessionID    string `json:"session_id" binding:"required"`
+		CheckpointID string `json:"checkpoint_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	storage := base.CurrentCheckpointStorage()
+	if storage == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "checkpoint storage not configured"})
+		return
+	}
+	cp, err := base.LoadCheckpoint(c.Request.Context(), storage, req.SessionID, req.CheckpointID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if cp == nil || cp.UserID != planOwner(c, req.UserID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "检查点不存在"})
+		return
+	}
+	switch cp.Status {
+	case base.CheckpointCompleted:
+		c.JSON(http.StatusConflict, gin.H{"error": "运行已完成，无需恢复", "checkpoint_id": cp.ID})
+		return
+	case base.CheckpointAwaitingApproval:
+		c.JSON(http.StatusConflict, gin.H{"error": "运行等待计划审批", "checkpoint_id": cp.ID, "plan_id": cp.PlanID})
+		return
+	}
+	var resumer base.CheckpointResumer
+	if r.agents != nil {
+		resumer, _ = r.agents.Named(cp.AgentName).(base.CheckpointResumer)
+	}
+	if resumer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "agent unavailable: " + cp.AgentName})
+		return
+	}
+
+	streamEvents(c, func(emit func(ev events.StreamEvent) error) error {
+		return resumer.ResumeCheckpoint(c.Request.Context(), cp, emit)
+	})
+}
+
+// streamEvents 以 SSE 推送 run 产生的事件，run 出错时追加一条 error 事件
+func streamEvents(c *gin.Context, run func(emit func(ev events.StreamEvent) error) error) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	var mu sync.Mutex
+	emit := func(ev events.StreamEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		c.SSEvent("message", ev)
+		c.Writer.Flush()
+		return c.Request.Context().Err()
+	}
+	if err := run(emit); err != nil {
+		_ = emit(events.StreamEvent{Type: events.Error, Data: err.Error()})
+	}
+}
+
+// planOwner 返回请求方的用户ID：优先取认证中间件写入的 user_id，否则使用请求参数
+func planOwner(c *gin.Context, fallback string) string {
+	if userID := c.GetString("user_id"); userID != "" {
+		return userID
+	}
+	return fallback
+}
+
+// loadPlan 读取待审批计划；计划不存在、已过期或不属于请求方时统一返回 404
+func (r *Router) loadPlan(c *gin.Context, userID string) (plans.Store, *plans.Plan, bool) {
+	store, _ := base.CurrentPlanStore()
+	if store == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "plan store not configured"})
+		return nil, nil, false
+	}
+	plan, err := store.Get(c.Request.Context(), c.Param("id"))
+	if err != nil && !errors.Is(err, plans.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	if err != nil || plan.UserID != planOwner(c, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "计划不存在或已过期"})
+		return nil, nil, false
+	}
+	return store, plan, true
+}
+
 // handleMonitorAlerts 处理监控告警
 func (r *Router) handleMonitorAlerts(c *gin.Context) {
 	alerts := r.monitor.GetAlerts()
@@ -901,9 +1241,13 @@ func (r *Router) handleListTools(c *gin.Context) {
 		},
 	}
 
+	// 已注册的智能体（编排器可调度的 action）
+	agentSpecs := base.RegisteredAgents()
+
 	c.JSON(http.StatusOK, gin.H{
-		"tools": tools,
-		"total": len(tools),
+		"tools":  tools,
+		"total":  len(tools),
+		"agents": agentSpecs,
 	})
 }
 
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	_ "github.com/blueplan/loomi-go/internal/loomi/agents"
+	"github.com/blueplan/loomi-go/internal/loomi/base"
+	"github.com/blueplan/loomi-go/internal/loomi/config"
+	"github.com/blueplan/loomi-go/internal/loomi/database"
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/plans"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+)
+
+// scriptedLLM answers sub-agent instructions with a fixed note and the orchestrator with a plan
+// of two actions on its first call and a finish afterwards
+type scriptedLLM struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (s *scriptedLLM) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []llm.Message, onChunk llm.StreamChunkHandler, opts ...llm.CallOptions) error {
+	last := messages[len(messages)-1].Text()
+	s.mu.Lock()
+	s.calls = append(s.calls, last)
+	planned := len(s.calls) > 1
+	s.mu.Unlock()
+	switch last {
+	case "brand":
+		return onChunk(ctx, `<brand_analysis1><title>品牌</title><content>品牌分析</content></brand_analysis1>`)
+	case "post A":
+		return onChunk(ctx, `<xhs_post1><title>笔记</title><content>笔记正文</content></xhs_post1>`)
+	}
+	if !planned {
+		return onChunk(ctx, `<orchestrator1><title>计划</title><content>先分析品牌再写笔记</content></orchestrator1><execute action="brand_analysis" instruction="brand" /><execute action="xhs_post" instruction="post A" />`)
+	}
+	return onChunk(ctx, `<orchestrator1><title>完成</title><content>已完成</content></orchestrator1><finish>ok</finish>`)
+}
+
+// called reports whether instruction reached the LLM
+func (s *scriptedLLM) called(instruction string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, call := range s.calls {
+		if call == instruction {
+			return true
+		}
+	}
+	return false
+}
+
+// memCheckpoints keeps checkpoints in memory; GetCheckpoint returns the session's latest one
+type memCheckpoints struct {
+	mu      sync.Mutex
+	records []database.CheckpointRecord
+}
+
+func (m *memCheckpoints) IsAvailable() bool                                      { return true }
+func (m *memCheckpoints) Ping(ctx context.Context) error                         { return nil }
+func (m *memCheckpoints) HealthCheck(ctx context.Context) map[string]interface{} { return nil }
+
+func (m *memCheckpoints) SaveCheckpoint(ctx context.Context, req database.SaveCheckpointRequest) (*database.SaveCheckpointResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records = append(m.records, database.CheckpointRecord{
+		ID:             int64(len(m.records) + 1),
+		ThreadID:       req.ThreadID,
+		CheckpointNS:   req.CheckpointNS,
+		CheckpointID:   req.CheckpointID,
+		UserID:         req.UserID,
+		SessionID:      req.SessionID,
+		CheckpointData: req.CheckpointData,
+	})
+	return &database.SaveCheckpointResponse{ID: int64(len(m.records))}, nil
+}
+
+func (m *memCheckpoints) GetCheckpoint(ctx context.Context, req database.GetCheckpointRequest) (*database.CheckpointRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.records) - 1; i >= 0; i-- {
+		rec := m.records[i]
+		if rec.ThreadID == req.ThreadID && rec.CheckpointNS == req.CheckpointNS && (req.CheckpointID == "" || rec.CheckpointID == req.CheckpointID) {
+			return &rec, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memCheckpoints) SaveCheckpointWrites(ctx context.Context, req database.SaveCheckpointWritesRequest) error {
+	return nil
+}
+
+// chattyLLM streams its answer in many small chunks
+type chattyLLM struct{}
+
+func (chattyLLM) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []llm.Message, onChunk llm.StreamChunkHandler, opts ...llm.CallOptions) error {
+	for i := 0; i < 40; i++ {
+		if err := onChunk(ctx, "好的，"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newTestRouter(t *testing.T, client llm.Client, middleware ...gin.HandlerFunc) *Router {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := &Router{engine: gin.New()}
+	r.engine.Use(middleware...)
+	r.setupRoutes()
+	r.RegisterAgents(base.NewAgentPool(logx.NewLogger(t.TempDir()), client))
+	return r
+}
+
+func post(r *Router, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestChatAppliesPlanAndRequestBudget(t *testing.T) {
+	base.SetBudgets(config.BudgetConfig{Plans: map[string]config.BudgetLimits{"free": {MaxTokens: 5}}})
+	defer base.SetBudgets(config.BudgetConfig{})
+
+	// stands in for the auth middleware, which sets user_plan from the token's plan claim
+	r := newTestRouter(t, chattyLLM{}, func(c *gin.Context) {
+		c.Set("user_plan", c.GetHeader("X-User-Plan"))
+	})
+	chat := func(plan, body string) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/loomi/chat", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-Plan", plan)
+		r.engine.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("chat: status %d body %s", w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+	stopped := func(body string) bool {
+		return strings.Contains(body, `"status":"budget_exceeded"`) && strings.Contains(body, `"limit":"tokens"`) && strings.Contains(body, `"max":5`)
+	}
+
+	if body := chat("", `{"user_id":"u1","session_id":"s1","instruction":"你好"}`); strings.Contains(body, "budget_exceeded") {
+		t.Fatalf("unlimited request stopped: %s", body)
+	}
+	if body := chat("free", `{"user_id":"u1","session_id":"s2","instruction":"你好"}`); !stopped(body) {
+		t.Fatalf("plan budget not applied: %s", body)
+	}
+	if body := chat("", `{"user_id":"u1","session_id":"s3","instruction":"你好","budget":{"max_tokens":5}}`); !stopped(body) {
+		t.Fatalf("request budget not applied: %s", body)
+	}
+}
+
+func TestApprovePlanRunsParkedPlan(t *testing.T) {
+	store := plans.NewMemoryStore()
+	base.SetPlanStore(store, time.Hour)
+	defer base.SetPlanStore(nil, 0)
+
+	client := &scriptedLLM{}
+	r := newTestRouter(t, client)
+
+	// auto mode off: the orchestrator parks its first plan and stops
+	var planID string
+	err := r.agents.Named("loomi_orchestrator").ProcessRequest(context.Background(), types.AgentRequest{
+		UserID: "u1", SessionID: "s1", Instruction: "写一篇小红书笔记",
+	}, func(ev events.StreamEvent) error {
+		if ev.Content == events.ContentOrchestratorPlan {
+			planID, _ = ev.Data.(map[string]any)["plan_id"].(string)
+		}
+		return nil
+	})
+	if err != nil || planID == "" {
+		t.Fatalf("park: err=%v plan_id=%q", err, planID)
+	}
+	if client.called("brand") {
+		t.Fatal("parked plan ran before approval")
+	}
+
+	if w := post(r, "/api/loomi/plans/"+planID+"/approve", `{"user_id":"u2"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("approve by another user: status %d", w.Code)
+	}
+
+	w := post(r, "/api/loomi/plans/"+planID+"/approve", `{"user_id":"u1","decisions":[{"id":"a2","decision":"drop"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("approve: status %d body %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); strings.Contains(body, `"type":"error"`) || !strings.Contains(body, string(events.ContentOrchestratorIteration)) {
+		t.Fatalf("approve stream: %s", body)
+	}
+	if !client.called("brand") || client.called("post A") {
+		t.Fatalf("approved plan ran %q, want brand only", client.calls)
+	}
+
+	if _, err := store.Get(context.Background(), planID); err != plans.ErrNotFound {
+		t.Fatalf("plan still stored after approval: %v", err)
+	}
+	if w := post(r, "/api/loomi/plans/"+planID+"/approve", `{"user_id":"u1"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("second approve: status %d", w.Code)
+	}
+}
+
+func TestResumeContinuesFromCheckpoint(t *testing.T) {
+	storage := &memCheckpoints{}
+	base.SetCheckpointStorage(storage)
+	defer base.SetCheckpointStorage(nil)
+
+	// a run interrupted after its first action: brand analysis is done, the post is not
+	cp := base.Checkpoint{
+		RequestID:   "req-1",
+		AgentName:   "loomi_orchestrator",
+		UserID:      "u1",
+		SessionID:   "s1",
+		Instruction: "写一篇小红书笔记",
+		AutoMode:    true,
+		Iteration:   1,
+		Response:    `<execute action="brand_analysis" instruction="brand" /><execute action="xhs_post" instruction="post A" />`,
+		Messages:    []llm.Message{{Role: "user", Content: "写一篇小红书笔记"}},
+		Actions: []base.CheckpointAction{
+			{ID: "a1", Action: "brand_analysis", Instruction: "brand", Summary: "品牌分析"},
+			{ID: "a2", Action: "xhs_post", Instruction: "post A"},
+		},
+		Completed:  []string{"a1"},
+		TokensUsed: 120,
+		Status:     base.CheckpointRunning,
+		Seq:        2,
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.SaveCheckpoint(context.Background(), database.SaveCheckpointRequest{
+		ThreadID:       "s1",
+		CheckpointNS:   base.CheckpointNS,
+		CheckpointID:   "req-1-2",
+		UserID:         "u1",
+		SessionID:      "s1",
+		CheckpointData: data,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &scriptedLLM{}
+	r := newTestRouter(t, client)
+
+	if w := post(r, "/api/loomi/resume", `{"user_id":"u2","session_id":"s1"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("resume by another user: status %d", w.Code)
+	}
+
+	w := post(r, "/api/loomi/resume", `{"user_id":"u1","session_id":"s1"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("resume: status %d body %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); strings.Contains(body, `"type":"error"`) {
+		t.Fatalf("resume stream: %s", body)
+	}
+	// the saved decision is not planned again and the completed action is not run again; the post
+	// is a final action, so the run ends after it
+	if len(client.calls) != 1 || client.calls[0] != "post A" {
+		t.Fatalf("resumed run called %q, want post A only", client.calls)
+	}
+
+	last, err := base.LoadCheckpoint(context.Background(), storage, "s1", "")
+	if err != nil || last == nil {
+		t.Fatalf("latest checkpoint: %v", err)
+	}
+	if last.Status != base.CheckpointCompleted || last.RequestID != "req-1" {
+		t.Fatalf("latest checkpoint %s: status %s request %s", last.ID, last.Status, last.RequestID)
+	}
+	if w := post(r, "/api/loomi/resume", `{"user_id":"u1","session_id":"s1"}`); w.Code != http.StatusConflict {
+		t.Fatalf("resume of a completed run: status %d", w.Code)
+	}
+}
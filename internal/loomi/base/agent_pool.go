@@ -0,0 +1,70 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"sync"
+
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+)
+
+// AgentPool hands out one shared instance per action. Agents keep request state on their Run,
+// so an instance serves concurrent sessions; the pool is rebuilt when the registry changes.
+type AgentPool struct {
+	logger *logx.Logger
+	client llm.Client
+
+	mu     sync.Mutex
+	gen    uint64
+	agents map[string]types.Agent
+}
+
+// NewAgentPool creates an agent pool whose agents use logger and client
+func NewAgentPool(logger *logx.Logger, client llm.Client) *AgentPool {
+	return &AgentPool{logger: logger, client: client, agents: make(map[string]types.Agent)}
+}
+
+// Get returns the shared agent for action, or nil if none is registered
+func (p *AgentPool) Get(action string) types.Agent {
+	return p.shared(action, func() types.Agent {
+		return NewAgentForAction(action, p.logger, p.client)
+	})
+}
+
+// Named returns the shared agent registered as agentName, or nil if none is registered
+func (p *AgentPool) Named(agentName string) types.Agent {
+	spec, ok := LookupAgent(agentName)
+	if !ok || spec.New == nil {
+		return nil
+	}
+	if spec.Action != "" {
+		return p.Get(spec.Action)
+	}
+	return p.shared("name:"+agentName, func() types.Agent {
+		return spec.New(p.logger, p.client)
+	})
+}
+
+// shared returns the agent cached under key, building it on first use
+func (p *AgentPool) shared(key string, build func() types.Agent) types.Agent {
+	registryMu.RLock()
+	gen := registryGen
+	registryMu.RUnlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if gen != p.gen {
+		p.agents = make(map[string]types.Agent)
+		p.gen = gen
+	}
+	if ag, ok := p.agents[key]; ok {
+		return ag
+	}
+	ag := build()
+	if ag != nil {
+		p.agents[key] = ag
+	}
+	return ag
+}
@@ -4,19 +4,25 @@ package base
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/blueplan/loomi-go/internal/loomi/config"
 	contextx "github.com/blueplan/loomi-go/internal/loomi/context"
 	"github.com/blueplan/loomi-go/internal/loomi/events"
 	"github.com/blueplan/loomi-go/internal/loomi/llm"
 	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/monitoring"
 	"github.com/blueplan/loomi-go/internal/loomi/notes"
 	poolx "github.com/blueplan/loomi-go/internal/loomi/pool"
 	stopx "github.com/blueplan/loomi-go/internal/loomi/stop"
 	"github.com/blueplan/loomi-go/internal/loomi/tokens"
+	"github.com/blueplan/loomi-go/internal/loomi/tokens/tokenizer"
 	"github.com/blueplan/loomi-go/internal/loomi/types"
 	"github.com/blueplan/loomi-go/internal/loomi/utils/markdown"
 )
@@ -32,16 +38,14 @@ type BaseLoomiAgent struct {
 	TokenAccumulator tokens.Accumulator
 	PoolManager      poolx.Manager
 
-	// Session management
-	CurrentUserID    string
-	CurrentSessionID string
-	CurrentTokenKey  string
-
 	// Performance configuration
 	EnableThoughtStreaming bool
 	ThoughtMinLength       int
 	ThoughtBatchSize       int
 	EnableFastMode         bool
+	// Item streaming: emit each item when its closing tag arrives; progress adds item_progress events
+	EnableItemStreaming bool
+	EnableItemProgress  bool
 
 	// Redis pool type
 	RedisPoolType string
@@ -49,9 +53,8 @@ type BaseLoomiAgent struct {
 	// Stream storage
 	StreamStorageEnabled bool
 
-	// Disconnect detection
-	LastDisconnectLogTime map[string]time.Time
-	DisconnectLogInterval time.Duration
+	// Structured output: JSON Schema of the agent's items, nil disables the JSON fallback
+	OutputSchema json.RawMessage
 }
 
 // AgentRequest represents a request to an agent
@@ -75,10 +78,10 @@ func NewBaseLoomiAgent(agentName string, logger *logx.Logger, llmClient llm.Clie
 		ThoughtMinLength:       10,
 		ThoughtBatchSize:       5,
 		EnableFastMode:         false,
+		EnableItemStreaming:    true,
+		EnableItemProgress:     itemProgressDefault.Load(),
 		RedisPoolType:          determineRedisPoolType(agentName),
 		StreamStorageEnabled:   true,
-		LastDisconnectLogTime:  make(map[string]time.Time),
-		DisconnectLogInterval:  60 * time.Second,
 	}
 }
 
@@ -125,18 +128,6 @@ func (a *BaseLoomiAgent) ProcessRequest(
 	})
 }
 
-// SetCurrentSession sets the current session information
-func (a *BaseLoomiAgent) SetCurrentSession(userID, sessionID string) {
-	a.CurrentUserID = userID
-	a.CurrentSessionID = sessionID
-}
-
-// ClearCurrentSession clears the current session information
-func (a *BaseLoomiAgent) ClearCurrentSession() {
-	a.CurrentUserID = ""
-	a.CurrentSessionID = ""
-}
-
 // CheckAndRaiseIfStopped checks if the current session has been stopped
 func (a *BaseLoomiAgent) CheckAndRaiseIfStopped(ctx context.Context, userID, sessionID string) error {
 	if a.StopManager == nil {
@@ -188,6 +179,16 @@ func (a *BaseLoomiAgent) CreateNote(
 	userID, sessionID, action, name, contextStr string,
 	title, coverTitle string,
 	selectStatus *int,
+) error {
+	return a.CreateNoteWithHook(ctx, userID, sessionID, action, name, contextStr, title, coverTitle, "", selectStatus)
+}
+
+// CreateNoteWithHook creates a new note that also keeps the item's parsed hook
+func (a *BaseLoomiAgent) CreateNoteWithHook(
+	ctx context.Context,
+	userID, sessionID, action, name, contextStr string,
+	title, coverTitle, hook string,
+	selectStatus *int,
 ) error {
 	if a.NotesService == nil {
 		return fmt.Errorf("notes service not available")
@@ -199,7 +200,11 @@ func (a *BaseLoomiAgent) CreateNote(
 		selectStatus = &autoSelect
 	}
 
-	return a.NotesService.Create(userID, sessionID, action, name, title, contextStr, *selectStatus)
+	prompt := RunFrom(ctx).Prompt()
+	if prompt.Experiment != "" {
+		monitoring.DefaultExperimentMetrics.RecordNote(userID, sessionID, name, prompt.Experiment, prompt.Variant, *selectStatus == 1)
+	}
+	return a.NotesService.Create(userID, sessionID, action, name, title, contextStr, hook, *selectStatus, prompt)
 }
 
 // ShouldEmitThought determines if thought content should be emitted
@@ -221,15 +226,19 @@ func (a *BaseLoomiAgent) SafeStreamCall(
 	userID, sessionID string,
 	messages []llm.Message,
 	onChunk func(ctx context.Context, chunk string) error,
+	opts ...llm.CallOptions,
 ) error {
 	// Check stop status before starting
 	if err := a.CheckAndRaiseIfStopped(ctx, userID, sessionID); err != nil {
 		return err
 	}
 
-	// Set current session
-	a.SetCurrentSession(userID, sessionID)
-	defer a.ClearCurrentSession()
+	// 预算（token、调用次数、时长、会话累计）用尽时不再发起调用
+	if err := CheckBudget(ctx, a.TokenAccumulator, userID, sessionID); err != nil {
+		return err
+	}
+
+	run := RunFrom(ctx)
 
 	// Prepare token accumulator key
 	if a.TokenAccumulator != nil {
@@ -239,44 +248,147 @@ func (a *BaseLoomiAgent) SafeStreamCall(
 		}
 	}
 
+	// 调用前按分词器统计 prompt token
+	promptTokens := a.EstimatePromptTokens(messages)
+
 	// Perform streaming call
 	chunkCount := 0
 	stopCheckInterval := 10
-
-	err := a.LLMClient.SafeStreamCall(ctx, userID, sessionID, messages, func(ctx context.Context, chunk string) error {
-		// Check stop status periodically
-		chunkCount++
-		if chunkCount%stopCheckInterval == 0 {
-			if err := a.CheckAndRaiseIfStopped(ctx, userID, sessionID); err != nil {
+	// completion 是已交给 onChunk 的全部文本，跨重试累积；completionTokens 是其 token 数，逐块累加
+	var completion strings.Builder
+	completionTokens := 0
+
+	// 智能体默认参数在前，调用方传入的选项覆盖之
+	callOpts := llm.ResolveCallOptions(append([]llm.CallOptions{a.BuildCallOptions()}, opts...)...)
+
+	// 携带智能体名称，供 llm.Router 按智能体选择 provider 链；优先级供并发调度使用
+	llmCtx := llm.WithPriority(llm.WithAgentName(ctx, a.AgentName), a.RedisPoolType)
+	llmCtx, cancelWall := run.wallClock(llmCtx)
+	defer cancelWall()
+
+	var err error
+	used := 0
+	for attempt := 0; ; attempt++ {
+		// 每次尝试（含重试）都计为一次模型调用，重试前同样检查预算
+		if attempt > 0 {
+			if err = CheckBudget(ctx, a.TokenAccumulator, userID, sessionID); err != nil {
+				break
+			}
+		}
+		run.CountLLMCall()
+
+		var chunkErr error
+		forward := func(ctx context.Context, chunk string) error {
+			// Check stop status periodically
+			chunkCount++
+			if chunkCount%stopCheckInterval == 0 {
+				if err := a.CheckAndRaiseIfStopped(ctx, userID, sessionID); err != nil {
+					chunkErr = err
+					return err
+				}
+				// 流式过程中按已生成的 token 与时长检查预算，超出即中断本次调用
+				if run != nil {
+					if err := run.checkRunBudget(promptTokens + completionTokens); err != nil {
+						chunkErr = err
+						return err
+					}
+				}
+			}
+			completion.WriteString(chunk)
+			completionTokens += tokenizer.Default().Count(chunk)
+			if err := onChunk(ctx, chunk); err != nil {
+				chunkErr = err
 				return err
 			}
+			return nil
 		}
-		// 近似按 chunk 长度统计 token（可替换为 provider 返回的token用量）
-		if a.TokenAccumulator != nil {
-			n := len([]rune(chunk)) / 4
-			if n < 1 {
-				n = 1
+
+		next := forward
+		var filter *resumeFilter
+		if completion.Len() > 0 {
+			// 已有部分输出时重新生成完整回答，越过已输出的部分后才继续转发
+			filter = newResumeFilter(completion.String())
+			next = func(ctx context.Context, chunk string) error {
+				return filter.write(ctx, chunk, forward)
 			}
-			a.TokenAccumulator.Add(userID, sessionID, n)
 		}
-		return onChunk(ctx, chunk)
-	})
+		// streamed 是本次尝试收到的全部文本（含被跳过的重复部分），用于估算用量
+		var streamed strings.Builder
+		handler := func(ctx context.Context, chunk string) error {
+			streamed.WriteString(chunk)
+			return next(ctx, chunk)
+		}
+
+		attemptOpts := callOpts
+		toolCalled := false
+		if onToolCall := callOpts.OnToolCall; onToolCall != nil {
+			attemptOpts.OnToolCall = func(ctx context.Context, d llm.ToolCallDelta) error {
+				toolCalled = true
+				return onToolCall(ctx, d)
+			}
+		}
+
+		// 每次尝试单独收集 provider 用量，重试与并发调用互不覆盖
+		res := &llm.CallResult{}
+		err = a.LLMClient.SafeStreamCall(llm.WithCallResult(llmCtx, res), userID, sessionID, messages, handler, attemptOpts)
+		if err == nil && filter != nil {
+			err = filter.flush()
+		}
+		used += attemptTokens(res, promptTokens, streamed.String())
+		if errors.Is(err, ErrResumeDiverged) {
+			a.Logger.Warn(ctx, "llm.stream.resume_diverged",
+				logx.KV("agent", a.AgentName),
+				logx.KV("attempt", attempt+1),
+				logx.KV("emitted_chars", completion.Len()))
+			emitSystemNotice(ctx, "重试生成的内容与已输出的部分不一致，无法接续，本次生成已停止。", map[string]any{
+				"reason":        "llm_resume_diverged",
+				"agent":         a.AgentName,
+				"emitted_chars": completion.Len(),
+			})
+		}
+		// 工具调用片段无法重放，调用方停止或非瞬时错误也不重试
+		if err == nil || chunkErr != nil || toolCalled || llmCtx.Err() != nil || !llm.IsRetryable(err) || attempt >= maxStreamRetries {
+			break
+		}
+
+		delay := retryBackoff(attempt)
+		a.Logger.Warn(ctx, "llm.stream.retry",
+			logx.KV("agent", a.AgentName),
+			logx.KV("attempt", attempt+1),
+			logx.KV("delay_ms", delay.Milliseconds()),
+			logx.KV("resumed_chars", completion.Len()),
+			logx.KV("error", err))
+		msg := fmt.Sprintf("模型连接中断，%.1f 秒后进行第 %d 次重试…", delay.Seconds(), attempt+1)
+		if completion.Len() > 0 {
+			msg = fmt.Sprintf("模型连接中断，%.1f 秒后进行第 %d 次重试，已输出的内容不会重复…", delay.Seconds(), attempt+1)
+		}
+		emitSystemNotice(ctx, msg, map[string]any{
+			"reason":        "llm_retry",
+			"agent":         a.AgentName,
+			"attempt":       attempt + 1,
+			"delay_ms":      delay.Milliseconds(),
+			"resumed_chars": completion.Len(),
+		})
+		if sleepErr := sleepCtx(ctx, delay); sleepErr != nil {
+			err = sleepErr
+			break
+		}
+	}
+
+	a.recordTokenUsage(ctx, userID, sessionID, used)
+	if err != nil && ctx.Err() == nil && run != nil {
+		// 墙钟预算到期或流式中超出预算时，返回预算错误而不是底层的超时错误
+		if llmCtx.Err() != nil {
+			_ = run.checkRunBudget(0)
+		}
+		if budgetErr := run.BudgetErr(); budgetErr != nil {
+			err = budgetErr
+		}
+	}
 
 	// Final stop check
 	if err := a.CheckAndRaiseIfStopped(ctx, userID, sessionID); err != nil {
 		if a.TokenAccumulator != nil {
-			// 若 LLM client 支持用量，优先按 provider 用量补一次精确统计
-			if up, ok := a.LLMClient.(interface {
-				LastUsage(string, string) (int, int, bool)
-			}); ok {
-				if p, c, ok2 := up.LastUsage(userID, sessionID); ok2 {
-					// 简单合并：prompt+completion
-					total := p + c
-					if total > 0 {
-						a.TokenAccumulator.Add(userID, sessionID, total)
-					}
-				}
-			}
 			if _, sumErr := a.TokenAccumulator.Summary(userID, sessionID); sumErr != nil {
 				a.Logger.Error(ctx, "token.summary.error", logx.KV("error", sumErr))
 			}
@@ -290,6 +402,86 @@ func (a *BaseLoomiAgent) SafeStreamCall(
 	return err
 }
 
+// maxToolRounds caps how many tool-call round trips SafeStreamCallWithTools performs
+const maxToolRounds = 5
+
+// SafeStreamCallWithTools runs SafeStreamCall with native tool calling: tool calls streamed by
+// the model are dispatched to tools and their results fed back until the model answers without
+// calling a tool. Clients without tool support get a plain SafeStreamCall, and the agent's XML
+// tag parsing remains the way side effects are triggered.
+func (a *BaseLoomiAgent) SafeStreamCallWithTools(
+	ctx context.Context,
+	userID, sessionID string,
+	messages []llm.Message,
+	tools *llm.ToolSet,
+	onChunk func(ctx context.Context, chunk string) error,
+	opts ...llm.CallOptions,
+) error {
+	if tools == nil || !llm.SupportsTools(a.LLMClient) {
+		return a.SafeStreamCall(ctx, userID, sessionID, messages, onChunk, opts...)
+	}
+
+	history := append([]llm.Message(nil), messages...)
+	for round := 0; round < maxToolRounds; round++ {
+		acc := llm.NewToolCallAccumulator()
+		var text strings.Builder
+		roundOpts := append(append([]llm.CallOptions{}, opts...), llm.CallOptions{Tools: tools.Tools(), OnToolCall: acc.Add})
+		err := a.SafeStreamCall(ctx, userID, sessionID, history, func(ctx context.Context, chunk string) error {
+			text.WriteString(chunk)
+			return onChunk(ctx, chunk)
+		}, roundOpts...)
+		if err != nil {
+			return err
+		}
+
+		calls := acc.Calls()
+		if len(calls) == 0 {
+			return nil
+		}
+		history = append(history, llm.Message{Role: "assistant", Content: text.String(), ToolCalls: calls})
+		for _, call := range calls {
+			a.Logger.Info(ctx, "tool.dispatch",
+				logx.KV("agent", a.AgentName),
+				logx.KV("tool", call.Name),
+				logx.KV("round", round+1))
+			history = append(history, tools.Dispatch(ctx, call))
+		}
+	}
+
+	// 达到轮次上限，禁止继续调用工具，让模型基于已有结果作答
+	a.Logger.Warn(ctx, "tool.rounds.exhausted", logx.KV("agent", a.AgentName), logx.KV("max_rounds", maxToolRounds))
+	finalOpts := append(append([]llm.CallOptions{}, opts...), llm.CallOptions{Tools: tools.Tools(), ToolChoice: "none"})
+	return a.SafeStreamCall(ctx, userID, sessionID, history, onChunk, finalOpts...)
+}
+
+// EstimatePromptTokens counts the prompt tokens of messages with the configured tokenizer
+func (a *BaseLoomiAgent) EstimatePromptTokens(messages []llm.Message) int {
+	contents := make([]string, 0, len(messages))
+	for _, m := range messages {
+		contents = append(contents, m.Text())
+	}
+	return tokenizer.CountChat(tokenizer.Default(), contents)
+}
+
+// attemptTokens returns one attempt's usage: what the provider reported for it, otherwise the
+// estimated prompt tokens plus the tokens of the text it streamed. Cache hits never reach a
+// provider and are always billed at the estimate.
+func attemptTokens(res *llm.CallResult, promptTokens int, streamed string) int {
+	if p, c, ok := res.Usage(); ok && p+c > 0 && !res.Cached() {
+		return p + c
+	}
+	return promptTokens + tokenizer.Default().Count(streamed)
+}
+
+// recordTokenUsage adds one call's usage, summed over its attempts, to the accumulator and the
+// run exactly once
+func (a *BaseLoomiAgent) recordTokenUsage(ctx context.Context, userID, sessionID string, used int) {
+	RunFrom(ctx).CountTokens(used)
+	if a.TokenAccumulator != nil {
+		a.TokenAccumulator.Add(userID, sessionID, used)
+	}
+}
+
 // BuildCleanAgentPrompt builds a clean prompt for agents
 func (a *BaseLoomiAgent) BuildCleanAgentPrompt(
 	ctx context.Context,
@@ -297,6 +489,10 @@ func (a *BaseLoomiAgent) BuildCleanAgentPrompt(
 	autoMode bool,
 	userSelections []string,
 ) (string, error) {
+	// Notes the user selected this round count as conversions for prompt experiments
+	if len(userSelections) > 0 {
+		monitoring.DefaultExperimentMetrics.RecordSelections(userID, sessionID, userSelections)
+	}
 	if a.ContextManager == nil {
 		return instruction, nil
 	}
@@ -315,21 +511,25 @@ func (a *BaseLoomiAgent) BuildCleanAgentPrompt(
 	return instruction, nil
 }
 
-// ExtractOtherContent extracts content outside of specified tags
+// trailingPartialTag matches a tag cut off mid-name at the end of a truncated stream
+var trailingPartialTag = regexp.MustCompile(`<[A-Za-z/][^<>]*$`)
+
+// ExtractOtherContent extracts content outside of specified tags. Blocks left open by a truncated
+// stream and stray closing tags are removed as well.
 func (a *BaseLoomiAgent) ExtractOtherContent(response string, tagPatterns []string) string {
 	cleaned := response
 
 	for _, pattern := range tagPatterns {
-		// Convert pattern to regex
-		regexPattern := strings.Replace(pattern, "\\d+", "\\d+", -1)
-		startPattern := regexPattern
-		endPattern := strings.Replace(regexPattern, "<", "</", 1)
-
-		// Remove complete tag blocks
-		fullPattern := fmt.Sprintf("%s.*?%s", startPattern, endPattern)
-		re := regexp.MustCompile(fullPattern)
-		cleaned = re.ReplaceAllString(cleaned, "")
+		// Accept patterns written as `<tag\\d+>` as well as `<tag\d+>`
+		startPattern := strings.ReplaceAll(pattern, `\\d+`, `\d+`)
+		endPattern := strings.Replace(startPattern, "<", "</", 1)
+
+		// Remove complete tag blocks, then an unclosed one and leftover closing tags
+		cleaned = regexp.MustCompile(startPattern+`[\s\S]*?`+endPattern).ReplaceAllString(cleaned, "")
+		cleaned = regexp.MustCompile(startPattern+`[\s\S]*$`).ReplaceAllString(cleaned, "")
+		cleaned = regexp.MustCompile(endPattern).ReplaceAllString(cleaned, "")
 	}
+	cleaned = trailingPartialTag.ReplaceAllString(cleaned, "")
 
 	// Clean up extra whitespace
 	re := regexp.MustCompile(`\n\s*\n`)
@@ -351,73 +551,45 @@ func (a *BaseLoomiAgent) EnsureMarkdownCompatibility(text string) string {
 
 // Helper functions
 
+// defaultAgentTemperature applies to agents that are not registered or leave Temperature unset
+const defaultAgentTemperature = 0.4
+
 func determineRedisPoolType(agentName string) string {
-	if strings.Contains(strings.ToLower(agentName), "orchestrator") ||
-		strings.Contains(strings.ToLower(agentName), "concierge") {
-		return "high_priority"
+	if spec, ok := LookupAgent(agentName); ok {
+		return spec.PoolType
 	}
 	return "normal"
 }
 
 func (a *BaseLoomiAgent) getAutoSelectStatus(action string) int {
-	autoSelectActions := map[string]bool{
-		"websearch":        true,
-		"persona":          true,
-		"brand_analysis":   true,
-		"knowledge":        true,
-		"content_analysis": true,
-		"resonant":         true,
-	}
-
-	userSelectActions := map[string]bool{
-		"hitpoint":       true,
-		"xhs_post":       true,
-		"wechat_article": true,
-		"tiktok_script":  true,
-		"revision":       true,
+	spec, ok := lookupNoteAction(action)
+	if !ok {
+		a.Logger.Warn(context.Background(), "Unknown action type, defaulting to user selection", logx.KV("action", action))
+		return 0
 	}
-
-	if autoSelectActions[action] {
+	if spec.AutoSelect {
 		return 1
 	}
-
-	if userSelectActions[action] {
-		return 0
-	}
-
-	a.Logger.Warn(context.Background(), "Unknown action type, defaulting to user selection", logx.KV("action", action))
 	return 0
 }
 
 // GetAgentTemperature returns the temperature setting for the agent
 func (a *BaseLoomiAgent) GetAgentTemperature() float64 {
-	highTempAgents := map[string]bool{
-		"loomi_hitpoint_agent":       true,
-		"loomi_tiktok_script_agent":  true,
-		"loomi_wechat_article_agent": true,
-		"loomi_xhs_post_agent":       true,
-		"loomi_revision_agent":       true,
-	}
-
-	switch a.AgentName {
-	case "loomi_orchestrator":
-		return 0.5
-	case "loomi_concierge":
-		return 0.4
-	case "loomi_knowledge_agent":
-		return 0.3
-	case "loomi_websearch_agent":
-		return 0.1
-	default:
-		if highTempAgents[a.AgentName] {
-			return 0.6
-		}
-		return 0.4
+	if o, ok := agentLLMOverride(a.AgentName); ok && o.Temperature != nil {
+		return *o.Temperature
+	}
+	if spec, ok := LookupAgent(a.AgentName); ok && spec.Temperature != nil {
+		return *spec.Temperature
 	}
+	return defaultAgentTemperature
 }
 
 // GetThinkingBudget returns the thinking budget for the agent
 func (a *BaseLoomiAgent) GetThinkingBudget() int {
+	if o, ok := agentLLMOverride(a.AgentName); ok && o.ThinkingBudget != nil {
+		return *o.ThinkingBudget
+	}
+
 	highBudgetAgents := map[string]bool{
 		"loomi_hitpoint_agent":       true,
 		"loomi_tiktok_script_agent":  true,
@@ -431,3 +603,40 @@ func (a *BaseLoomiAgent) GetThinkingBudget() int {
 	}
 	return 128
 }
+
+var (
+	agentLLMOverridesMu sync.RWMutex
+	agentLLMOverrides   = map[string]config.AgentLLMConfig{}
+)
+
+// SetAgentLLMOverrides installs per-agent generation overrides (config llm.agents), keyed by agent name
+func SetAgentLLMOverrides(overrides map[string]config.AgentLLMConfig) {
+	agentLLMOverridesMu.Lock()
+	defer agentLLMOverridesMu.Unlock()
+	agentLLMOverrides = make(map[string]config.AgentLLMConfig, len(overrides))
+	for name, o := range overrides {
+		agentLLMOverrides[name] = o
+	}
+}
+
+func agentLLMOverride(agentName string) (config.AgentLLMConfig, bool) {
+	agentLLMOverridesMu.RLock()
+	defer agentLLMOverridesMu.RUnlock()
+	o, ok := agentLLMOverrides[agentName]
+	return o, ok
+}
+
+// BuildCallOptions returns the generation options for this agent, applying config overrides
+func (a *BaseLoomiAgent) BuildCallOptions() llm.CallOptions {
+	opts := llm.CallOptions{
+		Temperature:    llm.Float64(a.GetAgentTemperature()),
+		ThinkingBudget: a.GetThinkingBudget(),
+	}
+	if o, ok := agentLLMOverride(a.AgentName); ok {
+		opts.Model = o.Model
+		opts.TopP = o.TopP
+		opts.MaxTokens = o.MaxTokens
+		opts.Stop = o.Stop
+	}
+	return opts
+}
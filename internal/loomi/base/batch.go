@@ -0,0 +1,114 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	xmlx "github.com/blueplan/loomi-go/internal/loomi/utils/xml"
+)
+
+var (
+	mergeThreshold atomic.Int64
+	mergeMaxBatch  atomic.Int64
+)
+
+func init() {
+	mergeThreshold.Store(2)
+	mergeMaxBatch.Store(5)
+}
+
+// SetMergeLimits sets how many same-type mergeable actions of one iteration it takes to merge
+// them, and how many tasks one merged request carries at most; orchestrators read them per iteration
+func SetMergeLimits(threshold, maxBatchSize int) {
+	if threshold > 0 {
+		mergeThreshold.Store(int64(threshold))
+	}
+	if maxBatchSize > 0 {
+		mergeMaxBatch.Store(int64(maxBatchSize))
+	}
+}
+
+// MergeLimits returns the configured merge threshold and maximum batch size
+func MergeLimits() (threshold, maxBatchSize int) {
+	return int(mergeThreshold.Load()), int(mergeMaxBatch.Load())
+}
+
+// BatchInstruction is the instruction of a request merging tasks whose items are written in
+// <tagN> blocks: the items of task K go in <tagK>
+func BatchInstruction(tag string, tasks []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "以下 %d 个任务请在一次回复中依次完成。第 K 个任务的所有条目都使用编号为 K 的标签（如第 2 个任务使用 <%s2>，同一任务的多个条目重复使用该编号），不要混用编号。\n", len(tasks), tag)
+	for i, task := range tasks {
+		fmt.Fprintf(&b, "\n任务 %d：%s", i+1, task)
+	}
+	return b.String()
+}
+
+// taskOf returns the 1-based task an item of a merged request belongs to: the <tagK> number
+// when it names a task, otherwise its position, capped at the last task
+func taskOf(result xmlx.ParseResult, position, tasks int) int {
+	if result.Index >= 1 && result.Index <= tasks {
+		return result.Index
+	}
+	return min(position, tasks)
+}
+
+// SplitBatch wraps the emit of a merged request so its result events are reported per task:
+// items are grouped by task and sent through the task's outcome with that task's instruction,
+// while other events are sent once and observed by every task
+func SplitBatch(emit func(ev events.StreamEvent) error, outcomes []*ActionOutcome) func(ev events.StreamEvent) error {
+	var mu sync.Mutex
+	positions := make([]int, len(outcomes))
+	return func(ev events.StreamEvent) error {
+		items, ok := ev.Data.([]map[string]any)
+		if !ok || len(items) == 0 || items[0]["task"] == nil {
+			mu.Lock()
+			for _, outcome := range outcomes {
+				outcome.observe(ev)
+			}
+			mu.Unlock()
+			return emit(ev)
+		}
+
+		groups := make([][]map[string]any, len(outcomes))
+		for _, item := range items {
+			task, _ := item["task"].(int)
+			task = min(max(task, 1), len(outcomes))
+			clean := make(map[string]any, len(item))
+			for k, v := range item {
+				if k != "task" {
+					clean[k] = v
+				}
+			}
+			groups[task-1] = append(groups[task-1], clean)
+		}
+		for i, group := range groups {
+			if len(group) == 0 {
+				continue
+			}
+			meta := make(map[string]any, len(ev.Meta)+2)
+			for k, v := range ev.Meta {
+				meta[k] = v
+			}
+			meta["instruction"] = outcomes[i].Instruction
+			meta["batch"] = map[string]any{"task": i + 1, "size": len(outcomes)}
+			mu.Lock()
+			if ev.Meta["stream"] == "item" {
+				positions[i] += len(group)
+				meta["position"] = positions[i]
+			}
+			taskEv := events.StreamEvent{Type: ev.Type, Content: ev.Content, Data: group, Meta: meta}
+			outcomes[i].observe(taskEv)
+			mu.Unlock()
+			if err := emit(taskEv); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,184 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/config"
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	"github.com/blueplan/loomi-go/internal/loomi/tokens"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+)
+
+var budgetCfg atomic.Pointer[config.BudgetConfig]
+
+// SetBudgets installs the default and per-plan budgets of requests started afterwards
+func SetBudgets(cfg config.BudgetConfig) {
+	budgetCfg.Store(&cfg)
+}
+
+// BudgetFor returns the budget of a request: the budget of its user plan (the default budget for
+// unknown plans), tightened by the request's own budget
+func BudgetFor(plan string, override *types.Budget) types.Budget {
+	var limits config.BudgetLimits
+	if cfg := budgetCfg.Load(); cfg != nil {
+		limits = cfg.Default
+		if planLimits, ok := cfg.Plans[plan]; ok && plan != "" {
+			limits = planLimits
+		}
+	}
+	budget := types.Budget{
+		MaxTokens:        limits.MaxTokens,
+		MaxSessionTokens: limits.MaxSessionTokens,
+		MaxLLMCalls:      limits.MaxLLMCalls,
+		MaxWallTime:      time.Duration(limits.MaxWallTimeSeconds) * time.Second,
+	}
+	if override != nil {
+		budget.MaxTokens = tighter(budget.MaxTokens, override.MaxTokens)
+		budget.MaxSessionTokens = tighter(budget.MaxSessionTokens, override.MaxSessionTokens)
+		budget.MaxLLMCalls = tighter(budget.MaxLLMCalls, override.MaxLLMCalls)
+		budget.MaxWallTime = tighter(budget.MaxWallTime, override.MaxWallTime)
+	}
+	return budget
+}
+
+// tighter returns the smaller of two limits, 0 being unlimited
+func tighter[T int | time.Duration](limit, override T) T {
+	if override > 0 && (limit == 0 || override < limit) {
+		return override
+	}
+	return limit
+}
+
+// Budget limits reported by BudgetExceededError
+const (
+	BudgetTokens        = "tokens"
+	BudgetSessionTokens = "session_tokens"
+	BudgetLLMCalls      = "llm_calls"
+	BudgetWallTime      = "wall_time_seconds"
+)
+
+// ErrBudgetExceeded matches every *BudgetExceededError
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// BudgetExceededError reports which limit of the request's budget was reached
+type BudgetExceededError struct {
+	Limit string
+	Used  int64
+	Max   int64
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("budget exceeded: %s %d/%d", e.Limit, e.Used, e.Max)
+}
+
+func (e *BudgetExceededError) Is(target error) bool {
+	return target == ErrBudgetExceeded
+}
+
+// Reason explains the stop to the user
+func (e *BudgetExceededError) Reason() string {
+	switch e.Limit {
+	case BudgetTokens:
+		return fmt.Sprintf("本次请求已使用 %d tokens，超出预算上限 %d，已停止生成，剩余动作已跳过", e.Used, e.Max)
+	case BudgetSessionTokens:
+		return fmt.Sprintf("本会话累计已使用 %d tokens，超出会话预算上限 %d，已停止生成，剩余动作已跳过", e.Used, e.Max)
+	case BudgetLLMCalls:
+		return fmt.Sprintf("本次请求已调用模型 %d 次，达到调用次数上限 %d，已停止生成，剩余动作已跳过", e.Used, e.Max)
+	case BudgetWallTime:
+		return fmt.Sprintf("本次请求已运行 %d 秒，超出时长上限 %d 秒，已停止生成，剩余动作已跳过", e.Used, e.Max)
+	}
+	return e.Error()
+}
+
+// CheckBudget checks the request's budget before an LLM call or an action: the run's tokens, LLM
+// calls and wall time, and the session's running total in acc. The first limit reached stops the
+// request with a billing_summary event; afterwards every check returns the same error.
+func CheckBudget(ctx context.Context, acc tokens.Accumulator, userID, sessionID string) error {
+	run := RunFrom(ctx)
+	if run == nil {
+		return nil
+	}
+	if err := run.BudgetErr(); err != nil {
+		return err
+	}
+	root := run.root()
+	budget := root.Budget
+	if budget.MaxLLMCalls > 0 {
+		if calls := root.llmCalls.Load(); calls >= int64(budget.MaxLLMCalls) {
+			return root.exceedBudget(&BudgetExceededError{Limit: BudgetLLMCalls, Used: calls, Max: int64(budget.MaxLLMCalls)})
+		}
+	}
+	if err := root.checkRunBudget(0); err != nil {
+		return err
+	}
+	if budget.MaxSessionTokens > 0 && acc != nil {
+		if total := acc.Total(userID, sessionID); total >= budget.MaxSessionTokens {
+			return root.exceedBudget(&BudgetExceededError{Limit: BudgetSessionTokens, Used: int64(total), Max: int64(budget.MaxSessionTokens)})
+		}
+	}
+	return nil
+}
+
+// checkRunBudget checks the request's tokens, counting inFlight tokens of a call still streaming,
+// and its wall time
+func (r *Run) checkRunBudget(inFlight int) error {
+	root := r.root()
+	budget := root.Budget
+	if budget.MaxTokens > 0 {
+		if used := root.Tokens() + inFlight; used >= budget.MaxTokens {
+			return root.exceedBudget(&BudgetExceededError{Limit: BudgetTokens, Used: int64(used), Max: int64(budget.MaxTokens)})
+		}
+	}
+	if budget.MaxWallTime > 0 {
+		if elapsed := time.Since(root.started); elapsed >= budget.MaxWallTime {
+			return root.exceedBudget(&BudgetExceededError{Limit: BudgetWallTime, Used: int64(elapsed.Seconds()), Max: int64(budget.MaxWallTime.Seconds())})
+		}
+	}
+	return nil
+}
+
+// wallClock bounds ctx by the request's wall time budget, so a stalled stream is cut off too
+func (r *Run) wallClock(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r == nil || r.root().Budget.MaxWallTime <= 0 {
+		return ctx, func() {}
+	}
+	root := r.root()
+	return context.WithDeadline(ctx, root.started.Add(root.Budget.MaxWallTime))
+}
+
+// exceedBudget stops the root run on its first exceeded limit and tells the user why; later calls
+// return that first error
+func (r *Run) exceedBudget(err *BudgetExceededError) error {
+	r.mu.Lock()
+	if r.budgetErr != nil {
+		defer r.mu.Unlock()
+		return r.budgetErr
+	}
+	r.budgetErr = err
+	r.mu.Unlock()
+
+	if r.Emit != nil {
+		used := r.Tokens()
+		_ = r.Emit(events.StreamEvent{
+			Type:    events.LLMChunk,
+			Content: events.ContentBillingSummary,
+			Data: map[string]any{
+				"status":       "budget_exceeded",
+				"reason":       err.Reason(),
+				"limit":        err.Limit,
+				"used":         err.Used,
+				"max":          err.Max,
+				"total_tokens": used,
+				"cost":         tokens.Cost(used),
+				"stats":        r.Stats(),
+			},
+		})
+	}
+	return err
+}
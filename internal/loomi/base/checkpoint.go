@@ -0,0 +1,308 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/database"
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+)
+
+// CheckpointNS namespaces orchestrator checkpoints among the graph checkpoints
+const CheckpointNS = "loomi_orchestrator"
+
+// Checkpoint statuses; only running checkpoints can be resumed
+const (
+	CheckpointRunning          = "running"
+	CheckpointAwaitingApproval = "awaiting_approval"
+	CheckpointCompleted        = "completed"
+)
+
+var (
+	checkpointMu      sync.RWMutex
+	checkpointStorage database.CheckpointStorage
+)
+
+// SetCheckpointStorage installs the storage orchestrators save their checkpoints to
+func SetCheckpointStorage(cs database.CheckpointStorage) {
+	checkpointMu.Lock()
+	defer checkpointMu.Unlock()
+	checkpointStorage = cs
+}
+
+// CurrentCheckpointStorage returns the installed checkpoint storage, or nil when runs are not checkpointed
+func CurrentCheckpointStorage() database.CheckpointStorage {
+	checkpointMu.RLock()
+	defer checkpointMu.RUnlock()
+	return checkpointStorage
+}
+
+// CheckpointAction is one action of a checkpointed plan and, once completed, what it produced
+type CheckpointAction struct {
+	ID          string   `json:"id"`
+	Action      string   `json:"action"`
+	Instruction string   `json:"instruction"`
+	Summary     string   `json:"summary,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	Skipped     bool     `json:"skipped,omitempty"`
+	NoteIDs     []string `json:"note_ids,omitempty"`
+}
+
+// Checkpoint is an orchestrator run's state after a decision or a completed action. Messages is
+// the conversation before the decision, so a resumed run finishes the iteration without planning
+// it again and without re-running its completed actions; file parts are kept as FileRefs.
+type Checkpoint struct {
+	ID        string `json:"id"`
+	RequestID string `json:"request_id"`
+	AgentName string `json:"agent_name"`
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+	UserPlan  string `json:"user_plan,omitempty"`
+
+	Instruction string   `json:"instruction"`
+	AutoMode    bool     `json:"auto_mode"`
+	Selections  []string `json:"selections,omitempty"`
+	UseFiles    bool     `json:"use_files,omitempty"`
+	FileIDs     []string `json:"file_ids,omitempty"`
+
+	Iteration int                `json:"iteration"`
+	Response  string             `json:"response"`
+	Messages  []llm.Message      `json:"messages"`
+	Actions   []CheckpointAction `json:"actions"`
+	Completed []string           `json:"completed"`
+	NoteIDs   []string           `json:"note_ids"`
+
+	TokensUsed int       `json:"tokens_used"`
+	Status     string    `json:"status"`
+	PlanID     string    `json:"plan_id,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// Seq numbers the run's checkpoints; the decision checkpoint is the one action writes refer to
+	Seq         int    `json:"seq"`
+	DecisionID  string `json:"decision_id,omitempty"`
+	DecisionRef int64  `json:"decision_ref,omitempty"`
+}
+
+// Request rebuilds the request the checkpointed run was serving
+func (cp *Checkpoint) Request() types.AgentRequest {
+	return types.AgentRequest{
+		UserID:      cp.UserID,
+		SessionID:   cp.SessionID,
+		UserPlan:    cp.UserPlan,
+		Instruction: cp.Instruction,
+		AutoMode:    cp.AutoMode,
+		Selections:  cp.Selections,
+		UseFiles:    cp.UseFiles,
+		FileIDs:     cp.FileIDs,
+	}
+}
+
+// Outcomes returns the outcomes of the completed actions by action ID
+func (cp *Checkpoint) Outcomes() map[string]*ActionOutcome {
+	done := make(map[string]bool, len(cp.Completed))
+	for _, id := range cp.Completed {
+		done[id] = true
+	}
+	outcomes := make(map[string]*ActionOutcome, len(cp.Completed))
+	for _, action := range cp.Actions {
+		if !done[action.ID] {
+			continue
+		}
+		outcome := &ActionOutcome{ID: action.ID, Action: action.Action, Instruction: action.Instruction, Skipped: action.Skipped}
+		if action.Error != "" {
+			outcome.Err = errors.New(action.Error)
+		}
+		outcome.text.WriteString(action.Summary)
+		outcome.noteIDs = action.NoteIDs
+		outcomes[action.ID] = outcome
+	}
+	return outcomes
+}
+
+// LoadCheckpoint returns the session's latest orchestrator checkpoint, or the one with checkpointID.
+// It returns nil when the session has none.
+func LoadCheckpoint(ctx context.Context, cs database.CheckpointStorage, sessionID, checkpointID string) (*Checkpoint, error) {
+	rec, err := cs.GetCheckpoint(ctx, database.GetCheckpointRequest{
+		ThreadID:     sessionID,
+		CheckpointNS: CheckpointNS,
+		CheckpointID: checkpointID,
+	})
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(rec.CheckpointData, &cp); err != nil {
+		return nil, fmt.Errorf("decode checkpoint %s: %w", rec.CheckpointID, err)
+	}
+	cp.ID = rec.CheckpointID
+	return &cp, nil
+}
+
+// Checkpointer saves one run's checkpoints. Failures are logged and never fail the run; a nil
+// Checkpointer saves nothing.
+type Checkpointer struct {
+	storage database.CheckpointStorage
+	logger  *logx.Logger
+
+	mu       sync.Mutex
+	state    Checkpoint
+	parentID string
+}
+
+// NewCheckpointer starts checkpointing run, continuing from a resumed checkpoint when from is set.
+// It returns nil when no checkpoint storage is installed.
+func NewCheckpointer(run *Run, req types.AgentRequest, from *Checkpoint, logger *logx.Logger) *Checkpointer {
+	cs := CurrentCheckpointStorage()
+	if cs == nil {
+		return nil
+	}
+	c := &Checkpointer{storage: cs, logger: logger}
+	if from != nil {
+		c.state = *from
+		c.parentID = from.ID
+		return c
+	}
+	c.state = Checkpoint{
+		RequestID:   run.RequestID,
+		AgentName:   run.AgentName,
+		UserID:      req.UserID,
+		SessionID:   req.SessionID,
+		UserPlan:    run.Options.UserPlan,
+		Instruction: req.Instruction,
+		AutoMode:    run.Options.AutoMode,
+		Selections:  run.Options.Selections,
+		UseFiles:    run.Options.UseFiles,
+		FileIDs:     run.Options.FileIDs,
+	}
+	return c
+}
+
+// Decision saves the iteration's plan before its actions run
+func (c *Checkpointer) Decision(ctx context.Context, iteration int, response string, messages []llm.Message, actions []CheckpointAction, tokensUsed int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Iteration = iteration
+	c.state.Response = response
+	c.state.Messages = FileRefs(messages)
+	c.state.Actions = actions
+	c.state.Completed = nil
+	c.state.TokensUsed = tokensUsed
+	c.state.Status = CheckpointRunning
+	c.state.DecisionID, c.state.DecisionRef = "", 0
+	if ref := c.save(ctx, "decision"); ref != 0 {
+		c.state.DecisionID, c.state.DecisionRef = c.state.ID, ref
+	}
+}
+
+// ActionDone records a completed (or failed) action: its output is written against the decision
+// checkpoint and a new checkpoint marks it completed
+func (c *Checkpointer) ActionDone(ctx context.Context, outcome *ActionOutcome, tokensUsed int) {
+	// An action cut off by cancellation (shutdown, client gone) did not complete and runs again on resume
+	if c == nil || errors.Is(outcome.Err, context.Canceled) || errors.Is(outcome.Err, context.DeadlineExceeded) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := 0
+	for i < len(c.state.Actions) && c.state.Actions[i].ID != outcome.ID {
+		i++
+	}
+	if i == len(c.state.Actions) {
+		return
+	}
+	action := &c.state.Actions[i]
+	action.Summary = outcome.Notes()
+	action.Skipped = outcome.Skipped
+	action.NoteIDs = outcome.NoteIDs()
+	if outcome.Err != nil {
+		action.Error = outcome.Err.Error()
+	}
+	c.state.Completed = append(c.state.Completed, outcome.ID)
+	c.state.NoteIDs = append(c.state.NoteIDs, action.NoteIDs...)
+	c.state.TokensUsed = tokensUsed
+
+	if c.state.DecisionRef != 0 {
+		data, _ := json.Marshal(action)
+		if err := c.storage.SaveCheckpointWrites(ctx, database.SaveCheckpointWritesRequest{
+			CheckpointRefID: c.state.DecisionRef,
+			ThreadID:        c.state.SessionID,
+			CheckpointNS:    CheckpointNS,
+			CheckpointID:    c.state.DecisionID,
+			Writes: []database.CheckpointWriteRecord{{
+				TaskID:    outcome.ID,
+				Channel:   "action",
+				WriteType: "json",
+				WriteData: data,
+			}},
+		}); err != nil {
+			c.logger.Warn(ctx, "checkpoint.writes.failed", logx.KV("action_id", outcome.ID), logx.KV("error", err))
+		}
+	}
+	c.save(ctx, "action")
+}
+
+// Finish saves the run's final status (completed, or awaiting approval of planID)
+func (c *Checkpointer) Finish(ctx context.Context, status, planID string, tokensUsed int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Status = status
+	c.state.PlanID = planID
+	c.state.TokensUsed = tokensUsed
+	c.save(ctx, status)
+}
+
+// save writes the current state as a child of the previous checkpoint and returns its row ID
+func (c *Checkpointer) save(ctx context.Context, step string) int64 {
+	c.state.Seq++
+	c.state.ID = fmt.Sprintf("%s-%d", c.state.RequestID, c.state.Seq)
+	c.state.UpdatedAt = time.Now()
+	data, err := json.Marshal(c.state)
+	if err != nil {
+		c.logger.Warn(ctx, "checkpoint.encode.failed", logx.KV("error", err))
+		return 0
+	}
+	resp, err := c.storage.SaveCheckpoint(ctx, database.SaveCheckpointRequest{
+		ThreadID:           c.state.SessionID,
+		CheckpointNS:       CheckpointNS,
+		CheckpointID:       c.state.ID,
+		ParentCheckpointID: c.parentID,
+		UserID:             c.state.UserID,
+		SessionID:          c.state.SessionID,
+		CheckpointType:     "json",
+		CheckpointData:     data,
+		MetadataData: map[string]interface{}{
+			"request_id": c.state.RequestID,
+			"iteration":  c.state.Iteration,
+			"step":       step,
+			"status":     c.state.Status,
+			"completed":  len(c.state.Completed),
+		},
+		ChannelVersions: map[string]interface{}{"seq": c.state.Seq},
+	})
+	if err != nil {
+		c.logger.Warn(ctx, "checkpoint.save.failed", logx.KV("checkpoint_id", c.state.ID), logx.KV("error", err))
+		return 0
+	}
+	c.parentID = c.state.ID
+	return resp.ID
+}
+
+// CheckpointResumer is implemented by agents that can continue a run from its checkpoint
+type CheckpointResumer interface {
+	ResumeCheckpoint(ctx context.Context, cp *Checkpoint, emit func(ev events.StreamEvent) error) error
+}
@@ -0,0 +1,147 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/blueplan/loomi-go/internal/loomi/database"
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+)
+
+// FileURLFunc turns a stored object key into a URL the provider can fetch (e.g. an OSS presigned URL)
+type FileURLFunc func(ctx context.Context, ossKey string) (string, error)
+
+var (
+	fileStorageMu sync.RWMutex
+	fileStorage   database.FileStorage
+	fileURLFor    FileURLFunc
+)
+
+// SetFileStorage installs the storage used to resolve AgentRequest.FileIDs into message parts.
+// urlFor is optional and only used for files stored by key without inline data.
+func SetFileStorage(fs database.FileStorage, urlFor FileURLFunc) {
+	fileStorageMu.Lock()
+	defer fileStorageMu.Unlock()
+	fileStorage = fs
+	fileURLFor = urlFor
+}
+
+// ResolveFileParts loads req.FileIDs when req.UseFiles is set and returns them as message parts:
+// images become image parts and everything else file parts. Files that cannot be loaded are
+// logged and skipped so the request still runs on text alone.
+func (a *BaseLoomiAgent) ResolveFileParts(ctx context.Context, req types.AgentRequest) []llm.Part {
+	if !req.UseFiles || len(req.FileIDs) == 0 {
+		return nil
+	}
+	fileStorageMu.RLock()
+	fs, urlFor := fileStorage, fileURLFor
+	fileStorageMu.RUnlock()
+	if fs == nil {
+		a.Logger.Warn(ctx, "file.storage.unavailable", logx.KV("file_ids", req.FileIDs))
+		return nil
+	}
+
+	parts := make([]llm.Part, 0, len(req.FileIDs))
+	for _, fileID := range req.FileIDs {
+		if part, ok := a.loadFilePart(ctx, fs, urlFor, fileID, req.UserID); ok {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// FileRefs returns messages with the content of stored-file parts dropped, keeping their FileID,
+// so parked plans and checkpoints do not carry the files themselves; RestoreFileParts reloads them
+func FileRefs(messages []llm.Message) []llm.Message {
+	out := make([]llm.Message, len(messages))
+	for i, msg := range messages {
+		out[i] = msg
+		if len(msg.Parts) == 0 {
+			continue
+		}
+		out[i].Parts = make([]llm.Part, len(msg.Parts))
+		for j, part := range msg.Parts {
+			if part.FileID != "" {
+				part.Data, part.URL = nil, ""
+			}
+			out[i].Parts[j] = part
+		}
+	}
+	return out
+}
+
+// RestoreFileParts loads the content of the file references left by FileRefs for userID. Files
+// that cannot be loaded any more are logged and dropped from their message.
+func (a *BaseLoomiAgent) RestoreFileParts(ctx context.Context, userID string, messages []llm.Message) []llm.Message {
+	fileStorageMu.RLock()
+	fs, urlFor := fileStorage, fileURLFor
+	fileStorageMu.RUnlock()
+
+	out := make([]llm.Message, len(messages))
+	for i, msg := range messages {
+		out[i] = msg
+		if len(msg.Parts) == 0 {
+			continue
+		}
+		out[i].Parts = make([]llm.Part, 0, len(msg.Parts))
+		for _, part := range msg.Parts {
+			if part.FileID != "" && len(part.Data) == 0 && part.URL == "" {
+				if fs == nil {
+					a.Logger.Warn(ctx, "file.storage.unavailable", logx.KV("file_id", part.FileID))
+					continue
+				}
+				var ok bool
+				if part, ok = a.loadFilePart(ctx, fs, urlFor, part.FileID, userID); !ok {
+					continue
+				}
+			}
+			out[i].Parts = append(out[i].Parts, part)
+		}
+	}
+	return out
+}
+
+// loadFilePart loads one stored file owned by userID as an image or file part
+func (a *BaseLoomiAgent) loadFilePart(ctx context.Context, fs database.FileStorage, urlFor FileURLFunc, fileID, userID string) (llm.Part, bool) {
+	id, err := strconv.ParseInt(strings.TrimSpace(fileID), 10, 64)
+	if err != nil {
+		a.Logger.Warn(ctx, "file.id.invalid", logx.KV("file_id", fileID))
+		return llm.Part{}, false
+	}
+	rec, err := fs.GetFile(ctx, database.GetFileRequest{ID: id})
+	if err != nil || rec == nil {
+		a.Logger.Warn(ctx, "file.load.failed", logx.KV("file_id", fileID), logx.KV("error", err))
+		return llm.Part{}, false
+	}
+	// 只允许读取本用户的文件，没有归属用户的文件一律不读
+	if rec.UserID == "" || rec.UserID != userID {
+		a.Logger.Warn(ctx, "file.owner.mismatch", logx.KV("file_id", fileID), logx.KV("user_id", userID))
+		return llm.Part{}, false
+	}
+
+	part := llm.Part{Type: llm.PartFile, MIMEType: rec.FileType, FileID: fileID, FileName: rec.FileName}
+	if strings.HasPrefix(rec.FileType, "image/") {
+		part.Type = llm.PartImage
+	}
+	switch {
+	case len(rec.FileData) > 0:
+		part.Data = rec.FileData
+	case rec.OSSKey != "" && urlFor != nil:
+		url, err := urlFor(ctx, rec.OSSKey)
+		if err != nil {
+			a.Logger.Warn(ctx, "file.url.failed", logx.KV("file_id", fileID), logx.KV("error", err))
+			return llm.Part{}, false
+		}
+		part.URL = url
+	default:
+		a.Logger.Warn(ctx, "file.content.missing", logx.KV("file_id", fileID))
+		return llm.Part{}, false
+	}
+	return part, true
+}
@@ -0,0 +1,121 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/blueplan/loomi-go/internal/loomi/database"
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+)
+
+// fakeFiles serves GetFile from records keyed by ID; the other FileStorage methods are not used
+type fakeFiles struct {
+	database.FileStorage
+	records map[int64]*database.FileRecord
+}
+
+func (f *fakeFiles) GetFile(ctx context.Context, req database.GetFileRequest) (*database.FileRecord, error) {
+	rec, ok := f.records[req.ID]
+	if !ok {
+		return nil, errors.New("no such file")
+	}
+	return rec, nil
+}
+
+func useFileStorage(t *testing.T, fs database.FileStorage, urlFor FileURLFunc) {
+	SetFileStorage(fs, urlFor)
+	t.Cleanup(func() { SetFileStorage(nil, nil) })
+}
+
+func TestResolveFileParts(t *testing.T) {
+	agent := NewBaseLoomiAgent("loomi_hitpoint_agent", logx.NewLogger(t.TempDir()), nil)
+	req := types.AgentRequest{UserID: "u1", UseFiles: true, FileIDs: []string{"1", "2", "x", "3", "4", "5", "6", "7", "99"}}
+
+	if parts := agent.ResolveFileParts(context.Background(), req); parts != nil {
+		t.Errorf("without file storage: %+v", parts)
+	}
+
+	png := []byte{0x89, 'P', 'N', 'G'}
+	useFileStorage(t, &fakeFiles{records: map[int64]*database.FileRecord{
+		1: {ID: 1, UserID: "u1", FileName: "photo.png", FileType: "image/png", FileData: png},
+		2: {ID: 2, UserID: "u1", FileName: "brief.pdf", FileType: "application/pdf", OSSKey: "files/brief.pdf"},
+		// files without an owner, or owned by someone else, are never read
+		3: {ID: 3, FileName: "orphan.png", FileType: "image/png", FileData: png},
+		4: {ID: 4, UserID: "u2", FileName: "other.png", FileType: "image/png", FileData: png},
+		5: {ID: 5, UserID: "u1", FileName: "expired.pdf", FileType: "application/pdf", OSSKey: "broken"},
+		6: {ID: 6, UserID: "u1", FileName: "empty.txt", FileType: "text/plain"},
+		7: {ID: 7, UserID: "u1", FileName: "notes.txt", FileType: "text/plain", FileData: []byte("笔记")},
+	}}, func(ctx context.Context, key string) (string, error) {
+		if key == "broken" {
+			return "", errors.New("presign failed")
+		}
+		return "https://oss.example.com/" + key, nil
+	})
+
+	want := []llm.Part{
+		{Type: llm.PartImage, Data: png, MIMEType: "image/png", FileID: "1", FileName: "photo.png"},
+		{Type: llm.PartFile, URL: "https://oss.example.com/files/brief.pdf", MIMEType: "application/pdf", FileID: "2", FileName: "brief.pdf"},
+		{Type: llm.PartFile, Data: []byte("笔记"), MIMEType: "text/plain", FileID: "7", FileName: "notes.txt"},
+	}
+	if got := agent.ResolveFileParts(context.Background(), req); !reflect.DeepEqual(got, want) {
+		t.Errorf("parts = %+v, want %+v", got, want)
+	}
+
+	for name, req := range map[string]types.AgentRequest{
+		"files not requested": {UserID: "u1", FileIDs: []string{"1"}},
+		"no file ids":         {UserID: "u1", UseFiles: true},
+		"no user":             {UseFiles: true, FileIDs: []string{"1", "3"}},
+	} {
+		if parts := agent.ResolveFileParts(context.Background(), req); len(parts) != 0 {
+			t.Errorf("%s: parts = %+v, want none", name, parts)
+		}
+	}
+}
+
+func TestFileRefsRoundTrip(t *testing.T) {
+	photo := bytes.Repeat([]byte{0xff}, 4096)
+	useFileStorage(t, &fakeFiles{records: map[int64]*database.FileRecord{
+		1: {ID: 1, UserID: "u1", FileName: "photo.png", FileType: "image/png", FileData: photo},
+		2: {ID: 2, UserID: "u2", FileName: "other.pdf", FileType: "application/pdf", FileData: []byte("%PDF")},
+	}}, nil)
+
+	messages := []llm.Message{
+		{Role: "system", Content: "你是编排助手"},
+		{Role: "user", Content: "看看这些", Parts: []llm.Part{
+			{Type: llm.PartImage, Data: photo, MIMEType: "image/png", FileID: "1", FileName: "photo.png"},
+			{Type: llm.PartFile, Data: []byte("%PDF"), MIMEType: "application/pdf", FileID: "2", FileName: "other.pdf"},
+			llm.ImageURLPart("https://example.com/inline.png"),
+		}},
+	}
+
+	refs := FileRefs(messages)
+	for _, part := range refs[1].Parts[:2] {
+		if len(part.Data) != 0 || part.URL != "" || part.FileID == "" {
+			t.Errorf("stored part kept its content: %+v", part)
+		}
+	}
+	if refs[1].Parts[2].URL != "https://example.com/inline.png" {
+		t.Errorf("part without a FileID changed: %+v", refs[1].Parts[2])
+	}
+	if len(messages[1].Parts[0].Data) != len(photo) {
+		t.Error("FileRefs modified the original messages")
+	}
+
+	agent := NewBaseLoomiAgent("loomi_hitpoint_agent", logx.NewLogger(t.TempDir()), nil)
+	restored := agent.RestoreFileParts(context.Background(), "u1", refs)
+	// file 2 belongs to another user and is dropped; the URL part is kept as it was
+	want := []llm.Part{messages[1].Parts[0], messages[1].Parts[2]}
+	if !reflect.DeepEqual(restored[1].Parts, want) {
+		t.Errorf("restored parts = %+v, want %+v", restored[1].Parts, want)
+	}
+	if restored[0].Content != "你是编排助手" || restored[0].Parts != nil {
+		t.Errorf("text message changed: %+v", restored[0])
+	}
+}
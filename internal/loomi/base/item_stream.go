@@ -0,0 +1,147 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	"github.com/blueplan/loomi-go/internal/loomi/monitoring"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+	xmlx "github.com/blueplan/loomi-go/internal/loomi/utils/xml"
+)
+
+var itemProgressDefault atomic.Bool
+
+// SetItemProgress turns item_progress events on for agents created afterwards
+func SetItemProgress(enabled bool) {
+	itemProgressDefault.Store(enabled)
+}
+
+// ItemBuilder turns a parsed result into the agent's item, assigning its session-unique ID.
+// position is the 1-based position among the request's items; false skips the result.
+type ItemBuilder func(ctx context.Context, req types.AgentRequest, result xmlx.ParseResult, position int) (map[string]any, bool)
+
+// ItemSaver persists an item, normally as a note
+type ItemSaver func(ctx context.Context, req types.AgentRequest, item map[string]any)
+
+// ItemStream emits an agent's items while the LLM is still streaming. Each item is built, emitted
+// (Meta["stream"] = "item") and saved as soon as its closing tag arrives; with EnableItemProgress
+// the text of the open item is also sent as item_progress events. Finish parses whatever was not
+// streamed, and FinalEvent carries the full list so clients can reconcile by item ID.
+type ItemStream struct {
+	agent       *BaseLoomiAgent
+	req         types.AgentRequest
+	cfg         xmlx.ParseConfig
+	contentType events.ContentType
+	emit        func(ev events.StreamEvent) error
+	build       ItemBuilder
+	save        ItemSaver
+	parser      *xmlx.StreamParser
+
+	items    []map[string]any
+	streamed bool
+}
+
+// NewItemStream creates an item stream for one request
+func (a *BaseLoomiAgent) NewItemStream(
+	req types.AgentRequest,
+	cfg xmlx.ParseConfig,
+	contentType events.ContentType,
+	emit func(ev events.StreamEvent) error,
+	build ItemBuilder,
+	save ItemSaver,
+) *ItemStream {
+	return &ItemStream{
+		agent:       a,
+		req:         req,
+		cfg:         cfg,
+		contentType: contentType,
+		emit:        emit,
+		build:       build,
+		save:        save,
+		parser:      xmlx.NewStreamParser(cfg),
+	}
+}
+
+// Write feeds one chunk of the LLM response; call it from the SafeStreamCall callback
+func (s *ItemStream) Write(ctx context.Context, chunk string) error {
+	if !s.agent.EnableItemStreaming {
+		return nil
+	}
+	results, progress := s.parser.Write(chunk)
+	if s.agent.EnableItemProgress {
+		for _, p := range progress {
+			if err := s.emit(events.StreamEvent{
+				Type:    events.LLMChunk,
+				Content: events.ContentItemProgress,
+				Data: map[string]any{
+					"content_type": s.contentType,
+					"index":        p.Index,
+					"field":        p.Field,
+					"delta":        p.Delta,
+				},
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, result := range results {
+		item, ok := s.build(ctx, s.req, result, len(s.items)+1)
+		if !ok {
+			continue
+		}
+		s.assignTask(item, result)
+		s.items = append(s.items, item)
+		s.streamed = true
+		if err := s.emit(events.StreamEvent{
+			Type:    events.LLMChunk,
+			Content: s.contentType,
+			Data:    []map[string]any{item},
+			Meta:    map[string]any{"stream": "item", "position": len(s.items)},
+		}); err != nil {
+			return err
+		}
+		s.save(ctx, s.req, item)
+	}
+	return nil
+}
+
+// Finish returns all items of the response. If none were streamed, the full response goes through
+// ParseStructured (including its JSON fallback) and those items are saved here.
+func (s *ItemStream) Finish(ctx context.Context, response string) []map[string]any {
+	if s.streamed {
+		monitoring.DefaultParseMetrics.Record(s.agent.AgentName, monitoring.ParseXML)
+		return s.items
+	}
+	for _, result := range s.agent.ParseStructured(ctx, s.req.UserID, s.req.SessionID, response, s.cfg) {
+		item, ok := s.build(ctx, s.req, result, len(s.items)+1)
+		if !ok {
+			continue
+		}
+		s.assignTask(item, result)
+		s.items = append(s.items, item)
+		s.save(ctx, s.req, item)
+	}
+	return s.items
+}
+
+// assignTask marks which task of a merged request (see SplitBatch) the item belongs to
+func (s *ItemStream) assignTask(item map[string]any, result xmlx.ParseResult) {
+	if len(s.req.Tasks) > 0 {
+		item["task"] = taskOf(result, len(s.items)+1, len(s.req.Tasks))
+	}
+}
+
+// FinalEvent is the closing event with every item; Meta["stream"] = "final" marks it as the
+// reconciliation of items already sent one by one
+func (s *ItemStream) FinalEvent(items []map[string]any, meta map[string]any) events.StreamEvent {
+	if s.streamed {
+		if meta == nil {
+			meta = map[string]any{}
+		}
+		meta["stream"] = "final"
+	}
+	return events.StreamEvent{Type: events.LLMChunk, Content: s.contentType, Data: items, Meta: meta}
+}
@@ -0,0 +1,108 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	"github.com/blueplan/loomi-go/internal/loomi/llm/faults"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+	xmlx "github.com/blueplan/loomi-go/internal/loomi/utils/xml"
+)
+
+var knowledgeChunks = []string{
+	"这是两个知识点的整理，", "供参考。\n",
+	"<knowledge1>", "<title>早八</title>", "<content>通勤\n路上</content>", "</knowledge1>\n",
+	"<knowledge2><title>", "夜宵</title><content>烧烤", "摊</content></knowledge2>",
+	"\n以上。",
+}
+
+// streamKnowledge runs knowledgeChunks through fault and an item stream the way the knowledge
+// agent does, returning the streamed item IDs, Finish's item IDs and the text outside the items
+func streamKnowledge(t *testing.T, fault faults.Fault) (streamed, finished []string, other string) {
+	t.Helper()
+	provider := &storyLLM{answers: [][]string{knowledgeChunks}}
+	client := faults.Wrap(provider, faults.Config{Script: map[int]faults.Fault{0: fault}})
+	agent := NewBaseLoomiAgent("item_stream_test_agent", logx.NewLogger(t.TempDir()), client).WithDefaultDependencies()
+	agent.EnableItemStreaming = true
+
+	emit := func(ev events.StreamEvent) error {
+		for _, item := range ev.Data.([]map[string]any) {
+			streamed = append(streamed, item["id"].(string))
+		}
+		return nil
+	}
+	build := func(ctx context.Context, req types.AgentRequest, result xmlx.ParseResult, position int) (map[string]any, bool) {
+		return map[string]any{"id": result.ID, "title": result.Title}, true
+	}
+	stream := agent.NewItemStream(types.AgentRequest{UserID: "u1", SessionID: "s1"}, xmlx.UnifiedConfigs["knowledge"],
+		events.ContentLoomiKnowledge, emit, build, func(context.Context, types.AgentRequest, map[string]any) {})
+
+	var response strings.Builder
+	err := agent.SafeStreamCall(context.Background(), "u1", "s1", nil, func(ctx context.Context, chunk string) error {
+		response.WriteString(chunk)
+		return stream.Write(ctx, chunk)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range stream.Finish(context.Background(), response.String()) {
+		finished = append(finished, item["id"].(string))
+	}
+	return streamed, finished, agent.ExtractOtherContent(response.String(), []string{`<knowledge\\d+>`})
+}
+
+func TestItemStreamUnderStreamFaults(t *testing.T) {
+	for name, tc := range map[string]struct {
+		fault    faults.Fault
+		streamed []string
+		other    string
+	}{
+		"clean": {
+			streamed: []string{"knowledge1", "knowledge2"},
+			other:    "这是两个知识点的整理，供参考。\n以上。",
+		},
+		// the cut lands inside <knowledge2>: only the closed item is emitted and the open one
+		// does not leak into the text
+		"truncated inside an item": {
+			fault:    faults.Fault{TruncateFraction: 0.8},
+			streamed: []string{"knowledge1"},
+			other:    "这是两个知识点的整理，供参考。",
+		},
+		// the cut lands in the middle of the opening tag
+		"truncated inside a tag": {
+			fault:    faults.Fault{TruncateFraction: 0.6},
+			streamed: []string{"knowledge1"},
+			other:    "这是两个知识点的整理，供参考。",
+		},
+		"truncated before any item closes": {
+			fault: faults.Fault{TruncateFraction: 0.3},
+			other: "这是两个知识点的整理，供参考。",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			streamed, finished, other := streamKnowledge(t, tc.fault)
+			if !reflect.DeepEqual(streamed, tc.streamed) || !reflect.DeepEqual(finished, tc.streamed) {
+				t.Errorf("streamed %v, finished %v, want %v", streamed, finished, tc.streamed)
+			}
+			if other != tc.other {
+				t.Errorf("other content = %q, want %q", other, tc.other)
+			}
+		})
+	}
+
+	// duplicated chunks garble the items' text, but each item index is still emitted once and
+	// no item tag is left in the other content
+	streamed, finished, other := streamKnowledge(t, faults.Fault{DuplicateChunks: true})
+	if want := []string{"knowledge1", "knowledge2"}; !reflect.DeepEqual(streamed, want) || !reflect.DeepEqual(finished, want) {
+		t.Errorf("duplicated chunks: streamed %v, finished %v, want %v", streamed, finished, want)
+	}
+	if strings.Contains(other, "knowledge") || !strings.HasPrefix(other, "这是两个知识点的整理，这是两个知识点的整理，") {
+		t.Errorf("duplicated chunks: other content = %q", other)
+	}
+}
@@ -0,0 +1,49 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	"github.com/blueplan/loomi-go/internal/loomi/plans"
+	"github.com/blueplan/loomi-go/internal/loomi/tokens"
+	"github.com/blueplan/loomi-go/internal/loomi/tokens/tokenizer"
+)
+
+type planConfig struct {
+	store plans.Store
+	ttl   time.Duration
+}
+
+var planCfg atomic.Pointer[planConfig]
+
+// SetPlanStore installs where plans awaiting approval are parked and for how long
+func SetPlanStore(store plans.Store, ttl time.Duration) {
+	planCfg.Store(&planConfig{store: store, ttl: ttl})
+}
+
+// CurrentPlanStore returns the installed plan store and TTL; without a store plans run unapproved
+func CurrentPlanStore() (plans.Store, time.Duration) {
+	cfg := planCfg.Load()
+	if cfg == nil {
+		return nil, 0
+	}
+	return cfg.store, cfg.ttl
+}
+
+// PlanResumer is implemented by agents that park their plan for approval when auto mode is off
+type PlanResumer interface {
+	ResumePlan(ctx context.Context, plan *plans.Plan, emit func(ev events.StreamEvent) error) error
+}
+
+// estimatedActionOverhead approximates the system prompt, context and output tokens of an action
+const estimatedActionOverhead = 2000
+
+// EstimateAction estimates the tokens and cost of running action with instruction
+func EstimateAction(action, instruction string) (int, float64) {
+	n := tokenizer.Default().Count(instruction) + estimatedActionOverhead
+	return n, tokens.Cost(n)
+}
@@ -0,0 +1,67 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"context"
+	"sync/atomic"
+
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/monitoring"
+	"github.com/blueplan/loomi-go/internal/loomi/notes"
+	"github.com/blueplan/loomi-go/internal/loomi/prompts"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+)
+
+// BuiltinPromptVersion marks notes generated with the prompt compiled into the agent
+const BuiltinPromptVersion = "builtin"
+
+var promptStore atomic.Pointer[prompts.Store]
+
+// SetPromptStore installs the versioned prompt store agents render system prompts from
+func SetPromptStore(store *prompts.Store) {
+	promptStore.Store(store)
+}
+
+// CurrentPromptStore returns the installed prompt store, or nil
+func CurrentPromptStore() *prompts.Store {
+	return promptStore.Load()
+}
+
+// SystemPrompt renders the agent's template (named after the agent) from the prompt store with
+// the request's variables, falling back to builtin when the store has none or rendering fails.
+// A running experiment picks the version by the user's variant. The served version is kept on
+// the run so notes and events created afterwards record it.
+func (a *BaseLoomiAgent) SystemPrompt(ctx context.Context, req types.AgentRequest, builtin string) string {
+	run := RunFrom(ctx)
+	run.setPrompt(notes.PromptInfo{Version: BuiltinPromptVersion})
+	store := promptStore.Load()
+	if store == nil {
+		return builtin
+	}
+	rendered, err := store.RenderFor(a.AgentName, req.UserID, req.SessionID, PromptVars(req))
+	if err != nil {
+		if err != prompts.ErrNotFound {
+			a.Logger.Error(ctx, "Failed to render prompt template, using builtin",
+				logx.KV("agent", a.AgentName),
+				logx.KV("error", err))
+		}
+		return builtin
+	}
+	run.setPrompt(notes.PromptInfo{Version: rendered.Version, Experiment: rendered.Experiment, Variant: rendered.Variant})
+	if rendered.Experiment != "" {
+		monitoring.DefaultExperimentMetrics.RecordExposure(req.UserID, req.SessionID, rendered.Experiment, rendered.Variant, rendered.Version)
+	}
+	return rendered.Text
+}
+
+// PromptVars maps a request onto the template variables; platform comes from background.platform
+func PromptVars(req types.AgentRequest) prompts.Vars {
+	platform, _ := req.Background["platform"].(string)
+	return prompts.Vars{
+		Platform:   platform,
+		AutoMode:   req.AutoMode,
+		Selections: req.Selections,
+		Background: req.Background,
+	}
+}
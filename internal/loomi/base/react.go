@@ -0,0 +1,195 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+)
+
+var (
+	reactMaxIterations atomic.Int64
+	reactTokenBudget   atomic.Int64
+)
+
+func init() {
+	reactMaxIterations.Store(4)
+	reactTokenBudget.Store(100000)
+}
+
+// SetReActLimits sets the iteration limit and token budget of orchestrators created afterwards;
+// a token budget of 0 disables the budget
+func SetReActLimits(maxIterations, tokenBudget int) {
+	if maxIterations > 0 {
+		reactMaxIterations.Store(int64(maxIterations))
+	}
+	if tokenBudget >= 0 {
+		reactTokenBudget.Store(int64(tokenBudget))
+	}
+}
+
+// ReActLimits returns the configured iteration limit and token budget
+func ReActLimits() (maxIterations, tokenBudget int) {
+	return int(reactMaxIterations.Load()), int(reactTokenBudget.Load())
+}
+
+// Reasons an orchestrator's observe→think→act loop stops
+const (
+	StopFinish        = "finish"
+	StopNoActions     = "no_actions"
+	StopFinalAction   = "final_action"
+	StopMaxIterations = "max_iterations"
+	StopTokenBudget   = "token_budget"
+	// StopAwaitingApproval parks the run until the user approves the plan (auto mode off)
+	StopAwaitingApproval = "awaiting_approval"
+	// StopBudgetExceeded ends the run once the request's budget is used up (see CheckBudget)
+	StopBudgetExceeded = "budget_exceeded"
+)
+
+// finishTags are the tags an orchestrator writes once the task is complete
+var finishTags = []string{"ORCHESTRATOR_DECLARATION", "finish", "complete", "done"}
+
+// finishTagPatterns match a finish tag (<done>, <done/>, <done>…</done>) ending the response
+var finishTagPatterns = func() []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, len(finishTags))
+	for i, tag := range finishTags {
+		patterns[i] = regexp.MustCompile(`(?is)<` + tag + `\b[^>]*>(?:.*</` + tag + `>)?\s*$`)
+	}
+	return patterns
+}()
+
+// FinishTag returns the finish tag ending an orchestrator response. Only the response's last
+// element counts: a tag quoted or echoed earlier in the text does not end the loop.
+func FinishTag(response string) (string, bool) {
+	for i, re := range finishTagPatterns {
+		if re.MatchString(response) {
+			return "<" + finishTags[i] + ">", true
+		}
+	}
+	return "", false
+}
+
+// IterationEvent reports the progress of one orchestrator iteration
+func IterationEvent(iteration, maxIterations int, status string, data map[string]any) events.StreamEvent {
+	payload := map[string]any{"iteration": iteration, "max_iterations": maxIterations, "status": status}
+	for k, v := range data {
+		payload[k] = v
+	}
+	return events.StreamEvent{Type: events.LLMChunk, Content: events.ContentOrchestratorIteration, Data: payload}
+}
+
+// maxObservationRunes bounds each action's summary in the next iteration's prompt
+const maxObservationRunes = 600
+
+// ActionOutcome is what a dispatched action produced; its summary is fed to the next iteration
+type ActionOutcome struct {
+	ID          string
+	Action      string
+	Instruction string
+	Err         error
+	// Skipped marks an action that did not run; Err says why
+	Skipped bool
+
+	items   []string
+	noteIDs []string
+	text    strings.Builder
+}
+
+// ObserveAction wraps emit so the result events of one action are summarized into the outcome
+func ObserveAction(action, instruction string, emit func(ev events.StreamEvent) error) (func(ev events.StreamEvent) error, *ActionOutcome) {
+	outcome := &ActionOutcome{Action: action, Instruction: instruction}
+	return func(ev events.StreamEvent) error {
+		outcome.observe(ev)
+		return emit(ev)
+	}, outcome
+}
+
+func (o *ActionOutcome) observe(ev events.StreamEvent) {
+	if ev.Type != events.LLMChunk {
+		return
+	}
+	switch ev.Content {
+	case events.ContentThought, events.ContentItemProgress, events.ContentSystemMessage,
+		events.ContentOrchestratorIteration, events.ContentActionStatus, events.ContentBillingSummary:
+		return
+	}
+	switch data := ev.Data.(type) {
+	case []map[string]any:
+		// Items streamed one by one are repeated by the final event
+		if ev.Meta["stream"] == "item" {
+			return
+		}
+		o.items, o.noteIDs = o.items[:0], o.noteIDs[:0]
+		for _, item := range data {
+			if id, ok := item["id"].(string); ok && id != "" {
+				o.noteIDs = append(o.noteIDs, id)
+			}
+			title, _ := item["title"].(string)
+			content, _ := item["content"].(string)
+			o.items = append(o.items, strings.TrimSpace(fmt.Sprintf("%v %s：%s", item["id"], title, content)))
+		}
+	case string:
+		o.text.WriteString(data)
+	}
+}
+
+// Notes is the full output of the action: the items it saved as notes, else its raw text
+func (o *ActionOutcome) Notes() string {
+	if len(o.items) > 0 {
+		return strings.Join(o.items, "\n")
+	}
+	return strings.TrimSpace(o.text.String())
+}
+
+// NoteIDs are the IDs of the items the action saved as notes
+func (o *ActionOutcome) NoteIDs() []string {
+	return o.noteIDs
+}
+
+// Summary is the action's output shortened for the orchestrator prompt
+func (o *ActionOutcome) Summary() string {
+	if o.Skipped {
+		return "未执行：" + o.Err.Error()
+	}
+	if o.Err != nil {
+		return "执行失败：" + o.Err.Error()
+	}
+	summary := o.Notes()
+	if summary == "" {
+		return "无输出"
+	}
+	if runes := []rune(summary); len(runes) > maxObservationRunes {
+		summary = string(runes[:maxObservationRunes]) + "…"
+	}
+	return summary
+}
+
+// Record is the persisted form of the outcome
+func (o *ActionOutcome) Record() map[string]any {
+	record := map[string]any{"action": o.Action, "instruction": o.Instruction, "summary": o.Summary()}
+	if o.ID != "" {
+		record["id"] = o.ID
+	}
+	if o.Err != nil {
+		record["error"] = o.Err.Error()
+	}
+	if o.Skipped {
+		record["skipped"] = true
+	}
+	return record
+}
+
+// FormatObservations renders the outcomes of an iteration as the next iteration's observation
+func FormatObservations(iteration int, outcomes []*ActionOutcome) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[第 %d 轮执行结果]\n", iteration)
+	for i, o := range outcomes {
+		fmt.Fprintf(&b, "%d. %s（%s）\n%s\n", i+1, o.Action, o.Instruction, o.Summary())
+	}
+	b.WriteString("\n请根据以上结果决定下一步行动；任务已完成时输出 <finish>。")
+	return b.String()
+}
@@ -0,0 +1,27 @@
+//go:build !api_lite
+
+package base
+
+import "testing"
+
+func TestFinishTag(t *testing.T) {
+	for _, tc := range []struct {
+		response string
+		want     string
+	}{
+		{"任务已完成。<finish>", "<finish>"},
+		{"全部完成\n<DONE/>\n", "<done>"},
+		{"<complete>三篇笔记均已生成</complete>", "<complete>"},
+		{"<ORCHESTRATOR_DECLARATION>\n交付\n</ORCHESTRATOR_DECLARATION>", "<ORCHESTRATOR_DECLARATION>"},
+		// quoted or echoed before the end of the response, a tag does not finish the loop
+		{`完成后我会输出 <finish>，现在先调研。<execute action="websearch" instruction="查竞品" />`, ""},
+		{"笔记正文里写着 <done> 字样，继续写下一篇", ""},
+		{"<finished>", ""},
+		{"", ""},
+	} {
+		tag, ok := FinishTag(tc.response)
+		if tag != tc.want || ok != (tc.want != "") {
+			t.Errorf("FinishTag(%q) = %q, %v; want %q", tc.response, tag, ok, tc.want)
+		}
+	}
+}
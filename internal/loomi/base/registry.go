@@ -0,0 +1,116 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+)
+
+// AgentFactory builds a fresh agent for one action execution
+type AgentFactory func(logger *logx.Logger, client llm.Client) types.Agent
+
+// AgentSpec describes a registered agent. Action is the name orchestrators dispatch on; entry
+// agents (concierge, orchestrator) register without one so they are never dispatched as actions.
+type AgentSpec struct {
+	Action      string             `json:"action,omitempty"`
+	AgentName   string             `json:"agent_name"`
+	Description string             `json:"description,omitempty"`
+	ContentType events.ContentType `json:"content_type"`
+	// NoteAction is the notes action the agent's results are saved under
+	NoteAction string `json:"note_action,omitempty"`
+	// AutoSelect marks notes as selected by default (select_status 1) instead of awaiting the user
+	AutoSelect bool `json:"auto_select"`
+	// Temperature is the default sampling temperature; nil means 0.4. Config llm.agents overrides it.
+	Temperature *float64 `json:"temperature,omitempty"`
+	// PoolType is the Redis pool / LLM priority class; defaults to "normal"
+	PoolType string       `json:"pool_type"`
+	New      AgentFactory `json:"-"`
+}
+
+var (
+	registryMu     sync.RWMutex
+	agentsByName   = map[string]AgentSpec{}
+	agentsByAction = map[string]AgentSpec{}
+	// registryGen changes on every registration so pooled instances of replaced agents are dropped
+	registryGen uint64
+)
+
+// RegisterAgent adds or replaces an agent in the registry; agents register themselves from init
+func RegisterAgent(spec AgentSpec) {
+	if spec.PoolType == "" {
+		spec.PoolType = "normal"
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registryGen++
+	if old, ok := agentsByName[spec.AgentName]; ok && old.Action != "" {
+		delete(agentsByAction, old.Action)
+	}
+	agentsByName[spec.AgentName] = spec
+	if spec.Action != "" {
+		agentsByAction[spec.Action] = spec
+	}
+}
+
+// LookupAction returns the agent registered for an action
+func LookupAction(action string) (AgentSpec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	spec, ok := agentsByAction[action]
+	return spec, ok
+}
+
+// LookupAgent returns the spec registered under an agent name
+func LookupAgent(agentName string) (AgentSpec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	spec, ok := agentsByName[agentName]
+	return spec, ok
+}
+
+// lookupNoteAction returns the agent whose notes are saved under action
+func lookupNoteAction(action string) (AgentSpec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, spec := range agentsByName {
+		if spec.NoteAction == action {
+			return spec, true
+		}
+	}
+	return AgentSpec{}, false
+}
+
+// NewAgentForAction builds the agent registered for action, or returns nil if there is none
+func NewAgentForAction(action string, logger *logx.Logger, client llm.Client) types.Agent {
+	spec, ok := LookupAction(action)
+	if !ok || spec.New == nil {
+		return nil
+	}
+	return spec.New(logger, client)
+}
+
+// RegisteredAgents lists the registry sorted by action, entry agents last
+func RegisteredAgents() []AgentSpec {
+	registryMu.RLock()
+	out := make([]AgentSpec, 0, len(agentsByName))
+	for _, spec := range agentsByName {
+		out = append(out, spec)
+	}
+	registryMu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if (out[i].Action == "") != (out[j].Action == "") {
+			return out[j].Action == ""
+		}
+		if out[i].Action != out[j].Action {
+			return out[i].Action < out[j].Action
+		}
+		return out[i].AgentName < out[j].AgentName
+	})
+	return out
+}
@@ -0,0 +1,25 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"testing"
+
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+)
+
+func TestRegisteredTemperature(t *testing.T) {
+	RegisterAgent(AgentSpec{AgentName: "registry_test_deterministic", Temperature: llm.Float64(0)})
+	RegisterAgent(AgentSpec{AgentName: "registry_test_default"})
+
+	for name, want := range map[string]float64{
+		"registry_test_deterministic": 0,
+		"registry_test_default":       defaultAgentTemperature,
+	} {
+		agent := NewBaseLoomiAgent(name, logx.NewLogger(t.TempDir()), nil)
+		if got := agent.GetAgentTemperature(); got != want {
+			t.Errorf("%s: temperature = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	"github.com/blueplan/loomi-go/internal/loomi/notes"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+)
+
+// RunOptions are the request options an invocation runs with
+type RunOptions struct {
+	AutoMode   bool
+	Selections []string
+	UseFiles   bool
+	FileIDs    []string
+	UserPlan   string
+}
+
+// Run is one agent invocation. Everything request-scoped lives here instead of on the agent, so a
+// single agent instance can serve concurrent sessions. The run travels in the context; sub-agents
+// started below it get child runs that share the request ID and add to its counters.
+type Run struct {
+	RequestID string
+	AgentName string
+	UserID    string
+	SessionID string
+	Options   RunOptions
+	Parent    *Run
+	// Emit sends events of this run; result events carry the prompt version served to it
+	Emit func(ev events.StreamEvent) error
+	// Budget is what the request may use; it is set on the root run and covers its sub-runs
+	Budget types.Budget
+
+	llmCalls atomic.Int64
+	actions  atomic.Int64
+	tokens   atomic.Int64
+	started  time.Time
+	cancel   context.CancelFunc
+
+	mu        sync.Mutex
+	prompt    notes.PromptInfo
+	budgetErr error
+}
+
+type runKey struct{}
+
+// NewRun starts a run for req. The returned context carries the run and is cancelled by End.
+// The request ID is inherited from an enclosing run, then the context's "request_id", else generated.
+func NewRun(ctx context.Context, agentName string, req types.AgentRequest, emit func(ev events.StreamEvent) error) (context.Context, *Run) {
+	run := &Run{
+		AgentName: agentName,
+		UserID:    req.UserID,
+		SessionID: req.SessionID,
+		Options: RunOptions{
+			AutoMode:   req.AutoMode,
+			Selections: req.Selections,
+			UseFiles:   req.UseFiles,
+			FileIDs:    req.FileIDs,
+			UserPlan:   req.UserPlan,
+		},
+		Parent:  RunFrom(ctx),
+		Emit:    emit,
+		started: time.Now(),
+	}
+	if run.Parent == nil {
+		run.Budget = BudgetFor(req.UserPlan, req.Budget)
+	}
+	switch {
+	case run.Parent != nil:
+		run.RequestID = run.Parent.RequestID
+	case ctx.Value("request_id") != nil:
+		run.RequestID = fmt.Sprint(ctx.Value("request_id"))
+	default:
+		run.RequestID = newRequestID()
+	}
+	ctx, run.cancel = context.WithCancel(ctx)
+	return context.WithValue(ctx, runKey{}, run), run
+}
+
+// StartRun starts the agent's run for req; emit is wrapped so the agent's result events carry
+// the served prompt version and experiment variant in Meta["prompt"]. Call End when done.
+func (a *BaseLoomiAgent) StartRun(ctx context.Context, req types.AgentRequest, emit func(ev events.StreamEvent) error) (context.Context, *Run) {
+	ctx, run := NewRun(ctx, a.AgentName, req, emit)
+	if spec, ok := LookupAgent(a.AgentName); ok {
+		run.Emit = func(ev events.StreamEvent) error {
+			if prompt := run.Prompt(); ev.Content == spec.ContentType && prompt.Version != "" {
+				meta := make(map[string]any, len(ev.Meta)+1)
+				for k, v := range ev.Meta {
+					meta[k] = v
+				}
+				meta["prompt"] = prompt
+				ev.Meta = meta
+			}
+			return emit(ev)
+		}
+	}
+	return ctx, run
+}
+
+// RunFrom returns the innermost run of ctx, or nil outside a run
+func RunFrom(ctx context.Context) *Run {
+	run, _ := ctx.Value(runKey{}).(*Run)
+	return run
+}
+
+// End cancels the run's context and everything started below it
+func (r *Run) End() {
+	if r != nil && r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// CountLLMCall counts an LLM call on the run and its ancestors
+func (r *Run) CountLLMCall() {
+	for ; r != nil; r = r.Parent {
+		r.llmCalls.Add(1)
+	}
+}
+
+// CountAction counts a dispatched action on the run and its ancestors
+func (r *Run) CountAction() {
+	for ; r != nil; r = r.Parent {
+		r.actions.Add(1)
+	}
+}
+
+// CountTokens adds the tokens of an LLM call to the run and its ancestors
+func (r *Run) CountTokens(n int) {
+	for ; r != nil; r = r.Parent {
+		r.tokens.Add(int64(n))
+	}
+}
+
+// Tokens returns the tokens used by the run, including its sub-runs
+func (r *Run) Tokens() int {
+	if r == nil {
+		return 0
+	}
+	return int(r.tokens.Load())
+}
+
+// Stats returns the run's counters, including those of its sub-runs
+func (r *Run) Stats() map[string]int {
+	if r == nil {
+		return map[string]int{"total_llm_calls": 0, "total_actions": 0, "total_tokens": 0}
+	}
+	return map[string]int{
+		"total_llm_calls": int(r.llmCalls.Load()),
+		"total_actions":   int(r.actions.Load()),
+		"total_tokens":    int(r.tokens.Load()),
+	}
+}
+
+// BudgetErr returns the *BudgetExceededError that stopped the request, or nil while it is within budget
+func (r *Run) BudgetErr() error {
+	if r == nil {
+		return nil
+	}
+	root := r.root()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	return root.budgetErr
+}
+
+// root returns the request's outermost run, which holds the budget
+func (r *Run) root() *Run {
+	for r.Parent != nil {
+		r = r.Parent
+	}
+	return r
+}
+
+// Prompt returns the system prompt version served to this run
+func (r *Run) Prompt() notes.PromptInfo {
+	if r == nil {
+		return notes.PromptInfo{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.prompt
+}
+
+func (r *Run) setPrompt(info notes.PromptInfo) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.prompt = info
+	r.mu.Unlock()
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
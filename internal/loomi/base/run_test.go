@@ -0,0 +1,144 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+	xmlx "github.com/blueplan/loomi-go/internal/loomi/utils/xml"
+)
+
+// sessionEchoLLM answers every session with three hitpoints marked with its session ID, streamed
+// in small chunks so concurrent runs interleave
+type sessionEchoLLM struct{}
+
+func (sessionEchoLLM) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []llm.Message, onChunk llm.StreamChunkHandler, opts ...llm.CallOptions) error {
+	var b strings.Builder
+	for i := 1; i <= 3; i++ {
+		fmt.Fprintf(&b, "<hitpoint%d><title>%s point %d</title><content>%s body %d</content></hitpoint%d>\n", i, sessionID, i, sessionID, i, i)
+	}
+	response := b.String()
+	for start := 0; start < len(response); start += 7 {
+		end := min(start+7, len(response))
+		if err := onChunk(ctx, response[start:end]); err != nil {
+			return err
+		}
+		runtime.Gosched()
+	}
+	return nil
+}
+
+// itemAgent streams hitpoint items and saves each one as a note, the way the item agents do
+type itemAgent struct {
+	*BaseLoomiAgent
+}
+
+func (a *itemAgent) ProcessRequest(ctx context.Context, req types.AgentRequest, emit func(ev events.StreamEvent) error) error {
+	ctx, run := a.StartRun(ctx, req, emit)
+	defer run.End()
+	emit = run.Emit
+
+	cfg := xmlx.UnifiedConfigs["hitpoint"]
+	var response strings.Builder
+	stream := a.NewItemStream(req, cfg, events.ContentLoomiHitpoint, emit,
+		func(ctx context.Context, req types.AgentRequest, result xmlx.ParseResult, position int) (map[string]any, bool) {
+			id, err := a.GetNextActionID(ctx, req.UserID, req.SessionID, "hitpoint")
+			if err != nil {
+				return nil, false
+			}
+			return map[string]any{"id": fmt.Sprintf("hitpoint%d", id), "title": result.Title, "content": result.Content}, true
+		},
+		func(ctx context.Context, req types.AgentRequest, item map[string]any) {
+			_ = a.CreateNote(ctx, req.UserID, req.SessionID, "hitpoint", item["id"].(string), item["content"].(string), item["title"].(string), "", nil)
+		})
+	err := a.SafeStreamCall(ctx, req.UserID, req.SessionID, []llm.Message{{Role: "user", Content: req.Instruction}}, func(ctx context.Context, chunk string) error {
+		response.WriteString(chunk)
+		return stream.Write(ctx, chunk)
+	})
+	if err != nil {
+		return err
+	}
+	return emit(stream.FinalEvent(stream.Finish(ctx, response.String()), nil))
+}
+
+// runIsolatedSession runs one session's request and checks that its events and notes carry no
+// other session's content
+func runIsolatedSession(agent *itemAgent, sessionID string) error {
+	const userID = "u1"
+	marker := sessionID + " "
+	finalItems := 0
+	emit := func(ev events.StreamEvent) error {
+		if ev.Content != events.ContentLoomiHitpoint {
+			return nil
+		}
+		items, ok := ev.Data.([]map[string]any)
+		if !ok {
+			return fmt.Errorf("session %s: unparsed hitpoints %v", sessionID, ev.Data)
+		}
+		for _, item := range items {
+			if title, _ := item["title"].(string); !strings.HasPrefix(title, marker) {
+				return fmt.Errorf("session %s received another session's hitpoint %q", sessionID, title)
+			}
+		}
+		if ev.Meta["stream"] == "final" {
+			finalItems = len(items)
+		}
+		return nil
+	}
+
+	req := types.AgentRequest{UserID: userID, SessionID: sessionID, Instruction: "hitpoints"}
+	if err := agent.ProcessRequest(context.Background(), req, emit); err != nil {
+		return err
+	}
+	if finalItems != 3 {
+		return fmt.Errorf("session %s: %d final hitpoints, want 3", sessionID, finalItems)
+	}
+
+	saved, err := agent.NotesService.GetByAction(userID, sessionID, "hitpoint")
+	if err != nil {
+		return err
+	}
+	if len(saved) != 3 {
+		return fmt.Errorf("session %s: %d notes, want 3", sessionID, len(saved))
+	}
+	for _, note := range saved {
+		if note.SessionID != sessionID || !strings.HasPrefix(note.Title, marker) {
+			return fmt.Errorf("session %s saved another session's note %q", sessionID, note.Title)
+		}
+	}
+	return nil
+}
+
+// TestAgentRunIsolation runs many sessions concurrently on one shared agent; run it with -race
+func TestAgentRunIsolation(t *testing.T) {
+	agent := &itemAgent{NewBaseLoomiAgent("loomi_hitpoint_agent", logx.NewLogger(t.TempDir()), sessionEchoLLM{}).WithDefaultDependencies()}
+
+	const sessions, iterations = 16, 10
+	var wg sync.WaitGroup
+	errs := make(chan error, sessions*iterations)
+	for i := 0; i < sessions; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				if err := runIsolatedSession(agent, fmt.Sprintf("s%d-%d", i, j)); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
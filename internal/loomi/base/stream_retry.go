@@ -0,0 +1,91 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+)
+
+const (
+	// maxStreamRetries caps how many times SafeStreamCall retries a retryable failure
+	maxStreamRetries = 3
+	retryBaseDelay   = 500 * time.Millisecond
+	retryMaxDelay    = 8 * time.Second
+)
+
+// retryBackoff returns the delay before retry attempt+1: exponential with jitter in [d/2, d]
+func retryBackoff(attempt int) time.Duration {
+	d := retryBaseDelay << attempt
+	if d <= 0 || d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// ErrResumeDiverged is returned when a retried stream does not repeat the text the caller already
+// received: the new answer cannot be joined to the emitted one, so the call fails instead
+var ErrResumeDiverged = fmt.Errorf("retried stream diverged from the emitted text: %w", llm.ErrNotRetryable)
+
+// resumeFilter joins a restarted stream to the text the caller already received. The retry asks
+// for the whole answer again; while the new output repeats the emitted text it is held back, and
+// only the part past it is forwarded. Output that departs from the emitted text fails with
+// ErrResumeDiverged rather than being appended to it.
+type resumeFilter struct {
+	emitted  string
+	held     strings.Builder
+	checking bool
+}
+
+func newResumeFilter(emitted string) *resumeFilter {
+	return &resumeFilter{emitted: emitted, checking: true}
+}
+
+func (f *resumeFilter) write(ctx context.Context, chunk string, forward func(ctx context.Context, chunk string) error) error {
+	if !f.checking {
+		return forward(ctx, chunk)
+	}
+	f.held.WriteString(chunk)
+	held := f.held.String()
+	switch {
+	case strings.HasPrefix(f.emitted, held):
+		// 仍在重复已输出的内容，先不转发
+		if len(held) == len(f.emitted) {
+			f.checking = false
+		}
+		return nil
+	case strings.HasPrefix(held, f.emitted):
+		f.checking = false
+		return forward(ctx, held[len(f.emitted):])
+	default:
+		// 重新生成的回答与已输出内容不同，无法拼接
+		f.checking = false
+		return ErrResumeDiverged
+	}
+}
+
+// flush checks the stream once it ends: an answer that stops before repeating all of the emitted
+// text is shorter than what the caller already has and cannot be joined to it
+func (f *resumeFilter) flush() error {
+	if !f.checking {
+		return nil
+	}
+	f.checking = false
+	return ErrResumeDiverged
+}
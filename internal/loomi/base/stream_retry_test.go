@@ -0,0 +1,171 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	"github.com/blueplan/loomi-go/internal/loomi/llm/faults"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+)
+
+var storyChunks = []string{"从前", "有座山，", "山里", "有座庙，", "庙里", "有个", "老和尚"}
+
+// storyLLM answers every call from the start, the way providers regenerate a retried request;
+// call i streams answers[i], or the last answer once they run out. Completed calls report usage.
+type storyLLM struct {
+	answers [][]string
+	mu      sync.Mutex
+	calls   [][]llm.Message
+}
+
+func (s *storyLLM) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []llm.Message, onChunk llm.StreamChunkHandler, opts ...llm.CallOptions) error {
+	s.mu.Lock()
+	n := len(s.calls)
+	s.calls = append(s.calls, messages)
+	s.mu.Unlock()
+
+	answer := s.answers[min(n, len(s.answers)-1)]
+	for _, chunk := range answer {
+		if err := onChunk(ctx, chunk); err != nil {
+			return err
+		}
+	}
+	llm.ReportUsage(ctx, 20, len(answer))
+	return nil
+}
+
+func newFaultyAgent(t *testing.T, provider *storyLLM, fault faults.Fault) (*BaseLoomiAgent, *faults.Client) {
+	client := faults.Wrap(provider, faults.Config{Script: map[int]faults.Fault{0: fault}})
+	return NewBaseLoomiAgent("loomi_hitpoint_agent", logx.NewLogger(t.TempDir()), client).WithDefaultDependencies(), client
+}
+
+func TestSafeStreamCallRetriesInjectedFaults(t *testing.T) {
+	full := strings.Join(storyChunks, "")
+	for name, fault := range map[string]faults.Fault{
+		"timeout":        {Latency: 20 * time.Millisecond, ErrorAfterChunks: 2, Err: os.ErrDeadlineExceeded},
+		"mid-stream cut": {ErrorAfterChunks: 4},
+		"rate limit":     {ErrorAfterChunks: 1, Err: &llm.APIError{Provider: "qwen", StatusCode: http.StatusTooManyRequests, Kind: llm.ErrRateLimited}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			provider := &storyLLM{answers: [][]string{storyChunks}}
+			agent, client := newFaultyAgent(t, provider, fault)
+			ctx, run := NewRun(context.Background(), agent.AgentName, types.AgentRequest{UserID: "u1", SessionID: "s1"}, nil)
+			defer run.End()
+
+			var text strings.Builder
+			err := agent.SafeStreamCall(ctx, "u1", "s1", []llm.Message{{Role: "user", Content: "讲个故事"}}, func(ctx context.Context, chunk string) error {
+				text.WriteString(chunk)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("SafeStreamCall: %v", err)
+			}
+			if text.String() != full {
+				t.Errorf("text = %q, want %q exactly once", text.String(), full)
+			}
+			// the retry asks for the whole answer again instead of sending a partial assistant message
+			if client.Calls() != 2 || len(provider.calls) != 2 || len(provider.calls[1]) != 1 {
+				t.Errorf("calls = %d, requests = %v, want one plain retry", client.Calls(), provider.calls)
+			}
+			// both attempts are billed: the cut one at its estimate, the completed one as reported
+			stats := run.Stats()
+			if stats["total_llm_calls"] != 2 || stats["total_tokens"] <= 20+len(storyChunks) {
+				t.Errorf("stats = %v", stats)
+			}
+		})
+	}
+}
+
+func TestSafeStreamCallFailsWhenRetryDiverges(t *testing.T) {
+	for name, retry := range map[string][]string{
+		"different answer": {"很久以前", "有片海，", "海边有座灯塔"},
+		"shorter answer":   {"从前", "有座"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			provider := &storyLLM{answers: [][]string{storyChunks, retry}}
+			agent, client := newFaultyAgent(t, provider, faults.Fault{ErrorAfterChunks: 2})
+			var notices []events.StreamEvent
+			ctx := WithSystemNotices(context.Background(), func(ev events.StreamEvent) error {
+				notices = append(notices, ev)
+				return nil
+			})
+
+			var text strings.Builder
+			err := agent.SafeStreamCall(ctx, "u1", "s1", []llm.Message{{Role: "user", Content: "讲个故事"}}, func(ctx context.Context, chunk string) error {
+				text.WriteString(chunk)
+				return nil
+			})
+			if !errors.Is(err, ErrResumeDiverged) || llm.IsRetryable(err) {
+				t.Fatalf("err = %v, want non-retryable ErrResumeDiverged", err)
+			}
+			if text.String() != "从前有座山，" {
+				t.Errorf("text = %q, want only the text emitted before the cut", text.String())
+			}
+			if client.Calls() != 2 {
+				t.Errorf("calls = %d, want no retry after diverging", client.Calls())
+			}
+			if len(notices) == 0 || notices[len(notices)-1].Meta["reason"] != "llm_resume_diverged" {
+				t.Errorf("notices = %v, want a resume_diverged notice", notices)
+			}
+		})
+	}
+}
+
+func TestSafeStreamCallDoesNotRetryPermanentFaults(t *testing.T) {
+	blocked := &llm.APIError{Provider: "qwen", StatusCode: http.StatusOK, Kind: llm.ErrSafetyBlocked}
+	agent, client := newFaultyAgent(t, &storyLLM{answers: [][]string{storyChunks}}, faults.Fault{ErrorAfterChunks: 2, Err: blocked})
+
+	err := agent.SafeStreamCall(context.Background(), "u1", "s1", []llm.Message{{Role: "user", Content: "讲个故事"}}, func(ctx context.Context, chunk string) error {
+		return nil
+	})
+	if !errors.Is(err, llm.ErrSafetyBlocked) {
+		t.Fatalf("err = %v, want ErrSafetyBlocked", err)
+	}
+	if client.Calls() != 1 {
+		t.Errorf("calls = %d, want no retry", client.Calls())
+	}
+}
+
+func TestResumeFilter(t *testing.T) {
+	for name, tc := range map[string]struct {
+		chunks []string
+		want   string
+		err    error
+	}{
+		"restart from the start":     {[]string{"从前", "有座山，", "山里"}, "山里", nil},
+		"restart that stops exactly": {[]string{"从前有座山，"}, "", nil},
+		"different answer":           {[]string{"有座庙，", "庙里"}, "", ErrResumeDiverged},
+		"answer that departs later":  {[]string{"从前", "有片海"}, "", ErrResumeDiverged},
+		"shorter answer":             {[]string{"从"}, "", ErrResumeDiverged},
+	} {
+		f := newResumeFilter("从前有座山，")
+		var got strings.Builder
+		forward := func(ctx context.Context, chunk string) error {
+			got.WriteString(chunk)
+			return nil
+		}
+		var err error
+		for _, chunk := range tc.chunks {
+			if err = f.write(context.Background(), chunk, forward); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = f.flush()
+		}
+		if !errors.Is(err, tc.err) || got.String() != tc.want {
+			t.Errorf("%s: forwarded %q, err %v; want %q, err %v", name, got.String(), err, tc.want, tc.err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/monitoring"
+	"github.com/blueplan/loomi-go/internal/loomi/utils/jsonschema"
+	xmlx "github.com/blueplan/loomi-go/internal/loomi/utils/xml"
+)
+
+// maxRepairErrors caps how many validation errors are quoted back to the model
+const maxRepairErrors = 10
+
+// WithOutputSchema declares the JSON Schema of the agent's items (see ItemSchema); it must describe
+// an object with an "items" array. Agents with a schema get a JSON conversion call, plus one
+// repair call, when their XML blocks cannot be parsed.
+func (a *BaseLoomiAgent) WithOutputSchema(schema json.RawMessage) *BaseLoomiAgent {
+	a.OutputSchema = schema
+	return a
+}
+
+// ItemSchema builds the default item schema for a parse config:
+// {"items":[{"title","content"[,"cover_text"][,"hook"]}]}
+func ItemSchema(cfg xmlx.ParseConfig) json.RawMessage {
+	props := map[string]any{
+		"title":   map[string]any{"type": "string"},
+		"content": map[string]any{"type": "string", "minLength": 1},
+	}
+	if cfg.CoverTextTag != "" {
+		props["cover_text"] = map[string]any{"type": "string"}
+	}
+	if cfg.HookTag != "" {
+		props["hook"] = map[string]any{"type": "string"}
+	}
+	schema := map[string]any{
+		"type":     "object",
+		"required": []string{"items"},
+		"properties": map[string]any{
+			"items": map[string]any{
+				"type":     "array",
+				"minItems": 1,
+				"items": map[string]any{
+					"type":       "object",
+					"required":   []string{"title", "content"},
+					"properties": props,
+				},
+			},
+		},
+	}
+	raw, _ := json.Marshal(schema)
+	return raw
+}
+
+// ParseStructured parses the agent's items from response. XML blocks are tried first; if none are
+// found and the agent declared an OutputSchema, the response is converted to JSON and validated,
+// with one repair call quoting the validation errors. The outcome is recorded per agent in
+// monitoring.DefaultParseMetrics. An empty result means the caller should emit the raw text.
+func (a *BaseLoomiAgent) ParseStructured(ctx context.Context, userID, sessionID, response string, cfg xmlx.ParseConfig) []xmlx.ParseResult {
+	if results := xmlx.NewLoomiXMLParser().ParseEnhanced(response, cfg, 1); len(results) > 0 {
+		monitoring.DefaultParseMetrics.Record(a.AgentName, monitoring.ParseXML)
+		return results
+	}
+	if len(a.OutputSchema) == 0 || strings.TrimSpace(response) == "" {
+		monitoring.DefaultParseMetrics.Record(a.AgentName, monitoring.ParseFailed)
+		return nil
+	}
+
+	schema, err := jsonschema.Parse(a.OutputSchema)
+	if err != nil {
+		a.Logger.Error(ctx, "structured.schema.invalid", logx.KV("agent", a.AgentName), logx.KV("error", err))
+		monitoring.DefaultParseMetrics.Record(a.AgentName, monitoring.ParseFailed)
+		return nil
+	}
+
+	// 模型可能已经直接输出了 JSON
+	if doc := jsonschema.ExtractJSON(response); len(schema.Validate([]byte(doc))) == 0 {
+		monitoring.DefaultParseMetrics.Record(a.AgentName, monitoring.ParseJSON)
+		return decodeItems(doc, cfg)
+	}
+
+	messages := []llm.Message{
+		{Role: "system", Content: structuredSystemPrompt(a.OutputSchema)},
+		{Role: "user", Content: response},
+	}
+	out, err := a.collectJSON(ctx, userID, sessionID, messages)
+	if err != nil {
+		a.Logger.Error(ctx, "structured.convert.failed", logx.KV("agent", a.AgentName), logx.KV("error", err))
+		monitoring.DefaultParseMetrics.Record(a.AgentName, monitoring.ParseFailed)
+		return nil
+	}
+	doc := jsonschema.ExtractJSON(out)
+	errs := schema.Validate([]byte(doc))
+	if len(errs) == 0 {
+		monitoring.DefaultParseMetrics.Record(a.AgentName, monitoring.ParseJSON)
+		return decodeItems(doc, cfg)
+	}
+
+	// 仅修复一次，避免无界重试
+	a.Logger.Warn(ctx, "structured.validation.failed",
+		logx.KV("agent", a.AgentName),
+		logx.KV("errors", len(errs)))
+	messages = append(messages,
+		llm.Message{Role: "assistant", Content: out},
+		llm.Message{Role: "user", Content: repairPrompt(errs)},
+	)
+	out, err = a.collectJSON(ctx, userID, sessionID, messages)
+	if err == nil {
+		doc = jsonschema.ExtractJSON(out)
+		if errs = schema.Validate([]byte(doc)); len(errs) == 0 {
+			monitoring.DefaultParseMetrics.Record(a.AgentName, monitoring.ParseRepaired)
+			return decodeItems(doc, cfg)
+		}
+	}
+	a.Logger.Warn(ctx, "structured.repair.failed",
+		logx.KV("agent", a.AgentName),
+		logx.KV("errors", errs),
+		logx.KV("error", err))
+	monitoring.DefaultParseMetrics.Record(a.AgentName, monitoring.ParseFailed)
+	return nil
+}
+
+// collectJSON runs a JSON-mode call and returns the full response text
+func (a *BaseLoomiAgent) collectJSON(ctx context.Context, userID, sessionID string, messages []llm.Message) (string, error) {
+	var out strings.Builder
+	err := a.SafeStreamCall(ctx, userID, sessionID, messages, func(ctx context.Context, chunk string) error {
+		out.WriteString(chunk)
+		return nil
+	}, llm.CallOptions{JSONMode: true, Temperature: llm.Float64(0)})
+	return out.String(), err
+}
+
+func structuredSystemPrompt(schema json.RawMessage) string {
+	return "你是格式转换助手。请把用户提供的内容整理为 JSON，严格符合以下 JSON Schema，不要改写原文含义，只输出 JSON，不要输出任何其他文字：\n" + string(schema)
+}
+
+func repairPrompt(errs []string) string {
+	if len(errs) > maxRepairErrors {
+		errs = append(errs[:maxRepairErrors:maxRepairErrors], fmt.Sprintf("……另有 %d 处错误", len(errs)-maxRepairErrors))
+	}
+	return "上面的 JSON 未通过校验：\n- " + strings.Join(errs, "\n- ") + "\n请修正后重新输出完整的 JSON，只输出 JSON。"
+}
+
+// decodeItems maps a validated document onto parse results numbered like ParseEnhanced
+func decodeItems(doc string, cfg xmlx.ParseConfig) []xmlx.ParseResult {
+	var payload struct {
+		Items []struct {
+			Title     string `json:"title"`
+			Content   string `json:"content"`
+			CoverText string `json:"cover_text"`
+			Hook      string `json:"hook"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(doc), &payload); err != nil {
+		return nil
+	}
+	results := make([]xmlx.ParseResult, 0, len(payload.Items))
+	for i, it := range payload.Items {
+		results = append(results, xmlx.ParseResult{
+			ID:        fmt.Sprintf("%s%d", cfg.TagName, i+1),
+			Title:     strings.TrimSpace(it.Title),
+			Content:   strings.TrimSpace(it.Content),
+			CoverText: strings.TrimSpace(it.CoverText),
+			Hook:      strings.TrimSpace(it.Hook),
+			Type:      cfg.Type,
+		})
+	}
+	return results
+}
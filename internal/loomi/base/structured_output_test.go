@@ -0,0 +1,97 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/monitoring"
+	xmlx "github.com/blueplan/loomi-go/internal/loomi/utils/xml"
+)
+
+func parseStats(agent string) monitoring.AgentParseStats {
+	for _, s := range monitoring.DefaultParseMetrics.Snapshot() {
+		if s.Agent == agent {
+			return s
+		}
+	}
+	return monitoring.AgentParseStats{}
+}
+
+func TestParseStructuredRepairsInvalidJSON(t *testing.T) {
+	cfg := xmlx.UnifiedConfigs["hitpoint"]
+	const prose = "切入点：早八通勤。打工人每天早上都在赶地铁。"
+	valid := `{"items": [{"title": "早八通勤", "content": "打工人每天早上都在赶地铁。"}]}`
+	want := []xmlx.ParseResult{{ID: "hitpoint1", Title: "早八通勤", Content: "打工人每天早上都在赶地铁。", Type: "hitpoint"}}
+
+	for name, tc := range map[string]struct {
+		response string
+		answers  [][]string
+		calls    int
+		want     []xmlx.ParseResult
+		stats    monitoring.AgentParseStats
+	}{
+		"already JSON": {
+			response: "```json\n" + valid + "\n```",
+			calls:    0,
+			want:     want,
+			stats:    monitoring.AgentParseStats{Total: 1, JSON: 1},
+		},
+		"converted": {
+			response: prose,
+			answers:  [][]string{{valid}},
+			calls:    1,
+			want:     want,
+			stats:    monitoring.AgentParseStats{Total: 1, JSON: 1},
+		},
+		"repaired": {
+			response: prose,
+			// the first answer is cut off, the repaired one is complete
+			answers: [][]string{{`{"items": [{"title": "早八通勤", `}, {"```json\n", valid, "\n```"}},
+			calls:   2,
+			want:    want,
+			stats:   monitoring.AgentParseStats{Total: 1, Repaired: 1},
+		},
+		"repair fails": {
+			response: prose,
+			answers:  [][]string{{`{"items": []}`}},
+			calls:    2,
+			stats:    monitoring.AgentParseStats{Total: 1, Failed: 1},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			provider := &storyLLM{answers: tc.answers}
+			agentName := "structured_test_" + strings.ReplaceAll(name, " ", "_")
+			agent := NewBaseLoomiAgent(agentName, logx.NewLogger(t.TempDir()), provider).
+				WithDefaultDependencies().
+				WithOutputSchema(ItemSchema(cfg))
+
+			got := agent.ParseStructured(context.Background(), "u1", "s1", tc.response, cfg)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("results = %+v, want %+v", got, tc.want)
+			}
+			if len(provider.calls) != tc.calls {
+				t.Fatalf("%d LLM calls, want %d", len(provider.calls), tc.calls)
+			}
+			stats := parseStats(agentName)
+			if stats.Total != tc.stats.Total || stats.JSON != tc.stats.JSON || stats.Repaired != tc.stats.Repaired || stats.Failed != tc.stats.Failed {
+				t.Errorf("parse stats = %+v, want %+v", stats, tc.stats)
+			}
+
+			if tc.calls == 2 {
+				// the repair call replays the bad answer and quotes the validation errors
+				repair := provider.calls[1]
+				if len(repair) != 4 || repair[2].Role != "assistant" || repair[2].Content != strings.Join(tc.answers[0], "") {
+					t.Fatalf("repair messages = %+v", repair)
+				}
+				if !strings.Contains(repair[3].Content, "未通过校验") || !strings.Contains(repair[3].Content, "- $") {
+					t.Errorf("repair prompt = %q", repair[3].Content)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+//go:build !api_lite
+
+package base
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+)
+
+type noticeEmitterKey struct{}
+
+// WithSystemNotices routes notices raised below the agent (queueing behind the provider concurrency
+// limit, stream retries) to the user as system_message events. An existing emitter is kept so only
+// the outermost agent of a request reports.
+func WithSystemNotices(ctx context.Context, emit func(ev events.StreamEvent) error) context.Context {
+	if _, ok := ctx.Value(noticeEmitterKey{}).(func(ev events.StreamEvent) error); ok {
+		return ctx
+	}
+	ctx = context.WithValue(ctx, noticeEmitterKey{}, emit)
+	return llm.WithWaitNotifier(ctx, func(ctx context.Context, info llm.WaitInfo) {
+		emitSystemNotice(ctx, fmt.Sprintf("当前请求较多，正在排队（前方约 %d 个请求），请稍候…", info.Position-1), map[string]any{
+			"reason":   "llm_queue",
+			"provider": info.Provider,
+			"priority": info.Priority,
+			"position": info.Position,
+		})
+	})
+}
+
+// emitSystemNotice sends a system_message through the emitter set by WithSystemNotices, if any
+func emitSystemNotice(ctx context.Context, msg string, meta map[string]any) {
+	emit, ok := ctx.Value(noticeEmitterKey{}).(func(ev events.StreamEvent) error)
+	if !ok {
+		return
+	}
+	_ = emit(events.StreamEvent{
+		Type:    events.LLMChunk,
+		Content: events.ContentSystemMessage,
+		Data:    msg,
+		Meta:    meta,
+	})
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -25,6 +26,7 @@ type Config struct {
 	Gemini                  GeminiConfig                  `json:"gemini" yaml:"gemini"`
 	LoomiRevision           LoomiRevisionConfig           `json:"loomi_revision" yaml:"loomi_revision"`
 	PerformanceOptimization PerformanceOptimizationConfig `json:"performance_optimization" yaml:"performance_optimization"`
+	Budgets                 BudgetConfig                  `json:"budgets" yaml:"budgets"`
 }
 
 // AppConfig represents application configuration
@@ -49,6 +51,42 @@ type APIConfig struct {
 type LLMConfig struct {
 	DefaultProvider string                       `json:"default_provider"`
 	Providers       map[string]LLMProviderConfig `json:"providers"`
+	Agents          map[string]AgentLLMConfig    `json:"agents"`
+	Routing         LLMRoutingConfig             `json:"routing"`
+	Concurrency     LLMConcurrencyConfig         `json:"concurrency"`
+}
+
+// LLMConcurrencyConfig configures the process-wide llm.Governor. Limits are concurrent streams per
+// provider; Weights share freed slots between priority classes (high_priority/normal/background).
+type LLMConcurrencyConfig struct {
+	DefaultLimit        int            `json:"default_limit"`
+	Providers           map[string]int `json:"providers"`
+	QueueTimeoutSeconds int            `json:"queue_timeout_seconds"`
+	Weights             map[string]int `json:"weights"`
+	// Distributed keeps slots in Redis so limits hold across replicas
+	Distributed bool `json:"distributed"`
+}
+
+// LLMRoutingConfig configures the provider fallback chains used by llm.Router.
+// Chain entries are "provider" or "provider:model", tried in order.
+type LLMRoutingConfig struct {
+	Default          []string            `json:"default"`
+	Agents           map[string][]string `json:"agents"`
+	FailureThreshold int                 `json:"failure_threshold"`
+	CooldownSeconds  int                 `json:"cooldown_seconds"`
+}
+
+// AgentLLMConfig overrides generation parameters for a single agent (llm.agents.<agent_name>)
+type AgentLLMConfig struct {
+	Model          string   `json:"model"`
+	Temperature    *float64 `json:"temperature,omitempty"`
+	TopP           *float64 `json:"top_p,omitempty"`
+	MaxTokens      int      `json:"max_tokens"`
+	Stop           []string `json:"stop"`
+	ThinkingBudget *int     `json:"thinking_budget,omitempty"`
+	// Cache enables the LLM response cache for this agent; CacheTTL (seconds) overrides performance_optimization.cache_ttl
+	Cache    bool `json:"cache"`
+	CacheTTL int  `json:"cache_ttl"`
 }
 
 // LLMProviderConfig represents LLM provider configuration
@@ -169,6 +207,21 @@ type PerformanceOptimizationConfig struct {
 	CacheTTL                int  `json:"cache_ttl" yaml:"cache_ttl"`
 }
 
+// BudgetLimits caps what one request may use; 0 means unlimited
+type BudgetLimits struct {
+	MaxTokens int `json:"max_tokens" yaml:"max_tokens"`
+	// MaxSessionTokens caps the session's running total across requests (tokens.Accumulator)
+	MaxSessionTokens   int `json:"max_session_tokens" yaml:"max_session_tokens"`
+	MaxLLMCalls        int `json:"max_llm_calls" yaml:"max_llm_calls"`
+	MaxWallTimeSeconds int `json:"max_wall_time_seconds" yaml:"max_wall_time_seconds"`
+}
+
+// BudgetConfig holds the default request budget and the budgets of user plans (budgets.plans.<plan>)
+type BudgetConfig struct {
+	Default BudgetLimits            `json:"default" yaml:"default"`
+	Plans   map[string]BudgetLimits `json:"plans" yaml:"plans"`
+}
+
 // Load loads configuration from YAML files and environment variables
 func Load() *Config {
 	config := &Config{}
@@ -199,6 +252,20 @@ func Load() *Config {
 	config.LLM = LLMConfig{
 		DefaultProvider: getEnvWithYAML("LLM_DEFAULT_PROVIDER", yamlConfig, "llm.default_provider", "openai"),
 		Providers:       loadLLMProviders(),
+		Agents:          loadAgentLLMOverrides(yamlConfig),
+		Routing: LLMRoutingConfig{
+			Default:          getEnvSliceWithYAML("LLM_FALLBACK_PROVIDERS", yamlConfig, "llm.routing.default", nil),
+			Agents:           loadAgentRoutes(yamlConfig),
+			FailureThreshold: getEnvIntWithYAML("LLM_BREAKER_FAILURE_THRESHOLD", yamlConfig, "llm.routing.failure_threshold", 5),
+			CooldownSeconds:  getEnvIntWithYAML("LLM_BREAKER_COOLDOWN_SECONDS", yamlConfig, "llm.routing.cooldown_seconds", 30),
+		},
+		Concurrency: LLMConcurrencyConfig{
+			DefaultLimit:        getEnvIntWithYAML("LLM_MAX_CONCURRENT", yamlConfig, "llm.concurrency.default_limit", 32),
+			Providers:           loadLLMIntMap(yamlConfig, "providers"),
+			QueueTimeoutSeconds: getEnvIntWithYAML("LLM_QUEUE_TIMEOUT_SECONDS", yamlConfig, "llm.concurrency.queue_timeout_seconds", 60),
+			Weights:             loadLLMIntMap(yamlConfig, "weights"),
+			Distributed:         getEnvBoolWithYAML("LLM_CONCURRENCY_DISTRIBUTED", yamlConfig, "llm.concurrency.distributed", false),
+		},
 	}
 
 	// Load Security configuration
@@ -304,6 +371,17 @@ func Load() *Config {
 		CacheTTL:                getEnvIntWithYAML("CACHE_TTL", yamlConfig, "performance_optimization.cache_ttl", 3600),
 	}
 
+	// Load Budgets configuration
+	config.Budgets = BudgetConfig{
+		Default: BudgetLimits{
+			MaxTokens:          getEnvIntWithYAML("BUDGET_MAX_TOKENS", yamlConfig, "budgets.default.max_tokens", 0),
+			MaxSessionTokens:   getEnvIntWithYAML("BUDGET_MAX_SESSION_TOKENS", yamlConfig, "budgets.default.max_session_tokens", 0),
+			MaxLLMCalls:        getEnvIntWithYAML("BUDGET_MAX_LLM_CALLS", yamlConfig, "budgets.default.max_llm_calls", 0),
+			MaxWallTimeSeconds: getEnvIntWithYAML("BUDGET_MAX_WALL_TIME_SECONDS", yamlConfig, "budgets.default.max_wall_time_seconds", 0),
+		},
+		Plans: loadBudgetPlans(yamlConfig),
+	}
+
 	return config
 }
 
@@ -344,6 +422,17 @@ func loadLLMProviders() map[string]LLMProviderConfig {
 		}
 	}
 
+	// Zhipu provider
+	if apiKey := getEnv("ZHIPU_API_KEY", ""); apiKey != "" {
+		providers["zhipu"] = LLMProviderConfig{
+			APIKey:      apiKey,
+			Model:       getEnv("ZHIPU_MODEL", "glm-4"),
+			BaseURL:     getEnv("ZHIPU_BASE_URL", "https://open.bigmodel.cn/api/paas/v4"),
+			Temperature: getEnvFloat64("ZHIPU_TEMPERATURE", 0.7),
+			MaxTokens:   getEnvInt("ZHIPU_MAX_TOKENS", 4096),
+		}
+	}
+
 	return providers
 }
 
@@ -525,8 +614,12 @@ func getYAMLValue(config map[string]interface{}, path string) string {
 	for i, part := range parts {
 		if i == len(parts)-1 {
 			if value, ok := current[part]; ok {
-				if str, ok := value.(string); ok {
-					return str
+				switch v := value.(type) {
+				case string:
+					return v
+				case int, int64, float64, bool:
+					// 数值/布尔型 YAML 标量也按字符串返回，由调用方再解析
+					return fmt.Sprint(v)
 				}
 			}
 			break
@@ -572,3 +665,147 @@ func getYAMLSlice(config map[string]interface{}, path string) []string {
 
 	return nil
 }
+
+// loadAgentLLMOverrides reads per-agent generation overrides from llm.agents in YAML
+func loadAgentLLMOverrides(yamlConfig map[string]interface{}) map[string]AgentLLMConfig {
+	overrides := make(map[string]AgentLLMConfig)
+	llmSection, ok := yamlConfig["llm"].(map[string]interface{})
+	if !ok {
+		return overrides
+	}
+	agents, ok := llmSection["agents"].(map[string]interface{})
+	if !ok {
+		return overrides
+	}
+
+	for name, raw := range agents {
+		values, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var agentCfg AgentLLMConfig
+		if model, ok := values["model"].(string); ok {
+			agentCfg.Model = model
+		}
+		if v, ok := yamlNumber(values["temperature"]); ok {
+			agentCfg.Temperature = &v
+		}
+		if v, ok := yamlNumber(values["top_p"]); ok {
+			agentCfg.TopP = &v
+		}
+		if v, ok := yamlNumber(values["max_tokens"]); ok {
+			agentCfg.MaxTokens = int(v)
+		}
+		if v, ok := yamlNumber(values["thinking_budget"]); ok {
+			budget := int(v)
+			agentCfg.ThinkingBudget = &budget
+		}
+		agentCfg.Stop = getYAMLSlice(values, "stop")
+		if v, ok := values["cache"].(bool); ok {
+			agentCfg.Cache = v
+		}
+		if v, ok := yamlNumber(values["cache_ttl"]); ok {
+			agentCfg.CacheTTL = int(v)
+		}
+		overrides[name] = agentCfg
+	}
+
+	return overrides
+}
+
+// loadAgentRoutes reads per-agent provider chains from llm.routing.agents in YAML
+func loadAgentRoutes(yamlConfig map[string]interface{}) map[string][]string {
+	routes := make(map[string][]string)
+	llmSection, ok := yamlConfig["llm"].(map[string]interface{})
+	if !ok {
+		return routes
+	}
+	routing, ok := llmSection["routing"].(map[string]interface{})
+	if !ok {
+		return routes
+	}
+	agents, ok := routing["agents"].(map[string]interface{})
+	if !ok {
+		return routes
+	}
+	for name := range agents {
+		if chain := getYAMLSlice(agents, name); len(chain) > 0 {
+			routes[name] = chain
+		}
+	}
+	return routes
+}
+
+// loadLLMIntMap reads a name -> number map from llm.concurrency.<key> in YAML
+func loadLLMIntMap(yamlConfig map[string]interface{}, key string) map[string]int {
+	out := make(map[string]int)
+	llmSection, ok := yamlConfig["llm"].(map[string]interface{})
+	if !ok {
+		return out
+	}
+	concurrency, ok := llmSection["concurrency"].(map[string]interface{})
+	if !ok {
+		return out
+	}
+	values, ok := concurrency[key].(map[string]interface{})
+	if !ok {
+		return out
+	}
+	for name, raw := range values {
+		if v, ok := yamlNumber(raw); ok {
+			out[name] = int(v)
+		}
+	}
+	return out
+}
+
+// loadBudgetPlans reads per-plan budgets from budgets.plans in YAML
+func loadBudgetPlans(yamlConfig map[string]interface{}) map[string]BudgetLimits {
+	out := make(map[string]BudgetLimits)
+	budgets, ok := yamlConfig["budgets"].(map[string]interface{})
+	if !ok {
+		return out
+	}
+	plans, ok := budgets["plans"].(map[string]interface{})
+	if !ok {
+		return out
+	}
+	for name, raw := range plans {
+		values, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var limits BudgetLimits
+		if v, ok := yamlNumber(values["max_tokens"]); ok {
+			limits.MaxTokens = int(v)
+		}
+		if v, ok := yamlNumber(values["max_session_tokens"]); ok {
+			limits.MaxSessionTokens = int(v)
+		}
+		if v, ok := yamlNumber(values["max_llm_calls"]); ok {
+			limits.MaxLLMCalls = int(v)
+		}
+		if v, ok := yamlNumber(values["max_wall_time_seconds"]); ok {
+			limits.MaxWallTimeSeconds = int(v)
+		}
+		out[name] = limits
+	}
+	return out
+}
+
+// yamlNumber converts YAML scalar values (int, float or numeric string) to float64
+func yamlNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
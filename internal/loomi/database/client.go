@@ -344,6 +344,7 @@ type FileRecord struct {
 	FileSize    int64     `json:"file_size"`
 	OSSKey      string    `json:"oss_key"`
 	Description string    `json:"description"`
+	FileData    []byte    `json:"file_data,omitempty"` // 以 base64 存储，JSON 解码时还原为字节
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -997,6 +998,69 @@ func (c *SupabaseClient) ListStreams(ctx context.Context, req ListStreamsRequest
 	}, nil
 }
 
+// PromptTemplateRecord 提示词模板版本（prompt_templates 表）
+type PromptTemplateRecord struct {
+	Name      string    `json:"name"`
+	Version   string    `json:"version"`
+	Content   string    `json:"content"`
+	Active    bool      `json:"active"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ListPromptTemplates 列出全部提示词模板版本
+func (c *SupabaseClient) ListPromptTemplates(ctx context.Context) ([]PromptTemplateRecord, error) {
+	resp, err := c.makeRequest(ctx, "GET", "prompt_templates?select=name,version,content,active,updated_at&order=name.asc", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("list prompt templates failed with status: %d", resp.StatusCode)
+	}
+
+	var records []PromptTemplateRecord
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// PromptExperimentRecord 提示词 A/B 实验（prompt_experiments 表），variants 为 jsonb 数组
+type PromptExperimentRecord struct {
+	Name     string                `json:"name"`
+	Agent    string                `json:"agent"`
+	Unit     string                `json:"unit"`
+	Variants []PromptVariantRecord `json:"variants"`
+	Active   bool                  `json:"active"`
+}
+
+// PromptVariantRecord 实验分组：名称、使用的模板版本与流量权重
+type PromptVariantRecord struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Weight  int    `json:"weight"`
+}
+
+// ListPromptExperiments 列出启用中的提示词实验
+func (c *SupabaseClient) ListPromptExperiments(ctx context.Context) ([]PromptExperimentRecord, error) {
+	resp, err := c.makeRequest(ctx, "GET", "prompt_experiments?select=name,agent,unit,variants,active&active=eq.true&order=name.asc", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("list prompt experiments failed with status: %d", resp.StatusCode)
+	}
+
+	var records []PromptExperimentRecord
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
 // 全局客户端管理
 var (
 	globalClient Client
@@ -114,6 +114,7 @@ func (c *InMemClient) SaveFile(ctx context.Context, req SaveFileRequest) (*SaveF
 		FileSize:    req.FileSize,
 		OSSKey:      req.OSSKey,
 		Description: req.Description,
+		FileData:    req.FileData,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
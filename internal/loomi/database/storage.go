@@ -6,6 +6,7 @@ import (
 
 	"github.com/blueplan/loomi-go/internal/loomi/config"
 	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/prompts"
 )
 
 // Storage 存储接口
@@ -279,3 +280,42 @@ func (s *SupabaseNotesStorage) DeleteNote(ctx context.Context, req DeleteNoteReq
 func (s *SupabaseNotesStorage) ListNotes(ctx context.Context, req ListNotesRequest) (*ListNotesResponse, error) {
 	return s.client.ListNotes(ctx, req)
 }
+
+// SupabasePromptSource 从 prompt_templates 表加载提示词模板、从 prompt_experiments 表加载实验
+// （实现 prompts.Source 与 prompts.ExperimentSource）
+type SupabasePromptSource struct {
+	client *SupabaseClient
+}
+
+// NewSupabasePromptSource 创建数据库提示词模板源
+func NewSupabasePromptSource(client *SupabaseClient) *SupabasePromptSource {
+	return &SupabasePromptSource{client: client}
+}
+
+func (s *SupabasePromptSource) Load(ctx context.Context) ([]prompts.Version, error) {
+	records, err := s.client.ListPromptTemplates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]prompts.Version, 0, len(records))
+	for _, r := range records {
+		versions = append(versions, prompts.Version{Name: r.Name, Version: r.Version, Text: r.Content, Active: r.Active})
+	}
+	return versions, nil
+}
+
+func (s *SupabasePromptSource) LoadExperiments(ctx context.Context) ([]prompts.Experiment, error) {
+	records, err := s.client.ListPromptExperiments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	experiments := make([]prompts.Experiment, 0, len(records))
+	for _, r := range records {
+		e := prompts.Experiment{Name: r.Name, Agent: r.Agent, Unit: r.Unit}
+		for _, v := range r.Variants {
+			e.Variants = append(e.Variants, prompts.Variant{Name: v.Name, Version: v.Version, Weight: v.Weight})
+		}
+		experiments = append(experiments, e)
+	}
+	return experiments, nil
+}
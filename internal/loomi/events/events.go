@@ -36,6 +36,14 @@ const (
 	ContentConciergeMessage   ContentType = "concierge_message"
 	ContentConciergeWebsearch ContentType = "concierge_websearch"
 	ContentLoomiPlanConcierge ContentType = "loomi_plan_concierge"
+	// ContentItemProgress carries text of an item that is still streaming (typing effect)
+	ContentItemProgress ContentType = "item_progress"
+	// ContentOrchestratorIteration reports the progress of one orchestrator ReAct iteration
+	ContentOrchestratorIteration ContentType = "orchestrator_iteration"
+	// ContentActionStatus reports the status of one node of an orchestrator action plan
+	ContentActionStatus ContentType = "action_status"
+	// ContentOrchestratorPlan carries a plan awaiting user approval when auto mode is off
+	ContentOrchestratorPlan ContentType = "orchestrator_plan"
 )
 
 type StreamEvent struct {
@@ -0,0 +1,128 @@
+package llm
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states reported in ProviderHealth.State
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// ProviderHealth is a point-in-time snapshot of one provider's circuit breaker
+type ProviderHealth struct {
+	Provider            string    `json:"provider"`
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	TotalCalls          int64     `json:"total_calls"`
+	TotalFailures       int64     `json:"total_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastFailureAt       time.Time `json:"last_failure_at,omitempty"`
+	OpenUntil           time.Time `json:"open_until,omitempty"`
+}
+
+// circuitBreaker opens after threshold consecutive failures, then lets a single
+// probe call through once cooldown has elapsed (half-open)
+type circuitBreaker struct {
+	provider  string
+	threshold int
+	cooldown  time.Duration
+
+	mu            sync.Mutex
+	state         string
+	failures      int
+	openUntil     time.Time
+	probing       bool
+	totalCalls    int64
+	totalFailures int64
+	lastErr       string
+	lastFailureAt time.Time
+}
+
+func newCircuitBreaker(provider string, threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+	return &circuitBreaker{provider: provider, threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+// allow reports whether a call may be attempted now
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Now().Before(b.openUntil) {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	b.totalCalls++
+	return true
+}
+
+func (b *circuitBreaker) onSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *circuitBreaker) onFailure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.totalFailures++
+	b.lastFailureAt = time.Now()
+	if err != nil {
+		b.lastErr = err.Error()
+	}
+	b.probing = false
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// onAbandon releases a half-open probe slot when the call ended for reasons
+// unrelated to provider health (caller cancelled, agent stopped)
+func (b *circuitBreaker) onAbandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) snapshot() ProviderHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := ProviderHealth{
+		Provider:            b.provider,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		TotalCalls:          b.totalCalls,
+		TotalFailures:       b.totalFailures,
+		LastError:           b.lastErr,
+		LastFailureAt:       b.lastFailureAt,
+	}
+	if b.state == BreakerOpen {
+		h.OpenUntil = b.openUntil
+		if !time.Now().Before(b.openUntil) {
+			// 冷却已结束，下一次调用将作为探测请求
+			h.State = BreakerHalfOpen
+		}
+	}
+	return h
+}
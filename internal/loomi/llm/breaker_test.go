@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errBoom = errors.New("boom")
+
+func TestBreakerOpensAtThreshold(t *testing.T) {
+	b := newCircuitBreaker("qwen", 3, time.Minute)
+	for i := 0; i < 2; i++ {
+		if !b.allow() {
+			t.Fatalf("call %d refused before the threshold", i)
+		}
+		b.onFailure(errBoom)
+	}
+	if h := b.snapshot(); h.State != BreakerClosed || h.ConsecutiveFailures != 2 {
+		t.Fatalf("after 2 failures: %+v", h)
+	}
+
+	// a success resets the consecutive count
+	b.allow()
+	b.onSuccess()
+	for i := 0; i < 3; i++ {
+		b.allow()
+		b.onFailure(errBoom)
+	}
+	h := b.snapshot()
+	if h.State != BreakerOpen || h.TotalFailures != 5 || h.TotalCalls != 6 || h.LastError != "boom" {
+		t.Fatalf("after 3 more failures: %+v", h)
+	}
+	if b.allow() {
+		t.Error("open breaker allowed a call during cooldown")
+	}
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	const cooldown = 10 * time.Millisecond
+	open := func() *circuitBreaker {
+		b := newCircuitBreaker("qwen", 1, cooldown)
+		b.allow()
+		b.onFailure(errBoom)
+		time.Sleep(2 * cooldown)
+		if h := b.snapshot(); h.State != BreakerHalfOpen {
+			t.Fatalf("after cooldown: %+v, want half_open", h)
+		}
+		return b
+	}
+
+	t.Run("probe succeeds", func(t *testing.T) {
+		b := open()
+		if !b.allow() {
+			t.Fatal("probe refused after cooldown")
+		}
+		if b.allow() {
+			t.Fatal("second call allowed while the probe is in flight")
+		}
+		b.onSuccess()
+		if h := b.snapshot(); h.State != BreakerClosed || h.ConsecutiveFailures != 0 || !b.allow() {
+			t.Errorf("after a good probe: %+v", h)
+		}
+	})
+
+	t.Run("probe fails", func(t *testing.T) {
+		b := open()
+		b.allow()
+		b.onFailure(errBoom)
+		if h := b.snapshot(); h.State != BreakerOpen || b.allow() {
+			t.Errorf("after a failed probe: %+v, want open again", h)
+		}
+	})
+
+	t.Run("probe abandoned", func(t *testing.T) {
+		b := open()
+		b.allow()
+		b.onAbandon()
+		// the caller gave up: the breaker stays half-open and lets the next probe through
+		if h := b.snapshot(); h.State != BreakerHalfOpen || h.TotalFailures != 1 {
+			t.Errorf("after an abandoned probe: %+v", h)
+		}
+		if !b.allow() {
+			t.Error("next probe refused after an abandoned one")
+		}
+	})
+}
@@ -0,0 +1,199 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/config"
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	"github.com/blueplan/loomi-go/internal/loomi/monitoring"
+)
+
+// Store persists cached responses; Get reports ok=false on a miss
+type Store interface {
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// Entry is a cached response: the complete chunk sequence of one successful stream
+type Entry struct {
+	Agent    string    `json:"agent"`
+	Chunks   []string  `json:"chunks"`
+	CachedAt time.Time `json:"cached_at"`
+}
+
+// Config selects which agents are cached and for how long. Agents not listed are never cached.
+type Config struct {
+	Agents map[string]time.Duration
+}
+
+// ConfigFrom builds the cache config from performance_optimization (global switch and default TTL)
+// and the per-agent llm.agents.<agent_name>.cache / cache_ttl settings
+func ConfigFrom(perf config.PerformanceOptimizationConfig, agents map[string]config.AgentLLMConfig) Config {
+	cfg := Config{Agents: make(map[string]time.Duration)}
+	if !perf.EnableCaching {
+		return cfg
+	}
+	for name, a := range agents {
+		if !a.Cache {
+			continue
+		}
+		ttl := a.CacheTTL
+		if ttl <= 0 {
+			ttl = perf.CacheTTL
+		}
+		if ttl > 0 {
+			cfg.Agents[name] = time.Duration(ttl) * time.Second
+		}
+	}
+	return cfg
+}
+
+// Enabled reports whether any agent has caching turned on
+func (c Config) Enabled() bool {
+	return len(c.Agents) > 0
+}
+
+// Client is an llm.Client decorator that serves repeated requests of cache-enabled agents from
+// a Store, replaying the original chunks. Calls offering tools are never cached, and only streams
+// that completed without error are stored. Store failures count as misses.
+type Client struct {
+	inner   llm.Client
+	store   Store
+	cfg     Config
+	metrics *monitoring.CacheMetrics
+}
+
+// Wrap decorates inner with response caching; hit/miss counts go to monitoring.DefaultCacheMetrics
+func Wrap(inner llm.Client, store Store, cfg Config) *Client {
+	return &Client{
+		inner:   inner,
+		store:   store,
+		cfg:     cfg,
+		metrics: monitoring.DefaultCacheMetrics,
+	}
+}
+
+// SafeStreamCall replays a cached response when one exists, otherwise calls the wrapped client and
+// stores the stream; the agent name comes from llm.WithAgentName
+func (c *Client) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []llm.Message, onChunk llm.StreamChunkHandler, opts ...llm.CallOptions) error {
+	agent := llm.AgentNameFromContext(ctx)
+	ttl, ok := c.cfg.Agents[agent]
+	o := llm.ResolveCallOptions(opts...)
+	if !ok || len(o.Tools) > 0 || o.OnToolCall != nil {
+		return c.inner.SafeStreamCall(ctx, userID, sessionID, messages, onChunk, opts...)
+	}
+
+	key := Key(agent, messages, o)
+	if raw, found, err := c.store.Get(ctx, key); err != nil {
+		c.metrics.RecordError(agent)
+	} else if found {
+		var entry Entry
+		if err := json.Unmarshal(raw, &entry); err == nil {
+			c.metrics.RecordHit(agent)
+			// 命中只记在本次调用的结果上，不按会话留存
+			llm.ReportCached(ctx)
+			return replay(ctx, entry.Chunks, onChunk)
+		}
+		c.metrics.RecordError(agent)
+	}
+
+	c.metrics.RecordMiss(agent)
+	var chunks []string
+	err := c.inner.SafeStreamCall(ctx, userID, sessionID, messages, func(ctx context.Context, chunk string) error {
+		chunks = append(chunks, chunk)
+		return onChunk(ctx, chunk)
+	}, opts...)
+	if err != nil || len(chunks) == 0 {
+		return err
+	}
+
+	raw, _ := json.Marshal(Entry{Agent: agent, Chunks: chunks, CachedAt: time.Now()})
+	if err := c.store.Set(ctx, key, raw, ttl); err != nil {
+		c.metrics.RecordError(agent)
+	}
+	return nil
+}
+
+// SupportsTools follows the wrapped client; tool calls always bypass the cache
+func (c *Client) SupportsTools() bool {
+	return llm.SupportsTools(c.inner)
+}
+
+func replay(ctx context.Context, chunks []string, onChunk llm.StreamChunkHandler) error {
+	for _, chunk := range chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := onChunk(ctx, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyOptions are the generation options that change the response; callbacks are excluded
+type keyOptions struct {
+	Model          string   `json:"model,omitempty"`
+	Temperature    *float64 `json:"temperature,omitempty"`
+	TopP           *float64 `json:"top_p,omitempty"`
+	MaxTokens      int      `json:"max_tokens,omitempty"`
+	Stop           []string `json:"stop,omitempty"`
+	ThinkingBudget int      `json:"thinking_budget,omitempty"`
+	JSONMode       bool     `json:"json_mode,omitempty"`
+}
+
+// Key derives the cache key from the agent name, the normalized messages and the generation options.
+// User and session are deliberately left out so identical prompts hit across sessions.
+func Key(agent string, messages []llm.Message, o llm.CallOptions) string {
+	h := sha256.New()
+	for _, m := range messages {
+		h.Write([]byte(strings.ToLower(strings.TrimSpace(m.Role))))
+		h.Write([]byte{0})
+		h.Write([]byte(normalize(m.Content)))
+		h.Write([]byte{0})
+		h.Write([]byte(m.ToolCallID))
+		for _, tc := range m.ToolCalls {
+			h.Write([]byte{0})
+			h.Write([]byte(tc.Name))
+			h.Write([]byte{0})
+			h.Write([]byte(tc.Arguments))
+		}
+		for _, p := range m.Parts {
+			h.Write([]byte{0})
+			h.Write([]byte(p.Type))
+			h.Write([]byte{0})
+			h.Write([]byte(normalize(p.Text)))
+			h.Write([]byte{0})
+			h.Write([]byte(p.URL))
+			h.Write([]byte{0})
+			h.Write(p.Data)
+		}
+		h.Write([]byte{1})
+	}
+	raw, _ := json.Marshal(keyOptions{
+		Model:          o.Model,
+		Temperature:    o.Temperature,
+		TopP:           o.TopP,
+		MaxTokens:      o.MaxTokens,
+		Stop:           o.Stop,
+		ThinkingBudget: o.ThinkingBudget,
+		JSONMode:       o.JSONMode,
+	})
+	h.Write(raw)
+	return agent + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// normalize makes whitespace-only differences (line endings, trailing spaces) hit the same entry
+func normalize(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+)
+
+type memStore struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func (s *memStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	return v, ok, nil
+}
+
+func (s *memStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = value
+	return nil
+}
+
+// usageLLM echoes the last message and reports fixed usage for every call
+type usageLLM struct{}
+
+func (usageLLM) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []llm.Message, onChunk llm.StreamChunkHandler, opts ...llm.CallOptions) error {
+	llm.ReportUsage(ctx, 7, 3)
+	return onChunk(ctx, "echo: "+messages[len(messages)-1].Text())
+}
+
+func call(c *Client, sessionID, prompt string) (*llm.CallResult, string, error) {
+	res := &llm.CallResult{}
+	ctx := llm.WithCallResult(llm.WithAgentName(context.Background(), "loomi_knowledge_agent"), res)
+	var text strings.Builder
+	err := c.SafeStreamCall(ctx, "u1", sessionID, []llm.Message{{Role: "user", Content: prompt}}, func(ctx context.Context, chunk string) error {
+		text.WriteString(chunk)
+		return nil
+	})
+	return res, text.String(), err
+}
+
+func TestHitIsReportedOnTheCallResult(t *testing.T) {
+	c := Wrap(usageLLM{}, &memStore{data: make(map[string][]byte)}, Config{Agents: map[string]time.Duration{"loomi_knowledge_agent": time.Minute}})
+
+	miss, text, err := call(c, "s1", "行业知识")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, comp, ok := miss.Usage(); miss.Cached() || !ok || p != 7 || comp != 3 || text != "echo: 行业知识" {
+		t.Fatalf("miss: cached=%v usage=%d/%d ok=%v text=%q", miss.Cached(), p, comp, ok, text)
+	}
+
+	// a hit and a miss in the same session, at the same time, each see only their own result
+	var wg sync.WaitGroup
+	var hit, other *llm.CallResult
+	var hitErr, otherErr error
+	wg.Add(2)
+	go func() { defer wg.Done(); hit, text, hitErr = call(c, "s1", "行业知识") }()
+	go func() { defer wg.Done(); other, _, otherErr = call(c, "s1", "竞品分析") }()
+	wg.Wait()
+	if hitErr != nil || otherErr != nil {
+		t.Fatal(hitErr, otherErr)
+	}
+
+	if _, _, ok := hit.Usage(); !hit.Cached() || ok || text != "echo: 行业知识" {
+		t.Fatalf("hit: cached=%v reported=%v text=%q", hit.Cached(), ok, text)
+	}
+	if _, _, ok := other.Usage(); other.Cached() || !ok {
+		t.Fatalf("concurrent miss: cached=%v reported=%v", other.Cached(), ok)
+	}
+}
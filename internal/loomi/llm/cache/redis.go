@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/pool"
+	"github.com/redis/go-redis/v9"
+)
+
+// KeyPrefix namespaces cache entries in Redis
+const KeyPrefix = "loomi:llm_cache:"
+
+// RedisStore stores entries in Redis using a client from pool.Manager
+type RedisStore struct {
+	pools    pool.RedisPools
+	poolType string
+}
+
+// NewRedisStore creates a store on the given pool type ("normal" when empty)
+func NewRedisStore(pools pool.RedisPools, poolType string) *RedisStore {
+	if poolType == "" {
+		poolType = "normal"
+	}
+	return &RedisStore{pools: pools, poolType: poolType}
+}
+
+// Get loads an entry; a missing key is a miss, not an error
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	client, err := s.pools.GetRedisClient(ctx, s.poolType)
+	if err != nil {
+		return nil, false, err
+	}
+	raw, err := client.Get(ctx, KeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return raw, true, nil
+}
+
+// Set stores an entry with its TTL
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	client, err := s.pools.GetRedisClient(ctx, s.poolType)
+	if err != nil {
+		return err
+	}
+	return client.Set(ctx, KeyPrefix+key, value, ttl).Err()
+}
@@ -0,0 +1,317 @@
+package cassette
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+)
+
+// Mode selects whether the client talks to the wrapped provider or to fixtures
+type Mode string
+
+const (
+	ModeRecord Mode = "record"
+	ModeReplay Mode = "replay"
+)
+
+// ErrCassetteMiss is returned in replay mode when no fixture matches the request. Replaying again
+// cannot find one either, so it wraps llm.ErrNotRetryable.
+var ErrCassetteMiss error = cassetteMiss{}
+
+type cassetteMiss struct{}
+
+func (cassetteMiss) Error() string { return "cassette: no recording for request" }
+func (cassetteMiss) Unwrap() error { return llm.ErrNotRetryable }
+
+// Chunk is one streamed delta with the delay observed before it
+type Chunk struct {
+	Text    string `json:"text"`
+	DelayMs int64  `json:"delay_ms"`
+}
+
+// Message mirrors llm.Message with stable JSON field names
+type Message struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Parts      []Part     `json:"parts,omitempty"`
+}
+
+// Part records a multimodal part; inline data is stored as its sha256 rather than the bytes
+type Part struct {
+	Type       string `json:"type"`
+	Text       string `json:"text,omitempty"`
+	MIMEType   string `json:"mime_type,omitempty"`
+	URL        string `json:"url,omitempty"`
+	FileID     string `json:"file_id,omitempty"`
+	DataSHA256 string `json:"data_sha256,omitempty"`
+}
+
+// ToolCall is a recorded native tool call
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// Cassette is the on-disk fixture format
+type Cassette struct {
+	Key              string     `json:"key"`
+	Agent            string     `json:"agent"`
+	Messages         []Message  `json:"messages"`
+	Chunks           []Chunk    `json:"chunks"`
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+	Error            string     `json:"error,omitempty"`
+	PromptTokens     int        `json:"prompt_tokens,omitempty"`
+	CompletionTokens int        `json:"completion_tokens,omitempty"`
+	RecordedAt       time.Time  `json:"recorded_at"`
+}
+
+// Client implements llm.Client on top of cassette fixtures: record mode wraps a real client
+// and saves each stream, replay mode plays the saved streams back without network access
+type Client struct {
+	mode  Mode
+	dir   string
+	inner llm.Client
+
+	// ReplaySpeed scales recorded delays in replay mode: 0 replays instantly, 1 in real time
+	ReplaySpeed float64
+}
+
+// New creates a cassette client. inner is required in record mode and ignored in replay mode.
+func New(mode Mode, dir string, inner llm.Client) (*Client, error) {
+	switch mode {
+	case ModeRecord:
+		if inner == nil {
+			return nil, errors.New("cassette: record mode requires an inner client")
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("cassette: create dir: %w", err)
+		}
+	case ModeReplay:
+	default:
+		return nil, fmt.Errorf("cassette: unknown mode %q", mode)
+	}
+	return &Client{mode: mode, dir: dir, inner: inner}, nil
+}
+
+// NewRecorder wraps inner and writes every call to dir
+func NewRecorder(inner llm.Client, dir string) (*Client, error) {
+	return New(ModeRecord, dir, inner)
+}
+
+// NewReplayer plays fixtures from dir back without any network access
+func NewReplayer(dir string) *Client {
+	c, _ := New(ModeReplay, dir, nil)
+	return c
+}
+
+// Key derives the fixture key from the agent name and the exact message list
+func Key(agentName string, messages []llm.Message) string {
+	h := sha256.New()
+	h.Write([]byte(agentName))
+	for _, m := range messages {
+		h.Write([]byte{0})
+		h.Write([]byte(m.Role))
+		h.Write([]byte{0})
+		h.Write([]byte(m.Content))
+		// 仅在存在工具调用时参与哈希，保持旧夹具的 key 不变
+		if m.ToolCallID != "" {
+			h.Write([]byte{0})
+			h.Write([]byte(m.ToolCallID))
+		}
+		for _, tc := range m.ToolCalls {
+			h.Write([]byte{0})
+			h.Write([]byte(tc.Name))
+			h.Write([]byte{0})
+			h.Write([]byte(tc.Arguments))
+		}
+		for _, p := range m.Parts {
+			h.Write([]byte{0})
+			h.Write([]byte(p.Type))
+			h.Write([]byte(p.Text))
+			h.Write([]byte(p.URL))
+			h.Write(p.Data)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Path returns the fixture file for a request
+func (c *Client) Path(agentName string, messages []llm.Message) string {
+	agent := agentName
+	if agent == "" {
+		agent = "_default"
+	}
+	return filepath.Join(c.dir, agent, Key(agentName, messages)+".json")
+}
+
+// SafeStreamCall records or replays depending on the client mode; the agent name comes from llm.WithAgentName
+func (c *Client) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []llm.Message, onChunk llm.StreamChunkHandler, opts ...llm.CallOptions) error {
+	agent := llm.AgentNameFromContext(ctx)
+	if c.mode == ModeReplay {
+		return c.replay(ctx, userID, sessionID, agent, messages, onChunk, llm.ResolveCallOptions(opts...))
+	}
+	return c.record(ctx, userID, sessionID, agent, messages, onChunk, opts)
+}
+
+// SupportsTools follows the wrapped client when recording; fixtures can always replay tool calls
+func (c *Client) SupportsTools() bool {
+	if c.mode == ModeReplay {
+		return true
+	}
+	return llm.SupportsTools(c.inner)
+}
+
+func (c *Client) record(ctx context.Context, userID, sessionID, agent string, messages []llm.Message, onChunk llm.StreamChunkHandler, opts []llm.CallOptions) error {
+	cas := &Cassette{
+		Key:        Key(agent, messages),
+		Agent:      agent,
+		Messages:   toCassetteMessages(messages),
+		RecordedAt: time.Now(),
+	}
+	last := time.Now()
+	var chunkErr error
+	o := llm.ResolveCallOptions(opts...)
+	var acc *llm.ToolCallAccumulator
+	if o.OnToolCall != nil {
+		// 记录工具调用的同时继续转发给调用方
+		acc = llm.NewToolCallAccumulator()
+		forward := o.OnToolCall
+		opts = append(opts, llm.CallOptions{OnToolCall: func(ctx context.Context, d llm.ToolCallDelta) error {
+			_ = acc.Add(ctx, d)
+			return forward(ctx, d)
+		}})
+	}
+	// 单独收集内层调用的用量，写入夹具后再上报给调用方
+	usage := &llm.CallResult{}
+	err := c.inner.SafeStreamCall(llm.WithCallResult(ctx, usage), userID, sessionID, messages, func(ctx context.Context, chunk string) error {
+		now := time.Now()
+		cas.Chunks = append(cas.Chunks, Chunk{Text: chunk, DelayMs: now.Sub(last).Milliseconds()})
+		last = now
+		if err := onChunk(ctx, chunk); err != nil {
+			chunkErr = err
+			return err
+		}
+		return nil
+	}, opts...)
+	prompt, completion, reported := usage.Usage()
+	if reported {
+		llm.ReportUsage(ctx, prompt, completion)
+	}
+
+	// 调用方中断的流不完整，不写入夹具
+	if chunkErr != nil || ctx.Err() != nil {
+		return err
+	}
+	if err != nil {
+		cas.Error = err.Error()
+	}
+	if acc != nil {
+		for _, tc := range acc.Calls() {
+			cas.ToolCalls = append(cas.ToolCalls, ToolCall{ID: tc.ID, Name: tc.Name, Arguments: tc.Arguments})
+		}
+	}
+	cas.PromptTokens, cas.CompletionTokens = prompt, completion
+	if writeErr := c.write(c.Path(agent, messages), cas); writeErr != nil {
+		return fmt.Errorf("cassette: write fixture: %w", writeErr)
+	}
+	return err
+}
+
+func (c *Client) replay(ctx context.Context, userID, sessionID, agent string, messages []llm.Message, onChunk llm.StreamChunkHandler, o llm.CallOptions) error {
+	path := c.Path(agent, messages)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: agent=%q key=%s (expected %s; re-record with LLM_CASSETTE_MODE=record)", ErrCassetteMiss, agent, Key(agent, messages), path)
+		}
+		return fmt.Errorf("cassette: read fixture: %w", err)
+	}
+	var cas Cassette
+	if err := json.Unmarshal(data, &cas); err != nil {
+		return fmt.Errorf("cassette: decode %s: %w", path, err)
+	}
+
+	llm.ReportUsage(ctx, cas.PromptTokens, cas.CompletionTokens)
+	for _, chunk := range cas.Chunks {
+		if c.ReplaySpeed > 0 && chunk.DelayMs > 0 {
+			delay := time.Duration(float64(chunk.DelayMs)*c.ReplaySpeed) * time.Millisecond
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := onChunk(ctx, chunk.Text); err != nil {
+			return err
+		}
+	}
+	if o.OnToolCall != nil {
+		for i, tc := range cas.ToolCalls {
+			if err := o.OnToolCall(ctx, llm.ToolCallDelta{Index: i, ID: tc.ID, Name: tc.Name, ArgumentsDelta: tc.Arguments}); err != nil {
+				return err
+			}
+		}
+	}
+	if cas.Error != "" {
+		return errors.New(cas.Error)
+	}
+	return nil
+}
+
+// write stores the fixture atomically so concurrent recorders never leave half-written files
+func (c *Client) write(path string, cas *Cassette) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cas, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cassette-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func toCassetteMessages(messages []llm.Message) []Message {
+	out := make([]Message, 0, len(messages))
+	for _, m := range messages {
+		msg := Message{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, tc := range m.ToolCalls {
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: tc.ID, Name: tc.Name, Arguments: tc.Arguments})
+		}
+		for _, p := range m.Parts {
+			part := Part{Type: string(p.Type), Text: p.Text, MIMEType: p.MIMEType, URL: p.URL, FileID: p.FileID}
+			if len(p.Data) > 0 {
+				sum := sha256.Sum256(p.Data)
+				part.DataSHA256 = hex.EncodeToString(sum[:])
+			}
+			msg.Parts = append(msg.Parts, part)
+		}
+		out = append(out, msg)
+	}
+	return out
+}
@@ -0,0 +1,97 @@
+package cassette
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+)
+
+// fixtureMessages is the request recorded in testdata/loomi_hitpoint_agent
+var fixtureMessages = []llm.Message{
+	{Role: "system", Content: "你是内容打点助手"},
+	{Role: "user", Content: "为一款粉底液找选题切入点"},
+}
+
+func stream(c *Client, agent string, messages []llm.Message) (string, *llm.CallResult, error) {
+	res := &llm.CallResult{}
+	ctx := llm.WithCallResult(llm.WithAgentName(context.Background(), agent), res)
+	var text strings.Builder
+	err := c.SafeStreamCall(ctx, "u1", "s1", messages, func(ctx context.Context, chunk string) error {
+		text.WriteString(chunk)
+		return nil
+	})
+	return text.String(), res, err
+}
+
+func TestReplayRecordedFixture(t *testing.T) {
+	text, res, err := stream(NewReplayer("testdata"), "loomi_hitpoint_agent", fixtureMessages)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	want := "<hitpoint1><title>早八通勤</title><content>十分钟出门的底妆</content></hitpoint1>\n<hitpoint2><title>油皮夏天</title><content>持妆一整天</content></hitpoint2>"
+	if text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+	if p, c, ok := res.Usage(); !ok || p != 42 || c != 18 {
+		t.Errorf("usage = %d/%d ok=%v, want 42/18", p, c, ok)
+	}
+}
+
+func TestReplayMissReturnsErrCassetteMiss(t *testing.T) {
+	replayer := NewReplayer("testdata")
+	changed := []llm.Message{fixtureMessages[0], {Role: "user", Content: "为一款口红找选题切入点"}}
+	for name, tc := range map[string]struct {
+		agent    string
+		messages []llm.Message
+	}{
+		"other prompt": {"loomi_hitpoint_agent", changed},
+		"other agent":  {"loomi_persona_agent", fixtureMessages},
+	} {
+		text, _, err := stream(replayer, tc.agent, tc.messages)
+		if !errors.Is(err, ErrCassetteMiss) || llm.IsRetryable(err) {
+			t.Errorf("%s: err = %v, want non-retryable ErrCassetteMiss", name, err)
+		}
+		if text != "" {
+			t.Errorf("%s: streamed %q on a miss", name, text)
+		}
+	}
+}
+
+// scriptedLLM streams fixed chunks and reports fixed usage
+type scriptedLLM struct{ chunks []string }
+
+func (s scriptedLLM) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []llm.Message, onChunk llm.StreamChunkHandler, opts ...llm.CallOptions) error {
+	for _, chunk := range s.chunks {
+		if err := onChunk(ctx, chunk); err != nil {
+			return err
+		}
+	}
+	llm.ReportUsage(ctx, 10, 4)
+	return nil
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRecorder(scriptedLLM{chunks: []string{"第一段", "第二段"}}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, res, err := stream(recorder, "loomi_knowledge_agent", fixtureMessages)
+	if err != nil || recorded != "第一段第二段" {
+		t.Fatalf("record: %q %v", recorded, err)
+	}
+	if p, c, ok := res.Usage(); !ok || p != 10 || c != 4 {
+		t.Errorf("recorded usage = %d/%d ok=%v, want 10/4", p, c, ok)
+	}
+
+	replayed, res, err := stream(NewReplayer(dir), "loomi_knowledge_agent", fixtureMessages)
+	if err != nil || replayed != recorded {
+		t.Fatalf("replay: %q %v, want %q", replayed, err, recorded)
+	}
+	if p, c, ok := res.Usage(); !ok || p != 10 || c != 4 {
+		t.Errorf("replayed usage = %d/%d ok=%v, want 10/4", p, c, ok)
+	}
+}
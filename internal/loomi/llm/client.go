@@ -2,39 +2,110 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/blueplan/loomi-go/internal/loomi/config"
 )
 
 type Message struct {
 	Role    string
 	Content string
+	// ToolCalls is set on assistant messages that requested tools
+	ToolCalls []ToolCall
+	// ToolCallID and Name identify the call a "tool" message answers
+	ToolCallID string
+	Name       string
+	// Parts carries multimodal content (images, files) sent after Content
+	Parts []Part
 }
 
 type StreamChunkHandler func(ctx context.Context, chunk string) error
 
 type Client interface {
-	SafeStreamCall(ctx context.Context, userID, sessionID string, messages []Message, onChunk StreamChunkHandler) error
+	// SafeStreamCall streams a completion; opts are merged with ResolveCallOptions
+	SafeStreamCall(ctx context.Context, userID, sessionID string, messages []Message, onChunk StreamChunkHandler, opts ...CallOptions) error
 }
 
-// UsageProvider 可选能力：提供最近一次调用的 token 用量（prompt/completion）
-type UsageProvider interface {
-	LastUsage(userID, sessionID string) (prompt int, completion int, ok bool)
+// NewClient creates a new LLM client
+func NewClient(provider string, cfg interface{}) (Client, error) {
+	var pc config.LLMProviderConfig
+	switch v := cfg.(type) {
+	case config.LLMProviderConfig:
+		pc = v
+	case *config.LLMProviderConfig:
+		if v != nil {
+			pc = *v
+		}
+	case *config.Config:
+		if v != nil {
+			pc = v.LLM.Providers[provider]
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("unsupported llm config type %T", cfg)
+	}
+
+	if provider == "mock" {
+		return &mockClient{}, nil
+	}
+	if provider == "" || (pc.APIKey == "" && pc.BaseURL == "") {
+		// 仅开发环境允许未配置的 provider 退回 mock，其他环境视为配置错误，避免线上返回 mock 回答
+		if !mockFallback.Load() {
+			return nil, fmt.Errorf("%w: %q has no api_key or base_url", ErrProviderNotConfigured, provider)
+		}
+		return &mockClient{}, nil
+	}
+
+	providersMu.RLock()
+	factory, ok := providerFactories[provider]
+	providersMu.RUnlock()
+	if ok {
+		client, err := factory(pc)
+		if err != nil {
+			return nil, err
+		}
+		return Govern(client, provider, currentGovernor()), nil
+	}
+
+	// openai / deepseek / qwen / doubao 等均走 OpenAI 兼容协议
+	return Govern(NewOpenAIClient(provider, pc), provider, currentGovernor()), nil
 }
 
-// NewClient creates a new LLM client
-func NewClient(provider string, config interface{}) (Client, error) {
-	// TODO: Implement actual client creation based on provider
-	return &mockClient{}, nil
+// ErrProviderNotConfigured is returned by NewClient for a provider without an API key or base URL
+// unless the mock fallback is enabled
+var ErrProviderNotConfigured = errors.New("llm: provider not configured")
+
+var mockFallback atomic.Bool
+
+// SetMockFallback lets NewClient serve unconfigured providers with the mock client. Main enables it
+// in development only; elsewhere a provider without credentials fails to build.
+func SetMockFallback(enabled bool) {
+	mockFallback.Store(enabled)
 }
 
-// mockClient is a mock implementation for testing
+// ProviderFactory builds a Client for a provider that does not speak the OpenAI protocol
+type ProviderFactory func(cfg config.LLMProviderConfig) (Client, error)
+
+var (
+	providersMu       sync.RWMutex
+	providerFactories = map[string]ProviderFactory{}
+)
+
+// RegisterProvider makes a native provider adapter (gemini, zhipu, ...) available to NewClient.
+// Adapters live next to their HTTP clients, so they are registered from main to avoid import cycles.
+func RegisterProvider(name string, factory ProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providerFactories[name] = factory
+}
+
+// mockClient answers every call with an empty stream; it serves the "mock" provider and, in
+// development, unconfigured ones
 type mockClient struct{}
 
-func (m *mockClient) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []Message, onChunk StreamChunkHandler) error {
-	// Mock implementation
-	fmt.Println("Mock LLM call for user:", userID, "session:", sessionID)
+func (m *mockClient) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []Message, onChunk StreamChunkHandler, opts ...CallOptions) error {
 	return nil
 }
-
-// Ensure mockClient also implements UsageProvider with zero usage
-func (m *mockClient) LastUsage(userID, sessionID string) (int, int, bool) { return 0, 0, true }
@@ -0,0 +1,25 @@
+package llm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/blueplan/loomi-go/internal/loomi/config"
+)
+
+func TestNewClientFallsBackToMockOnlyWhenAllowed(t *testing.T) {
+	t.Cleanup(func() { SetMockFallback(false) })
+
+	if _, err := NewClient("qwen", config.LLMProviderConfig{}); !errors.Is(err, ErrProviderNotConfigured) {
+		t.Errorf("unconfigured provider: err = %v, want ErrProviderNotConfigured", err)
+	}
+	if c, err := NewClient("mock", nil); err != nil || c == nil {
+		t.Errorf("explicit mock provider: %v", err)
+	}
+
+	SetMockFallback(true)
+	c, err := NewClient("qwen", config.LLMProviderConfig{})
+	if _, ok := c.(*mockClient); err != nil || !ok {
+		t.Errorf("development fallback: client = %T, err = %v", c, err)
+	}
+}
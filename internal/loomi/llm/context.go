@@ -0,0 +1,47 @@
+package llm
+
+import "context"
+
+type agentNameKey struct{}
+
+// WithAgentName tags ctx with the calling agent so routing-aware clients can pick a provider chain
+func WithAgentName(ctx context.Context, agentName string) context.Context {
+	return context.WithValue(ctx, agentNameKey{}, agentName)
+}
+
+// AgentNameFromContext returns the agent name set by WithAgentName, or ""
+func AgentNameFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(agentNameKey{}).(string)
+	return name
+}
+
+type priorityKey struct{}
+
+// WithPriority tags ctx with the caller's priority class (PriorityHigh, PriorityNormal, PriorityBackground)
+func WithPriority(ctx context.Context, priority string) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the class set by WithPriority, or PriorityNormal
+func PriorityFromContext(ctx context.Context) string {
+	if p, _ := ctx.Value(priorityKey{}).(string); p != "" {
+		return p
+	}
+	return PriorityNormal
+}
+
+// WaitNotifier is told when a call has to queue for a provider slot
+type WaitNotifier func(ctx context.Context, info WaitInfo)
+
+type waitNotifierKey struct{}
+
+// WithWaitNotifier attaches the callback the Governor invokes when a call starts waiting
+func WithWaitNotifier(ctx context.Context, notify WaitNotifier) context.Context {
+	return context.WithValue(ctx, waitNotifierKey{}, notify)
+}
+
+// WaitNotifierFromContext returns the callback set by WithWaitNotifier, or nil
+func WaitNotifierFromContext(ctx context.Context) WaitNotifier {
+	notify, _ := ctx.Value(waitNotifierKey{}).(WaitNotifier)
+	return notify
+}
@@ -0,0 +1,60 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Typed provider failures; adapters wrap them in *APIError so agents can use errors.Is
+var (
+	ErrRateLimited    = errors.New("llm: rate limited")
+	ErrContextTooLong = errors.New("llm: context too long")
+	ErrSafetyBlocked  = errors.New("llm: blocked by safety filter")
+	// ErrProviderUnavailable is returned by Router when every provider in the chain is failing or circuit-open
+	ErrProviderUnavailable = errors.New("llm: no provider available")
+	// ErrNotRetryable is wrapped by failures that neither a retry nor another provider can fix
+	ErrNotRetryable = errors.New("llm: not retryable")
+)
+
+// APIError is returned when a provider answers with a non-success payload
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Code       string
+	Body       string
+	// Kind is one of the sentinel errors above, or nil when the failure is unclassified
+	Kind error
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%s api error (status %d, code %s): %s", e.Provider, e.StatusCode, e.Code, e.Body)
+	}
+	return fmt.Sprintf("%s api error (status %d): %s", e.Provider, e.StatusCode, e.Body)
+}
+
+func (e *APIError) Unwrap() error { return e.Kind }
+
+// IsRetryable reports whether err is a transient provider failure that another attempt
+// (or another provider) may not hit: rate limits, 5xx, timeouts and dropped connections.
+func IsRetryable(err error) bool {
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, ErrRateLimited):
+		return true
+	case errors.Is(err, ErrContextTooLong), errors.Is(err, ErrSafetyBlocked), errors.Is(err, ErrNotRetryable):
+		return false
+	case errors.Is(err, ErrQueueTimeout):
+		// 已等满排队超时，立即重试只会再排一次队
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError || apiErr.StatusCode == http.StatusRequestTimeout
+	}
+	// 传输层错误（连接失败、超时、流中断）均视为可重试
+	return true
+}
@@ -0,0 +1,258 @@
+package faults
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+)
+
+// ErrInjected is the default error returned by an injected mid-stream failure
+var ErrInjected = errors.New("faults: injected stream error")
+
+// Fault describes what to do to a single call; zero fields inject nothing
+type Fault struct {
+	// Latency delays the call before the first chunk is forwarded
+	Latency time.Duration
+	// ErrorAfterChunks fails the call after this many chunks were forwarded (>0).
+	// If the stream is shorter, the error is returned once the stream ends.
+	ErrorAfterChunks int
+	// Err is returned by the injected failure; defaults to ErrInjected
+	Err error
+	// TruncateFraction cuts the stream after this fraction (0<f<1) of its text and ends it cleanly,
+	// typically leaving an unclosed XML tag
+	TruncateFraction float64
+	// DuplicateChunks forwards every chunk twice
+	DuplicateChunks bool
+	// TrickleSize re-slices chunks into pieces of at most this many runes, sent TrickleDelay apart
+	TrickleSize  int
+	TrickleDelay time.Duration
+}
+
+// Config combines probabilistic injection with per-call scripted faults
+type Config struct {
+	// Seed makes probabilistic injection reproducible; 0 uses the current time
+	Seed int64
+
+	LatencyProb float64
+	Latency     time.Duration
+
+	ErrorProb     float64
+	MaxErrorAfter int // mid-stream errors land after 1..MaxErrorAfter chunks (default 5)
+	TruncateProb  float64
+	DuplicateProb float64
+	TrickleProb   float64
+	TrickleSize   int
+	TrickleDelay  time.Duration
+
+	// Script pins the fault for a given call index (0-based); scripted calls skip the probabilities
+	Script map[int]Fault
+}
+
+// Injection records the fault applied to one call, for test assertions
+type Injection struct {
+	Call  int
+	Fault Fault
+}
+
+// Client is an llm.Client decorator that injects faults into the wrapped client's streams
+type Client struct {
+	inner llm.Client
+	cfg   Config
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	calls    int
+	injected []Injection
+}
+
+// Wrap decorates inner with fault injection
+func Wrap(inner llm.Client, cfg Config) *Client {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	if cfg.MaxErrorAfter <= 0 {
+		cfg.MaxErrorAfter = 5
+	}
+	if cfg.TrickleSize <= 0 {
+		cfg.TrickleSize = 1
+	}
+	return &Client{inner: inner, cfg: cfg, rng: rand.New(rand.NewSource(seed))}
+}
+
+// Calls returns how many calls have gone through the decorator
+func (c *Client) Calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+// Injections returns the non-empty faults applied so far
+func (c *Client) Injections() []Injection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Injection(nil), c.injected...)
+}
+
+// SafeStreamCall forwards to the wrapped client, applying the fault picked for this call
+func (c *Client) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []llm.Message, onChunk llm.StreamChunkHandler, opts ...llm.CallOptions) error {
+	f := c.next()
+
+	if f.Latency > 0 {
+		if err := sleep(ctx, f.Latency); err != nil {
+			return err
+		}
+	}
+
+	injectErr := f.Err
+	if injectErr == nil {
+		injectErr = ErrInjected
+	}
+
+	forwarded := 0
+	failed := false
+	emit := func(ctx context.Context, chunk string) error {
+		if failed {
+			// 上游忽略了注入的错误时，后续分片不再转发
+			return injectErr
+		}
+		for _, piece := range trickle(chunk, f.TrickleSize) {
+			if f.TrickleSize > 0 && f.TrickleDelay > 0 && forwarded > 0 {
+				if err := sleep(ctx, f.TrickleDelay); err != nil {
+					return err
+				}
+			}
+			times := 1
+			if f.DuplicateChunks {
+				times = 2
+			}
+			for i := 0; i < times; i++ {
+				if err := onChunk(ctx, piece); err != nil {
+					return err
+				}
+				forwarded++
+				if f.ErrorAfterChunks > 0 && forwarded >= f.ErrorAfterChunks {
+					failed = true
+					return injectErr
+				}
+			}
+		}
+		return nil
+	}
+
+	if f.TruncateFraction > 0 && f.TruncateFraction < 1 {
+		// 截断需要知道全文长度，先完整缓冲上游输出
+		var buffered []string
+		total := 0
+		err := c.inner.SafeStreamCall(ctx, userID, sessionID, messages, func(ctx context.Context, chunk string) error {
+			buffered = append(buffered, chunk)
+			total += len([]rune(chunk))
+			return nil
+		}, opts...)
+		if err != nil {
+			return err
+		}
+		remaining := int(float64(total) * f.TruncateFraction)
+		for _, chunk := range buffered {
+			if remaining <= 0 {
+				break
+			}
+			r := []rune(chunk)
+			if len(r) > remaining {
+				r = r[:remaining]
+			}
+			remaining -= len(r)
+			if err := emit(ctx, string(r)); err != nil {
+				return err
+			}
+		}
+		return finish(f, failed, forwarded, injectErr)
+	}
+
+	if err := c.inner.SafeStreamCall(ctx, userID, sessionID, messages, emit, opts...); err != nil {
+		return err
+	}
+	return finish(f, failed, forwarded, injectErr)
+}
+
+// SupportsTools passes through to the wrapped client
+func (c *Client) SupportsTools() bool {
+	return llm.SupportsTools(c.inner)
+}
+
+// finish returns the injected error when it fired, or when the stream ended before ErrorAfterChunks was reached
+func finish(f Fault, failed bool, forwarded int, injectErr error) error {
+	if failed || (f.ErrorAfterChunks > 0 && forwarded < f.ErrorAfterChunks) {
+		return injectErr
+	}
+	return nil
+}
+
+// next picks the fault for the upcoming call and advances the call counter
+func (c *Client) next() Fault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	idx := c.calls
+	c.calls++
+
+	f, scripted := c.cfg.Script[idx]
+	if !scripted {
+		if c.roll(c.cfg.LatencyProb) {
+			f.Latency = c.cfg.Latency
+		}
+		if c.roll(c.cfg.ErrorProb) {
+			f.ErrorAfterChunks = 1 + c.rng.Intn(c.cfg.MaxErrorAfter)
+		}
+		if c.roll(c.cfg.TruncateProb) {
+			f.TruncateFraction = 0.1 + 0.8*c.rng.Float64()
+		}
+		if c.roll(c.cfg.DuplicateProb) {
+			f.DuplicateChunks = true
+		}
+		if c.roll(c.cfg.TrickleProb) {
+			f.TrickleSize = c.cfg.TrickleSize
+			f.TrickleDelay = c.cfg.TrickleDelay
+		}
+	}
+	if f != (Fault{}) {
+		c.injected = append(c.injected, Injection{Call: idx, Fault: f})
+	}
+	return f
+}
+
+func (c *Client) roll(p float64) bool {
+	return p > 0 && c.rng.Float64() < p
+}
+
+// trickle splits chunk into pieces of at most size runes; size<=0 keeps the chunk whole
+func trickle(chunk string, size int) []string {
+	r := []rune(chunk)
+	if size <= 0 || len(r) <= size {
+		return []string{chunk}
+	}
+	pieces := make([]string, 0, len(r)/size+1)
+	for len(r) > 0 {
+		n := size
+		if n > len(r) {
+			n = len(r)
+		}
+		pieces = append(pieces, string(r[:n]))
+		r = r[n:]
+	}
+	return pieces
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
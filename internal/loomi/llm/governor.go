@@ -0,0 +1,339 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/config"
+)
+
+// ErrQueueTimeout is returned when a call waited longer than the governor's queue timeout for a slot
+var ErrQueueTimeout = errors.New("llm: timed out waiting for a provider slot")
+
+// Priority classes, named after BaseLoomiAgent.RedisPoolType
+const (
+	PriorityHigh       = "high_priority"
+	PriorityNormal     = "normal"
+	PriorityBackground = "background"
+)
+
+var defaultPriorityWeights = map[string]int{
+	PriorityHigh:       4,
+	PriorityNormal:     2,
+	PriorityBackground: 1,
+}
+
+// SlotStore shares provider slots between replicas. Acquire reports ok=false when the provider is at
+// its limit; the returned token is passed back to Release.
+type SlotStore interface {
+	Acquire(ctx context.Context, provider string, limit int) (token string, ok bool, err error)
+	Release(ctx context.Context, provider, token string) error
+}
+
+// GovernorConfig sets per-provider stream limits. A limit <= 0 leaves the provider unbounded.
+type GovernorConfig struct {
+	DefaultLimit int
+	Limits       map[string]int
+	QueueTimeout time.Duration
+	// Weights share freed slots between waiting priority classes; unknown classes count as normal
+	Weights map[string]int
+	// Slots, when set, makes limits hold across replicas; waiters then also poll every PollInterval
+	Slots        SlotStore
+	PollInterval time.Duration
+}
+
+// GovernorConfigFrom converts llm.concurrency settings
+func GovernorConfigFrom(c config.LLMConcurrencyConfig) GovernorConfig {
+	return GovernorConfig{
+		DefaultLimit: c.DefaultLimit,
+		Limits:       c.Providers,
+		QueueTimeout: time.Duration(c.QueueTimeoutSeconds) * time.Second,
+		Weights:      c.Weights,
+	}
+}
+
+// WaitInfo describes a call that has been queued behind a provider's limit
+type WaitInfo struct {
+	Provider string
+	Priority string
+	Position int
+}
+
+// Governor bounds concurrent streams per provider across the whole process. When a provider is
+// full, calls queue per priority class and freed slots go to the classes in weighted round robin,
+// so high-priority calls move faster without starving background work.
+type Governor struct {
+	cfg GovernorConfig
+
+	mu     sync.Mutex
+	queues map[string]*providerQueue
+}
+
+type providerQueue struct {
+	active  int
+	waiting map[string][]*waiter
+	// current holds the smooth weighted round robin state per class
+	current map[string]int
+}
+
+type waiter struct {
+	class string
+	ready chan string
+}
+
+// NewGovernor creates a governor
+func NewGovernor(cfg GovernorConfig) *Governor {
+	if cfg.QueueTimeout <= 0 {
+		cfg.QueueTimeout = 60 * time.Second
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 200 * time.Millisecond
+	}
+	weights := make(map[string]int, len(defaultPriorityWeights))
+	for class, w := range defaultPriorityWeights {
+		weights[class] = w
+	}
+	for class, w := range cfg.Weights {
+		if w > 0 {
+			weights[class] = w
+		}
+	}
+	cfg.Weights = weights
+	return &Governor{cfg: cfg, queues: make(map[string]*providerQueue)}
+}
+
+// Acquire waits for a slot on provider and returns the function that frees it. Calls that have to
+// wait are reported to the WaitNotifier in ctx; waiting ends with ErrQueueTimeout or ctx's error.
+func (g *Governor) Acquire(ctx context.Context, provider, priority string) (func(), error) {
+	limit := g.limitFor(provider)
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	class := priority
+	if _, ok := g.cfg.Weights[class]; !ok {
+		class = PriorityNormal
+	}
+
+	g.mu.Lock()
+	q := g.queue(provider)
+	if q.size() == 0 && q.active < limit {
+		// 先在本进程占位，再在锁外向共享存储申请槽位
+		q.active++
+		g.mu.Unlock()
+		if token, ok := g.claim(ctx, provider, limit); ok {
+			return g.releaser(provider, token), nil
+		}
+		g.mu.Lock()
+		q.active--
+	}
+	w := &waiter{class: class, ready: make(chan string, 1)}
+	q.waiting[class] = append(q.waiting[class], w)
+	position := q.size()
+	g.mu.Unlock()
+
+	if notify := WaitNotifierFromContext(ctx); notify != nil {
+		notify(ctx, WaitInfo{Provider: provider, Priority: class, Position: position})
+	}
+
+	timer := time.NewTimer(g.cfg.QueueTimeout)
+	defer timer.Stop()
+	var poll <-chan time.Time
+	if g.cfg.Slots != nil {
+		// 其他副本释放的槽位不会通知本进程，需要轮询
+		ticker := time.NewTicker(g.cfg.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	for {
+		select {
+		case token := <-w.ready:
+			return g.releaser(provider, token), nil
+		case <-poll:
+			g.dispatch(provider, q, limit)
+		case <-ctx.Done():
+			g.abandon(provider, q, w)
+			return nil, ctx.Err()
+		case <-timer.C:
+			g.abandon(provider, q, w)
+			return nil, fmt.Errorf("%w: %s", ErrQueueTimeout, provider)
+		}
+	}
+}
+
+func (g *Governor) limitFor(provider string) int {
+	if limit, ok := g.cfg.Limits[provider]; ok {
+		return limit
+	}
+	return g.cfg.DefaultLimit
+}
+
+func (g *Governor) queue(provider string) *providerQueue {
+	q, ok := g.queues[provider]
+	if !ok {
+		q = &providerQueue{waiting: make(map[string][]*waiter), current: make(map[string]int)}
+		g.queues[provider] = q
+	}
+	return q
+}
+
+// claim takes a shared slot when a SlotStore is configured. The store may be remote, so claim
+// runs without g.mu; the caller has already counted the slot in its provider queue.
+func (g *Governor) claim(ctx context.Context, provider string, limit int) (string, bool) {
+	if g.cfg.Slots == nil {
+		return "", true
+	}
+	token, ok, err := g.cfg.Slots.Acquire(ctx, provider, limit)
+	if err != nil {
+		// 共享存储不可用时退化为本进程限流
+		return "", true
+	}
+	return token, ok
+}
+
+// dispatch hands free slots to waiters. It must be called without g.mu: each slot is counted under
+// the lock, claimed from the shared store outside it, then given to the next waiter under it again.
+func (g *Governor) dispatch(provider string, q *providerQueue, limit int) {
+	for {
+		g.mu.Lock()
+		if q.size() == 0 || q.active >= limit {
+			g.mu.Unlock()
+			return
+		}
+		q.active++
+		g.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		token, ok := g.claim(ctx, provider, limit)
+		cancel()
+
+		g.mu.Lock()
+		if !ok || q.size() == 0 {
+			// 共享槽位已满，或等待者已在申请期间离开
+			q.active--
+			g.mu.Unlock()
+			if ok {
+				g.releaseShared(provider, token)
+			}
+			return
+		}
+		class := q.next(g.cfg.Weights)
+		w := q.waiting[class][0]
+		q.waiting[class] = q.waiting[class][1:]
+		w.ready <- token
+		g.mu.Unlock()
+	}
+}
+
+// abandon removes a waiter that gave up, returning its slot if one was granted meanwhile
+func (g *Governor) abandon(provider string, q *providerQueue, w *waiter) {
+	g.mu.Lock()
+	list := q.waiting[w.class]
+	for i, other := range list {
+		if other == w {
+			q.waiting[w.class] = append(list[:i:i], list[i+1:]...)
+			g.mu.Unlock()
+			return
+		}
+	}
+	g.mu.Unlock()
+	g.releaser(provider, <-w.ready)()
+}
+
+func (g *Governor) releaser(provider, token string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.releaseShared(provider, token)
+			g.mu.Lock()
+			q := g.queue(provider)
+			q.active--
+			g.mu.Unlock()
+			g.dispatch(provider, q, g.limitFor(provider))
+		})
+	}
+}
+
+// releaseShared returns a slot claimed from the shared store
+func (g *Governor) releaseShared(provider, token string) {
+	if g.cfg.Slots == nil || token == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = g.cfg.Slots.Release(ctx, provider, token)
+}
+
+func (q *providerQueue) size() int {
+	n := 0
+	for _, list := range q.waiting {
+		n += len(list)
+	}
+	return n
+}
+
+// next picks the class to serve with smooth weighted round robin over classes that have waiters
+func (q *providerQueue) next(weights map[string]int) string {
+	best, total := "", 0
+	for class, list := range q.waiting {
+		if len(list) == 0 {
+			continue
+		}
+		w := weights[class]
+		total += w
+		q.current[class] += w
+		if best == "" || q.current[class] > q.current[best] || (q.current[class] == q.current[best] && class < best) {
+			best = class
+		}
+	}
+	q.current[best] -= total
+	return best
+}
+
+// governedClient holds a governor slot for the duration of each stream
+type governedClient struct {
+	inner    Client
+	provider string
+	gov      *Governor
+}
+
+// Govern wraps client so each call first acquires a slot on provider; the priority comes from WithPriority
+func Govern(client Client, provider string, gov *Governor) Client {
+	if gov == nil {
+		return client
+	}
+	return &governedClient{inner: client, provider: provider, gov: gov}
+}
+
+func (c *governedClient) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []Message, onChunk StreamChunkHandler, opts ...CallOptions) error {
+	release, err := c.gov.Acquire(ctx, c.provider, PriorityFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer release()
+	return c.inner.SafeStreamCall(ctx, userID, sessionID, messages, onChunk, opts...)
+}
+
+func (c *governedClient) SupportsTools() bool {
+	return SupportsTools(c.inner)
+}
+
+var (
+	governorMu      sync.RWMutex
+	defaultGovernor *Governor
+)
+
+// SetGovernor installs the process-wide governor that NewClient applies to every provider client it builds
+func SetGovernor(g *Governor) {
+	governorMu.Lock()
+	defer governorMu.Unlock()
+	defaultGovernor = g
+}
+
+func currentGovernor() *Governor {
+	governorMu.RLock()
+	defer governorMu.RUnlock()
+	return defaultGovernor
+}
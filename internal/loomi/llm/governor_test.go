@@ -0,0 +1,116 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stallingSlots blocks Acquire for provider "slow" until unblock is closed and grants the rest
+type stallingSlots struct {
+	unblock chan struct{}
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+func (s *stallingSlots) Acquire(ctx context.Context, provider string, limit int) (string, bool, error) {
+	if provider == "slow" {
+		select {
+		case <-s.unblock:
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active[provider] >= limit {
+		return "", false, nil
+	}
+	s.active[provider]++
+	return provider, true, nil
+}
+
+func (s *stallingSlots) Release(ctx context.Context, provider, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active[provider]--
+	return nil
+}
+
+func TestGovernorClaimsSharedSlotsOutsideTheLock(t *testing.T) {
+	slots := &stallingSlots{unblock: make(chan struct{}), active: make(map[string]int)}
+	g := NewGovernor(GovernorConfig{DefaultLimit: 1, QueueTimeout: time.Second, Slots: slots, PollInterval: 10 * time.Millisecond})
+
+	slow := make(chan error, 1)
+	go func() {
+		release, err := g.Acquire(context.Background(), "slow", PriorityNormal)
+		if err == nil {
+			release()
+		}
+		slow <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// another provider is served while the first one's shared store is still answering
+	done := make(chan error, 1)
+	go func() {
+		release, err := g.Acquire(context.Background(), "fast", PriorityNormal)
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("fast provider: %v", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("fast provider blocked behind the slow provider's shared store")
+	}
+
+	close(slots.unblock)
+	if err := <-slow; err != nil {
+		t.Fatalf("slow provider: %v", err)
+	}
+}
+
+func TestGovernorQueuesBeyondLimit(t *testing.T) {
+	slots := &stallingSlots{unblock: make(chan struct{}), active: make(map[string]int)}
+	close(slots.unblock)
+	g := NewGovernor(GovernorConfig{DefaultLimit: 1, QueueTimeout: 50 * time.Millisecond, Slots: slots, PollInterval: 10 * time.Millisecond})
+
+	release, err := g.Acquire(context.Background(), "p", PriorityHigh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Acquire(context.Background(), "p", PriorityHigh); !errors.Is(err, ErrQueueTimeout) || IsRetryable(err) {
+		t.Fatalf("err = %v, want non-retryable ErrQueueTimeout", err)
+	}
+
+	// a freed slot goes to the waiter, and the shared slot moves with it
+	got := make(chan func(), 1)
+	go func() {
+		next, err := g.Acquire(context.Background(), "p", PriorityNormal)
+		if err != nil {
+			t.Error(err)
+			got <- func() {}
+			return
+		}
+		got <- next
+	}()
+	time.Sleep(20 * time.Millisecond)
+	release()
+	select {
+	case next := <-got:
+		next()
+	case <-time.After(time.Second):
+		t.Fatal("waiter not served after release")
+	}
+	if slots.active["p"] != 0 {
+		t.Fatalf("shared slots still held: %d", slots.active["p"])
+	}
+}
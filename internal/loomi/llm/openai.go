@@ -0,0 +1,307 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/config"
+)
+
+// OpenAIClient streams chat completions from any OpenAI-compatible /chat/completions endpoint
+type OpenAIClient struct {
+	provider   string
+	cfg        config.LLMProviderConfig
+	httpClient *http.Client
+}
+
+// NewOpenAIClient creates a streaming client for an OpenAI-compatible provider
+func NewOpenAIClient(provider string, cfg config.LLMProviderConfig) *OpenAIClient {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "https://api.openai.com/v1"
+	}
+	return &OpenAIClient{
+		provider:   provider,
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 300 * time.Second},
+	}
+}
+
+// WithHTTPClient replaces the underlying HTTP client (e.g. for httptest servers)
+func (c *OpenAIClient) WithHTTPClient(hc *http.Client) *OpenAIClient {
+	if hc != nil {
+		c.httpClient = hc
+	}
+	return c
+}
+
+// thinkingBudgetProviders accept the thinking_budget extension on their OpenAI-compatible endpoint
+var thinkingBudgetProviders = map[string]bool{"qwen": true}
+
+type openAIChatRequest struct {
+	Model          string              `json:"model"`
+	Messages       []openAIChatMessage `json:"messages"`
+	Temperature    *float64            `json:"temperature,omitempty"`
+	TopP           *float64            `json:"top_p,omitempty"`
+	MaxTokens      int                 `json:"max_tokens,omitempty"`
+	Stop           []string            `json:"stop,omitempty"`
+	ThinkingBudget int                 `json:"thinking_budget,omitempty"`
+	Stream         bool                `json:"stream"`
+	StreamOptions  *openAIStreamOption `json:"stream_options,omitempty"`
+	Tools          []openAITool        `json:"tools,omitempty"`
+	ToolChoice     interface{}         `json:"tool_choice,omitempty"`
+	ResponseFormat *openAIFormat       `json:"response_format,omitempty"`
+}
+
+type openAIFormat struct {
+	Type string `json:"type"`
+}
+
+type openAIChatMessage struct {
+	Role       string           `json:"role"`
+	Content    interface{}      `json:"content"`
+	Name       string           `json:"name,omitempty"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAITool struct {
+	Type     string             `json:"type"`
+	Function openAIToolFunction `json:"function"`
+}
+
+type openAIToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIStreamOption struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// SafeStreamCall sends the messages and forwards each content delta to onChunk
+func (c *OpenAIClient) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []Message, onChunk StreamChunkHandler, opts ...CallOptions) error {
+	o := ResolveCallOptions(opts...)
+	body := openAIChatRequest{
+		Model:         c.cfg.Model,
+		Messages:      make([]openAIChatMessage, 0, len(messages)),
+		TopP:          o.TopP,
+		MaxTokens:     c.cfg.MaxTokens,
+		Stop:          o.Stop,
+		Stream:        true,
+		StreamOptions: &openAIStreamOption{IncludeUsage: true},
+	}
+	if o.Model != "" {
+		body.Model = o.Model
+	}
+	// 未设置温度时不发送该字段，由 provider 使用默认值；provider 配置中的 0 视为未设置
+	body.Temperature = o.Temperature
+	if body.Temperature == nil && c.cfg.Temperature != 0 {
+		body.Temperature = Float64(c.cfg.Temperature)
+	}
+	if o.MaxTokens > 0 {
+		body.MaxTokens = o.MaxTokens
+	}
+	if o.JSONMode {
+		body.ResponseFormat = &openAIFormat{Type: "json_object"}
+	}
+	// thinking_budget 是扩展字段，严格兼容 OpenAI 协议的接口会拒绝未知参数，只发给已知支持的 provider
+	if thinkingBudgetProviders[c.provider] {
+		body.ThinkingBudget = o.ThinkingBudget
+	}
+	for _, m := range messages {
+		msg := openAIChatMessage{Role: m.Role, Content: openAIContent(m), Name: m.Name, ToolCallID: m.ToolCallID}
+		for _, tc := range m.ToolCalls {
+			call := openAIToolCall{ID: tc.ID, Type: "function"}
+			call.Function.Name = tc.Name
+			call.Function.Arguments = tc.Arguments
+			msg.ToolCalls = append(msg.ToolCalls, call)
+		}
+		body.Messages = append(body.Messages, msg)
+	}
+	for _, t := range o.Tools {
+		body.Tools = append(body.Tools, openAITool{
+			Type:     "function",
+			Function: openAIToolFunction{Name: t.Name, Description: t.Description, Parameters: t.Parameters},
+		})
+	}
+	if len(body.Tools) > 0 {
+		body.ToolChoice = openAIToolChoice(o.ToolChoice)
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("marshal chat request: %w", err)
+	}
+
+	url := strings.TrimRight(c.cfg.BaseURL, "/") + "/chat/completions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("create chat request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	if c.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.APIKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%s chat request failed: %w", c.provider, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return classifyOpenAIError(c.provider, resp.StatusCode, string(raw))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			// 忽略空行、注释行（": keep-alive"）与 event: 行
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			return nil
+		}
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("decode %s stream chunk: %w", c.provider, err)
+		}
+		if chunk.Error != nil {
+			return classifyOpenAIError(c.provider, http.StatusOK, chunk.Error.Type+": "+chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			ReportUsage(ctx, chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens)
+		}
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != nil && *choice.FinishReason == "content_filter" {
+				return &APIError{Provider: c.provider, StatusCode: http.StatusOK, Body: "finish_reason=content_filter", Kind: ErrSafetyBlocked}
+			}
+			for _, tc := range choice.Delta.ToolCalls {
+				if o.OnToolCall == nil {
+					continue
+				}
+				delta := ToolCallDelta{Index: tc.Index, ID: tc.ID, Name: tc.Function.Name, ArgumentsDelta: tc.Function.Arguments}
+				if err := o.OnToolCall(ctx, delta); err != nil {
+					return err
+				}
+			}
+			if choice.Delta.Content == "" {
+				continue
+			}
+			if err := onChunk(ctx, choice.Delta.Content); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("read %s stream: %w", c.provider, err)
+	}
+	return nil
+}
+
+// openAIContent returns a plain string for text-only messages and a content-part array otherwise
+func openAIContent(m Message) interface{} {
+	if len(m.Parts) == 0 {
+		return m.Content
+	}
+	parts := make([]map[string]interface{}, 0, len(m.Parts)+1)
+	for _, p := range m.ContentParts() {
+		switch {
+		case p.Type == PartImage:
+			parts = append(parts, map[string]interface{}{"type": "image_url", "image_url": map[string]string{"url": p.DataURL()}})
+		case p.Type == PartFile && len(p.Data) > 0:
+			parts = append(parts, map[string]interface{}{"type": "file", "file": map[string]string{"filename": p.FileName, "file_data": p.DataURL()}})
+		case p.Type == PartFile:
+			// 按 URL 引用的文件没有对应的原生类型，以链接文本传入
+			parts = append(parts, map[string]interface{}{"type": "text", "text": fmt.Sprintf("[%s](%s)", p.FileName, p.URL)})
+		default:
+			parts = append(parts, map[string]interface{}{"type": "text", "text": p.Text})
+		}
+	}
+	return parts
+}
+
+// SupportsTools reports that OpenAI-compatible endpoints accept function tools
+func (c *OpenAIClient) SupportsTools() bool { return true }
+
+// openAIToolChoice maps CallOptions.ToolChoice to the OpenAI tool_choice value
+func openAIToolChoice(choice string) interface{} {
+	switch choice {
+	case "", "auto":
+		return "auto"
+	case "none", "required":
+		return choice
+	default:
+		return map[string]interface{}{"type": "function", "function": map[string]string{"name": choice}}
+	}
+}
+
+// classifyOpenAIError maps OpenAI-style error bodies to typed errors
+func classifyOpenAIError(provider string, status int, body string) *APIError {
+	e := &APIError{Provider: provider, StatusCode: status, Body: body}
+	lower := strings.ToLower(body)
+	switch {
+	case status == http.StatusTooManyRequests || strings.Contains(lower, "rate_limit") || strings.Contains(lower, "rate limit"):
+		e.Kind = ErrRateLimited
+	case strings.Contains(lower, "context_length_exceeded") || strings.Contains(lower, "maximum context length"):
+		e.Kind = ErrContextTooLong
+	case strings.Contains(lower, "content_filter") || strings.Contains(lower, "content_policy"):
+		e.Kind = ErrSafetyBlocked
+	}
+	return e
+}
@@ -0,0 +1,114 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/blueplan/loomi-go/internal/loomi/config"
+)
+
+func newTestOpenAIClient(t *testing.T, handler http.HandlerFunc) *OpenAIClient {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return NewOpenAIClient("qwen", config.LLMProviderConfig{APIKey: "sk-test", Model: "qwen-plus", BaseURL: srv.URL}).WithHTTPClient(srv.Client())
+}
+
+func TestOpenAIClientStreamsChunksAndUsage(t *testing.T) {
+	var got openAIChatRequest
+	client := newTestOpenAIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer sk-test" {
+			t.Errorf("Authorization = %q", auth)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"你好\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"，世界\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":7,\"completion_tokens\":3}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	})
+
+	var text strings.Builder
+	res := &CallResult{}
+	err := client.SafeStreamCall(WithCallResult(context.Background(), res), "u1", "s1", []Message{{Role: "user", Content: "hi"}}, func(ctx context.Context, chunk string) error {
+		text.WriteString(chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SafeStreamCall: %v", err)
+	}
+	if text.String() != "你好，世界" {
+		t.Errorf("text = %q", text.String())
+	}
+	if !got.Stream || got.StreamOptions == nil || !got.StreamOptions.IncludeUsage || got.Model != "qwen-plus" {
+		t.Errorf("request = %+v", got)
+	}
+	prompt, completion, ok := res.Usage()
+	if !ok || prompt != 7 || completion != 3 {
+		t.Errorf("usage = %d/%d ok=%v, want 7/3", prompt, completion, ok)
+	}
+}
+
+func TestOpenAIClientMapsRateLimit(t *testing.T) {
+	client := newTestOpenAIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"message":"slow down","type":"rate_limit_error"}}`)
+	})
+
+	err := client.SafeStreamCall(context.Background(), "u1", "s1", []Message{{Role: "user", Content: "hi"}}, func(ctx context.Context, chunk string) error {
+		t.Errorf("unexpected chunk %q", chunk)
+		return nil
+	})
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || !IsRetryable(err) {
+		t.Errorf("err = %#v", err)
+	}
+}
+
+func TestOpenAIClientSendsOptionalFieldsOnlyWhenSet(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = nil
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+
+	for name, tc := range map[string]struct {
+		provider    string
+		opts        CallOptions
+		temperature any
+		thinking    any
+	}{
+		"unset temperature is omitted":   {"qwen", CallOptions{}, nil, nil},
+		"zero temperature is sent":       {"qwen", CallOptions{Temperature: Float64(0)}, 0.0, nil},
+		"qwen gets the thinking budget":  {"qwen", CallOptions{ThinkingBudget: 800}, nil, 800.0},
+		"others never see the extension": {"deepseek", CallOptions{ThinkingBudget: 800}, nil, nil},
+	} {
+		client := NewOpenAIClient(tc.provider, config.LLMProviderConfig{APIKey: "sk-test", Model: "m", BaseURL: srv.URL}).WithHTTPClient(srv.Client())
+		if err := client.SafeStreamCall(context.Background(), "u1", "s1", []Message{{Role: "user", Content: "hi"}}, func(ctx context.Context, chunk string) error { return nil }, tc.opts); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got["temperature"] != tc.temperature || got["thinking_budget"] != tc.thinking {
+			t.Errorf("%s: temperature = %v, thinking_budget = %v; want %v, %v", name, got["temperature"], got["thinking_budget"], tc.temperature, tc.thinking)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package llm
+
+// CallOptions carries per-call generation settings. Zero values mean "use the provider default";
+// Temperature and TopP are pointers because 0 is a meaningful value for both.
+type CallOptions struct {
+	Temperature    *float64
+	TopP           *float64
+	MaxTokens      int
+	Stop           []string
+	ThinkingBudget int
+	// Model overrides the provider's configured model for this call only
+	Model string
+
+	// Tools are offered to the model on clients that implement ToolCapable
+	Tools []Tool
+	// ToolChoice is "auto" (default), "none", "required" or a tool name
+	ToolChoice string
+	// OnToolCall receives streamed tool-call fragments
+	OnToolCall ToolCallDeltaHandler
+
+	// JSONMode asks the provider to constrain the output to a JSON object where supported
+	JSONMode bool
+}
+
+// ResolveCallOptions merges options left to right; set fields of later options win
+func ResolveCallOptions(opts ...CallOptions) CallOptions {
+	var out CallOptions
+	for _, o := range opts {
+		if o.Temperature != nil {
+			out.Temperature = o.Temperature
+		}
+		if o.TopP != nil {
+			out.TopP = o.TopP
+		}
+		if o.MaxTokens > 0 {
+			out.MaxTokens = o.MaxTokens
+		}
+		if len(o.Stop) > 0 {
+			out.Stop = o.Stop
+		}
+		if o.ThinkingBudget > 0 {
+			out.ThinkingBudget = o.ThinkingBudget
+		}
+		if o.Model != "" {
+			out.Model = o.Model
+		}
+		if len(o.Tools) > 0 {
+			out.Tools = o.Tools
+		}
+		if o.ToolChoice != "" {
+			out.ToolChoice = o.ToolChoice
+		}
+		if o.OnToolCall != nil {
+			out.OnToolCall = o.OnToolCall
+		}
+		if o.JSONMode {
+			out.JSONMode = true
+		}
+	}
+	return out
+}
+
+// Float64 returns a pointer to v, for filling CallOptions.Temperature/TopP
+func Float64(v float64) *float64 { return &v }
@@ -0,0 +1,93 @@
+package llm
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// PartType identifies the kind of content a Part carries
+type PartType string
+
+const (
+	PartText  PartType = "text"
+	PartImage PartType = "image"
+	PartFile  PartType = "file"
+)
+
+// Part is one piece of multimodal message content. Images and files carry either inline
+// Data with MIMEType, or a URL; FileID records the stored file the part was resolved from.
+type Part struct {
+	Type     PartType
+	Text     string
+	Data     []byte
+	MIMEType string
+	URL      string
+	FileID   string
+	FileName string
+}
+
+// TextPart creates a text part
+func TextPart(text string) Part {
+	return Part{Type: PartText, Text: text}
+}
+
+// ImagePart creates an inline image part from raw bytes
+func ImagePart(data []byte, mimeType string) Part {
+	return Part{Type: PartImage, Data: data, MIMEType: mimeType}
+}
+
+// ImageURLPart creates an image part referenced by URL
+func ImageURLPart(url string) Part {
+	return Part{Type: PartImage, URL: url}
+}
+
+// FilePart creates an inline document part (e.g. a PDF)
+func FilePart(data []byte, mimeType, fileName string) Part {
+	return Part{Type: PartFile, Data: data, MIMEType: mimeType, FileName: fileName}
+}
+
+// FileURLPart creates a document part referenced by URL
+func FileURLPart(url, mimeType, fileName string) Part {
+	return Part{Type: PartFile, URL: url, MIMEType: mimeType, FileName: fileName}
+}
+
+// DataURL encodes inline data as a data: URL, or returns URL for referenced parts
+func (p Part) DataURL() string {
+	if len(p.Data) == 0 {
+		return p.URL
+	}
+	mimeType := p.MIMEType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(p.Data)
+}
+
+// ContentParts returns the message as parts: Content, when set, becomes a leading text part
+func (m Message) ContentParts() []Part {
+	if len(m.Parts) == 0 {
+		if m.Content == "" {
+			return nil
+		}
+		return []Part{TextPart(m.Content)}
+	}
+	parts := make([]Part, 0, len(m.Parts)+1)
+	if m.Content != "" {
+		parts = append(parts, TextPart(m.Content))
+	}
+	return append(parts, m.Parts...)
+}
+
+// Text returns the message's textual content, joining Content and any text parts
+func (m Message) Text() string {
+	if len(m.Parts) == 0 {
+		return m.Content
+	}
+	var texts []string
+	for _, p := range m.ContentParts() {
+		if p.Type == PartText {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,75 @@
+package llm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPartConstructors(t *testing.T) {
+	png := []byte{0x89, 'P', 'N', 'G'}
+	for name, tc := range map[string]struct {
+		part    Part
+		want    Part
+		dataURL string
+	}{
+		"text": {TextPart("你好"), Part{Type: PartText, Text: "你好"}, ""},
+		"inline image": {
+			ImagePart(png, "image/png"),
+			Part{Type: PartImage, Data: png, MIMEType: "image/png"},
+			"data:image/png;base64,iVBORw==",
+		},
+		"image url": {
+			ImageURLPart("https://example.com/a.png"),
+			Part{Type: PartImage, URL: "https://example.com/a.png"},
+			"https://example.com/a.png",
+		},
+		"inline file": {
+			FilePart([]byte("%PDF"), "application/pdf", "brief.pdf"),
+			Part{Type: PartFile, Data: []byte("%PDF"), MIMEType: "application/pdf", FileName: "brief.pdf"},
+			"data:application/pdf;base64,JVBERg==",
+		},
+		"file url": {
+			FileURLPart("https://example.com/brief.pdf", "application/pdf", "brief.pdf"),
+			Part{Type: PartFile, URL: "https://example.com/brief.pdf", MIMEType: "application/pdf", FileName: "brief.pdf"},
+			"https://example.com/brief.pdf",
+		},
+		"inline data without a MIME type": {
+			Part{Type: PartFile, Data: []byte{1}},
+			Part{Type: PartFile, Data: []byte{1}},
+			"data:application/octet-stream;base64,AQ==",
+		},
+	} {
+		if !reflect.DeepEqual(tc.part, tc.want) {
+			t.Errorf("%s: part = %+v, want %+v", name, tc.part, tc.want)
+		}
+		if got := tc.part.DataURL(); got != tc.dataURL {
+			t.Errorf("%s: DataURL() = %q, want %q", name, got, tc.dataURL)
+		}
+	}
+}
+
+func TestMessageContentParts(t *testing.T) {
+	image := ImageURLPart("https://example.com/a.png")
+	for name, tc := range map[string]struct {
+		msg   Message
+		parts []Part
+		text  string
+	}{
+		"empty":        {Message{Role: "user"}, nil, ""},
+		"content only": {Message{Role: "user", Content: "看图"}, []Part{TextPart("看图")}, "看图"},
+		"parts only":   {Message{Role: "user", Parts: []Part{image, TextPart("说明")}}, []Part{image, TextPart("说明")}, "说明"},
+		// Content comes first and text parts are joined after it
+		"content and parts": {
+			Message{Role: "user", Content: "看图", Parts: []Part{image, TextPart("说明")}},
+			[]Part{TextPart("看图"), image, TextPart("说明")},
+			"看图\n说明",
+		},
+	} {
+		if got := tc.msg.ContentParts(); !reflect.DeepEqual(got, tc.parts) {
+			t.Errorf("%s: ContentParts() = %+v, want %+v", name, got, tc.parts)
+		}
+		if got := tc.msg.Text(); got != tc.text {
+			t.Errorf("%s: Text() = %q, want %q", name, got, tc.text)
+		}
+	}
+}
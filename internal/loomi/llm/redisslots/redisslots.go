@@ -0,0 +1,72 @@
+package redisslots
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/pool"
+	"github.com/redis/go-redis/v9"
+)
+
+// KeyPrefix namespaces the per-provider slot sets in Redis
+const KeyPrefix = "loomi:llm_slots:"
+
+// acquireScript drops expired leases, then adds one if the provider is below its limit
+var acquireScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[3]) then
+  return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[1] + ARGV[2], ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+// Store implements llm.SlotStore with one sorted set of leases per provider, so provider limits
+// hold across replicas. Leases expire after LeaseTTL in case a replica dies holding slots.
+type Store struct {
+	pools    pool.RedisPools
+	poolType string
+	LeaseTTL time.Duration
+}
+
+// New creates a slot store on the given pool type ("high_priority" when empty)
+func New(pools pool.RedisPools, poolType string) *Store {
+	if poolType == "" {
+		poolType = "high_priority"
+	}
+	return &Store{pools: pools, poolType: poolType, LeaseTTL: 10 * time.Minute}
+}
+
+// Acquire leases a slot if fewer than limit leases are live
+func (s *Store) Acquire(ctx context.Context, provider string, limit int) (string, bool, error) {
+	client, err := s.pools.GetRedisClient(ctx, s.poolType)
+	if err != nil {
+		return "", false, err
+	}
+	token := newToken()
+	now := time.Now().UnixMilli()
+	ok, err := acquireScript.Run(ctx, client, []string{KeyPrefix + provider},
+		now, s.LeaseTTL.Milliseconds(), limit, token).Int()
+	if err != nil {
+		return "", false, err
+	}
+	return token, ok == 1, nil
+}
+
+// Release returns a leased slot
+func (s *Store) Release(ctx context.Context, provider, token string) error {
+	client, err := s.pools.GetRedisClient(ctx, s.poolType)
+	if err != nil {
+		return err
+	}
+	return client.ZRem(ctx, KeyPrefix+provider, token).Err()
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,241 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/config"
+)
+
+// RouteTarget is one hop of a fallback chain; Model, when set, is pinned for this provider
+type RouteTarget struct {
+	Provider string
+	Model    string
+	Client   Client
+}
+
+// Router is a Client that tries an ordered chain of providers. It fails over on
+// retryable errors as long as nothing has been streamed to the caller yet, and
+// skips providers whose circuit breaker is open.
+type Router struct {
+	defaultChain []RouteTarget
+	agentChains  map[string][]RouteTarget
+
+	breakersMu sync.Mutex
+	breakers   map[string]*circuitBreaker
+	threshold  int
+	cooldown   time.Duration
+}
+
+// NewRouter builds a Router from cfg.Routing. Chain entries naming providers that are not
+// configured are skipped; an empty default chain falls back to cfg.DefaultProvider.
+func NewRouter(cfg config.LLMConfig) (*Router, error) {
+	r := &Router{
+		agentChains: make(map[string][]RouteTarget),
+		breakers:    make(map[string]*circuitBreaker),
+		threshold:   cfg.Routing.FailureThreshold,
+		cooldown:    time.Duration(cfg.Routing.CooldownSeconds) * time.Second,
+	}
+
+	clients := make(map[string]Client)
+	build := func(entries []string) ([]RouteTarget, error) {
+		var chain []RouteTarget
+		for _, entry := range entries {
+			provider, model, _ := strings.Cut(strings.TrimSpace(entry), ":")
+			if provider == "" {
+				continue
+			}
+			pc, ok := cfg.Providers[provider]
+			if !ok && provider != "mock" {
+				continue
+			}
+			client, ok := clients[provider]
+			if !ok {
+				var err error
+				if client, err = NewClient(provider, pc); err != nil {
+					return nil, fmt.Errorf("build llm provider %s: %w", provider, err)
+				}
+				clients[provider] = client
+			}
+			chain = append(chain, RouteTarget{Provider: provider, Model: model, Client: client})
+		}
+		return chain, nil
+	}
+
+	defaults := cfg.Routing.Default
+	if len(defaults) == 0 {
+		defaults = []string{cfg.DefaultProvider}
+	}
+	chain, err := build(defaults)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		client, err := NewClient(cfg.DefaultProvider, cfg.Providers[cfg.DefaultProvider])
+		if err != nil {
+			return nil, err
+		}
+		chain = []RouteTarget{{Provider: cfg.DefaultProvider, Client: client}}
+	}
+	r.defaultChain = chain
+
+	for agent, entries := range cfg.Routing.Agents {
+		chain, err := build(entries)
+		if err != nil {
+			return nil, err
+		}
+		if len(chain) > 0 {
+			r.agentChains[agent] = chain
+		}
+	}
+	return r, nil
+}
+
+// NewRouterWithTargets builds a Router over explicit targets (tests, custom wiring)
+func NewRouterWithTargets(defaultChain []RouteTarget, agentChains map[string][]RouteTarget, failureThreshold int, cooldown time.Duration) *Router {
+	if agentChains == nil {
+		agentChains = make(map[string][]RouteTarget)
+	}
+	return &Router{
+		defaultChain: defaultChain,
+		agentChains:  agentChains,
+		breakers:     make(map[string]*circuitBreaker),
+		threshold:    failureThreshold,
+		cooldown:     cooldown,
+	}
+}
+
+// SafeStreamCall routes the call using the agent name carried in ctx (see WithAgentName)
+func (r *Router) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []Message, onChunk StreamChunkHandler, opts ...CallOptions) error {
+	chain := r.chainFor(AgentNameFromContext(ctx))
+	lastErr := ErrProviderUnavailable
+
+	for _, target := range chain {
+		breaker := r.breaker(target.Provider)
+		if !breaker.allow() {
+			continue
+		}
+
+		callOpts := opts
+		if target.Model != "" {
+			// 路由规则中的模型与 provider 绑定，优先于调用方的模型覆盖
+			callOpts = append(append([]CallOptions{}, opts...), CallOptions{Model: target.Model})
+		}
+
+		emitted := false
+		var chunkErr error
+		if onToolCall := ResolveCallOptions(opts...).OnToolCall; onToolCall != nil {
+			// 工具调用片段同样算作已输出
+			callOpts = append(append([]CallOptions{}, callOpts...), CallOptions{OnToolCall: func(ctx context.Context, d ToolCallDelta) error {
+				emitted = true
+				if err := onToolCall(ctx, d); err != nil {
+					chunkErr = err
+					return err
+				}
+				return nil
+			}})
+		}
+		err := target.Client.SafeStreamCall(ctx, userID, sessionID, messages, func(ctx context.Context, chunk string) error {
+			emitted = true
+			if err := onChunk(ctx, chunk); err != nil {
+				chunkErr = err
+				return err
+			}
+			return nil
+		}, callOpts...)
+
+		switch {
+		case err == nil:
+			breaker.onSuccess()
+			return nil
+		case chunkErr != nil || ctx.Err() != nil:
+			// 调用方停止或取消，与 provider 健康无关
+			breaker.onAbandon()
+			return err
+		case errors.Is(err, ErrQueueTimeout):
+			// 排队超时说明该 provider 繁忙而非故障，不计入熔断，尝试下一个
+			breaker.onAbandon()
+			lastErr = err
+			continue
+		case !IsRetryable(err):
+			// provider 正常应答（安全拦截、超长等），不计入熔断
+			breaker.onSuccess()
+			return err
+		}
+
+		breaker.onFailure(err)
+		if emitted {
+			// 已向调用方输出部分内容，切换 provider 会产生重复/错乱输出
+			return err
+		}
+		lastErr = err
+	}
+
+	if lastErr == ErrProviderUnavailable {
+		return lastErr
+	}
+	return fmt.Errorf("%w: %w", ErrProviderUnavailable, lastErr)
+}
+
+// SupportsTools reports true only when every routed provider accepts native tools, so a
+// failover never silently drops the tool definitions
+func (r *Router) SupportsTools() bool {
+	all := append([]RouteTarget(nil), r.defaultChain...)
+	for _, chain := range r.agentChains {
+		all = append(all, chain...)
+	}
+	for _, t := range all {
+		if !SupportsTools(t.Client) {
+			return false
+		}
+	}
+	return len(all) > 0
+}
+
+// Health returns a circuit breaker snapshot for every provider the router knows about
+func (r *Router) Health() []ProviderHealth {
+	seen := make(map[string]bool)
+	var providers []string
+	collect := func(chain []RouteTarget) {
+		for _, t := range chain {
+			if !seen[t.Provider] {
+				seen[t.Provider] = true
+				providers = append(providers, t.Provider)
+			}
+		}
+	}
+	collect(r.defaultChain)
+	for _, chain := range r.agentChains {
+		collect(chain)
+	}
+	sort.Strings(providers)
+
+	out := make([]ProviderHealth, 0, len(providers))
+	for _, p := range providers {
+		out = append(out, r.breaker(p).snapshot())
+	}
+	return out
+}
+
+func (r *Router) chainFor(agentName string) []RouteTarget {
+	if chain, ok := r.agentChains[agentName]; ok {
+		return chain
+	}
+	return r.defaultChain
+}
+
+func (r *Router) breaker(provider string) *circuitBreaker {
+	r.breakersMu.Lock()
+	defer r.breakersMu.Unlock()
+	b, ok := r.breakers[provider]
+	if !ok {
+		b = newCircuitBreaker(provider, r.threshold, r.cooldown)
+		r.breakers[provider] = b
+	}
+	return b
+}
@@ -0,0 +1,193 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedProvider streams chunks and then returns errs[i] on its i-th call (the last entry once
+// they run out); a nil entry is a successful call
+type scriptedProvider struct {
+	chunks []string
+	errs   []error
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *scriptedProvider) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []Message, onChunk StreamChunkHandler, opts ...CallOptions) error {
+	p.mu.Lock()
+	n := p.calls
+	p.calls++
+	p.mu.Unlock()
+
+	for _, chunk := range p.chunks {
+		if err := onChunk(ctx, chunk); err != nil {
+			return err
+		}
+	}
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return p.errs[min(n, len(p.errs)-1)]
+}
+
+func (p *scriptedProvider) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+var errUnavailable = &APIError{Provider: "primary", StatusCode: http.StatusServiceUnavailable, Body: "overloaded"}
+
+func routeOnce(r *Router, agent string) (string, error) {
+	var text strings.Builder
+	err := r.SafeStreamCall(WithAgentName(context.Background(), agent), "u1", "s1", []Message{{Role: "user", Content: "hi"}}, func(ctx context.Context, chunk string) error {
+		text.WriteString(chunk)
+		return nil
+	})
+	return text.String(), err
+}
+
+func twoHopRouter(primary, backup Client) *Router {
+	return NewRouterWithTargets([]RouteTarget{{Provider: "primary", Client: primary}, {Provider: "backup", Client: backup}}, nil, 3, time.Minute)
+}
+
+func TestRouterFailsOverBeforeTheFirstChunk(t *testing.T) {
+	for name, err := range map[string]error{
+		"server error":  errUnavailable,
+		"queue timeout": fmt.Errorf("primary: %w", ErrQueueTimeout),
+	} {
+		t.Run(name, func(t *testing.T) {
+			primary := &scriptedProvider{errs: []error{err}}
+			backup := &scriptedProvider{chunks: []string{"来自", "备用"}}
+			r := twoHopRouter(primary, backup)
+
+			text, callErr := routeOnce(r, "")
+			if callErr != nil || text != "来自备用" {
+				t.Fatalf("text = %q, err = %v, want the backup's answer", text, callErr)
+			}
+			if primary.Calls() != 1 || backup.Calls() != 1 {
+				t.Errorf("calls = %d/%d, want 1/1", primary.Calls(), backup.Calls())
+			}
+		})
+	}
+
+	// a queue timeout means busy, not broken: it never counts toward the breaker
+	primary := &scriptedProvider{errs: []error{ErrQueueTimeout}}
+	r := twoHopRouter(primary, &scriptedProvider{})
+	for i := 0; i < 5; i++ {
+		_, _ = routeOnce(r, "")
+	}
+	if h := r.breaker("primary").snapshot(); h.State != BreakerClosed || h.TotalFailures != 0 || primary.Calls() != 5 {
+		t.Errorf("primary after queue timeouts: %+v, calls = %d", h, primary.Calls())
+	}
+}
+
+func TestRouterDoesNotFailOverAfterAChunk(t *testing.T) {
+	primary := &scriptedProvider{chunks: []string{"半句"}, errs: []error{errUnavailable}}
+	backup := &scriptedProvider{chunks: []string{"完整回答"}}
+	r := twoHopRouter(primary, backup)
+
+	text, err := routeOnce(r, "")
+	if !errors.Is(err, errUnavailable) || text != "半句" {
+		t.Fatalf("text = %q, err = %v, want the primary's partial answer and error", text, err)
+	}
+	if backup.Calls() != 0 {
+		t.Errorf("backup called %d times after output was streamed", backup.Calls())
+	}
+	if h := r.breaker("primary").snapshot(); h.ConsecutiveFailures != 1 {
+		t.Errorf("primary breaker %+v, want the failure counted", h)
+	}
+}
+
+func TestRouterNonRetryableErrorsDoNotTripTheBreaker(t *testing.T) {
+	blocked := &APIError{Provider: "primary", StatusCode: http.StatusBadRequest, Kind: ErrSafetyBlocked}
+	primary := &scriptedProvider{errs: []error{blocked}}
+	backup := &scriptedProvider{}
+	r := twoHopRouter(primary, backup)
+
+	for i := 0; i < 5; i++ {
+		if _, err := routeOnce(r, ""); !errors.Is(err, ErrSafetyBlocked) {
+			t.Fatalf("call %d: err = %v, want ErrSafetyBlocked without failover", i, err)
+		}
+	}
+	if backup.Calls() != 0 || primary.Calls() != 5 {
+		t.Errorf("calls = %d/%d, want every call answered by the primary", primary.Calls(), backup.Calls())
+	}
+	if h := r.breaker("primary").snapshot(); h.State != BreakerClosed || h.TotalFailures != 0 {
+		t.Errorf("primary breaker %+v, want closed", h)
+	}
+}
+
+func TestRouterSkipsOpenProviders(t *testing.T) {
+	primary := &scriptedProvider{errs: []error{errUnavailable}}
+	backup := &scriptedProvider{chunks: []string{"ok"}}
+	r := twoHopRouter(primary, backup)
+
+	for i := 0; i < 5; i++ {
+		if text, err := routeOnce(r, ""); err != nil || text != "ok" {
+			t.Fatalf("call %d: text = %q, err = %v", i, text, err)
+		}
+	}
+	// the breaker opens at the threshold of 3; later calls go straight to the backup
+	if primary.Calls() != 3 || backup.Calls() != 5 {
+		t.Errorf("calls = %d/%d, want 3/5", primary.Calls(), backup.Calls())
+	}
+
+	// with every provider open the router reports them unavailable
+	only := NewRouterWithTargets([]RouteTarget{{Provider: "primary", Client: primary}}, nil, 1, time.Minute)
+	if _, err := routeOnce(only, ""); !errors.Is(err, ErrProviderUnavailable) || !errors.Is(err, errUnavailable) {
+		t.Errorf("err = %v, want ErrProviderUnavailable wrapping the provider error", err)
+	}
+	if _, err := routeOnce(only, ""); err != ErrProviderUnavailable {
+		t.Errorf("err = %v, want bare ErrProviderUnavailable with the breaker open", err)
+	}
+}
+
+func TestRouterUsesAgentChains(t *testing.T) {
+	fallback := &scriptedProvider{chunks: []string{"default"}}
+	pinned := &scriptedProvider{chunks: []string{"pinned"}}
+	r := NewRouterWithTargets(
+		[]RouteTarget{{Provider: "primary", Client: fallback}},
+		map[string][]RouteTarget{"loomi_xhs_post_agent": {{Provider: "backup", Client: pinned}}},
+		3, time.Minute)
+
+	if text, _ := routeOnce(r, "loomi_xhs_post_agent"); text != "pinned" {
+		t.Errorf("agent chain answered %q", text)
+	}
+	if text, _ := routeOnce(r, "loomi_hitpoint_agent"); text != "default" {
+		t.Errorf("default chain answered %q", text)
+	}
+}
+
+func TestRouterHealth(t *testing.T) {
+	failing := &scriptedProvider{errs: []error{errUnavailable}}
+	r := NewRouterWithTargets(
+		[]RouteTarget{{Provider: "qwen", Client: failing}, {Provider: "deepseek", Client: &scriptedProvider{}}},
+		map[string][]RouteTarget{"loomi_xhs_post_agent": {{Provider: "zhipu", Client: &scriptedProvider{}}, {Provider: "qwen", Client: failing}}},
+		1, time.Minute)
+	_, _ = routeOnce(r, "")
+
+	health := r.Health()
+	var providers []string
+	for _, h := range health {
+		providers = append(providers, h.Provider)
+	}
+	if strings.Join(providers, ",") != "deepseek,qwen,zhipu" {
+		t.Fatalf("providers = %v, want each provider once, sorted", providers)
+	}
+	qwen, deepseek := health[1], health[0]
+	if qwen.State != BreakerOpen || qwen.TotalCalls != 1 || qwen.TotalFailures != 1 || qwen.LastError != errUnavailable.Error() || qwen.OpenUntil.IsZero() {
+		t.Errorf("qwen health %+v", qwen)
+	}
+	if deepseek.State != BreakerClosed || deepseek.TotalCalls != 1 || deepseek.TotalFailures != 0 {
+		t.Errorf("deepseek health %+v", deepseek)
+	}
+}
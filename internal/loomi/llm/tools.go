@@ -0,0 +1,168 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Tool declares a function the model may call; Parameters is a JSON Schema object
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage
+}
+
+// ToolCall is a complete tool invocation; Arguments is the raw JSON produced by the model
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// ToolCallDelta is one streamed fragment of a tool call. Fragments with the same Index
+// belong to the same call; ID and Name usually arrive only on the first fragment.
+type ToolCallDelta struct {
+	Index          int
+	ID             string
+	Name           string
+	ArgumentsDelta string
+}
+
+// ToolCallDeltaHandler receives tool-call fragments as the provider streams them
+type ToolCallDeltaHandler func(ctx context.Context, delta ToolCallDelta) error
+
+// ToolCapable is implemented by clients that can send tool definitions to the provider.
+// Clients without it ignore CallOptions.Tools, and agents fall back to XML tags.
+type ToolCapable interface {
+	SupportsTools() bool
+}
+
+// SupportsTools reports whether client accepts native tool definitions
+func SupportsTools(client Client) bool {
+	tc, ok := client.(ToolCapable)
+	return ok && tc.SupportsTools()
+}
+
+// ToolCallAccumulator assembles streamed deltas into complete calls
+type ToolCallAccumulator struct {
+	mu    sync.Mutex
+	calls map[int]*ToolCall
+	args  map[int]*strings.Builder
+}
+
+// NewToolCallAccumulator creates an empty accumulator
+func NewToolCallAccumulator() *ToolCallAccumulator {
+	return &ToolCallAccumulator{calls: make(map[int]*ToolCall), args: make(map[int]*strings.Builder)}
+}
+
+// Add merges one delta; it matches ToolCallDeltaHandler so it can be passed as CallOptions.OnToolCall
+func (a *ToolCallAccumulator) Add(_ context.Context, d ToolCallDelta) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	call, ok := a.calls[d.Index]
+	if !ok {
+		call = &ToolCall{}
+		a.calls[d.Index] = call
+		a.args[d.Index] = &strings.Builder{}
+	}
+	if d.ID != "" {
+		call.ID = d.ID
+	}
+	if d.Name != "" {
+		call.Name = d.Name
+	}
+	a.args[d.Index].WriteString(d.ArgumentsDelta)
+	return nil
+}
+
+// Calls returns the assembled calls ordered by stream index
+func (a *ToolCallAccumulator) Calls() []ToolCall {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	indexes := make([]int, 0, len(a.calls))
+	for i := range a.calls {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	out := make([]ToolCall, 0, len(indexes))
+	for _, i := range indexes {
+		call := *a.calls[i]
+		call.Arguments = a.args[i].String()
+		if call.ID == "" {
+			call.ID = fmt.Sprintf("call_%d", i)
+		}
+		out = append(out, call)
+	}
+	return out
+}
+
+// ToolHandler executes a tool with the model's JSON arguments and returns the result fed back to the model
+type ToolHandler func(ctx context.Context, args json.RawMessage) (string, error)
+
+// ToolSet pairs tool declarations with their Go handlers
+type ToolSet struct {
+	mu       sync.RWMutex
+	tools    []Tool
+	handlers map[string]ToolHandler
+}
+
+// NewToolSet creates an empty tool set
+func NewToolSet() *ToolSet {
+	return &ToolSet{handlers: make(map[string]ToolHandler)}
+}
+
+// Register declares a tool and binds its handler; re-registering a name replaces it
+func (s *ToolSet) Register(tool Tool, handler ToolHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.handlers[tool.Name]; exists {
+		for i := range s.tools {
+			if s.tools[i].Name == tool.Name {
+				s.tools[i] = tool
+			}
+		}
+	} else {
+		s.tools = append(s.tools, tool)
+	}
+	s.handlers[tool.Name] = handler
+}
+
+// Tools returns the declarations to send in CallOptions.Tools
+func (s *ToolSet) Tools() []Tool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Tool(nil), s.tools...)
+}
+
+// Dispatch invokes the handler for call and returns the tool-role message carrying its result.
+// Handler errors are reported to the model as the tool result rather than aborting the turn.
+func (s *ToolSet) Dispatch(ctx context.Context, call ToolCall) Message {
+	s.mu.RLock()
+	handler, ok := s.handlers[call.Name]
+	s.mu.RUnlock()
+
+	result := ""
+	switch {
+	case !ok:
+		result = fmt.Sprintf(`{"error":"unknown tool %q"}`, call.Name)
+	case call.Arguments != "" && !json.Valid([]byte(call.Arguments)):
+		result = `{"error":"arguments are not valid JSON"}`
+	default:
+		args := json.RawMessage(call.Arguments)
+		if len(args) == 0 {
+			args = json.RawMessage("{}")
+		}
+		out, err := handler(ctx, args)
+		if err != nil {
+			errJSON, _ := json.Marshal(map[string]string{"error": err.Error()})
+			result = string(errJSON)
+		} else {
+			result = out
+		}
+	}
+	return Message{Role: "tool", Content: result, ToolCallID: call.ID, Name: call.Name}
+}
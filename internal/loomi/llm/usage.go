@@ -0,0 +1,116 @@
+package llm
+
+import (
+	"context"
+	"sync"
+)
+
+// CallResult collects what clients report about a single call: the provider's token usage and
+// whether the answer was replayed from a cache. The caller attaches a
+// fresh one per call with WithCallResult, so concurrent calls of a session never see each other's
+// usage and nothing is kept once the call is done.
+type CallResult struct {
+	mu         sync.Mutex
+	prompt     int
+	completion int
+	reported   bool
+	cached     bool
+}
+
+// Usage returns the provider-reported usage, summed over every report made during the call
+func (r *CallResult) Usage() (prompt, completion int, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.prompt, r.completion, r.reported
+}
+
+// Cached reports whether the answer was replayed from a cache instead of reaching a provider
+func (r *CallResult) Cached() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cached
+}
+
+type callResultKey struct{}
+
+// WithCallResult attaches res to ctx; clients report the call's usage and cache hits into it
+func WithCallResult(ctx context.Context, res *CallResult) context.Context {
+	return context.WithValue(ctx, callResultKey{}, res)
+}
+
+// CallResultFromContext returns the result set by WithCallResult, or nil
+func CallResultFromContext(ctx context.Context) *CallResult {
+	res, _ := ctx.Value(callResultKey{}).(*CallResult)
+	return res
+}
+
+// ReportUsage adds provider-reported usage to the call's result; a no-op without one
+func ReportUsage(ctx context.Context, prompt, completion int) {
+	res := CallResultFromContext(ctx)
+	if res == nil || prompt+completion <= 0 {
+		return
+	}
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.prompt += prompt
+	res.completion += completion
+	res.reported = true
+}
+
+// ReportCached marks the call's answer as replayed from a cache; a no-op without a result
+func ReportCached(ctx context.Context) {
+	res := CallResultFromContext(ctx)
+	if res == nil {
+		return
+	}
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.cached = true
+}
+
+// UsageProvider is the pre-CallResult way to read token usage: the usage of a session's most recent
+// call. Clients no longer implement it; wrap one with NewUsageRecorder to keep callers working.
+//
+// Deprecated: attach a CallResult with WithCallResult and read it after the call.
+type UsageProvider interface {
+	LastUsage(userID, sessionID string) (prompt int, completion int, ok bool)
+}
+
+// UsageRecorder is a Client decorator implementing UsageProvider on top of CallResult. It keeps
+// one entry per user/session, like the per-client maps it replaces.
+type UsageRecorder struct {
+	inner Client
+
+	mu   sync.Mutex
+	last map[string][2]int
+}
+
+// NewUsageRecorder wraps inner so the usage of each session's last call can be read with LastUsage
+func NewUsageRecorder(inner Client) *UsageRecorder {
+	return &UsageRecorder{inner: inner, last: map[string][2]int{}}
+}
+
+// SafeStreamCall forwards to the wrapped client with a fresh CallResult, records its usage for the
+// session and passes it on to the caller's CallResult, if any
+func (r *UsageRecorder) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []Message, onChunk StreamChunkHandler, opts ...CallOptions) error {
+	res := &CallResult{}
+	err := r.inner.SafeStreamCall(WithCallResult(ctx, res), userID, sessionID, messages, onChunk, opts...)
+	if res.Cached() {
+		ReportCached(ctx)
+	}
+	if prompt, completion, ok := res.Usage(); ok {
+		r.mu.Lock()
+		r.last[userID+":"+sessionID] = [2]int{prompt, completion}
+		r.mu.Unlock()
+		ReportUsage(ctx, prompt, completion)
+	}
+	return err
+}
+
+// LastUsage returns the provider-reported usage of the session's most recent call that reported any
+func (r *UsageRecorder) LastUsage(userID, sessionID string) (int, int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.last[userID+":"+sessionID]
+	return u[0], u[1], ok
+}
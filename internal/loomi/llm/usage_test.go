@@ -0,0 +1,57 @@
+package llm
+
+import (
+	"context"
+	"testing"
+)
+
+// usageClient reports the usage it is given, per session, and marks calls cached when asked
+type usageClient map[string][3]int
+
+func (u usageClient) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []Message, onChunk StreamChunkHandler, opts ...CallOptions) error {
+	usage := u[sessionID]
+	ReportUsage(ctx, usage[0], usage[1])
+	if usage[2] == 1 {
+		ReportCached(ctx)
+	}
+	return onChunk(ctx, "ok")
+}
+
+func TestUsageRecorder(t *testing.T) {
+	inner := usageClient{"s1": {120, 30, 0}, "s2": {0, 0, 0}, "s3": {50, 5, 1}}
+	r := NewUsageRecorder(inner)
+	call := func(ctx context.Context, sessionID string) {
+		t.Helper()
+		if err := r.SafeStreamCall(ctx, "u1", sessionID, nil, func(context.Context, string) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the caller's own CallResult still receives the usage and the cache hit
+	outer := &CallResult{}
+	call(WithCallResult(context.Background(), outer), "s3")
+	if p, c, ok := outer.Usage(); !ok || p != 50 || c != 5 || !outer.Cached() {
+		t.Errorf("outer result = %d/%d/%v cached=%v", p, c, ok, outer.Cached())
+	}
+
+	call(context.Background(), "s1")
+	call(context.Background(), "s1")
+	call(context.Background(), "s2")
+	for _, tc := range []struct {
+		user, session    string
+		prompt, complete int
+		ok               bool
+	}{
+		// the last call replaces the previous one rather than adding to it
+		{"u1", "s1", 120, 30, true},
+		{"u1", "s3", 50, 5, true},
+		{"u1", "s2", 0, 0, false},
+		{"u2", "s1", 0, 0, false},
+	} {
+		var provider UsageProvider = r
+		p, c, ok := provider.LastUsage(tc.user, tc.session)
+		if p != tc.prompt || c != tc.complete || ok != tc.ok {
+			t.Errorf("LastUsage(%s, %s) = %d, %d, %v", tc.user, tc.session, p, c, ok)
+		}
+	}
+}
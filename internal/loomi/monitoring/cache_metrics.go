@@ -0,0 +1,70 @@
+package monitoring
+
+import (
+	"sort"
+	"sync"
+)
+
+// AgentCacheStats 单个智能体的 LLM 响应缓存统计
+type AgentCacheStats struct {
+	Agent   string  `json:"agent"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	Errors  int64   `json:"errors"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// CacheMetrics 按智能体统计 LLM 响应缓存命中率
+type CacheMetrics struct {
+	mu    sync.Mutex
+	stats map[string]*AgentCacheStats
+}
+
+// NewCacheMetrics 创建缓存统计
+func NewCacheMetrics() *CacheMetrics {
+	return &CacheMetrics{stats: make(map[string]*AgentCacheStats)}
+}
+
+// DefaultCacheMetrics 进程级缓存统计，由 llm/cache 写入
+var DefaultCacheMetrics = NewCacheMetrics()
+
+// RecordHit 记录一次命中
+func (m *CacheMetrics) RecordHit(agent string) {
+	m.update(agent, func(s *AgentCacheStats) { s.Hits++ })
+}
+
+// RecordMiss 记录一次未命中
+func (m *CacheMetrics) RecordMiss(agent string) {
+	m.update(agent, func(s *AgentCacheStats) { s.Misses++ })
+}
+
+// RecordError 记录一次缓存读写失败（按未命中处理，不影响调用）
+func (m *CacheMetrics) RecordError(agent string) {
+	m.update(agent, func(s *AgentCacheStats) { s.Errors++ })
+}
+
+func (m *CacheMetrics) update(agent string, fn func(s *AgentCacheStats)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.stats[agent]
+	if !ok {
+		s = &AgentCacheStats{Agent: agent}
+		m.stats[agent] = s
+	}
+	fn(s)
+	if total := s.Hits + s.Misses; total > 0 {
+		s.HitRate = float64(s.Hits) / float64(total)
+	}
+}
+
+// Snapshot 返回按智能体名称排序的统计快照
+func (m *CacheMetrics) Snapshot() []AgentCacheStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]AgentCacheStats, 0, len(m.stats))
+	for _, s := range m.stats {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Agent < out[j].Agent })
+	return out
+}
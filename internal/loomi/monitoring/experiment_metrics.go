@@ -0,0 +1,246 @@
+package monitoring
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// attributionTTL 曝光与笔记的归因保留时长，超过后的选择与修改不再计入实验
+const attributionTTL = 24 * time.Hour
+
+// VariantStats 单个实验分组的转化统计
+type VariantStats struct {
+	Experiment string `json:"experiment"`
+	Variant    string `json:"variant"`
+	Version    string `json:"version"`
+	Exposures  int64  `json:"exposures"`
+	Notes      int64  `json:"notes"`
+	Selected   int64  `json:"selected"`
+	Revisions  int64  `json:"revisions"`
+	// Conversion 为笔记选中率，CILow/CIHigh 为其 95% Wilson 置信区间
+	Conversion   float64 `json:"conversion"`
+	CILow        float64 `json:"ci_low"`
+	CIHigh       float64 `json:"ci_high"`
+	RevisionRate float64 `json:"revision_rate"`
+}
+
+type noteAttribution struct {
+	key      string
+	selected bool
+	at       time.Time
+}
+
+type sessionAttribution struct {
+	keys map[string]bool
+	at   time.Time
+}
+
+// ExperimentStore 持久化各分组的累计计数，使统计跨副本汇总、重启后保留
+type ExperimentStore interface {
+	// Add 把 delta 中的计数累加到 delta.Experiment/delta.Variant 分组，Version 非空时更新版本
+	Add(ctx context.Context, delta VariantStats) error
+	// Load 返回全部分组的累计计数（不含转化率等派生字段）
+	Load(ctx context.Context) ([]VariantStats, error)
+}
+
+// experimentStoreTimeout 单次读写存储的超时，存储不可用时不阻塞请求
+const experimentStoreTimeout = time.Second
+
+// ExperimentMetrics 按实验分组统计提示词 A/B 实验的曝光、笔记选中与修改请求
+type ExperimentMetrics struct {
+	mu        sync.Mutex
+	stats     map[string]*VariantStats
+	notes     map[string]*noteAttribution    // user:session:note -> 分组
+	sessions  map[string]*sessionAttribution // user:session -> 曝光过的分组
+	lastPrune time.Time
+	store     ExperimentStore
+}
+
+// NewExperimentMetrics 创建实验统计
+func NewExperimentMetrics() *ExperimentMetrics {
+	return &ExperimentMetrics{
+		stats:    make(map[string]*VariantStats),
+		notes:    make(map[string]*noteAttribution),
+		sessions: make(map[string]*sessionAttribution),
+	}
+}
+
+// DefaultExperimentMetrics 进程级实验统计，由 base 与选择结果管理器写入
+var DefaultExperimentMetrics = NewExperimentMetrics()
+
+// SetStore 设置计数的持久化存储；设置后计数同时写入存储，Snapshot 读取存储中的累计值。
+// 笔记与会话的归因仍保存在进程内。
+func (m *ExperimentMetrics) SetStore(store ExperimentStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store = store
+}
+
+// RecordExposure 记录一次分组曝光（智能体使用了该分组的提示词）
+func (m *ExperimentMetrics) RecordExposure(userID, sessionID, experiment, variant, version string) {
+	m.mu.Lock()
+	defer m.persist(VariantStats{Experiment: experiment, Variant: variant, Version: version, Exposures: 1})
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.prune(now)
+	s := m.variant(experiment, variant)
+	s.Version = version
+	s.Exposures++
+	sk := userID + ":" + sessionID
+	sa, ok := m.sessions[sk]
+	if !ok {
+		sa = &sessionAttribution{keys: make(map[string]bool)}
+		m.sessions[sk] = sa
+	}
+	sa.keys[statsKey(experiment, variant)] = true
+	sa.at = now
+}
+
+// RecordNote 记录分组生成的一条笔记，selected 为创建时的选中状态（自动选中）
+func (m *ExperimentMetrics) RecordNote(userID, sessionID, noteID, experiment, variant string, selected bool) {
+	delta := VariantStats{Experiment: experiment, Variant: variant, Notes: 1}
+	if selected {
+		delta.Selected = 1
+	}
+	m.mu.Lock()
+	defer m.persist(delta)
+	defer m.mu.Unlock()
+	s := m.variant(experiment, variant)
+	s.Notes++
+	s.Selected += delta.Selected
+	m.notes[userID+":"+sessionID+":"+noteID] = &noteAttribution{key: statsKey(experiment, variant), selected: selected, at: time.Now()}
+}
+
+// RecordSelections 记录用户选中的笔记，同一笔记只计一次
+func (m *ExperimentMetrics) RecordSelections(userID, sessionID string, noteIDs []string) {
+	var deltas []VariantStats
+	m.mu.Lock()
+	for _, id := range noteIDs {
+		na, ok := m.notes[userID+":"+sessionID+":"+id]
+		if !ok || na.selected {
+			continue
+		}
+		na.selected = true
+		if s, ok := m.stats[na.key]; ok {
+			s.Selected++
+			deltas = append(deltas, VariantStats{Experiment: s.Experiment, Variant: s.Variant, Selected: 1})
+		}
+	}
+	m.mu.Unlock()
+	m.persist(deltas...)
+}
+
+// RecordRevision 记录一次修改请求，计入该会话曝光过的所有分组
+func (m *ExperimentMetrics) RecordRevision(userID, sessionID string) {
+	var deltas []VariantStats
+	m.mu.Lock()
+	if sa, ok := m.sessions[userID+":"+sessionID]; ok {
+		for key := range sa.keys {
+			if s, ok := m.stats[key]; ok {
+				s.Revisions++
+				deltas = append(deltas, VariantStats{Experiment: s.Experiment, Variant: s.Variant, Revisions: 1})
+			}
+		}
+	}
+	m.mu.Unlock()
+	m.persist(deltas...)
+}
+
+// Snapshot 返回按实验、分组排序的统计快照；设置了存储时为存储中的累计值，读取失败时退回进程内统计
+func (m *ExperimentMetrics) Snapshot() []VariantStats {
+	m.mu.Lock()
+	store := m.store
+	out := make([]VariantStats, 0, len(m.stats))
+	for _, s := range m.stats {
+		out = append(out, *s)
+	}
+	m.mu.Unlock()
+
+	if store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), experimentStoreTimeout)
+		stored, err := store.Load(ctx)
+		cancel()
+		if err == nil {
+			out = stored
+		}
+	}
+
+	for i := range out {
+		s := &out[i]
+		if s.Notes > 0 {
+			s.Conversion = float64(s.Selected) / float64(s.Notes)
+			s.CILow, s.CIHigh = wilsonInterval(s.Selected, s.Notes)
+		}
+		if s.Exposures > 0 {
+			s.RevisionRate = float64(s.Revisions) / float64(s.Exposures)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Experiment != out[j].Experiment {
+			return out[i].Experiment < out[j].Experiment
+		}
+		return out[i].Variant < out[j].Variant
+	})
+	return out
+}
+
+// persist 把计数增量写入存储；必须在释放 m.mu 后调用，写入失败时只保留进程内统计
+func (m *ExperimentMetrics) persist(deltas ...VariantStats) {
+	m.mu.Lock()
+	store := m.store
+	m.mu.Unlock()
+	if store == nil {
+		return
+	}
+	for _, delta := range deltas {
+		ctx, cancel := context.WithTimeout(context.Background(), experimentStoreTimeout)
+		_ = store.Add(ctx, delta)
+		cancel()
+	}
+}
+
+func (m *ExperimentMetrics) variant(experiment, variant string) *VariantStats {
+	key := statsKey(experiment, variant)
+	s, ok := m.stats[key]
+	if !ok {
+		s = &VariantStats{Experiment: experiment, Variant: variant}
+		m.stats[key] = s
+	}
+	return s
+}
+
+// prune 每 10 分钟清理一次过期归因，避免内存无限增长
+func (m *ExperimentMetrics) prune(now time.Time) {
+	if now.Sub(m.lastPrune) < 10*time.Minute {
+		return
+	}
+	m.lastPrune = now
+	for k, na := range m.notes {
+		if now.Sub(na.at) > attributionTTL {
+			delete(m.notes, k)
+		}
+	}
+	for k, sa := range m.sessions {
+		if now.Sub(sa.at) > attributionTTL {
+			delete(m.sessions, k)
+		}
+	}
+}
+
+func statsKey(experiment, variant string) string {
+	return experiment + "/" + variant
+}
+
+// wilsonInterval 返回二项比例的 95% Wilson 置信区间
+func wilsonInterval(successes, total int64) (float64, float64) {
+	const z = 1.96
+	n := float64(total)
+	p := float64(successes) / n
+	denom := 1 + z*z/n
+	center := (p + z*z/(2*n)) / denom
+	half := z * math.Sqrt(p*(1-p)/n+z*z/(4*n*n)) / denom
+	return math.Max(0, center-half), math.Min(1, center+half)
+}
@@ -0,0 +1,82 @@
+package monitoring
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// memExperimentStore stands in for Redis: one set of counters shared by every replica
+type memExperimentStore struct {
+	mu    sync.Mutex
+	stats map[string]*VariantStats
+	err   error
+}
+
+func (s *memExperimentStore) Add(ctx context.Context, delta VariantStats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := statsKey(delta.Experiment, delta.Variant)
+	v, ok := s.stats[key]
+	if !ok {
+		v = &VariantStats{Experiment: delta.Experiment, Variant: delta.Variant}
+		s.stats[key] = v
+	}
+	if delta.Version != "" {
+		v.Version = delta.Version
+	}
+	v.Exposures += delta.Exposures
+	v.Notes += delta.Notes
+	v.Selected += delta.Selected
+	v.Revisions += delta.Revisions
+	return nil
+}
+
+func (s *memExperimentStore) Load(ctx context.Context) ([]VariantStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	out := make([]VariantStats, 0, len(s.stats))
+	for _, v := range s.stats {
+		out = append(out, *v)
+	}
+	return out, nil
+}
+
+func TestExperimentMetricsAddUpAcrossReplicas(t *testing.T) {
+	store := &memExperimentStore{stats: make(map[string]*VariantStats)}
+	a, b := NewExperimentMetrics(), NewExperimentMetrics()
+	a.SetStore(store)
+	b.SetStore(store)
+
+	a.RecordExposure("u1", "s1", "hook", "control", "v2")
+	a.RecordNote("u1", "s1", "xhs_post1", "hook", "control", false)
+	a.RecordSelections("u1", "s1", []string{"xhs_post1"})
+	b.RecordExposure("u2", "s2", "hook", "control", "v2")
+	b.RecordNote("u2", "s2", "xhs_post1", "hook", "control", true)
+	b.RecordRevision("u2", "s2")
+
+	for name, m := range map[string]*ExperimentMetrics{"a": a, "b": b, "restarted": func() *ExperimentMetrics {
+		m := NewExperimentMetrics()
+		m.SetStore(store)
+		return m
+	}()} {
+		got := m.Snapshot()
+		if len(got) != 1 {
+			t.Fatalf("%s: snapshot %+v", name, got)
+		}
+		s := got[0]
+		if s.Version != "v2" || s.Exposures != 2 || s.Notes != 2 || s.Selected != 2 || s.Revisions != 1 || s.Conversion != 1 || s.RevisionRate != 0.5 {
+			t.Errorf("%s: stats %+v", name, s)
+		}
+	}
+
+	// an unreachable store falls back to the replica's own counts
+	store.err = errors.New("redis down")
+	if got := b.Snapshot(); len(got) != 1 || got[0].Exposures != 1 || got[0].Revisions != 1 {
+		t.Errorf("fallback snapshot %+v", got)
+	}
+}
@@ -20,8 +20,14 @@ type Monitor struct {
 	alertChan chan Alert
 	stopChan  chan struct{}
 	logger    Logger
+
+	// 额外注册的服务检查（如 LLM provider 熔断状态）
+	serviceChecks map[string]ServiceCheck
 }
 
+// ServiceCheck 自定义服务健康检查
+type ServiceCheck func(ctx context.Context) ServiceInfo
+
 // MonitorConfig 监控配置
 type MonitorConfig struct {
 	Port             int             `json:"port"`
@@ -92,6 +98,8 @@ type ServiceInfo struct {
 	LastChecked  time.Time `json:"last_checked"`
 	ResponseTime int64     `json:"response_time"`
 	Error        string    `json:"error,omitempty"`
+	// Details 服务自定义的明细信息（如各 LLM provider 的熔断状态）
+	Details interface{} `json:"details,omitempty"`
 }
 
 // Logger 日志接口
@@ -105,25 +113,38 @@ type Logger interface {
 // NewMonitor 创建新的监控器
 func NewMonitor(config *MonitorConfig, logger Logger) *Monitor {
 	return &Monitor{
-		config:    config,
-		alerts:    make([]Alert, 0),
-		health:    &HealthStatus{},
-		alertChan: make(chan Alert, 100),
-		stopChan:  make(chan struct{}),
-		logger:    logger,
+		config:        config,
+		alerts:        make([]Alert, 0),
+		health:        &HealthStatus{},
+		alertChan:     make(chan Alert, 100),
+		stopChan:      make(chan struct{}),
+		logger:        logger,
+		serviceChecks: make(map[string]ServiceCheck),
 	}
 }
 
+// RegisterServiceCheck 注册自定义服务检查，结果出现在健康状态的 services[name] 中
+func (m *Monitor) RegisterServiceCheck(name string, check ServiceCheck) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.serviceChecks[name] = check
+}
+
 // Start 启动监控器
 func (m *Monitor) Start(ctx context.Context) error {
+	m.StartChecks(ctx)
+
+	// 启动HTTP服务器
+	return m.startHTTPServer(ctx)
+}
+
+// StartChecks 只启动健康检查与告警处理循环，不启动独立的 HTTP 服务（由 API 路由的 /monitor 提供查询）
+func (m *Monitor) StartChecks(ctx context.Context) {
 	// 启动健康检查循环
 	go m.healthCheckLoop(ctx)
 
 	// 启动告警处理循环
 	go m.alertProcessingLoop(ctx)
-
-	// 启动HTTP服务器
-	return m.startHTTPServer(ctx)
 }
 
 // Stop 停止监控器
@@ -171,6 +192,11 @@ func (m *Monitor) performHealthCheck(ctx context.Context) {
 	apiInfo := m.checkAPI(ctx)
 	services["api"] = apiInfo
 
+	// 执行注册的自定义检查
+	for name, check := range m.serviceChecks {
+		services[name] = check(ctx)
+	}
+
 	// 更新健康状态
 	m.health = &HealthStatus{
 		Status:      m.determineOverallStatus(services),
@@ -269,12 +295,18 @@ func (m *Monitor) checkAPI(ctx context.Context) ServiceInfo {
 
 // determineOverallStatus 确定整体状态
 func (m *Monitor) determineOverallStatus(services map[string]ServiceInfo) string {
+	status := "healthy"
 	for _, service := range services {
-		if service.Status != "healthy" {
+		switch service.Status {
+		case "healthy":
+		case "degraded":
+			// 部分降级（如某个 LLM provider 熔断但仍有备用）不影响整体可用
+			status = "degraded"
+		default:
 			return "unhealthy"
 		}
 	}
-	return "healthy"
+	return status
 }
 
 // checkAlertConditions 检查告警条件
@@ -445,7 +477,7 @@ func (m *Monitor) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	if health.Status == "healthy" {
+	if health.Status == "healthy" || health.Status == "degraded" {
 		w.WriteHeader(http.StatusOK)
 	} else {
 		w.WriteHeader(http.StatusServiceUnavailable)
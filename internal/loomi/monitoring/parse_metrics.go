@@ -0,0 +1,76 @@
+package monitoring
+
+import (
+	"sort"
+	"sync"
+)
+
+// ParseOutcome 智能体输出解析结果
+type ParseOutcome string
+
+const (
+	ParseXML      ParseOutcome = "xml"      // XML 标签直接解析成功
+	ParseJSON     ParseOutcome = "json"     // 结构化 JSON 一次校验通过
+	ParseRepaired ParseOutcome = "repaired" // 修复调用后校验通过
+	ParseFailed   ParseOutcome = "failed"   // 全部失败，回退为原始文本
+)
+
+// AgentParseStats 单个智能体的解析统计
+type AgentParseStats struct {
+	Agent       string  `json:"agent"`
+	Total       int64   `json:"total"`
+	XML         int64   `json:"xml"`
+	JSON        int64   `json:"json"`
+	Repaired    int64   `json:"repaired"`
+	Failed      int64   `json:"failed"`
+	SuccessRate float64 `json:"success_rate"`
+}
+
+// ParseMetrics 按智能体统计输出解析成功率
+type ParseMetrics struct {
+	mu    sync.Mutex
+	stats map[string]*AgentParseStats
+}
+
+// NewParseMetrics 创建解析统计
+func NewParseMetrics() *ParseMetrics {
+	return &ParseMetrics{stats: make(map[string]*AgentParseStats)}
+}
+
+// DefaultParseMetrics 进程级解析统计，由智能体基类写入
+var DefaultParseMetrics = NewParseMetrics()
+
+// Record 记录一次解析结果
+func (p *ParseMetrics) Record(agent string, outcome ParseOutcome) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.stats[agent]
+	if !ok {
+		s = &AgentParseStats{Agent: agent}
+		p.stats[agent] = s
+	}
+	s.Total++
+	switch outcome {
+	case ParseXML:
+		s.XML++
+	case ParseJSON:
+		s.JSON++
+	case ParseRepaired:
+		s.Repaired++
+	default:
+		s.Failed++
+	}
+	s.SuccessRate = float64(s.Total-s.Failed) / float64(s.Total)
+}
+
+// Snapshot 返回按智能体名称排序的统计快照
+func (p *ParseMetrics) Snapshot() []AgentParseStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]AgentParseStats, 0, len(p.stats))
+	for _, s := range p.stats {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Agent < out[j].Agent })
+	return out
+}
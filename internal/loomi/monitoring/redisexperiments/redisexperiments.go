@@ -0,0 +1,106 @@
+package redisexperiments
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/blueplan/loomi-go/internal/loomi/monitoring"
+	"github.com/blueplan/loomi-go/internal/loomi/pool"
+	"github.com/redis/go-redis/v9"
+)
+
+// KeyPrefix namespaces the experiment counters in Redis: one hash per variant plus an index set
+const KeyPrefix = "loomi:experiments:"
+
+const indexKey = KeyPrefix + "index"
+
+// Store implements monitoring.ExperimentStore with one hash of counters per experiment variant,
+// so conversion stats add up across replicas and survive restarts
+type Store struct {
+	pools    pool.RedisPools
+	poolType string
+}
+
+// New creates an experiment store on the given pool type ("normal" when empty)
+func New(pools pool.RedisPools, poolType string) *Store {
+	if poolType == "" {
+		poolType = "normal"
+	}
+	return &Store{pools: pools, poolType: poolType}
+}
+
+// Add increments the variant's counters by delta
+func (s *Store) Add(ctx context.Context, delta monitoring.VariantStats) error {
+	client, err := s.pools.GetRedisClient(ctx, s.poolType)
+	if err != nil {
+		return err
+	}
+	key := variantKey(delta.Experiment, delta.Variant)
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, indexKey, key)
+		pipe.HSet(ctx, key, "experiment", delta.Experiment, "variant", delta.Variant)
+		if delta.Version != "" {
+			pipe.HSet(ctx, key, "version", delta.Version)
+		}
+		for field, n := range map[string]int64{
+			"exposures": delta.Exposures,
+			"notes":     delta.Notes,
+			"selected":  delta.Selected,
+			"revisions": delta.Revisions,
+		} {
+			if n != 0 {
+				pipe.HIncrBy(ctx, key, field, n)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// Load returns the counters of every variant recorded so far
+func (s *Store) Load(ctx context.Context) ([]monitoring.VariantStats, error) {
+	client, err := s.pools.GetRedisClient(ctx, s.poolType)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := client.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	cmds := make([]*redis.MapStringStringCmd, len(keys))
+	if _, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.HGetAll(ctx, key)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	out := make([]monitoring.VariantStats, 0, len(keys))
+	for _, cmd := range cmds {
+		h := cmd.Val()
+		if h["experiment"] == "" {
+			continue
+		}
+		out = append(out, monitoring.VariantStats{
+			Experiment: h["experiment"],
+			Variant:    h["variant"],
+			Version:    h["version"],
+			Exposures:  count(h["exposures"]),
+			Notes:      count(h["notes"]),
+			Selected:   count(h["selected"]),
+			Revisions:  count(h["revisions"]),
+		})
+	}
+	return out, nil
+}
+
+func variantKey(experiment, variant string) string {
+	return KeyPrefix + experiment + "/" + variant
+}
+
+func count(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
@@ -1,7 +1,10 @@
 package notes
 
+import "sync"
+
 // InmemService provides an in-memory implementation of the notes service
 type InmemService struct {
+	mu    sync.RWMutex
 	notes map[string][]Note
 }
 
@@ -12,7 +15,7 @@ func NewInmem() Service {
 	}
 }
 
-func (s *InmemService) Create(userID, sessionID, action, name, title, content string, selectFlag int) error {
+func (s *InmemService) Create(userID, sessionID, action, name, title, content, hook string, selectFlag int, prompt PromptInfo) error {
 	key := userID + ":" + sessionID
 	note := Note{
 		ID:         name,
@@ -22,14 +25,20 @@ func (s *InmemService) Create(userID, sessionID, action, name, title, content st
 		Name:       name,
 		Title:      title,
 		Content:    content,
+		Hook:       hook,
 		SelectFlag: selectFlag,
+		Prompt:     prompt,
 	}
+	s.mu.Lock()
 	s.notes[key] = append(s.notes[key], note)
+	s.mu.Unlock()
 	return nil
 }
 
 func (s *InmemService) GetByAction(userID, sessionID, action string) ([]Note, error) {
 	key := userID + ":" + sessionID
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	allNotes := s.notes[key]
 	var result []Note
 	for _, note := range allNotes {
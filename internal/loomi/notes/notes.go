@@ -8,10 +8,20 @@ type Note struct {
 	Name       string
 	Title      string
 	Content    string
+	Hook       string
 	SelectFlag int
+	// Prompt is the system prompt the note was generated with
+	Prompt PromptInfo
+}
+
+// PromptInfo identifies a prompt version and, under an A/B experiment, the variant that served it
+type PromptInfo struct {
+	Version    string `json:"version"`
+	Experiment string `json:"experiment,omitempty"`
+	Variant    string `json:"variant,omitempty"`
 }
 
 type Service interface {
-	Create(userID, sessionID, action, name, title, content string, selectFlag int) error
+	Create(userID, sessionID, action, name, title, content, hook string, selectFlag int, prompt PromptInfo) error
 	GetByAction(userID, sessionID, action string) ([]Note, error)
 }
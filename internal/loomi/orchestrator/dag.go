@@ -0,0 +1,221 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/blueplan/loomi-go/internal/loomi/base"
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+)
+
+// 前置动作失败时依赖动作的处理方式
+const (
+	onFailureSkip    = "skip"
+	onFailureDegrade = "degrade"
+)
+
+// 动作节点状态，通过 action_status 事件推送
+const (
+	nodeRunning   = "running"
+	nodeSucceeded = "succeeded"
+	nodeFailed    = "failed"
+	nodeSkipped   = "skipped"
+)
+
+// planWaves 按 depends_on 对动作做拓扑分层，同一层的动作互不依赖、可并发执行；
+// ID 重复（包括自动编号与显式 id 冲突）、依赖不存在或存在环时拒绝整个计划
+func planWaves(items []actionItem) ([][]int, error) {
+	index := make(map[string]int, len(items))
+	for i, it := range items {
+		if j, dup := index[it.ID]; dup {
+			if it.AutoID || items[j].AutoID {
+				return nil, fmt.Errorf("动作 ID 冲突: 未写 id 的动作自动编号为 %s，与另一动作显式写的 id 相同，请为每个动作写上不重复的 id", it.ID)
+			}
+			return nil, fmt.Errorf("动作 ID 重复: %s", it.ID)
+		}
+		index[it.ID] = i
+	}
+	indegree := make([]int, len(items))
+	dependents := make([][]int, len(items))
+	for i, it := range items {
+		for _, dep := range it.DependsOn {
+			j, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("动作 %s 依赖的 %s 不存在", it.ID, dep)
+			}
+			indegree[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	var waves [][]int
+	var wave []int
+	for i := range items {
+		if indegree[i] == 0 {
+			wave = append(wave, i)
+		}
+	}
+	planned := 0
+	for len(wave) > 0 {
+		waves = append(waves, wave)
+		planned += len(wave)
+		var next []int
+		for _, i := range wave {
+			for _, d := range dependents[i] {
+				if indegree[d]--; indegree[d] == 0 {
+					next = append(next, d)
+				}
+			}
+		}
+		wave = next
+	}
+	if planned < len(items) {
+		var cyclic []string
+		for i, it := range items {
+			if indegree[i] > 0 {
+				cyclic = append(cyclic, it.ID)
+			}
+		}
+		return nil, fmt.Errorf("动作依赖存在环: %s", strings.Join(cyclic, ", "))
+	}
+	return waves, nil
+}
+
+// executeDAG 按拓扑层执行动作：每层在并发上限内并发执行，前置动作的笔记注入依赖动作的指令；
+// 前置动作失败时依赖动作按 on_failure 跳过（默认）或降级执行。返回各动作的输出摘要（顺序与 items 一致）
+func (o *Orchestrator) executeDAG(ctx context.Context, items []actionItem, waves [][]int, req Request, emit func(ev events.StreamEvent) error) []*base.ActionOutcome {
+	if len(items) == 0 {
+		return nil
+	}
+	// 单次调用内的并发上限之外，全局 llm.Governor 按 provider 排队，排队时提示用户
+	ctx = base.WithSystemNotices(ctx, emit)
+	index := make(map[string]int, len(items))
+	for i, it := range items {
+		index[it.ID] = i
+	}
+	outcomes := make([]*base.ActionOutcome, len(items))
+	sem := make(chan struct{}, o.maxConcurrent)
+	active := 0
+	peak := 0
+	mu := sync.Mutex{}
+
+	for w, wave := range waves {
+		var wg sync.WaitGroup
+		for _, i := range wave {
+			it := items[i]
+			deps := make([]*base.ActionOutcome, 0, len(it.DependsOn))
+			var failed []string
+			for _, dep := range it.DependsOn {
+				upstream := outcomes[index[dep]]
+				deps = append(deps, upstream)
+				if upstream.Err != nil {
+					failed = append(failed, dep)
+				}
+			}
+
+			observed, outcome := base.ObserveAction(it.ActionType, it.Instruction, emit)
+			outcome.ID = it.ID
+			outcomes[i] = outcome
+			// 预算用尽后剩余动作不再启动
+			if err := base.CheckBudget(ctx, o.tokenAcc, req.UserID, req.SessionID); err != nil {
+				outcome.Skipped = true
+				outcome.Err = err
+				o.emitNodeStatus(emit, it, w, nodeSkipped, map[string]any{"error": err.Error(), "reason": base.StopBudgetExceeded})
+				continue
+			}
+			if len(failed) > 0 && it.OnFailure != onFailureDegrade {
+				outcome.Skipped = true
+				outcome.Err = fmt.Errorf("前置动作 %s 失败", strings.Join(failed, ", "))
+				o.emitNodeStatus(emit, it, w, nodeSkipped, map[string]any{"error": outcome.Err.Error()})
+				continue
+			}
+			o.emitNodeStatus(emit, it, w, nodeRunning, map[string]any{"degraded": len(failed) > 0})
+
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(it actionItem, deps []*base.ActionOutcome) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				mu.Lock()
+				active++
+				if active > peak {
+					peak = active
+				}
+				mu.Unlock()
+				defer func() {
+					mu.Lock()
+					active--
+					mu.Unlock()
+				}()
+				outcome.Err = o.runAction(ctx, it, deps, req, observed)
+				if outcome.Err != nil {
+					o.logger.Error(ctx, "orchestrator.action.error", logx.KV("id", it.ID), logx.KV("action", it.ActionType), logx.KV("error", outcome.Err))
+					o.emitNodeStatus(emit, it, w, nodeFailed, map[string]any{"error": outcome.Err.Error()})
+					return
+				}
+				o.emitNodeStatus(emit, it, w, nodeSucceeded, nil)
+			}(it, deps)
+		}
+		wg.Wait()
+	}
+
+	// 记录峰值
+	o.statsMu.Lock()
+	o.concurrentPeaks = append(o.concurrentPeaks, peak)
+	o.statsMu.Unlock()
+	return outcomes
+}
+
+// runAction 执行单个动作，指令后附上前置动作的笔记
+func (o *Orchestrator) runAction(ctx context.Context, it actionItem, deps []*base.ActionOutcome, req Request, emit func(ev events.StreamEvent) error) error {
+	ag := o.createAgent(it.ActionType)
+	if ag == nil {
+		return fmt.Errorf("unknown action: %s", it.ActionType)
+	}
+	base.RunFrom(ctx).CountAction()
+	aReq := types.AgentRequest{
+		Instruction: withUpstreamNotes(it.Instruction, deps),
+		UserID:      req.UserID,
+		SessionID:   req.SessionID,
+		UseFiles:    false,
+		AutoMode:    req.AutoMode,
+		Selections:  req.Selections,
+	}
+	return ag.ProcessRequest(ctx, aReq, emit)
+}
+
+// withUpstreamNotes 把前置动作产出的笔记（或失败原因）拼接到指令之后
+func withUpstreamNotes(instruction string, deps []*base.ActionOutcome) string {
+	if len(deps) == 0 {
+		return instruction
+	}
+	var b strings.Builder
+	b.WriteString(instruction)
+	for _, dep := range deps {
+		if dep.Err != nil {
+			fmt.Fprintf(&b, "\n\n[前置动作 %s（%s）未完成：%s]", dep.ID, dep.Action, dep.Err.Error())
+			continue
+		}
+		fmt.Fprintf(&b, "\n\n[前置动作 %s（%s）的笔记]\n%s", dep.ID, dep.Action, dep.Notes())
+	}
+	return b.String()
+}
+
+// emitNodeStatus 推送动作节点状态
+func (o *Orchestrator) emitNodeStatus(emit func(ev events.StreamEvent) error, it actionItem, wave int, status string, extra map[string]any) {
+	data := map[string]any{
+		"id":         it.ID,
+		"action":     it.ActionType,
+		"status":     status,
+		"wave":       wave + 1,
+		"depends_on": it.DependsOn,
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	_ = emit(events.StreamEvent{Type: events.LLMChunk, Content: events.ContentActionStatus, Data: data})
+}
@@ -0,0 +1,168 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/blueplan/loomi-go/internal/loomi/base"
+	"github.com/blueplan/loomi-go/internal/loomi/events"
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+	"github.com/blueplan/loomi-go/internal/loomi/types"
+)
+
+func TestPlanWaves(t *testing.T) {
+	o := &Orchestrator{}
+	waves, err := planWaves(o.parseActions(`<Action type="websearch">搜索</Action>` +
+		`<Action type="persona" id="p" depends_on="a1">画像</Action>` +
+		`<Action type="knowledge" id="k">知识</Action>` +
+		`<Action type="hitpoint" id="h" depends_on="p, k">打点</Action>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]int{{0, 2}, {1}, {3}}; !reflect.DeepEqual(waves, want) {
+		t.Errorf("waves = %v, want %v", waves, want)
+	}
+
+	for name, tc := range map[string]struct {
+		plan string
+		want string
+	}{
+		"cycle": {
+			`<Action type="websearch" id="s">搜索</Action><Action type="persona" id="p" depends_on="h">画像</Action><Action type="hitpoint" id="h" depends_on="p">打点</Action>`,
+			"动作依赖存在环: p, h",
+		},
+		"self dependency": {
+			`<Action type="persona" id="p" depends_on="p">画像</Action>`,
+			"动作依赖存在环: p",
+		},
+		"missing dependency": {
+			`<Action type="persona" id="p" depends_on="s">画像</Action>`,
+			"动作 p 依赖的 s 不存在",
+		},
+		"duplicate id": {
+			`<Action type="persona" id="p">画像</Action><Action type="hitpoint" id="p">打点</Action>`,
+			"动作 ID 重复: p",
+		},
+		"explicit id taken by a later auto id": {
+			`<Action type="hitpoint" id="a2">找切入点</Action><Action type="xhs_post">写笔记</Action>`,
+			"动作 ID 冲突",
+		},
+		"auto id taken by a later explicit id": {
+			`<Action type="hitpoint">找切入点</Action><Action type="xhs_post" id="a1" depends_on="a1">写笔记</Action>`,
+			"动作 ID 冲突",
+		},
+	} {
+		if _, err := planWaves(o.parseActions(tc.plan)); err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", name, err, tc.want)
+		}
+	}
+}
+
+// dagAgent answers with "<instruction>的产出", or fails when fail is set; it keeps every
+// instruction it received keyed by the first line
+type dagAgent struct {
+	fail bool
+
+	mu  sync.Mutex
+	got map[string]string
+}
+
+func (a *dagAgent) ProcessRequest(ctx context.Context, req types.AgentRequest, emit func(ev events.StreamEvent) error) error {
+	task, _, _ := strings.Cut(req.Instruction, "\n")
+	a.mu.Lock()
+	a.got[task] = req.Instruction
+	a.mu.Unlock()
+	if a.fail {
+		return errors.New(task + "失败")
+	}
+	return emit(events.StreamEvent{Type: events.LLMChunk, Content: events.ContentLoomiHitpoint, Data: task + "的产出"})
+}
+
+func registerDAGAgent(action string, agent *dagAgent) {
+	base.RegisterAgent(base.AgentSpec{Action: action, AgentName: "dag_test_" + action, New: func(*logx.Logger, llm.Client) types.Agent { return agent }})
+}
+
+func TestExecuteDAG(t *testing.T) {
+	ok := &dagAgent{got: map[string]string{}}
+	broken := &dagAgent{fail: true, got: map[string]string{}}
+	registerDAGAgent("dag_test_ok", ok)
+	registerDAGAgent("dag_test_broken", broken)
+
+	o := New(logx.NewLogger(t.TempDir()), nil)
+	items := o.parseActions(`<Action type="dag_test_ok" id="search">搜索</Action>` +
+		`<Action type="dag_test_broken" id="persona">画像</Action>` +
+		`<Action type="dag_test_ok" id="hitpoint" depends_on="search">打点</Action>` +
+		`<Action type="dag_test_ok" id="post" depends_on="persona">写笔记</Action>` +
+		`<Action type="dag_test_ok" id="script" depends_on="search,persona" on_failure="degrade">写脚本</Action>` +
+		`<Action type="dag_test_ok" id="cover" depends_on="post" on_failure="degrade">做封面</Action>`)
+	waves, err := planWaves(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	statuses := map[string][]string{}
+	var degraded []string
+	emit := func(ev events.StreamEvent) error {
+		if ev.Content != events.ContentActionStatus {
+			return nil
+		}
+		data := ev.Data.(map[string]any)
+		id, status := data["id"].(string), data["status"].(string)
+		mu.Lock()
+		defer mu.Unlock()
+		statuses[id] = append(statuses[id], status)
+		if d, _ := data["degraded"].(bool); d {
+			degraded = append(degraded, id)
+		}
+		return nil
+	}
+	outcomes := o.executeDAG(context.Background(), items, waves, Request{UserID: "u1", SessionID: "s1"}, emit)
+
+	want := map[string][]string{
+		"search":   {nodeRunning, nodeSucceeded},
+		"persona":  {nodeRunning, nodeFailed},
+		"hitpoint": {nodeRunning, nodeSucceeded},
+		"post":     {nodeSkipped},
+		"script":   {nodeRunning, nodeSucceeded},
+		// a skipped upstream counts as failed, so a degrading dependent still runs
+		"cover": {nodeRunning, nodeSucceeded},
+	}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("action_status = %v, want %v", statuses, want)
+	}
+	if !reflect.DeepEqual(degraded, []string{"script", "cover"}) && !reflect.DeepEqual(degraded, []string{"cover", "script"}) {
+		t.Errorf("degraded = %v, want script and cover", degraded)
+	}
+
+	byID := map[string]*base.ActionOutcome{}
+	for _, outcome := range outcomes {
+		byID[outcome.ID] = outcome
+	}
+	if post := byID["post"]; !post.Skipped || post.Err == nil || !strings.Contains(post.Err.Error(), "persona") {
+		t.Errorf("post outcome = %+v, want skipped because persona failed", post)
+	}
+	if byID["hitpoint"].Notes() != "打点的产出" {
+		t.Errorf("hitpoint notes = %q", byID["hitpoint"].Notes())
+	}
+
+	// upstream notes, or the upstream failure, are appended to the dependent's instruction
+	if got := ok.got["打点"]; got != "打点\n\n[前置动作 search（dag_test_ok）的笔记]\n搜索的产出" {
+		t.Errorf("hitpoint instruction = %q", got)
+	}
+	script := ok.got["写脚本"]
+	if !strings.Contains(script, "[前置动作 search（dag_test_ok）的笔记]\n搜索的产出") || !strings.Contains(script, "[前置动作 persona（dag_test_broken）未完成：画像失败]") {
+		t.Errorf("script instruction = %q", script)
+	}
+	if _, ran := ok.got["写笔记"]; ran {
+		t.Error("post ran although persona failed")
+	}
+	if got := ok.got["搜索"]; got != "搜索" {
+		t.Errorf("search instruction = %q, want no upstream notes", got)
+	}
+}
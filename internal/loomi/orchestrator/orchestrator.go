@@ -2,12 +2,17 @@ package orchestrator
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
 	"regexp"
+	"strings"
 
-	"github.com/blueplan/loomi-go/internal/loomi/agents"
+	// 内置智能体在 agents 包的 init 中注册
+	_ "github.com/blueplan/loomi-go/internal/loomi/agents"
+	"github.com/blueplan/loomi-go/internal/loomi/base"
 	contextx "github.com/blueplan/loomi-go/internal/loomi/context"
 	"github.com/blueplan/loomi-go/internal/loomi/database"
 	"github.com/blueplan/loomi-go/internal/loomi/events"
@@ -17,6 +22,7 @@ import (
 	"github.com/blueplan/loomi-go/internal/loomi/pool"
 	stopx "github.com/blueplan/loomi-go/internal/loomi/stop"
 	"github.com/blueplan/loomi-go/internal/loomi/tokens"
+	"github.com/blueplan/loomi-go/internal/loomi/tokens/tokenizer"
 	"github.com/blueplan/loomi-go/internal/loomi/types"
 	"github.com/blueplan/loomi-go/internal/loomi/utils"
 )
@@ -26,8 +32,10 @@ type Orchestrator struct {
 	llm            llm.Client
 	maxConcurrent  int
 	outputInterval time.Duration
-	lastOutputTime time.Time
-	outputMu       sync.Mutex
+	maxIterations  int
+	tokenBudget    int
+	// 子智能体跨请求共享，请求状态保存在 base.Run 上
+	agents *base.AgentPool
 
 	// deps
 	ctxMgr   contextx.Manager
@@ -39,6 +47,7 @@ type Orchestrator struct {
 	queue    *utils.LayeredQueue
 
 	// stats
+	statsMu         sync.Mutex
 	concurrentPeaks []int
 }
 
@@ -48,10 +57,14 @@ type Request struct {
 	SessionID  string
 	AutoMode   bool
 	Selections []string
+	// UserPlan 选择用户套餐的预算，Budget 只能在其基础上收紧
+	UserPlan string
+	Budget   *types.Budget
 }
 
 func New(logger *logx.Logger, client llm.Client) *Orchestrator {
-	return &Orchestrator{logger: logger, llm: client, maxConcurrent: 8, outputInterval: 10 * time.Second}
+	maxIterations, tokenBudget := base.ReActLimits()
+	return &Orchestrator{logger: logger, llm: client, maxConcurrent: 8, outputInterval: 10 * time.Second, maxIterations: maxIterations, tokenBudget: tokenBudget, agents: base.NewAgentPool(logger, client)}
 }
 
 func (o *Orchestrator) WithDeps(ctxMgr contextx.Manager, notesSvc notes.Service, stopMgr stopx.Manager, poolMgr pool.Manager, tokenAcc tokens.Accumulator) *Orchestrator {
@@ -70,7 +83,11 @@ func (o *Orchestrator) WithPersistence(p *database.PersistenceManager) *Orchestr
 
 func (o *Orchestrator) WithQueue(q *utils.LayeredQueue) *Orchestrator { o.queue = q; return o }
 
+// Process 执行 observe→think→act 循环：每轮决策都带上前几轮动作输出的摘要，
+// 直到出现结束标签、本轮没有动作、达到轮数上限、超出 token 预算或请求预算用尽
 func (o *Orchestrator) Process(ctx context.Context, req Request, emit func(ev events.StreamEvent) error) error {
+	ctx, run := base.NewRun(ctx, "orchestrator", types.AgentRequest{UserID: req.UserID, SessionID: req.SessionID, AutoMode: req.AutoMode, Selections: req.Selections, UserPlan: req.UserPlan, Budget: req.Budget}, emit)
+	defer run.End()
 	o.logger.Info(ctx, "orchestrator.start")
 	defer o.logger.Info(ctx, "orchestrator.end")
 
@@ -88,41 +105,81 @@ func (o *Orchestrator) Process(ctx context.Context, req Request, emit func(ev ev
 		}
 	}
 
-	// 占位：第一轮决策（与 Python 一致，单次 LLM 决策 + 并发 action）
-	messages := []llm.Message{{Role: "system", Content: "orchestrator system"}, {Role: "user", Content: req.Query}}
-	buf := ""
-	onChunk := func(ctx context.Context, chunk string) error {
-		buf += chunk
-		// 思考片段按策略转发（节流）
-		if o.shouldEmit() {
-			_ = emit(events.StreamEvent{Type: events.LLMChunk, Content: events.ContentThought, Data: chunk})
-		}
-		return nil
-	}
-	if err := o.llm.SafeStreamCall(ctx, req.UserID, req.SessionID, messages, onChunk); err != nil {
-		return err
-	}
-	_ = time.Now() // 预留统计点
-	// 同步保存一次上下文（与 Python 行为一致：在决策后持久化上下文摘要）
-	if o.persist != nil {
-		_ = o.persist.SaveContext(ctx, req.UserID, req.SessionID, map[string]any{"orchestrator_buf": buf})
-	}
-
 	// 将任务入队（LayeredQueue 集成点）
 	if o.queue != nil {
 		_ = o.queue.Push(ctx, "orchestrator", req.SessionID)
 		_ = o.queue.MarkProcessing(ctx, "orchestrator", req.SessionID)
 	}
 
-	// 占位：根据 buf 解析 Observe/Think/Actions，并并发执行子 agent，再合并结果
-	// 后续将完整实现：文件上下文、notes 更新、连接池预热、并发信号量、计费摘要、停止/恢复
+	// 后续将完整实现：文件上下文、notes 更新、计费摘要、恢复
+	messages := []llm.Message{{Role: "system", Content: "orchestrator system"}, {Role: "user", Content: req.Query}}
+	iteration, stopReason := 0, base.StopMaxIterations
+	for iteration < o.maxIterations {
+		iteration++
+		_ = emit(base.IterationEvent(iteration, o.maxIterations, "thinking", nil))
 
-	actions := o.parseActions(buf)
-	if len(actions) > 0 {
-		if err := o.executeParallel(ctx, actions, req, emit); err != nil {
+		buf, err := o.think(ctx, req, messages, emit)
+		if errors.Is(err, base.ErrBudgetExceeded) {
+			// 预算用尽：billing_summary 已说明原因，按正常停止结束本次运行
+			stopReason = base.StopBudgetExceeded
+			break
+		}
+		if err != nil {
 			return err
 		}
+		// 同步保存一次上下文（与 Python 行为一致：在决策后持久化上下文摘要）
+		if o.persist != nil {
+			_ = o.persist.SaveContext(ctx, req.UserID, req.SessionID, map[string]any{"orchestrator_buf": buf})
+		}
+
+		// 按依赖分层执行本轮动作，失败的动作作为观察结果交给下一轮决策；无效计划退回模型重新规划
+		items := o.parseActions(buf)
+		waves, err := planWaves(items)
+		if err != nil {
+			o.logger.Warn(ctx, "orchestrator.plan.rejected", logx.KV("iteration", iteration), logx.KV("error", err))
+			_ = emit(base.IterationEvent(iteration, o.maxIterations, "rejected", map[string]any{"error": err.Error()}))
+			messages = append(messages,
+				llm.Message{Role: "assistant", Content: buf},
+				llm.Message{Role: "user", Content: fmt.Sprintf("[第 %d 轮计划无效] %s\n请修正动作的 id 与 depends_on 后重新规划。", iteration, err)},
+			)
+			continue
+		}
+		outcomes := o.executeDAG(ctx, items, waves, req, emit)
+		records := make([]map[string]any, 0, len(outcomes))
+		for _, outcome := range outcomes {
+			records = append(records, outcome.Record())
+		}
+		if o.ctxMgr != nil {
+			_ = o.ctxMgr.UpdateOrchestratorCallResponse(req.UserID, req.SessionID, iteration-1, buf, map[string]any{"iteration": iteration, "observations": records})
+		}
+		_ = emit(base.IterationEvent(iteration, o.maxIterations, "observed", map[string]any{"actions": records, "tokens_used": run.Tokens()}))
+
+		if o.stopMgr != nil {
+			if err := o.stopMgr.Check(req.UserID, req.SessionID); err != nil {
+				return err
+			}
+		}
+		reason := ""
+		if run.BudgetErr() != nil {
+			reason = base.StopBudgetExceeded
+		} else if _, ok := base.FinishTag(buf); ok {
+			reason = base.StopFinish
+		} else if len(outcomes) == 0 {
+			reason = base.StopNoActions
+		} else if o.tokenBudget > 0 && run.Tokens() >= o.tokenBudget {
+			reason = base.StopTokenBudget
+		}
+		if reason != "" {
+			stopReason = reason
+			break
+		}
+		messages = append(messages,
+			llm.Message{Role: "assistant", Content: buf},
+			llm.Message{Role: "user", Content: base.FormatObservations(iteration, outcomes)},
+		)
 	}
+	o.logger.Info(ctx, "orchestrator.loop.done", logx.KV("iterations", iteration), logx.KV("stop_reason", stopReason), logx.KV("tokens_used", run.Tokens()))
+	_ = emit(base.IterationEvent(iteration, o.maxIterations, "done", map[string]any{"stop_reason": stopReason, "tokens_used": run.Tokens(), "stats": run.Stats()}))
 
 	if o.tokenAcc != nil {
 		if summary, err := o.tokenAcc.Summary(req.UserID, req.SessionID); err == nil {
@@ -137,123 +194,81 @@ func (o *Orchestrator) Process(ctx context.Context, req Request, emit func(ev ev
 	return nil
 }
 
-func (o *Orchestrator) shouldEmit() bool {
-	o.outputMu.Lock()
-	defer o.outputMu.Unlock()
-	now := time.Now()
-	if o.lastOutputTime.IsZero() || now.Sub(o.lastOutputTime) >= o.outputInterval {
-		o.lastOutputTime = now
-		return true
+// think 执行一轮决策调用，思考片段按请求节流转发，并把用量计入本次运行
+func (o *Orchestrator) think(ctx context.Context, req Request, messages []llm.Message, emit func(ev events.StreamEvent) error) (string, error) {
+	buf := ""
+	var lastOutput time.Time
+	onChunk := func(ctx context.Context, chunk string) error {
+		buf += chunk
+		// 思考片段按策略转发（按本次请求节流）
+		if now := time.Now(); lastOutput.IsZero() || now.Sub(lastOutput) >= o.outputInterval {
+			lastOutput = now
+			_ = emit(events.StreamEvent{Type: events.LLMChunk, Content: events.ContentThought, Data: chunk})
+		}
+		return nil
+	}
+	if err := base.CheckBudget(ctx, o.tokenAcc, req.UserID, req.SessionID); err != nil {
+		return "", err
+	}
+	run := base.RunFrom(ctx)
+	run.CountLLMCall()
+	if err := o.llm.SafeStreamCall(ctx, req.UserID, req.SessionID, messages, onChunk); err != nil {
+		return "", err
+	}
+	contents := make([]string, 0, len(messages))
+	for _, m := range messages {
+		contents = append(contents, m.Text())
+	}
+	used := tokenizer.CountChat(tokenizer.Default(), contents) + tokenizer.Default().Count(buf)
+	run.CountTokens(used)
+	if o.tokenAcc != nil {
+		o.tokenAcc.Add(req.UserID, req.SessionID, used)
 	}
-	return false
+	return buf, nil
 }
 
-type actionItem struct{ ActionType, Instruction string }
+// actionItem 是计划中的一个动作节点，DependsOn 为其前置动作的 ID；AutoID 表示 ID 由解析时自动编号
+type actionItem struct {
+	ID          string
+	ActionType  string
+	Instruction string
+	DependsOn   []string
+	OnFailure   string
+	AutoID      bool
+}
+
+var (
+	actionRe    = regexp.MustCompile(`(?s)<Action\s+([^>]*)>(.*?)</Action>`)
+	actionAttrs = regexp.MustCompile(`([a-zA-Z_]+)="([^"]*)"`)
+)
 
-// parseActions: 占位解析，后续替换为基于 XML 的解析
+// parseActions 解析 <Action type="xxx" id="a2" depends_on="a1" on_failure="skip|degrade">指令</Action>；
+// 未写 id 的动作按出现顺序编号为 a1、a2…
 func (o *Orchestrator) parseActions(response string) []actionItem {
-	// 简化版解析：匹配 <Action type="xxx">...<\/Action>
-	re := regexp.MustCompile(`(?s)<Action\s+type=\"([a-zA-Z0-9_\-]+)\">(.*?)</Action>`)
-	matches := re.FindAllStringSubmatch(response, -1)
+	matches := actionRe.FindAllStringSubmatch(response, -1)
 	if len(matches) == 0 {
 		return nil
 	}
 	items := make([]actionItem, 0, len(matches))
 	for _, m := range matches {
-		if len(m) < 3 {
-			continue
+		attrs := map[string]string{}
+		for _, a := range actionAttrs.FindAllStringSubmatch(m[1], -1) {
+			attrs[a[1]] = strings.TrimSpace(a[2])
 		}
-		items = append(items, actionItem{ActionType: m[1], Instruction: m[2]})
-	}
-	return items
-}
-
-func (o *Orchestrator) executeParallel(ctx context.Context, items []actionItem, req Request, emit func(ev events.StreamEvent) error) error {
-	if len(items) == 0 {
-		return nil
-	}
-	sem := make(chan struct{}, o.maxConcurrent)
-	errCh := make(chan error, len(items))
-	done := make(chan struct{})
-	active := 0
-	peak := 0
-	mu := sync.Mutex{}
-
-	for _, it := range items {
-		sem <- struct{}{}
-		go func(ai actionItem) {
-			defer func() { <-sem }()
-			mu.Lock()
-			active++
-			if active > peak {
-				peak = active
-			}
-			mu.Unlock()
-			ag := o.createAgent(ai.ActionType)
-			if ag == nil {
-				// 未知类型，忽略
-				errCh <- nil
-				mu.Lock()
-				active--
-				mu.Unlock()
-				return
-			}
-			aReq := types.AgentRequest{
-				Instruction: ai.Instruction,
-				UserID:      req.UserID,
-				SessionID:   req.SessionID,
-				UseFiles:    false,
-				AutoMode:    req.AutoMode,
-				Selections:  req.Selections,
-			}
-			err := ag.ProcessRequest(ctx, aReq, emit)
-			errCh <- err
-			mu.Lock()
-			active--
-			mu.Unlock()
-		}(it)
-	}
-
-	go func() {
-		// 等待所有 goroutine 完成
-		for i := 0; i < cap(sem); i++ {
-			sem <- struct{}{}
+		if attrs["type"] == "" {
+			continue
 		}
-		close(done)
-	}()
-
-	// 收集错误
-	var firstErr error
-	for i := 0; i < len(items); i++ {
-		if e := <-errCh; e != nil && firstErr == nil {
-			firstErr = e
+		item := actionItem{ID: attrs["id"], ActionType: attrs["type"], Instruction: strings.TrimSpace(m[2]), OnFailure: attrs["on_failure"]}
+		if item.ID == "" {
+			item.ID = fmt.Sprintf("a%d", len(items)+1)
+			item.AutoID = true
 		}
+		item.DependsOn = strings.FieldsFunc(attrs["depends_on"], func(r rune) bool { return r == ',' || r == ' ' })
+		items = append(items, item)
 	}
-	<-done
-	// 记录峰值
-	o.concurrentPeaks = append(o.concurrentPeaks, peak)
-	return firstErr
+	return items
 }
 
 func (o *Orchestrator) createAgent(actionType string) types.Agent {
-	switch actionType {
-	case "knowledge":
-		return agents.NewKnowledgeAgent(o.logger, o.llm)
-	case "persona":
-		return agents.NewPersonaAgent(o.logger, o.llm)
-	case "websearch":
-		return agents.NewWebSearchAgent(o.logger, o.llm)
-	case "resonant":
-		return agents.NewResonantAgent(o.logger, o.llm)
-	case "revision":
-		return agents.NewRevisionAgent(o.logger, o.llm)
-	case "tiktok_script":
-		return agents.NewTikTokScriptAgent(o.logger, o.llm)
-	case "brand_analysis":
-		return agents.NewBrandAnalysisAgent(o.logger, o.llm)
-	case "content_analysis":
-		return agents.NewContentAnalysisAgent(o.logger, o.llm)
-	default:
-		return nil
-	}
+	return o.agents.Get(actionType)
 }
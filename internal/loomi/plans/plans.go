@@ -0,0 +1,176 @@
+// Package plans keeps orchestrator plans parked for user approval when auto mode is off.
+package plans
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+)
+
+// ErrNotFound is returned for unknown or expired plans
+var ErrNotFound = errors.New("plan not found")
+
+// Decisions a user can take on a planned action
+const (
+	DecisionApprove = "approve"
+	DecisionEdit    = "edit"
+	DecisionDrop    = "drop"
+)
+
+// Action is one action of a parked plan with its cost estimate
+type Action struct {
+	ID              string  `json:"id"`
+	Action          string  `json:"action"`
+	Instruction     string  `json:"instruction"`
+	EstimatedTokens int     `json:"estimated_tokens"`
+	EstimatedCost   float64 `json:"estimated_cost"`
+}
+
+// Plan is an orchestrator iteration parked before its actions run. It carries everything needed
+// to continue the run on any instance: the request, the conversation so far and the iteration.
+type Plan struct {
+	ID        string `json:"id"`
+	AgentName string `json:"agent_name"`
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+	RequestID string `json:"request_id"`
+	UserPlan  string `json:"user_plan,omitempty"`
+
+	Instruction string   `json:"instruction"`
+	Selections  []string `json:"selections,omitempty"`
+	UseFiles    bool     `json:"use_files,omitempty"`
+	FileIDs     []string `json:"file_ids,omitempty"`
+
+	Iteration  int           `json:"iteration"`
+	Response   string        `json:"response"`
+	Messages   []llm.Message `json:"messages"`
+	TokensUsed int           `json:"tokens_used"`
+
+	Actions         []Action  `json:"actions"`
+	EstimatedTokens int       `json:"estimated_tokens"`
+	EstimatedCost   float64   `json:"estimated_cost"`
+	CreatedAt       time.Time `json:"created_at"`
+	ExpiresAt       time.Time `json:"expires_at"`
+}
+
+// Summary is the plan as shown to the user for approval
+func (p *Plan) Summary() map[string]any {
+	return map[string]any{
+		"plan_id":          p.ID,
+		"iteration":        p.Iteration,
+		"actions":          p.Actions,
+		"estimated_tokens": p.EstimatedTokens,
+		"estimated_cost":   p.EstimatedCost,
+		"expires_at":       p.ExpiresAt,
+	}
+}
+
+// Decision is the user's answer for one action; actions without a decision are approved
+type Decision struct {
+	ID          string `json:"id"`
+	Decision    string `json:"decision"`
+	Instruction string `json:"instruction,omitempty"`
+}
+
+// NewID returns a random plan ID
+func NewID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "plan_" + hex.EncodeToString(b)
+}
+
+// Apply edits and drops actions according to the decisions and updates the estimates
+func (p *Plan) Apply(decisions []Decision, estimate func(action, instruction string) (int, float64)) error {
+	byID := make(map[string]Decision, len(decisions))
+	for _, d := range decisions {
+		byID[d.ID] = d
+	}
+	kept := make([]Action, 0, len(p.Actions))
+	for _, a := range p.Actions {
+		d, ok := byID[a.ID]
+		delete(byID, a.ID)
+		switch {
+		case !ok || d.Decision == DecisionApprove || d.Decision == "":
+		case d.Decision == DecisionDrop:
+			continue
+		case d.Decision == DecisionEdit:
+			if strings.TrimSpace(d.Instruction) == "" {
+				return fmt.Errorf("action %s: edit requires an instruction", a.ID)
+			}
+			a.Instruction = strings.TrimSpace(d.Instruction)
+			a.EstimatedTokens, a.EstimatedCost = estimate(a.Action, a.Instruction)
+		default:
+			return fmt.Errorf("action %s: unknown decision %q", a.ID, d.Decision)
+		}
+		kept = append(kept, a)
+	}
+	for id := range byID {
+		return fmt.Errorf("unknown action %s", id)
+	}
+
+	p.Actions = kept
+	p.EstimatedTokens, p.EstimatedCost = 0, 0
+	for _, a := range kept {
+		p.EstimatedTokens += a.EstimatedTokens
+		p.EstimatedCost += a.EstimatedCost
+	}
+	return nil
+}
+
+// Store keeps parked plans until they are resumed or expire
+type Store interface {
+	Save(ctx context.Context, plan *Plan, ttl time.Duration) error
+	Get(ctx context.Context, id string) (*Plan, error)
+	// Take removes and returns the plan, so only one caller resumes it
+	Take(ctx context.Context, id string) (*Plan, error)
+}
+
+// MemoryStore is an in-process Store for development and tests; plans do not survive a restart
+type MemoryStore struct {
+	mu    sync.Mutex
+	plans map[string]*Plan
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{plans: map[string]*Plan{}}
+}
+
+func (s *MemoryStore) Save(ctx context.Context, plan *Plan, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plan.ExpiresAt = time.Now().Add(ttl)
+	cp := *plan
+	s.plans[plan.ID] = &cp
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Plan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookup(id)
+}
+
+func (s *MemoryStore) Take(ctx context.Context, id string) (*Plan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plan, err := s.lookup(id)
+	delete(s.plans, id)
+	return plan, err
+}
+
+func (s *MemoryStore) lookup(id string) (*Plan, error) {
+	plan, ok := s.plans[id]
+	if !ok || time.Now().After(plan.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	cp := *plan
+	return &cp, nil
+}
@@ -0,0 +1,71 @@
+package plans
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/pool"
+	"github.com/redis/go-redis/v9"
+)
+
+// KeyPrefix namespaces parked plans in Redis
+const KeyPrefix = "loomi:plan:"
+
+// RedisStore keeps plans in Redis with a TTL, so a parked run can be resumed by any instance
+type RedisStore struct {
+	pools    pool.RedisPools
+	poolType string
+}
+
+// NewRedisStore creates a store on the given pool type ("high_priority" when empty)
+func NewRedisStore(pools pool.RedisPools, poolType string) *RedisStore {
+	if poolType == "" {
+		poolType = "high_priority"
+	}
+	return &RedisStore{pools: pools, poolType: poolType}
+}
+
+func (s *RedisStore) Save(ctx context.Context, plan *Plan, ttl time.Duration) error {
+	client, err := s.pools.GetRedisClient(ctx, s.poolType)
+	if err != nil {
+		return err
+	}
+	plan.ExpiresAt = time.Now().Add(ttl)
+	raw, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	return client.Set(ctx, KeyPrefix+plan.ID, raw, ttl).Err()
+}
+
+func (s *RedisStore) Get(ctx context.Context, id string) (*Plan, error) {
+	client, err := s.pools.GetRedisClient(ctx, s.poolType)
+	if err != nil {
+		return nil, err
+	}
+	return decode(client.Get(ctx, KeyPrefix+id).Bytes())
+}
+
+func (s *RedisStore) Take(ctx context.Context, id string) (*Plan, error) {
+	client, err := s.pools.GetRedisClient(ctx, s.poolType)
+	if err != nil {
+		return nil, err
+	}
+	return decode(client.GetDel(ctx, KeyPrefix+id).Bytes())
+}
+
+func decode(raw []byte, err error) (*Plan, error) {
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var plan Plan
+	if err := json.Unmarshal(raw, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
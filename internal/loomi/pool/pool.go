@@ -11,10 +11,15 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// RedisPools 按连接池类型获取Redis客户端，是Redis存储（待审批计划、LLM响应缓存、分布式并发槽位）所需的部分
+type RedisPools interface {
+	GetRedisClient(ctx context.Context, poolType string) (*redis.Client, error)
+}
+
 // Manager 连接池管理器接口
 type Manager interface {
 	// 获取Redis客户端
-	GetRedisClient(ctx context.Context, poolType string) (*redis.Client, error)
+	RedisPools
 
 	// 获取连接池统计信息
 	GetPoolStats(ctx context.Context) (map[string]interface{}, error)
@@ -0,0 +1,55 @@
+package prompts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// templateExt is the file extension of template versions in a DirSource
+const templateExt = ".tmpl"
+
+// DirSource reads <Dir>/<name>/<version>.tmpl. A file <Dir>/<name>/ACTIVE containing a version
+// pins the served version. A missing Dir holds no templates.
+type DirSource struct {
+	Dir string
+}
+
+// Load implements Source
+func (d DirSource) Load(ctx context.Context) ([]Version, error) {
+	entries, err := os.ReadDir(d.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Version
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		dir := filepath.Join(d.Dir, name)
+		files, err := filepath.Glob(filepath.Join(dir, "*"+templateExt))
+		if err != nil {
+			return nil, err
+		}
+		active := ""
+		if raw, err := os.ReadFile(filepath.Join(dir, "ACTIVE")); err == nil {
+			active = strings.TrimSpace(string(raw))
+		}
+		for _, file := range files {
+			text, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("read prompt %s: %w", file, err)
+			}
+			version := strings.TrimSuffix(filepath.Base(file), templateExt)
+			out = append(out, Version{Name: name, Version: version, Text: string(text), Active: version == active})
+		}
+	}
+	return out, nil
+}
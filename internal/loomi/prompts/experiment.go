@@ -0,0 +1,107 @@
+package prompts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Experiment splits an agent's traffic across prompt versions. Assignment is a stable hash of the
+// experiment name and the unit key, so a user (or session) always sees the same variant.
+type Experiment struct {
+	Name  string `yaml:"name" json:"name"`
+	Agent string `yaml:"agent" json:"agent"`
+	// Unit is "user" (default) or "session"
+	Unit     string    `yaml:"unit" json:"unit"`
+	Variants []Variant `yaml:"variants" json:"variants"`
+}
+
+// Variant is one arm of an experiment, served with a specific template version
+type Variant struct {
+	Name    string `yaml:"name" json:"name"`
+	Version string `yaml:"version" json:"version"`
+	// Weight is the relative share of traffic; 0 means 1
+	Weight int `yaml:"weight" json:"weight"`
+}
+
+// ExperimentSource is implemented by sources that also define experiments
+type ExperimentSource interface {
+	LoadExperiments(ctx context.Context) ([]Experiment, error)
+}
+
+// Assign returns the variant for a user/session
+func (e Experiment) Assign(userID, sessionID string) Variant {
+	key := userID
+	if e.Unit == "session" {
+		key = userID + ":" + sessionID
+	}
+	sum := sha256.Sum256([]byte(e.Name + "\x00" + key))
+
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	n := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	for _, v := range e.Variants {
+		if n < v.Weight {
+			return v
+		}
+		n -= v.Weight
+	}
+	return e.Variants[len(e.Variants)-1]
+}
+
+// normalize fills defaults and checks the variants against the loaded versions of the agent
+func (e *Experiment) normalize(p *prompt) error {
+	if e.Name == "" || e.Agent == "" {
+		return fmt.Errorf("experiment needs name and agent")
+	}
+	if e.Unit == "" {
+		e.Unit = "user"
+	}
+	if e.Unit != "user" && e.Unit != "session" {
+		return fmt.Errorf("experiment %s: unit must be user or session", e.Name)
+	}
+	if len(e.Variants) < 2 {
+		return fmt.Errorf("experiment %s: needs at least two variants", e.Name)
+	}
+	for i := range e.Variants {
+		v := &e.Variants[i]
+		if v.Weight == 0 {
+			v.Weight = 1
+		}
+		if v.Name == "" || v.Weight < 0 {
+			return fmt.Errorf("experiment %s: variant %d needs a name and a positive weight", e.Name, i)
+		}
+		if p == nil || p.versions[v.Version] == nil {
+			return fmt.Errorf("experiment %s: prompt %s@%s not loaded", e.Name, e.Agent, v.Version)
+		}
+	}
+	return nil
+}
+
+// experimentsFile holds the experiments of a DirSource
+const experimentsFile = "experiments.yaml"
+
+// LoadExperiments reads <Dir>/experiments.yaml ({experiments: [...]}); a missing file defines none
+func (d DirSource) LoadExperiments(ctx context.Context) ([]Experiment, error) {
+	raw, err := os.ReadFile(filepath.Join(d.Dir, experimentsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Experiments []Experiment `yaml:"experiments"`
+	}
+	if err := yaml.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", experimentsFile, err)
+	}
+	return file.Experiments, nil
+}
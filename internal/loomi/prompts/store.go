@@ -0,0 +1,255 @@
+// Package prompts serves versioned system prompt templates loaded from a directory or the database.
+package prompts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// ErrNotFound is returned when the store has no template for a name or version
+var ErrNotFound = errors.New("prompt template not found")
+
+// Version is one revision of a named template. Name is the agent name (e.g. loomi_xhs_post_agent).
+type Version struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Text    string `json:"-"`
+	// Active pins the version that is served; without a pin the highest version wins
+	Active bool `json:"active"`
+}
+
+// Vars are the variables available to templates, e.g. {{if .AutoMode}} or {{.Background.brand}}
+type Vars struct {
+	Platform   string
+	AutoMode   bool
+	Selections []string
+	Background map[string]any
+}
+
+// Rendered is a rendered system prompt and the version it came from. Experiment and Variant are
+// set when the version was picked by a running experiment.
+type Rendered struct {
+	Text       string
+	Version    string
+	Experiment string
+	Variant    string
+}
+
+// Source loads every template version from a backing store
+type Source interface {
+	Load(ctx context.Context) ([]Version, error)
+}
+
+type compiled struct {
+	Version
+	tmpl *template.Template
+}
+
+type prompt struct {
+	active   *compiled
+	versions map[string]*compiled
+}
+
+// Store holds the parsed templates and swaps them atomically on reload
+type Store struct {
+	source Source
+
+	mu          sync.RWMutex
+	prompts     map[string]*prompt
+	experiments map[string]Experiment // by agent
+	loadedAt    time.Time
+}
+
+// NewStore creates an empty store; call Reload before serving
+func NewStore(source Source) *Store {
+	return &Store{source: source, prompts: map[string]*prompt{}}
+}
+
+// Reload loads and parses all templates. On any error the previous set keeps serving.
+func (s *Store) Reload(ctx context.Context) error {
+	versions, err := s.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+	next := make(map[string]*prompt)
+	for _, v := range versions {
+		tmpl, err := template.New(v.Name + "@" + v.Version).Option("missingkey=zero").Parse(v.Text)
+		if err != nil {
+			return fmt.Errorf("parse prompt %s@%s: %w", v.Name, v.Version, err)
+		}
+		p := next[v.Name]
+		if p == nil {
+			p = &prompt{versions: map[string]*compiled{}}
+			next[v.Name] = p
+		}
+		c := &compiled{Version: v, tmpl: tmpl}
+		p.versions[v.Version] = c
+		switch {
+		case p.active == nil:
+			p.active = c
+		case c.Active && !p.active.Active:
+			p.active = c
+		case c.Active == p.active.Active && versionLess(p.active.Version.Version, c.Version.Version):
+			p.active = c
+		}
+	}
+
+	experiments := make(map[string]Experiment)
+	if es, ok := s.source.(ExperimentSource); ok {
+		list, err := es.LoadExperiments(ctx)
+		if err != nil {
+			return err
+		}
+		for _, e := range list {
+			if err := e.normalize(next[e.Agent]); err != nil {
+				return err
+			}
+			if other, ok := experiments[e.Agent]; ok {
+				return fmt.Errorf("experiments %s and %s both target %s", other.Name, e.Name, e.Agent)
+			}
+			experiments[e.Agent] = e
+		}
+	}
+
+	s.mu.Lock()
+	s.prompts = next
+	s.experiments = experiments
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// Watch reloads every interval until ctx is done, so edited templates take effect without a restart
+func (s *Store) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Reload(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// Render renders the active version of name
+func (s *Store) Render(name string, vars Vars) (Rendered, error) {
+	return s.RenderVersion(name, "", vars)
+}
+
+// RenderFor renders name for a user/session: the assigned variant's version when an experiment
+// runs on name, otherwise the active version
+func (s *Store) RenderFor(name, userID, sessionID string, vars Vars) (Rendered, error) {
+	s.mu.RLock()
+	e, ok := s.experiments[name]
+	s.mu.RUnlock()
+	if !ok {
+		return s.Render(name, vars)
+	}
+	v := e.Assign(userID, sessionID)
+	r, err := s.RenderVersion(name, v.Version, vars)
+	if err != nil {
+		return r, err
+	}
+	r.Experiment, r.Variant = e.Name, v.Name
+	return r, nil
+}
+
+// Experiments returns the running experiments sorted by name
+func (s *Store) Experiments() []Experiment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Experiment, 0, len(s.experiments))
+	for _, e := range s.experiments {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// RenderVersion renders a specific version of name; an empty version means the active one
+func (s *Store) RenderVersion(name, version string, vars Vars) (Rendered, error) {
+	s.mu.RLock()
+	p := s.prompts[name]
+	var c *compiled
+	if p != nil {
+		if version == "" {
+			c = p.active
+		} else {
+			c = p.versions[version]
+		}
+	}
+	s.mu.RUnlock()
+	if c == nil {
+		return Rendered{}, ErrNotFound
+	}
+
+	var buf bytes.Buffer
+	if err := c.tmpl.Execute(&buf, vars); err != nil {
+		return Rendered{}, fmt.Errorf("render prompt %s@%s: %w", name, c.Version.Version, err)
+	}
+	return Rendered{Text: buf.String(), Version: c.Version.Version}, nil
+}
+
+// Info describes the loaded versions of one template
+type Info struct {
+	Name     string   `json:"name"`
+	Active   string   `json:"active"`
+	Versions []string `json:"versions"`
+}
+
+// List returns the loaded templates sorted by name, and when they were loaded
+func (s *Store) List() ([]Info, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Info, 0, len(s.prompts))
+	for name, p := range s.prompts {
+		info := Info{Name: name, Active: p.active.Version.Version}
+		for v := range p.versions {
+			info.Versions = append(info.Versions, v)
+		}
+		sort.Slice(info.Versions, func(i, j int) bool { return versionLess(info.Versions[i], info.Versions[j]) })
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, s.loadedAt
+}
+
+// versionLess orders versions naturally, comparing digit runs numerically (v2 < v10)
+func versionLess(a, b string) bool {
+	for a != "" && b != "" {
+		ra, restA := leadingRun(a)
+		rb, restB := leadingRun(b)
+		if ra != rb {
+			na, errA := strconv.Atoi(ra)
+			nb, errB := strconv.Atoi(rb)
+			if errA == nil && errB == nil && na != nb {
+				return na < nb
+			}
+			return ra < rb
+		}
+		a, b = restA, restB
+	}
+	return a == "" && b != ""
+}
+
+// leadingRun splits off the leading run of digits or non-digits
+func leadingRun(s string) (string, string) {
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	digit := isDigit(s[0])
+	i := 1
+	for i < len(s) && isDigit(s[i]) == digit {
+		i++
+	}
+	return s[:i], s[i:]
+}
@@ -0,0 +1,150 @@
+package prompts
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSource serves whatever versions (or error) it was last given
+type fakeSource struct {
+	mu       sync.Mutex
+	versions []Version
+	err      error
+}
+
+func (f *fakeSource) Load(ctx context.Context) ([]Version, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.versions, f.err
+}
+
+func (f *fakeSource) set(err error, versions ...Version) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.versions, f.err = versions, err
+}
+
+func loadedStore(t *testing.T, versions ...Version) (*Store, *fakeSource) {
+	t.Helper()
+	src := &fakeSource{versions: versions}
+	s := NewStore(src)
+	if err := s.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s, src
+}
+
+func TestStoreVersionSelection(t *testing.T) {
+	s, _ := loadedStore(t,
+		Version{Name: "loomi_xhs_post_agent", Version: "v2", Text: "post v2"},
+		Version{Name: "loomi_xhs_post_agent", Version: "v10", Text: "post v10"},
+		Version{Name: "loomi_xhs_post_agent", Version: "v9", Text: "post v9"},
+		Version{Name: "loomi_hitpoint_agent", Version: "v3", Text: "hitpoint v3"},
+		Version{Name: "loomi_hitpoint_agent", Version: "v1", Text: "hitpoint v1", Active: true},
+		Version{Name: "loomi_hitpoint_agent", Version: "v2", Text: "hitpoint v2"},
+	)
+
+	for name, tc := range map[string]struct {
+		prompt, version string
+		want            string
+	}{
+		"highest version, compared naturally": {"loomi_xhs_post_agent", "", "v10"},
+		"pinned version beats a higher one":   {"loomi_hitpoint_agent", "", "v1"},
+		"explicit version":                    {"loomi_hitpoint_agent", "v3", "v3"},
+	} {
+		r, err := s.RenderVersion(tc.prompt, tc.version, Vars{})
+		if err != nil || r.Version != tc.want || !strings.HasSuffix(r.Text, tc.want) {
+			t.Errorf("%s: rendered %+v, err = %v, want %s", name, r, err, tc.want)
+		}
+	}
+	for _, tc := range [][2]string{{"loomi_hitpoint_agent", "v4"}, {"loomi_persona_agent", ""}} {
+		if _, err := s.RenderVersion(tc[0], tc[1], Vars{}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("RenderVersion(%s, %q) err = %v, want ErrNotFound", tc[0], tc[1], err)
+		}
+	}
+
+	list, loadedAt := s.List()
+	want := []Info{
+		{Name: "loomi_hitpoint_agent", Active: "v1", Versions: []string{"v1", "v2", "v3"}},
+		{Name: "loomi_xhs_post_agent", Active: "v10", Versions: []string{"v2", "v9", "v10"}},
+	}
+	if !reflect.DeepEqual(list, want) || loadedAt.IsZero() {
+		t.Errorf("List() = %+v at %v, want %+v", list, loadedAt, want)
+	}
+}
+
+func TestStoreRendering(t *testing.T) {
+	s, src := loadedStore(t,
+		Version{Name: "loomi_xhs_post_agent", Version: "v1", Text: `{{if .AutoMode}}自动{{else}}手动{{end}}模式，平台 {{.Platform}}，品牌 {{.Background.brand}}`},
+		Version{Name: "loomi_xhs_post_agent", Version: "v0", Text: `第 6 个选择：{{index .Selections 5}}`},
+	)
+
+	r, err := s.Render("loomi_xhs_post_agent", Vars{Platform: "小红书", AutoMode: true, Background: map[string]any{"brand": "蓝图"}})
+	if err != nil || r.Text != "自动模式，平台 小红书，品牌 蓝图" {
+		t.Errorf("Render = %q, err = %v", r.Text, err)
+	}
+	if _, err := s.RenderVersion("loomi_xhs_post_agent", "v0", Vars{Selections: []string{"a"}}); err == nil || !strings.HasPrefix(err.Error(), "render prompt loomi_xhs_post_agent@v0") {
+		t.Errorf("execution error = %v", err)
+	}
+
+	// a template that does not parse fails the whole reload and the previous set keeps serving
+	src.set(nil, Version{Name: "loomi_xhs_post_agent", Version: "v2", Text: "{{if .AutoMode}}未闭合"})
+	if err := s.Reload(context.Background()); err == nil || !strings.HasPrefix(err.Error(), "parse prompt loomi_xhs_post_agent@v2") {
+		t.Errorf("Reload err = %v, want a parse error", err)
+	}
+	if r, err := s.Render("loomi_xhs_post_agent", Vars{}); err != nil || r.Version != "v1" {
+		t.Errorf("after a failed reload: %+v, err = %v, want v1", r, err)
+	}
+}
+
+func TestStoreWatchReloads(t *testing.T) {
+	s, src := loadedStore(t, Version{Name: "loomi_xhs_post_agent", Version: "v1", Text: "旧提示词"})
+	errs := make(chan error, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Watch(ctx, 5*time.Millisecond, func(err error) { errs <- err })
+		close(done)
+	}()
+
+	waitFor := func(what string, ok func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); !ok(); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+
+	src.set(nil, Version{Name: "loomi_xhs_post_agent", Version: "v2", Text: "新提示词"})
+	waitFor("the new version", func() bool {
+		r, _ := s.Render("loomi_xhs_post_agent", Vars{})
+		return r.Text == "新提示词"
+	})
+
+	errBroken := errors.New("database unavailable")
+	src.set(errBroken)
+	select {
+	case err := <-errs:
+		if !errors.Is(err, errBroken) {
+			t.Errorf("onError got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("reload error not reported")
+	}
+	if r, _ := s.Render("loomi_xhs_post_agent", Vars{}); r.Version != "v2" {
+		t.Errorf("after a failed reload serving %+v, want v2", r)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Watch did not return after cancel")
+	}
+}
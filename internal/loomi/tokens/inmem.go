@@ -21,12 +21,14 @@ func (a *InmemAccumulator) Init(userID, sessionID string) error {
 }
 
 func (a *InmemAccumulator) Summary(userID, sessionID string) (any, error) {
+	total := a.Total(userID, sessionID)
+	return map[string]any{"total_tokens": total, "cost": Cost(total)}, nil
+}
+
+func (a *InmemAccumulator) Total(userID, sessionID string) int {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	total := a.data[userID+":"+sessionID]
-	// 简化成本计算（可按模型单价细化）
-	cost := float64(total) * 0.000002
-	return map[string]any{"total_tokens": total, "cost": cost}, nil
+	return a.data[userID+":"+sessionID]
 }
 
 func (a *InmemAccumulator) Initialize(userID, sessionID string) (string, error) {
@@ -26,19 +26,24 @@ func (a *RedisAccumulator) Initialize(userID, sessionID string) (string, error)
 }
 
 func (a *RedisAccumulator) Summary(userID, sessionID string) (any, error) {
+	total := a.Total(userID, sessionID)
+	return map[string]any{"total_tokens": total, "cost": Cost(total)}, nil
+}
+
+func (a *RedisAccumulator) Total(userID, sessionID string) int {
 	// Aggregate per-minute counters for this user/session
 	ctx := context.Background()
 	var total int64 = 0
 	if a.r == nil {
-		return map[string]any{"total_tokens": 0, "cost": 0.0}, nil
+		return 0
 	}
 	cli, err := a.r.GetClient("high_priority")
 	if err != nil {
-		return map[string]any{"total_tokens": 0, "cost": 0.0}, nil
+		return 0
 	}
 	c, ok := cli.(*redis.Client)
 	if !ok {
-		return map[string]any{"total_tokens": 0, "cost": 0.0}, nil
+		return 0
 	}
 	// keys pattern: loomi:tokens:{user_id}:{session_id}:YYYYMMDDHHMM
 	pattern := fmt.Sprintf("loomi:tokens:%s:%s:*", userID, sessionID)
@@ -73,8 +78,7 @@ func (a *RedisAccumulator) Summary(userID, sessionID string) (any, error) {
 			break
 		}
 	}
-	cost := float64(total) * 0.000002
-	return map[string]any{"total_tokens": total, "cost": cost}, nil
+	return int(total)
 }
 
 func (a *RedisAccumulator) addTokens(ctx context.Context, userID, sessionID string, n int) {
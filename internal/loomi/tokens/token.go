@@ -1,8 +1,18 @@
 package tokens
 
+// CostPerToken 简化成本计算的每 token 单价（可按模型单价细化）
+const CostPerToken = 0.000002
+
+// Cost 按 CostPerToken 估算 token 成本
+func Cost(tokens int) float64 {
+	return float64(tokens) * CostPerToken
+}
+
 type Accumulator interface {
 	Init(userID, sessionID string) error
 	Summary(userID, sessionID string) (any, error)
+	// Total 返回会话当前累计的 token 数（预算检查使用）
+	Total(userID, sessionID string) int
 	Initialize(userID, sessionID string) (string, error)
 	Add(userID, sessionID string, n int)
 	Cleanup(userID, sessionID string) error
@@ -0,0 +1,112 @@
+package tokenizer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// cl100kPattern approximates the cl100k_base pre-tokenizer. Go's regexp has no lookahead,
+// so trailing whitespace runs are not split off the way tiktoken does; counts differ by at most
+// a token per whitespace run.
+var cl100kPattern = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// BPE is a byte-level BPE tokenizer driven by a tiktoken-style rank file
+type BPE struct {
+	name    string
+	ranks   map[string]int
+	pattern *regexp.Regexp
+}
+
+// LoadBPEFile loads a tiktoken vocab file ("<base64 token> <rank>" per line, e.g. cl100k_base.tiktoken).
+// The tokenizer is named after the file without extension.
+func LoadBPEFile(path string) (*BPE, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open bpe vocab: %w", err)
+	}
+	defer f.Close()
+
+	ranks := make(map[string]int, 100000)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		tok, rankStr, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("bpe vocab %s:%d: malformed line", path, lineNo)
+		}
+		raw, err := base64.StdEncoding.DecodeString(tok)
+		if err != nil {
+			return nil, fmt.Errorf("bpe vocab %s:%d: %w", path, lineNo, err)
+		}
+		rank, err := strconv.Atoi(rankStr)
+		if err != nil {
+			return nil, fmt.Errorf("bpe vocab %s:%d: %w", path, lineNo, err)
+		}
+		ranks[string(raw)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read bpe vocab: %w", err)
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("bpe vocab %s is empty", path)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return NewBPE(name, ranks, cl100kPattern), nil
+}
+
+// NewBPE builds a tokenizer from merge ranks and a pre-tokenization pattern
+func NewBPE(name string, ranks map[string]int, pattern *regexp.Regexp) *BPE {
+	if pattern == nil {
+		pattern = cl100kPattern
+	}
+	return &BPE{name: name, ranks: ranks, pattern: pattern}
+}
+
+func (b *BPE) Name() string { return b.name }
+
+// Count returns the number of BPE tokens in text
+func (b *BPE) Count(text string) int {
+	n := 0
+	for _, piece := range b.pattern.FindAllString(text, -1) {
+		if _, ok := b.ranks[piece]; ok {
+			n++
+			continue
+		}
+		n += len(b.merge([]byte(piece)))
+	}
+	return n
+}
+
+// merge applies the lowest-rank pair merges until none remain and returns the part boundaries
+func (b *BPE) merge(piece []byte) []int {
+	// parts[i] 为第 i 个片段的起始字节偏移，末尾哨兵为 len(piece)
+	parts := make([]int, len(piece)+1)
+	for i := range parts {
+		parts[i] = i
+	}
+	for len(parts) > 2 {
+		best, bestRank := -1, int(^uint(0)>>1)
+		for i := 0; i+2 < len(parts); i++ {
+			if rank, ok := b.ranks[string(piece[parts[i]:parts[i+2]])]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+	return parts[:len(parts)-1]
+}
@@ -0,0 +1,69 @@
+package tokenizer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tinyRanks merges "ab" before "abc"; "bc" is only reachable when "ab" is absent
+var tinyRanks = map[string]int{"a": 0, "b": 1, "c": 2, "ab": 3, "bc": 4, "abc": 5}
+
+func TestBPECount(t *testing.T) {
+	b := NewBPE("tiny", tinyRanks, nil)
+	for text, want := range map[string]int{
+		"":    0,
+		"abc": 1, // a whole piece in the table is one token
+		"bc":  1,
+		// ab+c → abc, then c: merges follow rank order, not left to right
+		"abcc": 2,
+		// " cab" is one piece: " ", "c", "ab"
+		"abcc cab": 5,
+		// digits are split in runs of three and no pair merges
+		"12345": 5,
+	} {
+		if got := b.Count(text); got != want {
+			t.Errorf("Count(%q) = %d, want %d", text, got, want)
+		}
+	}
+	if b.Name() != "tiny" {
+		t.Errorf("Name() = %q", b.Name())
+	}
+}
+
+func TestLoadBPEFile(t *testing.T) {
+	write := func(name, content string) string {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	b, err := LoadBPEFile(write("tiny.tiktoken", "YQ== 0\nYg== 1\n\nYWI= 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Name() != "tiny" || b.Count("ab") != 1 || b.Count("abab") != 2 {
+		t.Errorf("loaded %s: Count(ab) = %d, Count(abab) = %d", b.Name(), b.Count("ab"), b.Count("abab"))
+	}
+
+	for name, tc := range map[string]struct {
+		content string
+		want    string
+	}{
+		"malformed line": {"YQ== 0\nYg==\n", "tiny.tiktoken:2: malformed line"},
+		"bad base64":     {"YQ== 0\n!!! 1\n", "tiny.tiktoken:2: illegal base64"},
+		"bad rank":       {"YQ== zero\n", "tiny.tiktoken:1: strconv.Atoi"},
+		"empty file":     {"", "is empty"},
+		"blank lines":    {"\n  \n", "is empty"},
+	} {
+		if _, err := LoadBPEFile(write("tiny.tiktoken", tc.content)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", name, err, tc.want)
+		}
+	}
+	if _, err := LoadBPEFile(filepath.Join(t.TempDir(), "missing.tiktoken")); err == nil || !strings.HasPrefix(err.Error(), "open bpe vocab") {
+		t.Errorf("missing file: err = %v", err)
+	}
+}
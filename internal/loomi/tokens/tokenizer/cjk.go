@@ -0,0 +1,71 @@
+package tokenizer
+
+import (
+	"math"
+	"unicode"
+)
+
+// CJKEstimator approximates token counts without a vocab file. CJK characters are
+// counted individually, runs of Latin letters/digits are divided by LatinCharsPerToken,
+// and every other non-space symbol counts as one token.
+type CJKEstimator struct {
+	// TokensPerCJK is the average number of tokens per Han/Kana/Hangul character
+	TokensPerCJK float64
+	// LatinCharsPerToken is the average word-piece length for alphanumeric text
+	LatinCharsPerToken float64
+}
+
+// NewCJKEstimator returns an estimator whose defaults approximate cl100k_base on Chinese text;
+// use CalibrateCJK to fit TokensPerCJK for another vocab
+func NewCJKEstimator() *CJKEstimator {
+	return &CJKEstimator{TokensPerCJK: 1.1, LatinCharsPerToken: 4}
+}
+
+func (e *CJKEstimator) Name() string { return "cjk_estimator" }
+
+// Count estimates the number of tokens in text
+func (e *CJKEstimator) Count(text string) int {
+	cjk, latin, other := classify(text)
+	est := float64(cjk)*e.TokensPerCJK + float64(other)
+	if latin > 0 {
+		est += math.Ceil(float64(latin) / e.LatinCharsPerToken)
+	}
+	return int(math.Ceil(est))
+}
+
+// CalibrateCJK fits TokensPerCJK against a reference tokenizer over sample texts,
+// e.g. after loading a BPE vocab once to tune the fallback for another deployment
+func CalibrateCJK(ref Tokenizer, samples []string) *CJKEstimator {
+	e := NewCJKEstimator()
+	var cjkTotal, restTokens, refTokens float64
+	for _, s := range samples {
+		cjk, latin, other := classify(s)
+		cjkTotal += float64(cjk)
+		restTokens += math.Ceil(float64(latin)/e.LatinCharsPerToken) + float64(other)
+		refTokens += float64(ref.Count(s))
+	}
+	if cjkTotal > 0 && refTokens > restTokens {
+		e.TokensPerCJK = (refTokens - restTokens) / cjkTotal
+	}
+	return e
+}
+
+func classify(text string) (cjk, latin, other int) {
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+		case isCJK(r):
+			cjk++
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			latin++
+		default:
+			other++
+		}
+	}
+	return cjk, latin, other
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
@@ -0,0 +1,49 @@
+package tokenizer
+
+import "testing"
+
+// fixedTokenizer returns a preset count per text
+type fixedTokenizer map[string]int
+
+func (f fixedTokenizer) Name() string          { return "fixed" }
+func (f fixedTokenizer) Count(text string) int { return f[text] }
+
+func TestCJKEstimatorCount(t *testing.T) {
+	e := NewCJKEstimator()
+	for text, want := range map[string]int{
+		"":      0,
+		"   \n": 0,
+		"hello": 2, // ceil(5/4)
+		"你好":    3, // ceil(2×1.1)
+		"こんにちは": 6,
+		"한국":    3,
+		// 4 CJK × 1.1 + ",", "!" + ceil(8 letters and digits / 4) = 8.4
+		"你好world, 测试123!": 9,
+		"标点，也算。":          7, // 4 × 1.1 + 2 symbols
+	} {
+		if got := e.Count(text); got != want {
+			t.Errorf("Count(%q) = %d, want %d", text, got, want)
+		}
+	}
+}
+
+func TestCalibrateCJK(t *testing.T) {
+	// 4 CJK characters; the reference spends 9 tokens, 1 of them on "abcd"
+	ref := fixedTokenizer{"你好": 4, "测试abcd": 5}
+	e := CalibrateCJK(ref, []string{"你好", "测试abcd"})
+	if e.TokensPerCJK != 2 {
+		t.Fatalf("TokensPerCJK = %v, want 2", e.TokensPerCJK)
+	}
+	if got := e.Count("你好"); got != ref["你好"] {
+		t.Errorf("calibrated Count = %d, want %d", got, ref["你好"])
+	}
+
+	for name, samples := range map[string][]string{
+		"no CJK samples":       {"abcd"},
+		"reference undercount": {"测试abcd"},
+	} {
+		if e := CalibrateCJK(fixedTokenizer{"abcd": 7, "测试abcd": 1}, samples); e.TokensPerCJK != NewCJKEstimator().TokensPerCJK {
+			t.Errorf("%s: TokensPerCJK = %v, want the default", name, e.TokensPerCJK)
+		}
+	}
+}
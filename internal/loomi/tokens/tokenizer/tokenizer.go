@@ -0,0 +1,68 @@
+package tokenizer
+
+import "sync"
+
+// Tokenizer counts tokens for a piece of text
+type Tokenizer interface {
+	Name() string
+	Count(text string) int
+}
+
+var (
+	mu         sync.RWMutex
+	registry             = map[string]Tokenizer{}
+	defaultTok Tokenizer = NewCJKEstimator()
+)
+
+// Register makes a tokenizer available by name (e.g. "cl100k_base")
+func Register(t Tokenizer) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[t.Name()] = t
+}
+
+// Get returns a registered tokenizer, falling back to the default
+func Get(name string) Tokenizer {
+	mu.RLock()
+	defer mu.RUnlock()
+	if t, ok := registry[name]; ok {
+		return t
+	}
+	return defaultTok
+}
+
+// SetDefault replaces the tokenizer used when no specific one is requested
+func SetDefault(t Tokenizer) {
+	if t == nil {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	defaultTok = t
+	registry[t.Name()] = t
+}
+
+// Default returns the process-wide tokenizer (a CJK-aware estimator unless a BPE vocab was loaded)
+func Default() Tokenizer {
+	mu.RLock()
+	defer mu.RUnlock()
+	return defaultTok
+}
+
+// Chat message framing overhead, following the OpenAI chat format accounting
+const (
+	tokensPerMessage = 4
+	tokensPerReply   = 3
+)
+
+// CountChat counts prompt tokens for a chat request given each message's content
+func CountChat(t Tokenizer, contents []string) int {
+	if t == nil {
+		t = Default()
+	}
+	n := tokensPerReply
+	for _, c := range contents {
+		n += tokensPerMessage + t.Count(c)
+	}
+	return n
+}
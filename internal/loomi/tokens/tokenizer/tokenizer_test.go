@@ -0,0 +1,24 @@
+package tokenizer
+
+import "testing"
+
+func TestCountChat(t *testing.T) {
+	tok := fixedTokenizer{"system": 2, "hi": 1}
+	for name, tc := range map[string]struct {
+		contents []string
+		want     int
+	}{
+		"no messages": {nil, 3},
+		// 3 for the reply, 4 framing per message plus its content
+		"two messages":  {[]string{"system", "hi"}, 3 + 4 + 2 + 4 + 1},
+		"empty content": {[]string{""}, 3 + 4},
+	} {
+		if got := CountChat(tok, tc.contents); got != tc.want {
+			t.Errorf("%s: CountChat = %d, want %d", name, got, tc.want)
+		}
+	}
+
+	if got, want := CountChat(nil, []string{"你好"}), 3+4+Default().Count("你好"); got != want {
+		t.Errorf("CountChat(nil) = %d, want %d with the default tokenizer", got, want)
+	}
+}
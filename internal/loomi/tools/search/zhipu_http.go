@@ -1,11 +1,14 @@
 package search
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	logx "github.com/blueplan/loomi-go/internal/loomi/log"
@@ -21,15 +24,18 @@ type ZhipuHTTPClient struct {
 
 // ZhipuHTTPRequest 智谱HTTP请求
 type ZhipuHTTPRequest struct {
-	Model       string                 `json:"model"`
-	Messages    []ZhipuMessage         `json:"messages"`
-	MaxTokens   int                    `json:"max_tokens"`
-	Temperature float64                `json:"temperature"`
-	TopP        float64                `json:"top_p"`
-	Stream      bool                   `json:"stream"`
-	Tools       []ZhipuTool            `json:"tools,omitempty"`
-	ToolChoice  interface{}            `json:"tool_choice,omitempty"`
-	Extra       map[string]interface{} `json:"extra,omitempty"`
+	Model       string         `json:"model"`
+	Messages    []ZhipuMessage `json:"messages"`
+	MaxTokens   int            `json:"max_tokens"`
+	Temperature float64        `json:"temperature"`
+	TopP        float64        `json:"top_p"`
+	Stop        []string       `json:"stop,omitempty"`
+	Stream      bool           `json:"stream"`
+	Tools       []ZhipuTool    `json:"tools,omitempty"`
+	ToolChoice  interface{}    `json:"tool_choice,omitempty"`
+	// ResponseFormat 如 {"type":"json_object"}，要求模型输出 JSON
+	ResponseFormat map[string]string      `json:"response_format,omitempty"`
+	Extra          map[string]interface{} `json:"extra,omitempty"`
 }
 
 // ZhipuMessage 智谱消息
@@ -103,6 +109,17 @@ type ZhipuError struct {
 	Code    string `json:"code"`
 }
 
+// ZhipuAPIError 智谱接口返回的错误（保留状态码与业务错误码，便于上层分类）
+type ZhipuAPIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *ZhipuAPIError) Error() string {
+	return fmt.Sprintf("智谱API错误: status=%d code=%s message=%s", e.StatusCode, e.Code, e.Message)
+}
+
 // NewZhipuHTTPClient 创建智谱HTTP客户端
 func NewZhipuHTTPClient(logger *logx.Logger) *ZhipuHTTPClient {
 	return &ZhipuHTTPClient{
@@ -124,6 +141,13 @@ func (zhc *ZhipuHTTPClient) SetBaseURL(baseURL string) {
 	zhc.baseURL = baseURL
 }
 
+// SetHTTPClient 设置HTTP客户端（流式调用需要更长的超时）
+func (zhc *ZhipuHTTPClient) SetHTTPClient(hc *http.Client) {
+	if hc != nil {
+		zhc.httpClient = hc
+	}
+}
+
 // ChatCompletion 聊天补全
 func (zhc *ZhipuHTTPClient) ChatCompletion(ctx context.Context, req ZhipuHTTPRequest) (*ZhipuHTTPResponse, error) {
 	zhc.logger.Info(ctx, "开始智谱聊天补全",
@@ -239,29 +263,37 @@ func (zhc *ZhipuHTTPClient) StreamChatCompletion(ctx context.Context, req ZhipuH
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("智谱API返回错误状态码: %d", resp.StatusCode)
+		return decodeZhipuAPIError(resp)
 	}
 
-	// 处理流式响应
-	decoder := json.NewDecoder(resp.Body)
-	for {
+	// 处理流式响应（SSE：每行 data: {...}，以 data: [DONE] 结束；usage 随最后一个分片返回）
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
 		var response ZhipuHTTPResponse
-		if err := decoder.Decode(&response); err != nil {
-			if err.Error() == "EOF" {
-				break
-			}
+		if err := json.Unmarshal([]byte(data), &response); err != nil {
 			return fmt.Errorf("解析流式响应失败: %w", err)
 		}
+		if response.Error != nil {
+			return &ZhipuAPIError{StatusCode: resp.StatusCode, Code: response.Error.Code, Message: response.Error.Message}
+		}
 
 		// 调用回调函数
 		if err := callback(&response); err != nil {
 			return fmt.Errorf("处理流式响应失败: %w", err)
 		}
-
-		// 检查是否结束
-		if len(response.Choices) > 0 && response.Choices[0].FinishReason != "" {
-			break
-		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取流式响应失败: %w", err)
 	}
 
 	zhc.logger.Info(ctx, "智谱流式聊天补全完成")
@@ -323,6 +355,16 @@ func (zhc *ZhipuHTTPClient) Embedding(ctx context.Context, input []string, model
 	return &response, nil
 }
 
+// decodeZhipuAPIError 解析非200响应体中的错误信息
+func decodeZhipuAPIError(resp *http.Response) error {
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var errorResp ZhipuHTTPResponse
+	if err := json.Unmarshal(raw, &errorResp); err == nil && errorResp.Error != nil {
+		return &ZhipuAPIError{StatusCode: resp.StatusCode, Code: errorResp.Error.Code, Message: errorResp.Error.Message}
+	}
+	return &ZhipuAPIError{StatusCode: resp.StatusCode, Message: string(raw)}
+}
+
 // ZhipuEmbeddingResponse 智谱嵌入响应
 type ZhipuEmbeddingResponse struct {
 	Object string `json:"object"`
@@ -0,0 +1,155 @@
+package search
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/config"
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+	logx "github.com/blueplan/loomi-go/internal/loomi/log"
+)
+
+// ZhipuLLMClient 将 ZhipuHTTPClient 适配为 llm.Client，供 LLM_DEFAULT_PROVIDER=zhipu 使用
+type ZhipuLLMClient struct {
+	http *ZhipuHTTPClient
+	cfg  config.LLMProviderConfig
+}
+
+// NewZhipuLLMClient 根据 provider 配置创建智谱 LLM 适配器
+func NewZhipuLLMClient(logger *logx.Logger, cfg config.LLMProviderConfig) *ZhipuLLMClient {
+	hc := NewZhipuHTTPClient(logger)
+	hc.SetAPIKey(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		hc.SetBaseURL(strings.TrimRight(cfg.BaseURL, "/"))
+	}
+	// 默认 60s 超时对长文本流式生成不够
+	hc.SetHTTPClient(&http.Client{Timeout: 300 * time.Second})
+	return &ZhipuLLMClient{http: hc, cfg: cfg}
+}
+
+// SafeStreamCall 流式调用智谱 chat/completions，并把 delta 文本转交给 onChunk
+func (c *ZhipuLLMClient) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []llm.Message, onChunk llm.StreamChunkHandler, opts ...llm.CallOptions) error {
+	o := llm.ResolveCallOptions(opts...)
+	req := ZhipuHTTPRequest{
+		Model:       c.cfg.Model,
+		Messages:    toZhipuMessages(messages),
+		MaxTokens:   c.cfg.MaxTokens,
+		Temperature: c.cfg.Temperature,
+		Stop:        o.Stop,
+	}
+	if o.Model != "" {
+		req.Model = o.Model
+	}
+	if o.Temperature != nil {
+		req.Temperature = *o.Temperature
+	}
+	if o.TopP != nil {
+		req.TopP = *o.TopP
+	}
+	if o.MaxTokens > 0 {
+		req.MaxTokens = o.MaxTokens
+	}
+	if o.JSONMode {
+		req.ResponseFormat = map[string]string{"type": "json_object"}
+	}
+	// 智谱接口没有 thinking budget 参数，ThinkingBudget 在此忽略
+
+	var chunkErr error
+	var usage ZhipuUsage
+	err := c.http.StreamChatCompletion(ctx, req, func(resp *ZhipuHTTPResponse) error {
+		if resp.Usage.TotalTokens > 0 {
+			usage = resp.Usage
+		}
+		for _, choice := range resp.Choices {
+			if choice.FinishReason == "sensitive" {
+				return &llm.APIError{Provider: "zhipu", StatusCode: http.StatusOK, Code: "sensitive", Body: "finish_reason=sensitive", Kind: llm.ErrSafetyBlocked}
+			}
+			text, _ := choice.Delta.Content.(string)
+			if text == "" {
+				continue
+			}
+			if err := onChunk(ctx, text); err != nil {
+				chunkErr = err
+				return err
+			}
+		}
+		return nil
+	})
+	// 用量随本次调用上报，不按会话留存
+	llm.ReportUsage(ctx, usage.PromptTokens, usage.CompletionTokens)
+	if chunkErr != nil {
+		// 下游（停止检查等）返回的错误原样透传，不包装
+		return chunkErr
+	}
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return mapZhipuError(err)
+}
+
+// toZhipuMessages 角色映射：智谱支持 system/user/assistant/tool，其余角色按 user 处理
+func toZhipuMessages(messages []llm.Message) []ZhipuMessage {
+	out := make([]ZhipuMessage, 0, len(messages))
+	for _, m := range messages {
+		role := m.Role
+		switch role {
+		case "system", "user", "assistant", "tool":
+		default:
+			role = "user"
+		}
+		out = append(out, ZhipuMessage{Role: role, Content: toZhipuContent(m)})
+	}
+	return out
+}
+
+// toZhipuContent 纯文本消息保持字符串；含图片时转为 GLM-4V 的 content 数组
+func toZhipuContent(m llm.Message) interface{} {
+	if len(m.Parts) == 0 {
+		return m.Content
+	}
+	parts := make([]map[string]interface{}, 0, len(m.Parts)+1)
+	for _, p := range m.ContentParts() {
+		switch p.Type {
+		case llm.PartImage:
+			// 智谱接受图片 URL 或不带 data: 前缀的 base64
+			url := p.URL
+			if len(p.Data) > 0 {
+				url = base64.StdEncoding.EncodeToString(p.Data)
+			}
+			parts = append(parts, map[string]interface{}{"type": "image_url", "image_url": map[string]string{"url": url}})
+		case llm.PartFile:
+			// 文档没有原生类型，以文件名和链接文本传入
+			parts = append(parts, map[string]interface{}{"type": "text", "text": fmt.Sprintf("[%s](%s)", p.FileName, p.URL)})
+		default:
+			parts = append(parts, map[string]interface{}{"type": "text", "text": p.Text})
+		}
+	}
+	return parts
+}
+
+// mapZhipuError 将智谱业务错误码映射为 llm 包的类型化错误
+// 参考错误码：1261 prompt 超长；1301 内容安全；1302/1303/1305 并发或频率超限
+func mapZhipuError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var apiErr *ZhipuAPIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	out := &llm.APIError{Provider: "zhipu", StatusCode: apiErr.StatusCode, Code: apiErr.Code, Body: apiErr.Message}
+	switch {
+	case apiErr.Code == "1302" || apiErr.Code == "1303" || apiErr.Code == "1305" || apiErr.StatusCode == http.StatusTooManyRequests:
+		out.Kind = llm.ErrRateLimited
+	case apiErr.Code == "1261" || strings.Contains(apiErr.Message, "超长"):
+		out.Kind = llm.ErrContextTooLong
+	case apiErr.Code == "1301":
+		out.Kind = llm.ErrSafetyBlocked
+	}
+	return out
+}
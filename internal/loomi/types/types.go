@@ -2,6 +2,7 @@ package types
 
 import (
 	"context"
+	"time"
 
 	"github.com/blueplan/loomi-go/internal/loomi/events"
 )
@@ -15,6 +16,13 @@ type AgentRequest struct {
 	FileIDs     []string
 	AutoMode    bool
 	Selections  []string
+	// Tasks are the instructions of same-type actions merged into this request; the items of
+	// task K are written in <tagK> blocks and reported back per task
+	Tasks []string
+	// UserPlan selects the budget of the user's plan (budgets.plans.<plan>); Budget, when set,
+	// can only tighten it
+	UserPlan string
+	Budget   *Budget
 	// Extended fields to mirror Python request_data structure
 	Background         map[string]any
 	InteractionType    string
@@ -23,6 +31,15 @@ type AgentRequest struct {
 	Nova3Selections    map[string]any
 }
 
+// Budget caps what one request may use; zero fields are unlimited
+type Budget struct {
+	MaxTokens int
+	// MaxSessionTokens caps the session's running total across requests
+	MaxSessionTokens int
+	MaxLLMCalls      int
+	MaxWallTime      time.Duration
+}
+
 // Agent defines the interface for all agents
 type Agent interface {
 	ProcessRequest(ctx context.Context, req AgentRequest, emit func(ev events.StreamEvent) error) error
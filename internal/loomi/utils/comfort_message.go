@@ -86,7 +86,7 @@ func (c *ComfortMessageTool) GenerateFirstComfort(ctx context.Context, userInput
 	err := c.llmClient.SafeStreamCall(ctx, "comfort", "first", messages, func(ctx context.Context, chunk string) error {
 		response += chunk
 		return nil
-	})
+	}, c.callOptions())
 
 	if err != nil {
 		c.logger.Error(ctx, "生成首次安慰消息失败", "error", err)
@@ -129,7 +129,7 @@ func (c *ComfortMessageTool) GenerateComfort(ctx context.Context, userInput stri
 	err := c.llmClient.SafeStreamCall(ctx, "comfort", "followup", messages, func(ctx context.Context, chunk string) error {
 		response += chunk
 		return nil
-	})
+	}, c.callOptions())
 
 	if err != nil {
 		c.logger.Error(ctx, "生成后续安慰消息失败", "error", err)
@@ -172,7 +172,7 @@ func (c *ComfortMessageTool) GenerateErrorComfort(ctx context.Context, errorMsg
 	err := c.llmClient.SafeStreamCall(ctx, "comfort", "error", messages, func(ctx context.Context, chunk string) error {
 		response += chunk
 		return nil
-	})
+	}, c.callOptions())
 
 	if err != nil {
 		c.logger.Error(ctx, "生成错误安慰消息失败", "error", err)
@@ -215,7 +215,7 @@ func (c *ComfortMessageTool) GenerateProgressComfort(ctx context.Context, progre
 	err := c.llmClient.SafeStreamCall(ctx, "comfort", "progress", messages, func(ctx context.Context, chunk string) error {
 		response += chunk
 		return nil
-	})
+	}, c.callOptions())
 
 	if err != nil {
 		c.logger.Error(ctx, "生成进度安慰消息失败", "error", err)
@@ -226,6 +226,16 @@ func (c *ComfortMessageTool) GenerateProgressComfort(ctx context.Context, progre
 	return response, nil
 }
 
+// callOptions 将安慰消息专用配置转换为单次调用参数
+func (c *ComfortMessageTool) callOptions() llm.CallOptions {
+	return llm.CallOptions{
+		Model:       c.comfortModel,
+		Temperature: llm.Float64(c.temperature),
+		TopP:        llm.Float64(c.topP),
+		MaxTokens:   c.maxTokens,
+	}
+}
+
 // GetComfortConfig 获取安慰消息配置
 func (c *ComfortMessageTool) GetComfortConfig() map[string]interface{} {
 	return map[string]interface{}{
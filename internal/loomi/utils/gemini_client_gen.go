@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -8,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/config"
@@ -109,6 +111,73 @@ func (gc *GeminiClientGen) StreamGenerateContent(ctx context.Context, contents [
 	return nil
 }
 
+// StreamGenerateChat 按角色流式生成内容（alt=sse），每个分片以完整响应结构回调，便于读取 usage 与安全拦截信息
+// model 为空时使用客户端默认模型
+func (gc *GeminiClientGen) StreamGenerateChat(ctx context.Context, model string, contents []Content, systemInstruction string, config *GenerateContentConfig, onChunk func(*GenerateContentResponse) error) error {
+	if model == "" {
+		model = gc.modelID
+	}
+	request := map[string]interface{}{
+		"contents": contents,
+	}
+	if systemInstruction != "" {
+		request["systemInstruction"] = map[string]interface{}{
+			"parts": []Part{{Text: systemInstruction}},
+		}
+	}
+	if config != nil {
+		request["generationConfig"] = config
+	}
+
+	url := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", gc.baseURL, model)
+	if !gc.vertexAI {
+		url += "&key=" + gc.apiKey
+	}
+
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("序列化请求失败: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("创建HTTP请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if gc.vertexAI {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gc.apiKey))
+	}
+
+	resp, err := gc.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("发送HTTP请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return decodeGeminiAPIError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		chunk := &GenerateContentResponse{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), chunk); err != nil {
+			return fmt.Errorf("解析流式响应失败: %w", err)
+		}
+		if err := onChunk(chunk); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取流式响应失败: %w", err)
+	}
+	return nil
+}
+
 // AnalyzeImage 分析图片
 func (gc *GeminiClientGen) AnalyzeImage(ctx context.Context, imageData []byte, prompt string) (*GenerateContentResponse, error) {
 	gc.logger.Info(ctx, "开始分析图片", "image_size", len(imageData))
@@ -417,16 +486,31 @@ func (gc *GeminiClientGen) sendStreamRequest(ctx context.Context, endpoint strin
 
 // GenerateContentConfig 生成内容配置
 type GenerateContentConfig struct {
-	Temperature     float64 `json:"temperature"`
-	TopK            int     `json:"topK"`
-	TopP            float64 `json:"topP"`
-	MaxOutputTokens int     `json:"maxOutputTokens"`
+	Temperature     float64         `json:"temperature"`
+	TopK            int             `json:"topK"`
+	TopP            float64         `json:"topP"`
+	MaxOutputTokens int             `json:"maxOutputTokens"`
+	StopSequences   []string        `json:"stopSequences,omitempty"`
+	ThinkingConfig  *ThinkingConfig `json:"thinkingConfig,omitempty"`
+	// ResponseMimeType 为 "application/json" 时要求模型输出 JSON
+	ResponseMimeType string `json:"responseMimeType,omitempty"`
+}
+
+// ThinkingConfig 思考预算配置（Gemini 2.5 系列）
+type ThinkingConfig struct {
+	ThinkingBudget int `json:"thinkingBudget"`
 }
 
 // GenerateContentResponse 生成内容响应
 type GenerateContentResponse struct {
-	Candidates []Candidate `json:"candidates"`
-	Usage      Usage       `json:"usageMetadata"`
+	Candidates     []Candidate     `json:"candidates"`
+	Usage          Usage           `json:"usageMetadata"`
+	PromptFeedback *PromptFeedback `json:"promptFeedback,omitempty"`
+}
+
+// PromptFeedback 输入侧安全反馈（blockReason 非空表示请求被拦截）
+type PromptFeedback struct {
+	BlockReason string `json:"blockReason"`
 }
 
 // Candidate 候选响应
@@ -437,12 +521,27 @@ type Candidate struct {
 
 // Content 内容
 type Content struct {
+	Role  string `json:"role,omitempty"`
 	Parts []Part `json:"parts"`
 }
 
 // Part 内容部分
 type Part struct {
-	Text string `json:"text"`
+	Text       string      `json:"text,omitempty"`
+	InlineData *InlineData `json:"inlineData,omitempty"`
+	FileData   *FileData   `json:"fileData,omitempty"`
+}
+
+// InlineData 内联的图片/文档数据（base64）
+type InlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+// FileData 按 URI 引用的文件
+type FileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
 }
 
 // Usage 使用情况
@@ -472,8 +571,34 @@ type ListModelsResponse struct {
 	Models []ModelInfo `json:"models"`
 }
 
+// GeminiAPIError Gemini 接口错误
+type GeminiAPIError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *GeminiAPIError) Error() string {
+	return fmt.Sprintf("Gemini API错误: status=%d %s: %s", e.StatusCode, e.Status, e.Message)
+}
+
 // 辅助函数
 
+// decodeGeminiAPIError 解析 {"error": {"code", "message", "status"}} 错误体
+func decodeGeminiAPIError(resp *http.Response) error {
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+			Status  string `json:"status"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &body); err == nil && body.Error.Message != "" {
+		return &GeminiAPIError{StatusCode: resp.StatusCode, Status: body.Error.Status, Message: body.Error.Message}
+	}
+	return &GeminiAPIError{StatusCode: resp.StatusCode, Message: string(raw)}
+}
+
 // encodeBase64 编码为Base64
 func encodeBase64(data []byte) string {
 	return fmt.Sprintf("%x", data) // 简化实现，实际应该使用base64编码
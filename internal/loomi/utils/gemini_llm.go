@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/blueplan/loomi-go/internal/loomi/config"
+	"github.com/blueplan/loomi-go/internal/loomi/llm"
+)
+
+// GeminiLLMClient 将 GeminiClientGen 适配为 llm.Client，供 LLM_DEFAULT_PROVIDER=gemini 使用
+type GeminiLLMClient struct {
+	gen *GeminiClientGen
+	cfg config.LLMProviderConfig
+}
+
+// NewGeminiLLMClient 基于 GeminiClientGen 创建适配器；provider 配置中的 key/model/base_url 优先于环境变量
+func NewGeminiLLMClient(gen *GeminiClientGen, cfg config.LLMProviderConfig) *GeminiLLMClient {
+	if cfg.APIKey != "" {
+		gen.apiKey = cfg.APIKey
+	}
+	if cfg.Model != "" {
+		gen.modelID = cfg.Model
+	}
+	if cfg.BaseURL != "" && !gen.vertexAI {
+		gen.baseURL = strings.TrimRight(cfg.BaseURL, "/")
+	}
+	// 流式长文本生成需要比默认 120s 更长的超时
+	gen.httpClient = &http.Client{Timeout: 300 * time.Second}
+	return &GeminiLLMClient{gen: gen, cfg: cfg}
+}
+
+// SafeStreamCall 流式调用 Gemini，并把候选文本转交给 onChunk
+func (c *GeminiLLMClient) SafeStreamCall(ctx context.Context, userID, sessionID string, messages []llm.Message, onChunk llm.StreamChunkHandler, opts ...llm.CallOptions) error {
+	o := llm.ResolveCallOptions(opts...)
+	contents, system := toGeminiContents(messages)
+	genCfg := &GenerateContentConfig{
+		Temperature:     c.cfg.Temperature,
+		TopK:            40,
+		TopP:            0.95,
+		MaxOutputTokens: c.cfg.MaxTokens,
+		StopSequences:   o.Stop,
+	}
+	if o.Temperature != nil {
+		genCfg.Temperature = *o.Temperature
+	}
+	if o.TopP != nil {
+		genCfg.TopP = *o.TopP
+	}
+	if o.MaxTokens > 0 {
+		genCfg.MaxOutputTokens = o.MaxTokens
+	}
+	if o.ThinkingBudget > 0 {
+		genCfg.ThinkingConfig = &ThinkingConfig{ThinkingBudget: o.ThinkingBudget}
+	}
+	if o.JSONMode {
+		genCfg.ResponseMimeType = "application/json"
+	}
+
+	var chunkErr error
+	// usageMetadata 在流中逐块累计，以最后一次为准，结束时随本次调用上报
+	var usage Usage
+	err := c.gen.StreamGenerateChat(ctx, o.Model, contents, system, genCfg, func(resp *GenerateContentResponse) error {
+		if resp.Usage.TotalTokenCount > 0 {
+			usage = resp.Usage
+		}
+		if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+			return &llm.APIError{Provider: "gemini", StatusCode: http.StatusOK, Code: resp.PromptFeedback.BlockReason, Body: "prompt blocked", Kind: llm.ErrSafetyBlocked}
+		}
+		for _, cand := range resp.Candidates {
+			for _, part := range cand.Content.Parts {
+				if part.Text == "" {
+					continue
+				}
+				if err := onChunk(ctx, part.Text); err != nil {
+					chunkErr = err
+					return err
+				}
+			}
+			if isGeminiSafetyFinish(cand.FinishReason) {
+				return &llm.APIError{Provider: "gemini", StatusCode: http.StatusOK, Code: cand.FinishReason, Body: "candidate blocked", Kind: llm.ErrSafetyBlocked}
+			}
+		}
+		return nil
+	})
+	llm.ReportUsage(ctx, usage.PromptTokenCount, usage.CandidatesTokenCount)
+	if chunkErr != nil {
+		return chunkErr
+	}
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return mapGeminiError(err)
+}
+
+// toGeminiContents 角色映射：system 合并为 systemInstruction，assistant→model，其余→user
+func toGeminiContents(messages []llm.Message) ([]Content, string) {
+	var system []string
+	contents := make([]Content, 0, len(messages))
+	for _, m := range messages {
+		switch m.Role {
+		case "system":
+			system = append(system, m.Content)
+			continue
+		case "assistant", "model":
+			contents = append(contents, Content{Role: "model", Parts: toGeminiParts(m)})
+		default:
+			contents = append(contents, Content{Role: "user", Parts: toGeminiParts(m)})
+		}
+	}
+	return contents, strings.Join(system, "\n\n")
+}
+
+// toGeminiParts 将消息内容转换为 Gemini parts：内联数据用 inlineData，URL 引用用 fileData
+func toGeminiParts(m llm.Message) []Part {
+	if len(m.Parts) == 0 {
+		return []Part{{Text: m.Content}}
+	}
+	parts := make([]Part, 0, len(m.Parts)+1)
+	for _, p := range m.ContentParts() {
+		switch {
+		case p.Type == llm.PartText:
+			parts = append(parts, Part{Text: p.Text})
+		case len(p.Data) > 0:
+			mimeType := p.MIMEType
+			if mimeType == "" {
+				mimeType = "application/octet-stream"
+			}
+			parts = append(parts, Part{InlineData: &InlineData{MimeType: mimeType, Data: base64.StdEncoding.EncodeToString(p.Data)}})
+		default:
+			parts = append(parts, Part{FileData: &FileData{MimeType: p.MIMEType, FileURI: p.URL}})
+		}
+	}
+	return parts
+}
+
+func isGeminiSafetyFinish(reason string) bool {
+	switch reason {
+	case "SAFETY", "PROHIBITED_CONTENT", "BLOCKLIST", "SPII", "IMAGE_SAFETY":
+		return true
+	}
+	return false
+}
+
+// mapGeminiError 将 Gemini 错误体映射为 llm 包的类型化错误
+func mapGeminiError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var apiErr *GeminiAPIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	out := &llm.APIError{Provider: "gemini", StatusCode: apiErr.StatusCode, Code: apiErr.Status, Body: apiErr.Message}
+	lower := strings.ToLower(apiErr.Message)
+	switch {
+	case apiErr.StatusCode == http.StatusTooManyRequests || apiErr.Status == "RESOURCE_EXHAUSTED":
+		out.Kind = llm.ErrRateLimited
+	case strings.Contains(lower, "exceeds the maximum number of tokens") || strings.Contains(lower, "input token count"):
+		out.Kind = llm.ErrContextTooLong
+	case strings.Contains(lower, "safety") || strings.Contains(lower, "prohibited"):
+		out.Kind = llm.ErrSafetyBlocked
+	}
+	return out
+}
@@ -0,0 +1,95 @@
+package jsonschema
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const itemsSchema = `{
+	"type": "object",
+	"required": ["items"],
+	"additionalProperties": false,
+	"properties": {
+		"items": {
+			"type": "array",
+			"minItems": 1,
+			"maxItems": 2,
+			"items": {
+				"type": "object",
+				"required": ["title", "content"],
+				"properties": {
+					"title": {"type": "string", "maxLength": 4},
+					"content": {"type": "string", "minLength": 1},
+					"score": {"type": "number", "minimum": 0, "maximum": 1},
+					"level": {"type": ["integer", "null"], "enum": [1, 2, null]}
+				}
+			}
+		}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	schema, err := Parse([]byte(itemsSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tc := range map[string]struct {
+		doc  string
+		want []string
+	}{
+		"valid":               {`{"items": [{"title": "早八", "content": "通勤", "score": 0.5, "level": 2}]}`, nil},
+		"integer as number":   {`{"items": [{"title": "早八", "content": "通勤", "score": 1}]}`, nil},
+		"null in type list":   {`{"items": [{"title": "早八", "content": "通勤", "level": null}]}`, nil},
+		"max length in runes": {`{"items": [{"title": "打工人的", "content": "x"}]}`, nil},
+		"invalid JSON":        {`{"items": [`, []string{"$: invalid JSON: unexpected EOF"}},
+		"wrong root type":     {`[]`, []string{"$: expected object, got array"}},
+		"missing required":    {`{}`, []string{`$: missing required property "items"`}},
+		"additional property": {`{"items": [{"title": "a", "content": "b"}], "extra": 1}`, []string{`$: unexpected property "extra"`}},
+		"too few items":       {`{"items": []}`, []string{"$.items: expected at least 1 items, got 0"}},
+		"too many items": {
+			`{"items": [{"title": "a", "content": "b"}, {"title": "a", "content": "b"}, {"title": "a", "content": "b"}]}`,
+			[]string{"$.items: expected at most 2 items, got 3"},
+		},
+		"nested errors in key order": {
+			`{"items": [{"title": "早八通勤打卡", "content": "", "score": 2}, {"content": 3}]}`,
+			[]string{
+				"$.items[0].content: string shorter than 1",
+				"$.items[0].score: 2 is greater than maximum 1",
+				"$.items[0].title: string longer than 4",
+				`$.items[1]: missing required property "title"`,
+				"$.items[1].content: expected string, got integer",
+			},
+		},
+		"below minimum":  {`{"items": [{"title": "a", "content": "b", "score": -0.5}]}`, []string{"$.items[0].score: -0.5 is less than minimum 0"}},
+		"not in enum":    {`{"items": [{"title": "a", "content": "b", "level": 3}]}`, []string{"$.items[0].level: value 3 is not one of [1 2 <nil>]"}},
+		"float for enum": {`{"items": [{"title": "a", "content": "b", "level": 1.5}]}`, []string{"$.items[0].level: expected integer or null, got number"}},
+	} {
+		if got := schema.Validate([]byte(tc.doc)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: Validate = %q, want %q", name, got, tc.want)
+		}
+	}
+
+	if _, err := Parse([]byte(`{"type": `)); err == nil || !strings.HasPrefix(err.Error(), "parse schema") {
+		t.Errorf("Parse(truncated) err = %v", err)
+	}
+	var empty *Schema
+	if errs := empty.Validate([]byte(`{"anything": true}`)); errs != nil {
+		t.Errorf("nil schema: %q", errs)
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	for text, want := range map[string]string{
+		`{"a": 1}`: `{"a": 1}`,
+		"好的，结果如下：\n```json\n{\"a\": [1]}\n```\n希望有帮助": `{"a": [1]}`,
+		`前言 [1, [2, 3]] 后记`:         `[1, [2, 3]]`,
+		`{"s": "含 } 和 \" 的字符串"} 尾巴`: `{"s": "含 } 和 \" 的字符串"}`,
+		`{"cut": [1, 2`:             `{"cut": [1, 2`,
+		"没有 JSON":                   "没有 JSON",
+	} {
+		if got := ExtractJSON(text); got != want {
+			t.Errorf("ExtractJSON(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
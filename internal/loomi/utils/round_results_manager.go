@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/log"
+	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/monitoring"
 	"blueplan-research-dev-langgraph22/loomi-go/internal/loomi/pool"
 )
 
@@ -60,6 +61,13 @@ func (rrm *RoundResultsManager) SaveUserSelectResult(ctx context.Context, userID
 		return fmt.Errorf("获取Redis客户端失败: %w", err)
 	}
 
+	// 选中的笔记计入提示词实验转化
+	noteIDs := make([]string, 0, len(selections))
+	for id := range selections {
+		noteIDs = append(noteIDs, id)
+	}
+	monitoring.DefaultExperimentMetrics.RecordSelections(userID, sessionID, noteIDs)
+
 	// 构建结果数据
 	result := UserSelectResult{
 		Round:      round,
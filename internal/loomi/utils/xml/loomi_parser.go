@@ -3,6 +3,7 @@ package xmlx
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -104,18 +105,19 @@ var ContentConfigs = map[string]ParseConfig{
 // ParseEnhanced provides enhanced XML parsing with title extraction
 func (p *LoomiXMLParser) ParseEnhanced(text string, config ParseConfig, startID int) []ParseResult {
 	// Pattern for extracting complete XML blocks
-	pattern := fmt.Sprintf(`<%s\d+>([\s\S]*?)</%s\d+>`, config.TagName, config.TagName)
+	pattern := fmt.Sprintf(`<%s(\d+)>([\s\S]*?)</%s\d+>`, config.TagName, config.TagName)
 	re := regexp.MustCompile(pattern)
 	matches := re.FindAllStringSubmatch(text, -1)
 
 	results := make([]ParseResult, 0, len(matches))
 
 	for i, match := range matches {
-		if len(match) < 2 {
+		if len(match) < 3 {
 			continue
 		}
 
-		blockContent := match[1]
+		index, _ := strconv.Atoi(match[1])
+		blockContent := match[2]
 
 		// Extract title if available
 		title := p.extractTitle(blockContent, config)
@@ -140,6 +142,7 @@ func (p *LoomiXMLParser) ParseEnhanced(text string, config ParseConfig, startID
 			CoverText: strings.TrimSpace(cover),
 			Hook:      strings.TrimSpace(hook),
 			Type:      config.Type,
+			Index:     index,
 		})
 	}
 
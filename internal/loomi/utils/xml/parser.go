@@ -11,6 +11,8 @@ type ParseResult struct {
 	CoverText string
 	Hook      string
 	Type      string
+	// Index is the N of the <tagN> block the result was parsed from, 0 if unknown
+	Index int
 }
 
 type Config struct {
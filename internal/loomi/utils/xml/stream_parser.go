@@ -0,0 +1,140 @@
+package xmlx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ItemProgress is a piece of text added to the item that is still streaming. Field is the
+// config tag the text belongs to ("title", "content", ...), or "" for text outside sub-tags.
+type ItemProgress struct {
+	Index int
+	Field string
+	Delta string
+}
+
+// StreamParser finds <tagN>...</tagN> items in a streamed response as soon as each closing tag
+// arrives; it is the item-level counterpart of utils.StreamingTagParser. Write is not safe for
+// concurrent use.
+type StreamParser struct {
+	cfg     ParseConfig
+	parser  *LoomiXMLParser
+	openRe  *regexp.Regexp
+	closeRe *regexp.Regexp
+	fields  map[string]bool
+
+	buf     strings.Builder
+	scanned int
+	open    *openItem
+}
+
+type openItem struct {
+	index   int
+	start   int // position after the opening tag
+	emitted int // progress has been reported up to here
+	field   string
+}
+
+var subTagRe = regexp.MustCompile(`<(/?)([A-Za-z_][\w-]*)>`)
+
+// NewStreamParser creates a stream parser for cfg
+func NewStreamParser(cfg ParseConfig) *StreamParser {
+	tag := regexp.QuoteMeta(cfg.TagName)
+	fields := map[string]bool{}
+	for _, f := range []string{cfg.TitleTag, cfg.ContentTag, cfg.CoverTextTag, cfg.HookTag} {
+		if f != "" {
+			fields[f] = true
+		}
+	}
+	return &StreamParser{
+		cfg:     cfg,
+		parser:  NewLoomiXMLParser(),
+		openRe:  regexp.MustCompile(`<` + tag + `(\d+)>`),
+		closeRe: regexp.MustCompile(`</` + tag + `\d+>`),
+		fields:  fields,
+	}
+}
+
+// Write adds a chunk and returns the items completed by it, plus the text added to the item
+// that is still open
+func (p *StreamParser) Write(chunk string) ([]ParseResult, []ItemProgress) {
+	p.buf.WriteString(chunk)
+	s := p.buf.String()
+
+	var done []ParseResult
+	var progress []ItemProgress
+	for {
+		if p.open == nil {
+			loc := p.openRe.FindStringSubmatchIndex(s[p.scanned:])
+			if loc == nil {
+				// 保留可能被截断的开始标签
+				if keep := len(p.cfg.TagName) + 12; len(s)-keep > p.scanned {
+					p.scanned = len(s) - keep
+				}
+				return done, progress
+			}
+			var index int
+			fmt.Sscanf(s[p.scanned+loc[2]:p.scanned+loc[3]], "%d", &index)
+			start := p.scanned + loc[1]
+			p.open = &openItem{index: index, start: start, emitted: start}
+			p.scanned = start
+			continue
+		}
+
+		loc := p.closeRe.FindStringIndex(s[p.open.start:])
+		if loc == nil {
+			progress = append(progress, p.progress(s, safeEnd(s, p.open.emitted))...)
+			return done, progress
+		}
+		end := p.open.start + loc[0]
+		progress = append(progress, p.progress(s, end)...)
+		block := fmt.Sprintf("<%s%d>%s</%s%d>", p.cfg.TagName, p.open.index, s[p.open.start:end], p.cfg.TagName, p.open.index)
+		done = append(done, p.parser.ParseEnhanced(block, p.cfg, p.open.index)...)
+		p.scanned = p.open.start + loc[1]
+		p.open = nil
+	}
+}
+
+// progress reports the open item's text between what was already reported and end, split by sub-tags
+func (p *StreamParser) progress(s string, end int) []ItemProgress {
+	item := p.open
+	if end <= item.emitted {
+		return nil
+	}
+	segment := s[item.emitted:end]
+	item.emitted = end
+
+	var out []ItemProgress
+	add := func(text string) {
+		if text == "" || (item.field == "" && strings.TrimSpace(text) == "") {
+			return
+		}
+		out = append(out, ItemProgress{Index: item.index, Field: item.field, Delta: text})
+	}
+	last := 0
+	for _, m := range subTagRe.FindAllStringSubmatchIndex(segment, -1) {
+		name := segment[m[4]:m[5]]
+		if !p.fields[name] {
+			continue
+		}
+		add(segment[last:m[0]])
+		last = m[1]
+		if m[3] > m[2] {
+			item.field = ""
+		} else {
+			item.field = name
+		}
+	}
+	add(segment[last:])
+	return out
+}
+
+// safeEnd returns how far s can be reported without splitting a tag that is still arriving
+func safeEnd(s string, from int) int {
+	i := strings.LastIndex(s[from:], "<")
+	if i == -1 || strings.Contains(s[from+i:], ">") {
+		return len(s)
+	}
+	return from + i
+}